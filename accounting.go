@@ -0,0 +1,187 @@
+package camillo
+
+import (
+	"container/list"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Accounting is a middleware handler that tracks the request count and the number of
+// concurrent requests per client IP. The least recently seen clients without active
+// requests are evicted once more than MaxClients clients are tracked, so churning through
+// addresses can't reset the count of a busy client.
+type Accounting struct {
+	// MaxClients is the maximum number of idle client IPs that are tracked.
+	MaxClients int
+	// MaxActive is the maximum number of concurrent requests allowed per client IP.
+	// Requests beyond the limit are rejected with a 429. Zero means no limit.
+	MaxActive int64
+	// Block is an optional mitigation hook called before every request is passed on.
+	// When it returns true the request is rejected with a 429.
+	Block func(ClientStats) bool
+	// Clock is used to record when a client was last seen
+	Clock Clock
+	// ClientIP resolves the client IP addresses behind trusted proxies.
+	ClientIP *ClientIP
+
+	mtx     sync.Mutex
+	lru     *list.List
+	clients map[string]*list.Element
+}
+
+// ClientStats holds the accounting information of a single client IP.
+type ClientStats struct {
+	IP       string    `json:"ip"`
+	Requests uint64    `json:"requests"`
+	Active   int64     `json:"active"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// NewAccounting returns a new instance of Accounting
+func NewAccounting() *Accounting {
+	return &Accounting{
+		MaxClients: 1024 * 10,
+		Clock:      SystemClock,
+	}
+}
+
+func (a *Accounting) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	ip := remoteIP(r)
+	if a.ClientIP != nil {
+		ip = a.ClientIP.Resolve(r)
+	}
+	client, stats := a.begin(ip)
+	defer a.end(client)
+
+	if (a.MaxActive > 0 && stats.Active > a.MaxActive) || (a.Block != nil && a.Block(stats)) {
+		http.Error(rw, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	next(ctx, rw, r)
+}
+
+func (a *Accounting) begin(ip string) (*ClientStats, ClientStats) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.clients == nil {
+		a.clients = make(map[string]*list.Element)
+		a.lru = list.New()
+	}
+
+	var client *ClientStats
+	if e, ok := a.clients[ip]; ok {
+		a.lru.MoveToFront(e)
+		client = e.Value.(*ClientStats)
+	} else {
+		a.evict()
+		client = &ClientStats{IP: ip}
+		a.clients[ip] = a.lru.PushFront(client)
+	}
+
+	client.Requests++
+	client.Active++
+	client.LastSeen = clockNow(a.Clock)
+	return client, *client
+}
+
+// evict makes room for a new client by evicting the least recently seen idle clients.
+func (a *Accounting) evict() {
+	for e := a.lru.Back(); e != nil && a.MaxClients > 0 && a.lru.Len() >= a.MaxClients; {
+		prev := e.Prev()
+		if client := e.Value.(*ClientStats); client.Active == 0 {
+			a.lru.Remove(e)
+			delete(a.clients, client.IP)
+		}
+		e = prev
+	}
+}
+
+func (a *Accounting) end(client *ClientStats) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	client.Active--
+}
+
+// Client returns the accounting information of the given client IP.
+func (a *Accounting) Client(ip string) (ClientStats, bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	e, ok := a.clients[ip]
+	if !ok {
+		return ClientStats{}, false
+	}
+	return *e.Value.(*ClientStats), true
+}
+
+// TopTalkers returns up to n tracked clients ordered by their request count.
+func (a *Accounting) TopTalkers(n int) []ClientStats {
+	a.mtx.Lock()
+	clients := make([]ClientStats, 0, len(a.clients))
+	for _, e := range a.clients {
+		clients = append(clients, *e.Value.(*ClientStats))
+	}
+	a.mtx.Unlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Requests != clients[j].Requests {
+			return clients[i].Requests > clients[j].Requests
+		}
+		return clients[i].IP < clients[j].IP
+	})
+	if n >= 0 && n < len(clients) {
+		clients = clients[:n]
+	}
+	return clients
+}
+
+// Handler returns a read-only http.Handler that writes the top talkers as JSON. The
+// number of clients defaults to 10 and can be changed with the n query parameter.
+func (a *Accounting) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			rw.Header().Set("Allow", "GET, HEAD")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		n := 10
+		if v := r.URL.Query().Get("n"); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 0 {
+				http.Error(rw, "invalid n", http.StatusBadRequest)
+				return
+			}
+			n = i
+		}
+
+		b, err := json.Marshal(a.TopTalkers(n))
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Write(b)
+	})
+}
+
+// remoteIP returns the IP of the direct peer of the request.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
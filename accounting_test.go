@@ -0,0 +1,156 @@
+package camillo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func newAccountingRequest(t *testing.T, remoteAddr string) *http.Request {
+	req, err := http.NewRequest("GET", "http://localhost:3000/", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func TestAccounting(t *testing.T) {
+	a := NewAccounting()
+
+	n := New()
+	n.Use(a)
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		c, _ := a.Client(remoteIP(r))
+		expect(t, c.Active, int64(1))
+	})
+
+	for _, addr := range []string{"10.0.0.1:1234", "10.0.0.2:1234", "10.0.0.1:4321"} {
+		n.ServeHTTP(httptest.NewRecorder(), newAccountingRequest(t, addr))
+	}
+
+	c, ok := a.Client("10.0.0.1")
+	expect(t, ok, true)
+	expect(t, c.Requests, uint64(2))
+	expect(t, c.Active, int64(0))
+
+	top := a.TopTalkers(1)
+	expect(t, len(top), 1)
+	expect(t, top[0].IP, "10.0.0.1")
+}
+
+func TestAccountingEviction(t *testing.T) {
+	a := NewAccounting()
+	a.MaxClients = 2
+
+	n := New(a)
+	for _, addr := range []string{"10.0.0.1:1", "10.0.0.2:1", "10.0.0.1:1", "10.0.0.3:1"} {
+		n.ServeHTTP(httptest.NewRecorder(), newAccountingRequest(t, addr))
+	}
+
+	_, ok := a.Client("10.0.0.2")
+	expect(t, ok, false)
+	_, ok = a.Client("10.0.0.1")
+	expect(t, ok, true)
+	expect(t, len(a.TopTalkers(-1)), 2)
+}
+
+func TestAccountingEvictionActive(t *testing.T) {
+	a := NewAccounting()
+	a.MaxClients = 1
+	a.MaxActive = 1
+
+	inner := httptest.NewRecorder()
+	n := New(a)
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		if r.RemoteAddr != "10.0.0.1:1" {
+			return
+		}
+		// churning through addresses doesn't reset the active requests of the client
+		for _, addr := range []string{"10.0.0.2:1", "10.0.0.3:1"} {
+			n.ServeHTTP(httptest.NewRecorder(), newAccountingRequest(t, addr))
+		}
+		n.ServeHTTP(inner, newAccountingRequest(t, r.RemoteAddr))
+	})
+
+	n.ServeHTTP(httptest.NewRecorder(), newAccountingRequest(t, "10.0.0.1:1"))
+	expect(t, inner.Code, http.StatusTooManyRequests)
+	_, ok := a.Client("10.0.0.2")
+	expect(t, ok, false)
+}
+
+func TestAccountingClientIP(t *testing.T) {
+	a := NewAccounting()
+	a.ClientIP, _ = NewClientIP("10.0.0.0/8")
+
+	n := New(a)
+	req := newAccountingRequest(t, "10.0.0.1:1")
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	n.ServeHTTP(httptest.NewRecorder(), req)
+
+	_, ok := a.Client("192.0.2.1")
+	expect(t, ok, true)
+	_, ok = a.Client("10.0.0.1")
+	expect(t, ok, false)
+}
+
+func TestAccountingMaxActive(t *testing.T) {
+	a := NewAccounting()
+	a.MaxActive = 1
+
+	inner := httptest.NewRecorder()
+	n := New(a)
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		// a second concurrent request from the same client
+		n.ServeHTTP(inner, newAccountingRequest(t, r.RemoteAddr))
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	outer := httptest.NewRecorder()
+	n.ServeHTTP(outer, newAccountingRequest(t, "10.0.0.1:1"))
+	expect(t, outer.Code, http.StatusOK)
+	expect(t, inner.Code, http.StatusTooManyRequests)
+}
+
+func TestAccountingBlock(t *testing.T) {
+	a := NewAccounting()
+	a.Block = func(c ClientStats) bool {
+		return c.Requests > 1
+	}
+
+	n := New(a)
+	first := httptest.NewRecorder()
+	n.ServeHTTP(first, newAccountingRequest(t, "10.0.0.1:1"))
+	second := httptest.NewRecorder()
+	n.ServeHTTP(second, newAccountingRequest(t, "10.0.0.1:1"))
+
+	expect(t, first.Code, http.StatusOK)
+	expect(t, second.Code, http.StatusTooManyRequests)
+}
+
+func TestAccountingHandler(t *testing.T) {
+	a := NewAccounting()
+	n := New(a)
+	for _, addr := range []string{"10.0.0.1:1", "10.0.0.2:1", "10.0.0.2:1"} {
+		n.ServeHTTP(httptest.NewRecorder(), newAccountingRequest(t, addr))
+	}
+
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:3000/admin/clients?n=1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	a.Handler().ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusOK)
+
+	var top []ClientStats
+	if err := json.Unmarshal(recorder.Body.Bytes(), &top); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, len(top), 1)
+	expect(t, top[0].IP, "10.0.0.2")
+	expect(t, top[0].Requests, uint64(2))
+}
@@ -0,0 +1,128 @@
+package camillo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// immutableCacheControl is the Cache-Control header of the fingerprinted assets, whose
+// contents never change under the same name.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// AssetManifest maps the names of the assets, such as "app.css", to fingerprinted names
+// derived from a hash of their contents, such as "app-3f9ab2c1.css", so the assets can be
+// cached forever and are still refetched when they change. A Static with the manifest in
+// Assets serves the fingerprinted names with immutable caching:
+//
+//	manifest, err := camillo.NewAssetManifest(http.Dir("public"), "/assets")
+//	s := camillo.NewStatic(http.Dir("public"))
+//	s.Prefix = "/assets"
+//	s.Assets = manifest
+//	tmpl := template.New("page").Funcs(manifest.FuncMap())
+//
+// and templates link {{AssetPath "app.css"}}, rendered as /assets/app-3f9ab2c1.css.
+type AssetManifest struct {
+	// Prefix is the URL prefix of the assets, the Prefix of the Static serving them.
+	Prefix string
+
+	paths map[string]string
+	names map[string]string
+}
+
+// NewAssetManifest fingerprints the files of dir, except the dot files, for the assets
+// served under prefix. It reads every file, so it is meant to run at startup.
+func NewAssetManifest(dir http.FileSystem, prefix string) (*AssetManifest, error) {
+	m := &AssetManifest{Prefix: prefix, paths: map[string]string{}, names: map[string]string{}}
+	if err := m.walk(dir, "/"); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// LoadAssetManifest reads a manifest written by AssetManifest.Write, such as one produced
+// at build time, for the assets served under prefix.
+func LoadAssetManifest(r io.Reader, prefix string) (*AssetManifest, error) {
+	var paths map[string]string
+	if err := json.NewDecoder(r).Decode(&paths); err != nil {
+		return nil, err
+	}
+	m := &AssetManifest{Prefix: prefix, paths: paths, names: make(map[string]string, len(paths))}
+	for name, fingerprinted := range paths {
+		m.names[fingerprinted] = name
+	}
+	return m, nil
+}
+
+func (m *AssetManifest) walk(dir http.FileSystem, name string) error {
+	f, err := dir.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fi.IsDir() {
+		infos, err := f.Readdir(-1)
+		if err != nil {
+			return err
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+		for _, info := range infos {
+			if !strings.HasPrefix(info.Name(), ".") {
+				if err := m.walk(dir, path.Join(name, info.Name())); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	ext := path.Ext(name)
+	logical := strings.TrimPrefix(name, "/")
+	fingerprinted := strings.TrimSuffix(logical, ext) + "-" + hex.EncodeToString(h.Sum(nil)[:4]) + ext
+	m.paths[logical] = fingerprinted
+	m.names[fingerprinted] = logical
+	return nil
+}
+
+// AssetPath returns the URL of the fingerprinted asset name, or the URL of name when it
+// isn't in the manifest.
+func (m *AssetManifest) AssetPath(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if fingerprinted, ok := m.paths[name]; ok {
+		name = fingerprinted
+	}
+	return strings.TrimSuffix(m.Prefix, "/") + "/" + name
+}
+
+// FuncMap returns the AssetPath template function.
+func (m *AssetManifest) FuncMap() template.FuncMap {
+	return template.FuncMap{"AssetPath": m.AssetPath}
+}
+
+// Resolve returns the name of the asset of a fingerprinted name.
+func (m *AssetManifest) Resolve(fingerprinted string) (string, bool) {
+	name, ok := m.names[strings.TrimPrefix(fingerprinted, "/")]
+	return name, ok
+}
+
+// Write writes the manifest as JSON, for LoadAssetManifest.
+func (m *AssetManifest) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m.paths)
+}
@@ -0,0 +1,70 @@
+package camillo
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAssetManifest(t *testing.T) {
+	dir := http.FS(fstest.MapFS{
+		"app.css":    {Data: []byte("body {}")},
+		"js/app.js":  {Data: []byte("console.log(1)")},
+		".gitignore": {Data: []byte("*")},
+	})
+	m, err := NewAssetManifest(dir, "/assets/")
+	expect(t, err, nil)
+
+	expect(t, m.AssetPath("app.css"), "/assets/app-62368a1a.css")
+	expect(t, m.AssetPath("/js/app.js"), "/assets/js/app-0a286891.js")
+	expect(t, m.AssetPath("missing.png"), "/assets/missing.png")
+	expect(t, m.AssetPath(".gitignore"), "/assets/.gitignore")
+
+	name, ok := m.Resolve("/js/app-0a286891.js")
+	expect(t, ok, true)
+	expect(t, name, "js/app.js")
+	_, ok = m.Resolve("app.css")
+	expect(t, ok, false)
+
+	var page bytes.Buffer
+	tmpl := template.Must(template.New("page").Funcs(m.FuncMap()).Parse(`<link href="{{AssetPath "app.css"}}">`))
+	tmpl.Execute(&page, nil)
+	expect(t, page.String(), `<link href="/assets/app-62368a1a.css">`)
+
+	var manifest bytes.Buffer
+	expect(t, m.Write(&manifest), nil)
+	loaded, err := LoadAssetManifest(&manifest, "/static")
+	expect(t, err, nil)
+	expect(t, loaded.AssetPath("app.css"), "/static/app-62368a1a.css")
+}
+
+func TestStaticAssets(t *testing.T) {
+	dir := http.FS(fstest.MapFS{
+		"app.css": {Data: []byte("body {}")},
+	})
+	m, _ := NewAssetManifest(dir, "/assets")
+	s := NewStatic(dir)
+	s.Prefix = "/assets"
+	s.Assets = m
+	s.CachePolicies = []StaticCachePolicy{{Pattern: "*.css", CacheControl: "no-cache"}}
+
+	n := New(s)
+	serve := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		n.ServeHTTP(response, httptest.NewRequest("GET", "http://localhost:3000"+path, nil))
+		return response
+	}
+
+	response := serve(m.AssetPath("app.css"))
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Body.String(), "body {}")
+	expect(t, response.Header().Get("Content-Type"), "text/css; charset=utf-8")
+	expect(t, response.Header().Get("Cache-Control"), "public, max-age=31536000, immutable")
+
+	response = serve("/assets/app.css")
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Header().Get("Cache-Control"), "no-cache")
+}
@@ -0,0 +1,130 @@
+package camillo
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// AsyncLogSink is a LeveledLogSink that queues messages in memory and writes them to
+// another sink from a background goroutine, so a slow destination such as a disk, a pipe
+// or a remote syslog can't add latency to the requests being logged. Messages logged while
+// the queue is full are dropped and counted.
+type AsyncLogSink struct {
+	sink  LogSink
+	queue chan asyncLogEntry
+	done  chan struct{}
+
+	mtx    sync.RWMutex
+	closed bool
+
+	written uint64
+	dropped uint64
+}
+
+type asyncLogEntry struct {
+	leveled bool
+	level   LogLevel
+	msg     string
+	flushed chan struct{}
+}
+
+// AsyncLogStats holds the counters of an AsyncLogSink.
+type AsyncLogStats struct {
+	// Queued is the number of messages waiting to be written.
+	Queued int
+	// Written is the number of messages written to the underlying sink.
+	Written uint64
+	// Dropped is the number of messages dropped because the queue was full or the sink
+	// was closed.
+	Dropped uint64
+}
+
+// NewAsyncLogSink returns a new AsyncLogSink writing to sink with a queue of size
+// messages. Close should be called before exiting to write the queued messages.
+func NewAsyncLogSink(sink LogSink, size int) *AsyncLogSink {
+	s := &AsyncLogSink{
+		sink:  sink,
+		queue: make(chan asyncLogEntry, size),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Printf queues the message to be written with the Printf method of the underlying sink.
+func (s *AsyncLogSink) Printf(format string, v ...interface{}) {
+	s.enqueue(asyncLogEntry{msg: fmt.Sprintf(format, v...)})
+}
+
+// Logf queues the message to be written at level. Messages are written with Printf when
+// the underlying sink isn't leveled.
+func (s *AsyncLogSink) Logf(level LogLevel, format string, v ...interface{}) {
+	s.enqueue(asyncLogEntry{leveled: true, level: level, msg: fmt.Sprintf(format, v...)})
+}
+
+func (s *AsyncLogSink) enqueue(entry asyncLogEntry) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	if s.closed {
+		atomic.AddUint64(&s.dropped, 1)
+		return
+	}
+	select {
+	case s.queue <- entry:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Flush blocks until the messages queued before the call have been written.
+func (s *AsyncLogSink) Flush() {
+	s.mtx.RLock()
+	if s.closed {
+		s.mtx.RUnlock()
+		return
+	}
+	flushed := make(chan struct{})
+	s.queue <- asyncLogEntry{flushed: flushed}
+	s.mtx.RUnlock()
+	<-flushed
+}
+
+// Close writes the queued messages and stops the background goroutine. Messages logged
+// after Close are dropped.
+func (s *AsyncLogSink) Close() error {
+	s.mtx.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mtx.Unlock()
+	<-s.done
+	return nil
+}
+
+// Stats returns the current counters of the sink.
+func (s *AsyncLogSink) Stats() AsyncLogStats {
+	return AsyncLogStats{
+		Queued:  len(s.queue),
+		Written: atomic.LoadUint64(&s.written),
+		Dropped: atomic.LoadUint64(&s.dropped),
+	}
+}
+
+func (s *AsyncLogSink) run() {
+	defer close(s.done)
+	for entry := range s.queue {
+		switch {
+		case entry.flushed != nil:
+			close(entry.flushed)
+			continue
+		case entry.leveled:
+			logf(s.sink, entry.level, "%s", entry.msg)
+		default:
+			s.sink.Printf("%s", entry.msg)
+		}
+		atomic.AddUint64(&s.written, 1)
+	}
+}
@@ -0,0 +1,61 @@
+package camillo
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAsyncLogSink(t *testing.T) {
+	var recorder leveledRecorder
+	sink := NewAsyncLogSink(&recorder, 16)
+
+	l := NewLogger()
+	l.Logger = sink
+	n := New(l)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost:3000/foobar", nil)
+	n.ServeHTTP(httptest.NewRecorder(), req)
+	sink.Flush()
+
+	expect(t, len(recorder), 2)
+	expect(t, recorder[0], "info Started GET /foobar")
+	expect(t, recorder[1][:len("warn Completed 404")], "warn Completed 404")
+	expect(t, sink.Stats(), AsyncLogStats{Written: 2})
+
+	sink.Close()
+	sink.Printf("after close")
+	expect(t, sink.Stats(), AsyncLogStats{Written: 2, Dropped: 1})
+}
+
+func TestAsyncLogSinkDropsWhenFull(t *testing.T) {
+	var mtx sync.Mutex
+	var lines []string
+	blocked := make(chan struct{})
+	sink := NewAsyncLogSink(LogSinkFunc(func(format string, v ...interface{}) {
+		<-blocked
+		mtx.Lock()
+		lines = append(lines, fmt.Sprintf(format, v...))
+		mtx.Unlock()
+	}), 2)
+
+	// the first message is taken by the background goroutine, which blocks on it
+	sink.Printf("message %d", 0)
+	for sink.Stats().Queued != 0 {
+	}
+	for i := 1; i <= 4; i++ {
+		sink.Printf("message %d", i)
+	}
+	expect(t, sink.Stats(), AsyncLogStats{Queued: 2, Dropped: 2})
+
+	close(blocked)
+	sink.Close()
+	expect(t, strings.Join(lines, ","), "message 0,message 1,message 2")
+	expect(t, sink.Stats(), AsyncLogStats{Written: 3, Dropped: 2})
+}
@@ -0,0 +1,195 @@
+package camillo
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// AuditRecord is a record of an audit log. Hash is the SHA-256 of PrevHash and the record
+// as written without its hash field, so every record vouches for all the records before it.
+type AuditRecord struct {
+	Seq uint64 `json:"seq"`
+	LoggerEntry
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// AuditLog is a middleware handler that writes a tamper-evident access trail: one
+// hash-chained JSON record per request, appended to a file. Editing, removing or reordering
+// records before the last one breaks the chain, which VerifyAuditLog detects. Removing the
+// last records leaves a valid chain, so the truncations are only detected by comparing
+// the log with its Head, kept elsewhere, such as in another system's logs.
+type AuditLog struct {
+	// Logger receives the errors writing the audit log
+	Logger LogSink
+	// Clock is used to time requests
+	Clock Clock
+	// ClientIP resolves the client IP addresses behind trusted proxies.
+	ClientIP *ClientIP
+	// Sync flushes every record to disk before the request completes.
+	Sync bool
+
+	mtx  sync.Mutex
+	w    io.Writer
+	seq  uint64
+	prev string
+}
+
+// NewAuditLog returns a new AuditLog starting a new chain in w
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{
+		Logger: log.New(os.Stdout, "[camillo] ", 0),
+		Clock:  SystemClock,
+		w:      w,
+	}
+}
+
+// OpenAuditLog returns a new AuditLog appending to the file filename, continuing the chain
+// of its existing records. The chain is verified first, and an error is returned when it
+// is broken.
+func OpenAuditLog(filename string) (*AuditLog, error) {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	last, err := verifyAuditLog(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	a := NewAuditLog(file)
+	a.seq = last.Seq
+	a.prev = last.Hash
+	return a, nil
+}
+
+func (a *AuditLog) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	start := clockNow(a.Clock)
+	next(ctx, rw, r)
+
+	res := rw.(ResponseWriter)
+	duration := clockNow(a.Clock).Sub(start)
+	remoteIP := remoteIP(r)
+	if a.ClientIP != nil {
+		remoteIP = a.ClientIP.Resolve(r)
+	}
+
+	err := a.Append(LoggerEntry{
+		Time:         start,
+		Method:       r.Method,
+		Path:         r.URL.Path,
+		Status:       res.Status(),
+		Duration:     duration,
+		DurationMS:   float64(duration) / float64(time.Millisecond),
+		Bytes:        res.Size(),
+		RequestBytes: r.ContentLength,
+		RemoteIP:     remoteIP,
+		RequestID:    requestID(ctx, r, res),
+	})
+	if err != nil {
+		logf(a.Logger, LogLevelError, "%sfailed to write the audit log: %s", logPrefix(requestID(ctx, r, res)), err)
+	}
+}
+
+// Append appends a record of entry to the chain.
+func (a *AuditLog) Append(entry LoggerEntry) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	record := AuditRecord{Seq: a.seq + 1, LoggerEntry: entry, PrevHash: a.prev}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = auditPayload(line)
+	record.Hash = auditHash(record.PrevHash, line)
+	line = append(line[:len(line)-1], `,"hash":"`+record.Hash+`"}`+"\n"...)
+
+	if _, err := a.w.Write(line); err != nil {
+		return err
+	}
+	if file, ok := a.w.(*os.File); ok && a.Sync {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	a.seq = record.Seq
+	a.prev = record.Hash
+	return nil
+}
+
+// Head returns the sequence number and the hash of the last record, which an audit log
+// truncated after it no longer ends with.
+func (a *AuditLog) Head() (uint64, string) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.seq, a.prev
+}
+
+// Close closes the file of the audit log when it is an io.Closer.
+func (a *AuditLog) Close() error {
+	if closer, ok := a.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// VerifyAuditLog reads the records of an audit log and verifies their chain. It returns the
+// number of valid records and an error describing the first broken record.
+func VerifyAuditLog(r io.Reader) (int, error) {
+	last, err := verifyAuditLog(r)
+	return int(last.Seq), err
+}
+
+// auditHashField is the suffix of a record holding its hash, after the payload.
+const auditHashField = len(`,"hash":""}`) + sha256.Size*2
+
+func verifyAuditLog(r io.Reader) (AuditRecord, error) {
+	var last AuditRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var record AuditRecord
+		if err := json.Unmarshal(line, &record); err != nil || len(line) < auditHashField {
+			return last, fmt.Errorf("camillo: audit record %d is malformed", last.Seq+1)
+		}
+		end := len(line) - auditHashField
+		payload := append(line[:end:end], '}')
+		switch {
+		case record.Seq != last.Seq+1:
+			return last, fmt.Errorf("camillo: audit record %d follows record %d", record.Seq, last.Seq)
+		case record.PrevHash != last.Hash:
+			return last, fmt.Errorf("camillo: audit record %d doesn't chain to record %d", record.Seq, last.Seq)
+		case string(line[end:]) != `,"hash":"`+record.Hash+`"}` || auditHash(record.PrevHash, payload) != record.Hash:
+			return last, fmt.Errorf("camillo: audit record %d has been modified", record.Seq)
+		}
+		last = record
+	}
+	return last, scanner.Err()
+}
+
+// auditPayload returns a marshaled record without its empty hash field.
+func auditPayload(line []byte) []byte {
+	return append(bytes.TrimSuffix(line, []byte(`,"hash":""}`)), '}')
+}
+
+func auditHash(prev string, payload []byte) string {
+	h := sha256.New()
+	io.WriteString(h, prev)
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,103 @@
+package camillo
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buff bytes.Buffer
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+	a := NewAuditLog(&buff)
+	a.Clock = clock
+
+	n := New(a)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		clock.Advance(time.Millisecond)
+		rw.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		req, _ := http.NewRequest("DELETE", "http://localhost:3000"+path, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buff.String(), "\n"), "\n")
+	expect(t, len(lines), 3)
+
+	var first, second AuditRecord
+	expect(t, json.Unmarshal([]byte(lines[0]), &first), nil)
+	expect(t, json.Unmarshal([]byte(lines[1]), &second), nil)
+	expect(t, first.Seq, uint64(1))
+	expect(t, first.Path, "/a")
+	expect(t, first.Status, http.StatusNoContent)
+	expect(t, first.RemoteIP, "10.0.0.1")
+	expect(t, first.PrevHash, "")
+	expect(t, len(first.Hash), 64)
+	expect(t, second.PrevHash, first.Hash)
+
+	var third AuditRecord
+	expect(t, json.Unmarshal([]byte(lines[2]), &third), nil)
+	seq, hash := a.Head()
+	expect(t, seq, uint64(3))
+	expect(t, hash, third.Hash)
+
+	count, err := VerifyAuditLog(strings.NewReader(buff.String()))
+	expect(t, count, 3)
+	expect(t, err, nil)
+}
+
+func TestAuditLogTampering(t *testing.T) {
+	var buff bytes.Buffer
+	a := NewAuditLog(&buff)
+	for _, status := range []int{200, 403, 200} {
+		a.Append(LoggerEntry{Method: "GET", Path: "/admin", Status: status})
+	}
+	lines := strings.SplitAfter(buff.String(), "\n")
+
+	modified := strings.Replace(buff.String(), `"status":403`, `"status":200`, 1)
+	count, err := VerifyAuditLog(strings.NewReader(modified))
+	expect(t, count, 1)
+	expect(t, err.Error(), "camillo: audit record 2 has been modified")
+
+	count, err = VerifyAuditLog(strings.NewReader(lines[0] + lines[2]))
+	expect(t, count, 1)
+	expect(t, err.Error(), "camillo: audit record 3 follows record 1")
+
+	count, err = VerifyAuditLog(strings.NewReader(lines[0] + lines[1]))
+	expect(t, count, 2)
+	expect(t, err, nil)
+}
+
+func TestOpenAuditLog(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "audit.log")
+
+	a, err := OpenAuditLog(name)
+	expect(t, err, nil)
+	a.Append(LoggerEntry{Path: "/first"})
+	a.Close()
+
+	a, err = OpenAuditLog(name)
+	expect(t, err, nil)
+	a.Append(LoggerEntry{Path: "/second"})
+	a.Close()
+
+	file, _ := os.Open(name)
+	defer file.Close()
+	count, err := VerifyAuditLog(file)
+	expect(t, count, 2)
+	expect(t, err, nil)
+
+	b, _ := os.ReadFile(name)
+	os.WriteFile(name, bytes.Replace(b, []byte("/first"), []byte("/other"), 1), 0600)
+	_, err = OpenAuditLog(name)
+	expect(t, err.Error(), "camillo: audit record 1 has been modified")
+}
@@ -0,0 +1,78 @@
+package camillo
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/context"
+)
+
+// AutoTLS obtains and renews the certificates of its domains from Let's Encrypt, and is a
+// middleware handler answering the HTTP-01 challenges of these domains, for the servers
+// where :80 already runs a camillo stack. RunAutoTLS uses it to serve HTTPS:
+//
+//	a := camillo.NewAutoTLS("example.com", "www.example.com")
+//	a.CacheDir = "/var/lib/app/certs"
+//	n.Use(a)
+//	n.RunAutoTLS()
+//
+// Using Let's Encrypt means accepting its terms of service.
+type AutoTLS struct {
+	// Domains are the host names certificates are obtained for. The TLS handshakes and the
+	// challenges for the other host names fail.
+	Domains []string
+	// CacheDir is the directory the certificates are stored in, so they survive restarts.
+	// It should only be readable by the server.
+	CacheDir string
+	// Email is the optional contact address of the Let's Encrypt account, for the notices
+	// about the certificates.
+	Email string
+
+	once sync.Once
+	m    *autocert.Manager
+}
+
+// NewAutoTLS returns a new instance of AutoTLS for domains, caching the certificates in
+// the certs directory
+func NewAutoTLS(domains ...string) *AutoTLS {
+	return &AutoTLS{
+		Domains:  domains,
+		CacheDir: "certs",
+	}
+}
+
+func (a *AutoTLS) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	if r.TLS != nil || !acmeChallenge(r) {
+		next(ctx, rw, r)
+		return
+	}
+	a.manager().HTTPHandler(nil).ServeHTTP(rw, r)
+}
+
+// TLSConfig returns the configuration of the TLS servers using the certificates.
+func (a *AutoTLS) TLSConfig() *tls.Config {
+	return a.manager().TLSConfig()
+}
+
+// acmeChallenge returns whether r is an HTTP-01 challenge of the ACME servers.
+func acmeChallenge(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/.well-known/acme-challenge/")
+}
+
+// manager returns the autocert manager of a, built from its configuration the first time.
+func (a *AutoTLS) manager() *autocert.Manager {
+	a.once.Do(func() {
+		a.m = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(a.Domains...),
+			Email:      a.Email,
+		}
+		if a.CacheDir != "" {
+			a.m.Cache = autocert.DirCache(a.CacheDir)
+		}
+	})
+	return a.m
+}
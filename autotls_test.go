@@ -0,0 +1,28 @@
+package camillo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAutoTLS(t *testing.T) {
+	a := NewAutoTLS("example.com")
+	a.CacheDir = t.TempDir()
+	n := New(a)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	serve := func(host, path string) int {
+		response := httptest.NewRecorder()
+		n.ServeHTTP(response, httptest.NewRequest("GET", "http://"+host+path, nil))
+		return response.Code
+	}
+
+	// the challenges of unknown tokens and of other domains are answered, not passed on
+	expect(t, serve("example.com", "/.well-known/acme-challenge/token"), http.StatusNotFound)
+	expect(t, serve("attacker.com", "/.well-known/acme-challenge/token"), http.StatusForbidden)
+	expect(t, serve("example.com", "/"), http.StatusTeapot)
+
+	expect(t, a.TLSConfig().GetCertificate != nil, true)
+}
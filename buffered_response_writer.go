@@ -0,0 +1,274 @@
+package camillo
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// ErrResponseCommitted is returned when a buffered response can no longer be changed
+// because it has already been sent to the client.
+var ErrResponseCommitted = errors.New("camillo: response already committed")
+
+// BufferedResponseWriter is a ResponseWriter that holds the response in memory until it
+// is committed. Until then the response can be inspected, discarded with Rollback and
+// replaced, which allows custom error pages, retries and content rewriting.
+//
+// Flushing a BufferedResponseWriter commits the buffered response and passes all later
+// writes straight through, so streaming handlers keep working.
+type BufferedResponseWriter struct {
+	// MaxSize is the maximum number of bytes that are buffered. When the limit is
+	// exceeded the response is committed. Zero means no limit.
+	MaxSize int
+	// Clock times the commit of the response. Nil means the system clock.
+	Clock Clock
+
+	rw          http.ResponseWriter
+	header      http.Header
+	original    http.Header
+	status      int
+	size        int
+	buf         bytes.Buffer
+	committed   bool
+	hijacked    bool
+	beforeFuncs []beforeFunc
+	capture     *captureBuffer
+	snapshot    http.Header
+	firstByte   time.Time
+}
+
+// NewBufferedResponseWriter creates a BufferedResponseWriter that wraps an http.ResponseWriter
+func NewBufferedResponseWriter(rw http.ResponseWriter) *BufferedResponseWriter {
+	return &BufferedResponseWriter{
+		rw:       rw,
+		header:   rw.Header().Clone(),
+		original: rw.Header().Clone(),
+	}
+}
+
+func (b *BufferedResponseWriter) Header() http.Header {
+	if b.committed {
+		return b.rw.Header()
+	}
+	return b.header
+}
+
+func (b *BufferedResponseWriter) WriteHeader(s int) {
+	if b.committed {
+		b.rw.WriteHeader(s)
+		return
+	}
+	if b.status != 0 || (s >= 100 && s < 200 && s != http.StatusSwitchingProtocols) {
+		return
+	}
+	b.status = s
+}
+
+func (b *BufferedResponseWriter) Write(p []byte) (int, error) {
+	if !b.Written() {
+		// The status will be StatusOK if WriteHeader has not been called yet
+		b.WriteHeader(http.StatusOK)
+	}
+	if b.capture != nil {
+		b.capture.Write(p)
+	}
+	b.size += len(p)
+	if b.committed {
+		return b.rw.Write(p)
+	}
+	n, err := b.buf.Write(p)
+	if b.MaxSize > 0 && b.buf.Len() > b.MaxSize {
+		if err := b.Commit(); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// Body returns the currently buffered response body.
+func (b *BufferedResponseWriter) Body() []byte {
+	return b.buf.Bytes()
+}
+
+// Committed returns whether or not the response has been sent to the client.
+func (b *BufferedResponseWriter) Committed() bool {
+	return b.committed
+}
+
+// Commit sends the buffered status, headers and body to the client. The Before functions
+// are called right before the headers are written. A response whose headers were set
+// without being written is sent with a 200, as it would have been without buffering, and
+// committing a response left untouched does nothing.
+func (b *BufferedResponseWriter) Commit() error {
+	if b.committed || b.hijacked {
+		return nil
+	}
+	if b.status == 0 {
+		if reflect.DeepEqual(b.header, b.original) {
+			return nil
+		}
+		b.status = http.StatusOK
+	}
+
+	for i := len(b.beforeFuncs) - 1; i >= 0; i-- {
+		b.beforeFuncs[i](b)
+	}
+	b.snapshot = b.header.Clone()
+
+	// trailers set before the commit are sent after the body, as they would have been
+	// without buffering
+	h := b.rw.Header()
+	for k := range h {
+		delete(h, k)
+	}
+	trailers := make(http.Header)
+	for k, v := range b.header {
+		if isTrailer(b.header, k) {
+			trailers[k] = v
+			continue
+		}
+		h[k] = v
+	}
+
+	b.committed = true
+	b.firstByte = clockNow(b.Clock)
+	b.rw.WriteHeader(b.status)
+	_, err := b.rw.Write(b.buf.Bytes())
+	b.buf.Reset()
+	for k, v := range trailers {
+		h[k] = v
+	}
+	return err
+}
+
+// Rollback discards the buffered status, headers and body so a new response can be
+// written. It returns ErrResponseCommitted when the response was already sent.
+func (b *BufferedResponseWriter) Rollback() error {
+	if b.committed || b.hijacked {
+		return ErrResponseCommitted
+	}
+	b.status = 0
+	b.size = 0
+	b.buf.Reset()
+	b.header = b.original.Clone()
+	if b.capture != nil {
+		b.capture = &captureBuffer{limit: b.capture.limit}
+	}
+	return nil
+}
+
+func (b *BufferedResponseWriter) Status() int {
+	return b.status
+}
+
+func (b *BufferedResponseWriter) Size() int {
+	return b.size
+}
+
+// FirstByteTime returns the time the response was committed.
+func (b *BufferedResponseWriter) FirstByteTime() time.Time {
+	return b.firstByte
+}
+
+func (b *BufferedResponseWriter) SetWriteDeadline(deadline time.Time) error {
+	return http.NewResponseController(b.rw).SetWriteDeadline(deadline)
+}
+
+func (b *BufferedResponseWriter) Written() bool {
+	return b.status != 0 || b.hijacked
+}
+
+func (b *BufferedResponseWriter) WrittenHeader() http.Header {
+	return b.snapshot
+}
+
+func (b *BufferedResponseWriter) Before(before func(ResponseWriter)) {
+	b.beforeFuncs = append(b.beforeFuncs, before)
+}
+
+func (b *BufferedResponseWriter) Capture(limit int) {
+	if b.capture == nil {
+		b.capture = &captureBuffer{}
+	}
+	if limit > b.capture.limit {
+		b.capture.limit = limit
+	}
+}
+
+func (b *BufferedResponseWriter) Captured() ([]byte, bool) {
+	if b.capture == nil {
+		return nil, false
+	}
+	return b.capture.buf, b.capture.truncated
+}
+
+// Flush commits the buffered response and flushes it to the client.
+func (b *BufferedResponseWriter) Flush() {
+	if !b.Written() {
+		b.WriteHeader(http.StatusOK)
+	}
+	b.Commit()
+	if flusher, ok := b.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (b *BufferedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := b.rw.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err == nil {
+		b.hijacked = true
+	}
+	return conn, brw, err
+}
+
+// Unwrap returns the wrapped http.ResponseWriter. http.ResponseController flushes through
+// Flush, so the buffered response is committed first.
+func (b *BufferedResponseWriter) Unwrap() http.ResponseWriter {
+	return b.rw
+}
+
+func (b *BufferedResponseWriter) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := b.rw.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
+}
+
+func (b *BufferedResponseWriter) CloseNotify() <-chan bool {
+	return b.rw.(http.CloseNotifier).CloseNotify()
+}
+
+// Buffer is a middleware handler that buffers the responses of the handlers after it
+// and commits them once the chain completes. Handlers further down the chain can
+// discard a buffered response through the *BufferedResponseWriter they are passed.
+type Buffer struct {
+	// MaxSize is the maximum number of bytes that are buffered per response.
+	MaxSize int
+}
+
+// NewBuffer returns a new instance of Buffer
+func NewBuffer() *Buffer {
+	return &Buffer{
+		MaxSize: 1024 * 1024 * 4,
+	}
+}
+
+func (buf *Buffer) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	b := NewBufferedResponseWriter(rw)
+	b.MaxSize = buf.MaxSize
+
+	next(ctx, b, r)
+	b.Commit()
+}
@@ -0,0 +1,224 @@
+package camillo
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestBufferedResponseWriterCommit(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewBufferedResponseWriter(rec)
+	result := ""
+
+	rw.Before(func(rw ResponseWriter) {
+		result += "foo"
+		rw.Header().Set("X-Status", http.StatusText(rw.Status()))
+	})
+	rw.Header().Set("Content-Type", "text/plain")
+	rw.WriteHeader(http.StatusCreated)
+	rw.Write([]byte("Hello world"))
+
+	expect(t, rw.Written(), true)
+	expect(t, rw.Status(), http.StatusCreated)
+	expect(t, string(rw.Body()), "Hello world")
+	expect(t, rec.Body.Len(), 0)
+	expect(t, result, "")
+
+	if err := rw.Commit(); err != nil {
+		t.Error(err)
+	}
+
+	expect(t, rw.Committed(), true)
+	expect(t, rec.Code, http.StatusCreated)
+	expect(t, rec.Body.String(), "Hello world")
+	expect(t, rec.Header().Get("Content-Type"), "text/plain")
+	expect(t, rec.Header().Get("X-Status"), "Created")
+	expect(t, rw.WrittenHeader().Get("X-Status"), "Created")
+	expect(t, rw.Size(), 11)
+	expect(t, result, "foo")
+	expect(t, rw.Rollback(), ErrResponseCommitted)
+}
+
+func TestBufferedResponseWriterRollback(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Outer", "kept")
+	rw := NewBufferedResponseWriter(rec)
+
+	rw.Header().Set("X-Inner", "dropped")
+	rw.WriteHeader(http.StatusInternalServerError)
+	rw.Write([]byte("stack trace"))
+
+	if err := rw.Rollback(); err != nil {
+		t.Error(err)
+	}
+	expect(t, rw.Written(), false)
+	expect(t, rw.Size(), 0)
+
+	rw.WriteHeader(http.StatusServiceUnavailable)
+	rw.Write([]byte("try again later"))
+	rw.Commit()
+
+	expect(t, rec.Code, http.StatusServiceUnavailable)
+	expect(t, rec.Body.String(), "try again later")
+	expect(t, rec.Header().Get("X-Outer"), "kept")
+	expect(t, rec.Header().Get("X-Inner"), "")
+}
+
+func TestBufferedResponseWriterFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewBufferedResponseWriter(rec)
+
+	rw.Write([]byte("chunk 1 "))
+	rw.Flush()
+	expect(t, rec.Flushed, true)
+	expect(t, rw.Committed(), true)
+	expect(t, rec.Body.String(), "chunk 1 ")
+
+	rw.Write([]byte("chunk 2"))
+	expect(t, rec.Body.String(), "chunk 1 chunk 2")
+	expect(t, rw.Size(), 15)
+}
+
+func TestBufferedResponseWriterMaxSize(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewBufferedResponseWriter(rec)
+	rw.MaxSize = 4
+
+	rw.Write([]byte("foo"))
+	expect(t, rw.Committed(), false)
+	rw.Write([]byte("bar"))
+	expect(t, rw.Committed(), true)
+	expect(t, rec.Body.String(), "foobar")
+}
+
+func TestBufferedResponseWriterCommitUnwritten(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewBufferedResponseWriter(rec)
+
+	if err := rw.Commit(); err != nil {
+		t.Error(err)
+	}
+	expect(t, rw.Committed(), false)
+	expect(t, rw.Written(), false)
+}
+
+func TestBufferedResponseWriterCommitHeaders(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewBufferedResponseWriter(rec)
+	var before bool
+	rw.Before(func(ResponseWriter) { before = true })
+
+	// the headers set without writing are sent with an implicit 200
+	rw.Header().Set("Set-Cookie", "session=abc")
+	if err := rw.Commit(); err != nil {
+		t.Error(err)
+	}
+	expect(t, rw.Committed(), true)
+	expect(t, before, true)
+	expect(t, rec.Code, http.StatusOK)
+	expect(t, rec.Header().Get("Set-Cookie"), "session=abc")
+}
+
+func TestBufferedResponseWriterInterfaces(t *testing.T) {
+	var rw ResponseWriter = NewBufferedResponseWriter(newHijackableResponse())
+
+	_, ok := rw.(http.Hijacker)
+	expect(t, ok, true)
+	_, ok = rw.(http.Pusher)
+	expect(t, ok, true)
+	_, _, err := rw.(http.Hijacker).Hijack()
+	if err != nil {
+		t.Error(err)
+	}
+	expect(t, rw.Written(), true)
+}
+
+func TestBuffer(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	n := New()
+	n.Use(NewBuffer())
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		next(ctx, rw, r)
+
+		// replace error responses written further down the chain
+		if b := rw.(*BufferedResponseWriter); b.Status() >= 500 {
+			b.Rollback()
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			rw.Write([]byte("maintenance"))
+		}
+	})
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, "database is down", http.StatusInternalServerError)
+	})
+
+	n.ServeHTTP(recorder, (*http.Request)(nil))
+	expect(t, recorder.Code, http.StatusServiceUnavailable)
+	expect(t, recorder.Body.String(), "maintenance")
+}
+
+func TestBufferedResponseWriterFirstByteTime(t *testing.T) {
+	rw := NewBufferedResponseWriter(httptest.NewRecorder())
+
+	rw.Write([]byte("Hello world"))
+	expect(t, rw.FirstByteTime().IsZero(), true)
+
+	before := time.Now()
+	rw.Commit()
+	expect(t, rw.FirstByteTime().Before(before), false)
+}
+
+func TestBufferedResponseWriterFirstByteClock(t *testing.T) {
+	now := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	rw := NewBufferedResponseWriter(httptest.NewRecorder())
+	rw.Clock = NewManualClock(now)
+
+	rw.Write([]byte("Hello world"))
+	rw.Commit()
+	expect(t, rw.FirstByteTime(), now)
+}
+
+func TestBufferedResponseWriterResponseController(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewBufferedResponseWriter(rec)
+
+	rw.Write([]byte("Hello world"))
+	if err := http.NewResponseController(rw).Flush(); err != nil {
+		t.Error(err)
+	}
+	expect(t, rw.Committed(), true)
+	expect(t, rec.Body.String(), "Hello world")
+
+	err := http.NewResponseController(rw).SetWriteDeadline(time.Now())
+	expect(t, errors.Is(err, http.ErrNotSupported), true)
+}
+
+func TestBufferedResponseWriterTrailers(t *testing.T) {
+	n := New(NewBuffer())
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		DeclareTrailer(rw, "X-Checksum")
+		rw.Write([]byte("Hello world"))
+		rw.Header().Set("X-Checksum", "abc")
+		AddTrailer(rw, "Server-Timing", "app;dur=1")
+	})
+	server := httptest.NewServer(n)
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	expect(t, string(body), "Hello world")
+	expect(t, res.Header.Get("X-Checksum"), "")
+	expect(t, res.Trailer.Get("X-Checksum"), "abc")
+	expect(t, res.Trailer.Get("Server-Timing"), "app;dur=1")
+}
@@ -4,6 +4,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/context"
 )
@@ -63,11 +66,36 @@ func Wrap(handler http.Handler) Handler {
 // Camillo middleware is evaluated in the order that they are added to the stack using
 // the Use and UseHandler methods.
 type Camillo struct {
-	ctx        context.Context
+	// ShutdownTimeout is how long Run waits for the requests in flight to complete when
+	// it shuts down, before closing their connections. Zero means no limit.
+	ShutdownTimeout time.Duration
+	// ShutdownDelay is how long Run keeps accepting connections once signaled to stop,
+	// while the stack drains, so the readiness probes of Health fail and the load balancers
+	// take the server out of rotation before it refuses connections. A second signal cuts
+	// it short.
+	ShutdownDelay time.Duration
+	// Logger receives the messages of Run
+	Logger LogSink
+	// Clock times the first byte of the responses. Nil means the system clock.
+	Clock Clock
+
+	ctx   context.Context
+	mtx   sync.Mutex
+	stack atomic.Value
+	drain Drain
+}
+
+// stack is a built middleware chain. It is never modified once it is in use, so a request
+// keeps running on the stack it started on.
+type stack struct {
 	middleware middleware
 	handlers   []Handler
 }
 
+func newStack(handlers []Handler) *stack {
+	return &stack{middleware: build(handlers), handlers: handlers}
+}
+
 // New returns a new Camillo instance with no middleware preconfigured.
 func New(handlers ...Handler) *Camillo {
 	return NewWithContext(context.Background(), handlers...)
@@ -75,11 +103,16 @@ func New(handlers ...Handler) *Camillo {
 
 // NewWithContext returns a new Camillo instance with no middleware preconfigured.
 func NewWithContext(ctx context.Context, handlers ...Handler) *Camillo {
-	return &Camillo{
-		ctx:        ctx,
-		handlers:   handlers,
-		middleware: build(handlers),
+	n := &Camillo{
+		ShutdownTimeout: 30 * time.Second,
+		Logger:          log.New(os.Stdout, "[camillo] ", 0),
+	}
+	if ctx == nil {
+		ctx = context.Background()
 	}
+	n.ctx = context.WithValue(ctx, drainKey{}, &n.drain)
+	n.stack.Store(newStack(handlers))
+	return n
 }
 
 // Classic returns a new Camillo instance with the default middleware already
@@ -92,12 +125,24 @@ func Classic() *Camillo {
 	return New(NewRecovery(), NewLogger(), NewStatic(http.Dir("public")))
 }
 
+// ServeHTTP runs the middleware stack. The ResponseWriter passed to the handlers is pooled,
+// so handlers must not use it after the stack has returned.
 func (n *Camillo) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	var ctx context.Context
 
+	atomic.AddInt64(&n.drain.inflight, 1)
+	defer atomic.AddInt64(&n.drain.inflight, -1)
+	if n.drain.Draining() {
+		rw.Header().Set("Connection", "close")
+	}
+
+	s := n.current()
+	res := acquireResponseWriter(rw, n.Clock)
+	defer releaseResponseWriter(res)
+
 	ctx = sharedContextStore.Get(r)
 	if ctx != nil {
-		n.middleware.ServeHTTP(ctx, NewResponseWriter(rw), r)
+		s.middleware.ServeHTTP(ctx, res, r)
 		return
 	}
 
@@ -111,13 +156,34 @@ func (n *Camillo) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	sharedContextStore.Push(r, ctx)
 	defer sharedContextStore.Pop(r, ctx)
 
-	n.middleware.ServeHTTP(ctx, NewResponseWriter(rw), r)
+	s.middleware.ServeHTTP(ctx, res, r)
 }
 
 // Use adds a Handler onto the middleware stack. Handlers are invoked in the order they are added to a Camillo.
 func (n *Camillo) Use(handler Handler) {
-	n.handlers = append(n.handlers, handler)
-	n.middleware = build(n.handlers)
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	old := n.current().handlers
+	handlers := make([]Handler, len(old), len(old)+1)
+	copy(handlers, old)
+	n.stack.Store(newStack(append(handlers, handler)))
+}
+
+// Swap atomically replaces the whole middleware stack with handlers and returns the handlers
+// it replaced. The new stack is built before it is switched in, so requests that are in
+// flight finish on the old stack while new requests run on the new one. This allows
+// changing the middleware from configuration without restarting the server.
+func (n *Camillo) Swap(handlers ...Handler) []Handler {
+	handlers = append([]Handler(nil), handlers...)
+	s := newStack(handlers)
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	old := n.current().handlers
+	n.stack.Store(s)
+	return old
 }
 
 // UseFunc adds a Camillo-style handler function onto the middleware stack.
@@ -135,17 +201,16 @@ func (n *Camillo) UseHandlerFunc(handlerFunc func(rw http.ResponseWriter, r *htt
 	n.UseHandler(http.HandlerFunc(handlerFunc))
 }
 
-// Run is a convenience function that runs the camillo stack as an HTTP
-// server. The addr string takes the same format as http.ListenAndServe.
-func (n *Camillo) Run(addr string) {
-	l := log.New(os.Stdout, "[camillo] ", 0)
-	l.Printf("listening on %s", addr)
-	l.Fatal(http.ListenAndServe(addr, n))
-}
-
 // Handlers returns a list of all the handlers in the current Camillo middleware chain.
 func (n *Camillo) Handlers() []Handler {
-	return n.handlers
+	return n.current().handlers
+}
+
+func (n *Camillo) current() *stack {
+	if s, ok := n.stack.Load().(*stack); ok {
+		return s
+	}
+	return newStack(nil)
 }
 
 func build(handlers []Handler) middleware {
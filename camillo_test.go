@@ -75,3 +75,93 @@ func TestHandlers(t *testing.T) {
 	handlers[0].ServeHTTP(nil, response, (*http.Request)(nil), nil)
 	expect(t, response.Code, http.StatusOK)
 }
+
+func TestCamilloSwap(t *testing.T) {
+	status := func(code int) Handler {
+		return HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+			rw.WriteHeader(code)
+		})
+	}
+	serve := func(n *Camillo) int {
+		response := httptest.NewRecorder()
+		n.ServeHTTP(response, (*http.Request)(nil))
+		return response.Code
+	}
+
+	n := New(status(http.StatusOK))
+	old := n.Swap(status(http.StatusAccepted), status(http.StatusConflict))
+	expect(t, len(old), 1)
+	expect(t, len(n.Handlers()), 2)
+	expect(t, serve(n), http.StatusAccepted)
+}
+
+func TestCamilloSwapInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan int)
+
+	n := New(HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		close(started)
+		<-release
+		next(ctx, rw, r)
+	}), HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	go func() {
+		response := httptest.NewRecorder()
+		n.ServeHTTP(response, (*http.Request)(nil))
+		done <- response.Code
+	}()
+	<-started
+
+	n.Swap(HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		rw.WriteHeader(http.StatusTeapot)
+	}))
+	response := httptest.NewRecorder()
+	n.ServeHTTP(response, (*http.Request)(nil))
+	expect(t, response.Code, http.StatusTeapot)
+
+	close(release)
+	expect(t, <-done, http.StatusOK)
+}
+
+func TestCamilloUseConcurrent(t *testing.T) {
+	n := New()
+	done := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+				next(ctx, rw, r)
+			})
+			n.ServeHTTP(httptest.NewRecorder(), (*http.Request)(nil))
+			done <- true
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+	expect(t, len(n.Handlers()), 10)
+}
+
+func BenchmarkCamilloServeHTTP(b *testing.B) {
+	response := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:3000/", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	n := New()
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		next(ctx, rw, r)
+	})
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n.ServeHTTP(response, req)
+	}
+}
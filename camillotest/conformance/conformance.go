@@ -0,0 +1,465 @@
+// Package conformance runs Camillo middleware against a matrix of scenarios (panics
+// downstream, hijacks, early writes, context cancelation, streaming) and reports the
+// behaviour that breaks composition with other middleware.
+//
+//	func TestMyMiddleware(t *testing.T) {
+//	  conformance.Run(t, NewMyMiddleware(), nil)
+//	}
+package conformance
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fd/camillo"
+	"golang.org/x/net/context"
+)
+
+// Options configures a conformance run. The zero value runs every scenario.
+type Options struct {
+	// Skip lists the names of the scenarios that should not be run, for example
+	// "passthrough" for middleware that intentionally never call next.
+	Skip []string
+	// Request returns the request used for every scenario. Defaults to GET /.
+	Request func() *http.Request
+	// Timeout is the time the middleware gets to return. Defaults to 5 seconds.
+	Timeout time.Duration
+}
+
+// Violation describes a scenario in which the middleware misbehaved.
+type Violation struct {
+	Scenario string
+	Message  string
+}
+
+func (v Violation) String() string {
+	return v.Scenario + ": " + v.Message
+}
+
+// Scenarios are the names of the scenarios run by Check, in order.
+var Scenarios = []string{
+	"passthrough",
+	"downstream-panic",
+	"early-write",
+	"hijack",
+	"context-cancel",
+	"streaming",
+}
+
+// Run checks h and reports every violation as an error on t.
+func Run(t testing.TB, h camillo.Handler, opts *Options) {
+	for _, v := range Check(h, opts) {
+		t.Error(v.String())
+	}
+}
+
+// Check runs h against every scenario and returns the violations found.
+func Check(h camillo.Handler, opts *Options) []Violation {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	var violations []Violation
+	for _, name := range Scenarios {
+		if opts.skip(name) {
+			continue
+		}
+		for _, msg := range scenarios[name](h, opts) {
+			violations = append(violations, Violation{name, msg})
+		}
+	}
+	return violations
+}
+
+var scenarios = map[string]func(camillo.Handler, *Options) []string{
+	"passthrough":      checkPassthrough,
+	"downstream-panic": checkDownstreamPanic,
+	"early-write":      checkEarlyWrite,
+	"hijack":           checkHijack,
+	"context-cancel":   checkContextCancel,
+	"streaming":        checkStreaming,
+}
+
+func checkPassthrough(h camillo.Handler, opts *Options) []string {
+	var msgs []string
+	calls := 0
+	res := invoke(h, opts, context.Background(), func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		calls++
+		msgs = append(msgs, checkNextArgs(ctx, rw, r)...)
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte("ok"))
+	})
+	if res.timedOut {
+		return res.unexpected()
+	}
+	msgs = append(msgs, res.unexpected()...)
+
+	switch {
+	case calls == 0:
+		msgs = append(msgs, "next was not called")
+	case calls > 1:
+		msgs = append(msgs, fmt.Sprintf("next was called %d times", calls))
+	}
+	if calls > 0 && res.probe.status != http.StatusOK {
+		msgs = append(msgs, fmt.Sprintf("downstream status 200 was replaced by %d", res.probe.status))
+	}
+	return msgs
+}
+
+func checkDownstreamPanic(h camillo.Handler, opts *Options) []string {
+	called := false
+	res := invoke(h, opts, context.Background(), func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		called = true
+		panic(errDownstreamPanic)
+	})
+	if res.timedOut {
+		return res.unexpected()
+	}
+	if !called {
+		return nil
+	}
+	if res.panicked && res.panicValue != errDownstreamPanic {
+		return []string{fmt.Sprintf("downstream panic was replaced by panic %v", res.panicValue)}
+	}
+	if !res.panicked && res.probe.status == 0 {
+		return []string{"downstream panic was swallowed without writing a response"}
+	}
+	return nil
+}
+
+func checkEarlyWrite(h camillo.Handler, opts *Options) []string {
+	called := false
+	res := invoke(h, opts, context.Background(), func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		called = true
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("created"))
+	})
+	msgs := res.unexpected()
+	if res.timedOut {
+		return msgs
+	}
+	if called && res.probe.status != http.StatusCreated {
+		msgs = append(msgs, fmt.Sprintf("status written downstream was replaced by %d", res.probe.status))
+	}
+	return msgs
+}
+
+func checkHijack(h camillo.Handler, opts *Options) []string {
+	var msgs []string
+	res := invoke(h, opts, context.Background(), func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		hijacker, ok := rw.(http.Hijacker)
+		if !ok {
+			msgs = append(msgs, "ResponseWriter passed to next does not implement http.Hijacker")
+			return
+		}
+		conn, _, err := hijacker.Hijack()
+		if err != nil {
+			msgs = append(msgs, "Hijack failed: "+err.Error())
+			return
+		}
+		conn.Close()
+	})
+	if res.timedOut {
+		return res.unexpected()
+	}
+	msgs = append(msgs, res.unexpected()...)
+	if res.probe.writesAfterHijack > 0 {
+		msgs = append(msgs, "response was written after the connection was hijacked")
+	}
+	return msgs
+}
+
+func checkContextCancel(h camillo.Handler, opts *Options) []string {
+	var msgs []string
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey, "value"))
+	cancel()
+
+	res := invoke(h, opts, ctx, func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		if ctx == nil {
+			msgs = append(msgs, "next was called with a nil context")
+			return
+		}
+		if ctx.Err() == nil {
+			msgs = append(msgs, "context passed to next is not canceled with its parent")
+		}
+		if ctx.Value(ctxKey) != "value" {
+			msgs = append(msgs, "context passed to next does not carry the parent's values")
+		}
+	})
+	if res.timedOut {
+		return res.unexpected()
+	}
+	return append(msgs, res.unexpected()...)
+}
+
+func checkStreaming(h camillo.Handler, opts *Options) []string {
+	var msgs []string
+	p := newProbe()
+	res := invokeProbe(h, opts, context.Background(), p, func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		flusher, ok := rw.(http.Flusher)
+		if !ok {
+			msgs = append(msgs, "ResponseWriter passed to next does not implement http.Flusher")
+			return
+		}
+		rw.Write([]byte("chunk"))
+		flusher.Flush()
+		if p.flushCount() == 0 || !bytes.Contains(p.body(), []byte("chunk")) {
+			msgs = append(msgs, "flushed data did not reach the client")
+		}
+	})
+	if res.timedOut {
+		return res.unexpected()
+	}
+	return append(msgs, res.unexpected()...)
+}
+
+// CheckOps runs h with a downstream handler driven by data, for use as the body of a
+// fuzz target. The downstream sets headers, writes statuses and bodies, flushes and
+// panics as instructed by the bytes of data.
+//
+//	func FuzzMyMiddleware(f *testing.F) {
+//	  f.Fuzz(func(t *testing.T, data []byte) {
+//	    for _, v := range conformance.CheckOps(NewMyMiddleware(), nil, data) {
+//	      t.Error(v.String())
+//	    }
+//	  })
+//	}
+func CheckOps(h camillo.Handler, opts *Options, data []byte) []Violation {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	status := 0
+	panicked := false
+	res := invoke(h, opts, context.Background(), func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		for i := 0; i < len(data); i++ {
+			switch data[i] % 5 {
+			case 0:
+				rw.Header().Set(fmt.Sprintf("X-Op-%d", i), "set")
+			case 1:
+				s := http.StatusOK
+				if i+1 < len(data) {
+					i++
+					s = 200 + int(data[i])%400
+				}
+				if status == 0 {
+					status = s
+				}
+				rw.WriteHeader(s)
+			case 2:
+				if status == 0 {
+					status = http.StatusOK
+				}
+				rw.Write(data[i : i+1])
+			case 3:
+				if flusher, ok := rw.(http.Flusher); ok {
+					if status == 0 {
+						status = http.StatusOK
+					}
+					flusher.Flush()
+				}
+			case 4:
+				panicked = true
+				panic(errDownstreamPanic)
+			}
+		}
+	})
+
+	var violations []Violation
+	report := func(msg string) {
+		violations = append(violations, Violation{"ops", msg})
+	}
+	switch {
+	case res.timedOut:
+		report("middleware did not return")
+		return violations
+	case res.panicked && panicked && status != 0 && res.panicValue == http.ErrAbortHandler:
+		// aborting a response that was written before the panic is allowed
+	case res.panicked && (!panicked || res.panicValue != errDownstreamPanic):
+		report(fmt.Sprintf("middleware panicked: %v", res.panicValue))
+	case panicked && !res.panicked && res.probe.status == 0:
+		report("downstream panic was swallowed without writing a response")
+	}
+	if status != 0 && res.probe.status != status {
+		report(fmt.Sprintf("status %d written downstream was replaced by %d", status, res.probe.status))
+	}
+	return violations
+}
+
+// checkNextArgs checks the arguments passed to next by well behaved middleware.
+func checkNextArgs(ctx context.Context, rw http.ResponseWriter, r *http.Request) []string {
+	var msgs []string
+	if ctx == nil {
+		msgs = append(msgs, "next was called with a nil context")
+	}
+	if r == nil {
+		msgs = append(msgs, "next was called with a nil request")
+	}
+	if _, ok := rw.(camillo.ResponseWriter); !ok {
+		msgs = append(msgs, "ResponseWriter passed to next does not implement camillo.ResponseWriter")
+	}
+	return msgs
+}
+
+type contextKey int
+
+const ctxKey contextKey = 0
+
+var errDownstreamPanic = fmt.Errorf("conformance: downstream panic")
+
+type result struct {
+	probe      *probe
+	panicked   bool
+	panicValue interface{}
+	timedOut   bool
+}
+
+// unexpected reports the outcomes that are violations in every scenario without a
+// downstream panic.
+func (res *result) unexpected() []string {
+	if res.timedOut {
+		return []string{"middleware did not return"}
+	}
+	if res.panicked {
+		return []string{fmt.Sprintf("middleware panicked: %v", res.panicValue)}
+	}
+	return nil
+}
+
+func invoke(h camillo.Handler, opts *Options, ctx context.Context, next camillo.NextFunc) *result {
+	return invokeProbe(h, opts, ctx, newProbe(), next)
+}
+
+func invokeProbe(h camillo.Handler, opts *Options, ctx context.Context, p *probe, next camillo.NextFunc) *result {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	type outcome struct {
+		panicked   bool
+		panicValue interface{}
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			err := recover()
+			done <- outcome{err != nil, err}
+		}()
+		h.ServeHTTP(ctx, camillo.NewResponseWriter(p), opts.request(), next)
+	}()
+
+	select {
+	case o := <-done:
+		return &result{probe: p, panicked: o.panicked, panicValue: o.panicValue}
+	case <-time.After(timeout):
+		// the middleware is still running, so the checks must neither read p nor the
+		// state written by next
+		return &result{probe: newProbe(), timedOut: true}
+	}
+}
+
+func (opts *Options) skip(name string) bool {
+	for _, s := range opts.Skip {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts *Options) request() *http.Request {
+	if opts.Request != nil {
+		return opts.Request()
+	}
+	r, err := http.NewRequest("GET", "http://example.com/", nil)
+	if err != nil {
+		panic(err)
+	}
+	r.RemoteAddr = "192.0.2.1:1234"
+	return r
+}
+
+// probe is the http.ResponseWriter underneath the camillo.ResponseWriter passed to
+// the middleware. It records what would have reached the client.
+type probe struct {
+	mtx               sync.Mutex
+	header            http.Header
+	status            int
+	buf               bytes.Buffer
+	flushes           int
+	hijacked          bool
+	writesAfterHijack int
+}
+
+func newProbe() *probe {
+	return &probe{header: make(http.Header)}
+}
+
+func (p *probe) Header() http.Header {
+	return p.header
+}
+
+func (p *probe) WriteHeader(s int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.hijacked {
+		p.writesAfterHijack++
+		return
+	}
+	if p.status == 0 {
+		p.status = s
+	}
+}
+
+func (p *probe) Write(b []byte) (int, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.hijacked {
+		p.writesAfterHijack++
+		return 0, http.ErrHijacked
+	}
+	if p.status == 0 {
+		p.status = http.StatusOK
+	}
+	return p.buf.Write(b)
+}
+
+func (p *probe) Flush() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.flushes++
+}
+
+func (p *probe) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	p.hijacked = true
+	server, client := net.Pipe()
+	client.Close()
+	return server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), nil
+}
+
+func (p *probe) flushCount() int {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return p.flushes
+}
+
+func (p *probe) body() []byte {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	return append([]byte(nil), p.buf.Bytes()...)
+}
@@ -0,0 +1,140 @@
+package conformance
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fd/camillo"
+	"golang.org/x/net/context"
+)
+
+func quietRecovery() *camillo.Recovery {
+	rec := camillo.NewRecovery()
+	rec.Logger = log.New(ioutil.Discard, "", 0)
+	return rec
+}
+
+func quietLogger() *camillo.Logger {
+	l := camillo.NewLogger()
+	l.Logger = log.New(ioutil.Discard, "", 0)
+	return l
+}
+
+func TestCheckBuiltinMiddleware(t *testing.T) {
+	Run(t, quietLogger(), nil)
+	Run(t, quietRecovery(), nil)
+	Run(t, camillo.NewStats(), nil)
+	Run(t, camillo.NewAccounting(), nil)
+	Run(t, camillo.NewBuffer(), nil)
+	Run(t, camillo.NewErrors(), nil)
+}
+
+func TestCheckViolations(t *testing.T) {
+	bad := camillo.HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next camillo.NextFunc) {
+		defer func() {
+			// swallows downstream panics
+			recover()
+		}()
+
+		buf := &bufferingWriter{ResponseWriter: rw.(camillo.ResponseWriter)}
+		next(context.Background(), buf, r)
+		rw.WriteHeader(http.StatusOK)
+		rw.Write(buf.Bytes())
+	})
+
+	found := map[string]bool{}
+	for _, v := range Check(bad, nil) {
+		found[v.Scenario] = true
+	}
+
+	for _, scenario := range []string{"downstream-panic", "early-write", "hijack", "context-cancel", "streaming"} {
+		if !found[scenario] {
+			t.Errorf("Expected a violation in scenario %s", scenario)
+		}
+	}
+	if found["passthrough"] {
+		t.Errorf("Did not expect a violation in scenario passthrough")
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	// keeps writing after the scenarios gave up on it
+	slow := camillo.HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next camillo.NextFunc) {
+		time.Sleep(50 * time.Millisecond)
+		next(ctx, rw, r)
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	violations := Check(slow, &Options{Timeout: time.Millisecond})
+	if len(violations) != len(Scenarios) {
+		t.Errorf("Expected %d violations - Got %v", len(Scenarios), violations)
+	}
+	for _, v := range violations {
+		if v.Message != "middleware did not return" {
+			t.Errorf("Expected a timeout - Got %v", v)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestCheckSkip(t *testing.T) {
+	auth := camillo.HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next camillo.NextFunc) {
+		rw.WriteHeader(http.StatusUnauthorized)
+	})
+
+	violations := Check(auth, &Options{Skip: []string{"passthrough"}})
+	if len(violations) != 0 {
+		t.Errorf("Expected no violations - Got %v", violations)
+	}
+}
+
+func TestCheckOps(t *testing.T) {
+	inputs := [][]byte{
+		nil,
+		{0, 2, 3},
+		{1, 5, 2, 4},
+		{3, 3, 2, 1, 1},
+		{4},
+	}
+	for _, data := range inputs {
+		for _, v := range CheckOps(quietRecovery(), nil, data) {
+			t.Errorf("%v: %v", data, v)
+		}
+	}
+
+	overriding := camillo.HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next camillo.NextFunc) {
+		next(ctx, &bufferingWriter{ResponseWriter: rw.(camillo.ResponseWriter)}, r)
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	if len(CheckOps(overriding, nil, []byte{1, 1})) == 0 {
+		t.Errorf("Expected a violation for an overridden status")
+	}
+}
+
+func FuzzCheckOps(f *testing.F) {
+	f.Add([]byte{1, 5, 2, 3, 4})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, v := range CheckOps(quietRecovery(), nil, data) {
+			t.Error(v.String())
+		}
+	})
+}
+
+// bufferingWriter holds the response body and drops the status, breaking streaming,
+// hijacking and early writes.
+type bufferingWriter struct {
+	camillo.ResponseWriter
+	bytes.Buffer
+}
+
+func (w *bufferingWriter) WriteHeader(int) {}
+
+func (w *bufferingWriter) Write(b []byte) (int, error) {
+	return w.Buffer.Write(b)
+}
+
+func (w *bufferingWriter) Flush() {}
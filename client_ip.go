@@ -0,0 +1,113 @@
+package camillo
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP resolves the IP address of the client that made a request. The X-Forwarded-For,
+// X-Real-IP and Forwarded headers are only believed when the direct peer is a trusted
+// proxy, so clients can't spoof their address.
+type ClientIP struct {
+	trusted []*net.IPNet
+}
+
+// NewClientIP returns a ClientIP trusting the proxies in the given CIDR ranges, such as
+// "10.0.0.0/8". Single addresses are accepted as well.
+func NewClientIP(trustedProxies ...string) (*ClientIP, error) {
+	c := &ClientIP{}
+	for _, cidr := range trustedProxies {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		c.trusted = append(c.trusted, network)
+	}
+	return c, nil
+}
+
+// Resolve returns the IP address of the client that made r. Behind trusted proxies, the
+// forwarded addresses are walked from the nearest proxy outwards and the first address
+// that isn't a trusted proxy is returned. Otherwise the address of the peer is returned.
+func (c *ClientIP) Resolve(r *http.Request) string {
+	peer := net.ParseIP(remoteIP(r))
+	if peer == nil {
+		return remoteIP(r)
+	}
+	if !c.Trusted(peer) {
+		return peer.String()
+	}
+
+	if forwarded := r.Header.Values("Forwarded"); len(forwarded) > 0 {
+		return c.walk(peer, forwardedFor(forwarded))
+	}
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		var hops []string
+		for _, v := range xff {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		return c.walk(peer, hops)
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return peer.String()
+}
+
+// Trusted returns whether ip is a trusted proxy.
+func (c *ClientIP) Trusted(ip net.IP) bool {
+	for _, network := range c.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ClientIP) walk(peer net.IP, hops []string) string {
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHop(hops[i])
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !c.Trusted(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// forwardedFor returns the for parameters of the elements of Forwarded headers (RFC 7239).
+func forwardedFor(headers []string) []string {
+	var hops []string
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+					hops = append(hops, strings.Trim(pair[4:], `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// parseHop parses an address of a forwarding header, which may have a port and brackets.
+func parseHop(hop string) net.IP {
+	hop = strings.TrimSpace(hop)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	return net.ParseIP(strings.Trim(hop, "[]"))
+}
@@ -0,0 +1,48 @@
+package camillo
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	c, err := NewClientIP("10.0.0.0/8", "192.168.1.1", "2001:db8::/32")
+	expect(t, err, nil)
+
+	for _, test := range []struct {
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{"203.0.113.9:1234", nil, "203.0.113.9"},
+		{"203.0.113.9:1234", http.Header{"X-Forwarded-For": {"1.2.3.4"}}, "203.0.113.9"},
+		{"10.0.0.1:1234", nil, "10.0.0.1"},
+		{"10.0.0.1:1234", http.Header{"X-Forwarded-For": {"1.2.3.4"}}, "1.2.3.4"},
+		{"10.0.0.1:1234", http.Header{"X-Forwarded-For": {"6.6.6.6, 1.2.3.4, 10.0.0.2"}}, "1.2.3.4"},
+		{"10.0.0.1:1234", http.Header{"X-Forwarded-For": {"6.6.6.6", "1.2.3.4"}}, "1.2.3.4"},
+		{"10.0.0.1:1234", http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}, "10.0.0.3"},
+		{"10.0.0.1:1234", http.Header{"X-Forwarded-For": {"garbage, 10.0.0.2"}}, "10.0.0.2"},
+		{"192.168.1.1:1234", http.Header{"X-Real-Ip": {"1.2.3.4"}}, "1.2.3.4"},
+		{"192.168.1.2:1234", http.Header{"X-Real-Ip": {"1.2.3.4"}}, "192.168.1.2"},
+		{"10.0.0.1:1234", http.Header{"Forwarded": {`for=192.0.2.60;proto=http;by=203.0.113.43`}}, "192.0.2.60"},
+		{"10.0.0.1:1234", http.Header{"Forwarded": {`for="[2001:db8:cafe::17]:4711", for=10.0.0.5`}}, "2001:db8:cafe::17"},
+		{"[2001:db8::1]:1234", http.Header{"X-Forwarded-For": {"1.2.3.4"}}, "1.2.3.4"},
+		{"10.0.0.1:1234", http.Header{"Forwarded": {"for=1.2.3.4"}, "X-Forwarded-For": {"6.6.6.6"}}, "1.2.3.4"},
+	} {
+		req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.header != nil {
+			req.Header = test.header
+		}
+		if got := c.Resolve(req); got != test.want {
+			t.Errorf("Expected client IP of %s %v to be %s, got %s", test.remoteAddr, test.header, test.want, got)
+		}
+	}
+}
+
+func TestClientIPInvalidCIDR(t *testing.T) {
+	_, err := NewClientIP("10.0.0.0/33")
+	refute(t, err, nil)
+	_, err = NewClientIP("not an ip")
+	refute(t, err, nil)
+}
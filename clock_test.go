@@ -0,0 +1,24 @@
+package camillo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := NewManualClock(start)
+	expect(t, c.Now(), start)
+
+	c.Advance(time.Minute)
+	expect(t, c.Now(), start.Add(time.Minute))
+
+	c.Set(start)
+	expect(t, c.Now(), start)
+}
+
+func TestClockNow(t *testing.T) {
+	start := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	expect(t, clockNow(NewManualClock(start)), start)
+	refute(t, clockNow(nil).IsZero(), true)
+}
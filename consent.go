@@ -0,0 +1,143 @@
+package camillo
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// ConsentState is the cookie consent of the user making a request.
+type ConsentState struct {
+	// Given reports whether the user made a choice at all, which tells a consent banner
+	// whether it should be shown.
+	Given bool
+	// Categories are the consented categories, such as "analytics" or "marketing".
+	Categories map[string]bool
+}
+
+// Allows returns whether the user consented to category.
+func (s ConsentState) Allows(category string) bool {
+	return s.Categories[category]
+}
+
+type consentKey struct{}
+
+// ConsentFromContext returns the consent state tracked by Consent. Without Consent no
+// category is allowed.
+func ConsentFromContext(ctx context.Context) ConsentState {
+	state, _ := ctx.Value(consentKey{}).(ConsentState)
+	return state
+}
+
+// Consent is a middleware handler that reads the first-party consent cookie and exposes
+// the consent state in the context. Middleware that injects analytics or tracking should
+// be wrapped with RequireConsent, so it is skipped for users that didn't consent.
+type Consent struct {
+	// CookieName is the name of the consent cookie.
+	CookieName string
+	// MaxAge is how long the consent cookie is kept, in seconds.
+	MaxAge int
+	// Categories are the known consent categories. Unknown categories in the cookie are
+	// ignored. All categories are accepted when it is empty.
+	Categories []string
+	// TrackingHeaders maps response headers to the category they require. The headers are
+	// removed from the responses to users that didn't consent to the category.
+	TrackingHeaders map[string]string
+	// RespectGPC treats requests with the Sec-GPC header as not consenting to any
+	// category, following the Global Privacy Control proposal.
+	RespectGPC bool
+}
+
+// NewConsent returns a new instance of Consent
+func NewConsent() *Consent {
+	return &Consent{
+		CookieName: "camillo_consent",
+		MaxAge:     60 * 60 * 24 * 180,
+		RespectGPC: true,
+	}
+}
+
+func (c *Consent) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	state := c.State(r)
+	ctx = context.WithValue(ctx, consentKey{}, state)
+
+	if res, ok := rw.(ResponseWriter); ok && len(c.TrackingHeaders) > 0 {
+		res.Before(func(res ResponseWriter) {
+			for header, category := range c.TrackingHeaders {
+				if !state.Allows(category) {
+					res.Header().Del(header)
+				}
+			}
+		})
+	}
+
+	next(ctx, rw, r)
+}
+
+// State returns the consent state of r.
+func (c *Consent) State(r *http.Request) ConsentState {
+	state := ConsentState{Categories: make(map[string]bool)}
+
+	cookie, err := r.Cookie(c.CookieName)
+	if err != nil {
+		return state
+	}
+	state.Given = true
+	if c.RespectGPC && r.Header.Get("Sec-GPC") == "1" {
+		return state
+	}
+	for _, category := range strings.Split(cookie.Value, ".") {
+		if category != "" && c.known(category) {
+			state.Categories[category] = true
+		}
+	}
+	return state
+}
+
+// Set records the user's consent to categories in the consent cookie. Calling it without
+// categories records that the user declined all of them.
+func (c *Consent) Set(rw http.ResponseWriter, r *http.Request, categories ...string) {
+	var values []string
+	for _, category := range categories {
+		if c.known(category) {
+			values = append(values, category)
+		}
+	}
+	sort.Strings(values)
+
+	http.SetCookie(rw, &http.Cookie{
+		Name:     c.CookieName,
+		Value:    strings.Join(values, "."),
+		Path:     "/",
+		MaxAge:   c.MaxAge,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (c *Consent) known(category string) bool {
+	if len(c.Categories) == 0 {
+		return true
+	}
+	for _, known := range c.Categories {
+		if known == category {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireConsent wraps h so it only runs for users that consented to category. For other
+// users the request is passed on to the next handler.
+func RequireConsent(category string, h Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		if !ConsentFromContext(ctx).Allows(category) {
+			next(ctx, rw, r)
+			return
+		}
+		h.ServeHTTP(ctx, rw, r, next)
+	})
+}
@@ -0,0 +1,80 @@
+package camillo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestConsentState(t *testing.T) {
+	c := NewConsent()
+	c.Categories = []string{"analytics", "marketing"}
+
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	state := c.State(req)
+	expect(t, state.Given, false)
+	expect(t, state.Allows("analytics"), false)
+
+	req.AddCookie(&http.Cookie{Name: "camillo_consent", Value: "analytics.unknown"})
+	state = c.State(req)
+	expect(t, state.Given, true)
+	expect(t, state.Allows("analytics"), true)
+	expect(t, state.Allows("marketing"), false)
+	expect(t, state.Allows("unknown"), false)
+
+	req.Header.Set("Sec-GPC", "1")
+	state = c.State(req)
+	expect(t, state.Given, true)
+	expect(t, state.Allows("analytics"), false)
+}
+
+func TestConsentSet(t *testing.T) {
+	c := NewConsent()
+	c.Categories = []string{"analytics", "marketing"}
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://localhost:3000/consent", nil)
+	c.Set(recorder, req, "marketing", "analytics", "unknown")
+
+	cookies := recorder.Result().Cookies()
+	expect(t, len(cookies), 1)
+	expect(t, cookies[0].Name, "camillo_consent")
+	expect(t, cookies[0].Value, "analytics.marketing")
+	expect(t, cookies[0].HttpOnly, true)
+	expect(t, cookies[0].SameSite, http.SameSiteLaxMode)
+}
+
+func TestConsentServeHTTP(t *testing.T) {
+	c := NewConsent()
+	c.TrackingHeaders = map[string]string{"X-Tracking-Id": "analytics"}
+
+	n := New(c)
+	n.Use(RequireConsent("analytics", HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		rw.Header().Set("X-Analytics", "injected")
+		next(ctx, rw, r)
+	})))
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		rw.Header().Set("X-Tracking-Id", "abc")
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	serve := func(cookie string) http.Header {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: "camillo_consent", Value: cookie})
+		}
+		n.ServeHTTP(recorder, req)
+		return recorder.Header()
+	}
+
+	h := serve("")
+	expect(t, h.Get("X-Analytics"), "")
+	expect(t, h.Get("X-Tracking-Id"), "")
+
+	h = serve("analytics")
+	expect(t, h.Get("X-Analytics"), "injected")
+	expect(t, h.Get("X-Tracking-Id"), "abc")
+}
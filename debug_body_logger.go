@@ -0,0 +1,167 @@
+package camillo
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultDebugRedactFields are the JSON properties and form fields that DebugBodyLogger
+// redacts by default.
+var DefaultDebugRedactFields = []string{
+	"password", "passwd", "secret", "token", "access_token", "refresh_token", "id_token",
+	"client_secret", "api_key", "apikey", "authorization", "credit_card", "card_number", "cvv",
+}
+
+// DefaultDebugContentTypes are the media types of the bodies that DebugBodyLogger logs by
+// default. A type ending in "/*" matches all subtypes.
+var DefaultDebugContentTypes = []string{
+	"application/json",
+	"application/x-www-form-urlencoded",
+	"application/xml",
+	"text/*",
+}
+
+// DebugBodyLogger is a debugging middleware handler that logs up to MaxBodySize bytes of
+// the request and response bodies of selected paths, which helps reproducing bugs reported
+// by API clients. Only bodies of the configured content types are logged and the values
+// of secret JSON properties and form fields are redacted.
+type DebugBodyLogger struct {
+	// Logger receives the bodies. Leveled sinks log them at LogLevelDebug.
+	Logger LogSink
+	// Paths are path.Match patterns of the request paths whose bodies are logged. No
+	// bodies are logged when it is empty.
+	Paths []string
+	// MaxBodySize is the maximum number of bytes logged of every body.
+	MaxBodySize int
+	// ContentTypes are the media types of the bodies that are logged.
+	ContentTypes []string
+	// RedactFields are the names of the JSON properties and form fields whose values are
+	// redacted, compared case-insensitively.
+	RedactFields []string
+
+	once   sync.Once
+	redact *regexp.Regexp
+}
+
+// NewDebugBodyLogger returns a new instance of DebugBodyLogger logging the bodies of the
+// requests matching paths
+func NewDebugBodyLogger(paths ...string) *DebugBodyLogger {
+	return &DebugBodyLogger{
+		Logger:       log.New(os.Stdout, "[camillo] ", 0),
+		Paths:        paths,
+		MaxBodySize:  1024 * 4,
+		ContentTypes: DefaultDebugContentTypes,
+		RedactFields: DefaultDebugRedactFields,
+	}
+}
+
+func (d *DebugBodyLogger) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	res, ok := rw.(ResponseWriter)
+	if !ok || !d.match(r.URL.Path) {
+		next(ctx, rw, r)
+		return
+	}
+
+	var body *capturedBody
+	if r.Body != nil && d.loggable(r.Header.Get("Content-Type")) {
+		body = &capturedBody{ReadCloser: r.Body, capture: captureBuffer{limit: d.MaxBodySize}}
+		r.Body = body
+	}
+	res.Capture(d.MaxBodySize)
+
+	next(ctx, rw, r)
+
+	prefix := logPrefix(requestID(ctx, r, res))
+	if body != nil {
+		body.rest()
+		d.log(prefix+"request body of "+r.Method+" "+r.URL.Path, r.Header.Get("Content-Type"), body.capture.buf, body.capture.truncated)
+	}
+	if contentType := res.Header().Get("Content-Type"); d.loggable(contentType) {
+		captured, truncated := res.Captured()
+		d.log(prefix+"response body of "+r.Method+" "+r.URL.Path, contentType, captured, truncated)
+	}
+}
+
+func (d *DebugBodyLogger) log(what, contentType string, body []byte, truncated bool) {
+	if len(body) == 0 {
+		return
+	}
+	suffix := ""
+	if truncated {
+		suffix = " (truncated)"
+	}
+	logf(d.Logger, LogLevelDebug, "%s (%s): %s%s", what, contentType, d.Redact(contentType, body), suffix)
+}
+
+// Redact returns body with the values of the secret fields replaced by "[REDACTED]". JSON
+// and form bodies are redacted, including truncated ones; other bodies are returned as is.
+func (d *DebugBodyLogger) Redact(contentType string, body []byte) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case len(d.RedactFields) == 0:
+		return string(body)
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		// the fields are compiled once, on first use
+		d.once.Do(func() {
+			fields := make([]string, len(d.RedactFields))
+			for i, field := range d.RedactFields {
+				fields[i] = regexp.QuoteMeta(field)
+			}
+			d.redact = regexp.MustCompile(`(?i)("(?:` + strings.Join(fields, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+		})
+		return d.redact.ReplaceAllString(string(body), `${1}"[REDACTED]"`)
+	case mediaType == "application/x-www-form-urlencoded":
+		pairs := strings.Split(string(body), "&")
+		for i, pair := range pairs {
+			name := pair
+			if j := strings.IndexByte(pair, '='); j >= 0 {
+				name = pair[:j]
+			}
+			if unescaped, err := url.QueryUnescape(name); err == nil && d.secret(unescaped) {
+				pairs[i] = name + "=[REDACTED]"
+			}
+		}
+		return strings.Join(pairs, "&")
+	}
+	return string(body)
+}
+
+func (d *DebugBodyLogger) secret(name string) bool {
+	for _, field := range d.RedactFields {
+		if strings.EqualFold(field, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DebugBodyLogger) match(p string) bool {
+	for _, pattern := range d.Paths {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DebugBodyLogger) loggable(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range d.ContentTypes {
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, allowed[:len(allowed)-1])) {
+			return true
+		}
+	}
+	return false
+}
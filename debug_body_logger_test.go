@@ -0,0 +1,76 @@
+package camillo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugBodyLogger(t *testing.T) {
+	var lines []string
+	d := NewDebugBodyLogger("/api/*")
+	d.Logger = LogSinkFunc(func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	})
+	d.MaxBodySize = 64
+
+	n := New(d)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		expect(t, string(b), `{"user":"frank","password":"hunter2"}`)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Write([]byte(`{"access_token": "abc", "expires": 3600}`))
+	})
+
+	req, _ := http.NewRequest("POST", "http://localhost:3000/api/login", strings.NewReader(`{"user":"frank","password":"hunter2"}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	n.ServeHTTP(httptest.NewRecorder(), req)
+
+	expect(t, len(lines), 2)
+	expect(t, lines[0], `request body of POST /api/login (application/json; charset=utf-8): {"user":"frank","password":"[REDACTED]"}`)
+	expect(t, lines[1], `response body of POST /api/login (application/json): {"access_token": "[REDACTED]", "expires": 3600}`)
+}
+
+func TestDebugBodyLoggerFilters(t *testing.T) {
+	var lines []string
+	d := NewDebugBodyLogger("/api/*")
+	d.Logger = LogSinkFunc(func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	})
+	d.MaxBodySize = 8
+
+	n := New(d)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "image/png")
+		rw.Write([]byte("\x89PNG..."))
+	})
+
+	serve := func(path, contentType, body string) {
+		req, _ := http.NewRequest("POST", "http://localhost:3000"+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve("/other", "text/plain", "hello")
+	expect(t, len(lines), 0)
+
+	serve("/api/upload", "application/octet-stream", "binary")
+	expect(t, len(lines), 0)
+
+	serve("/api/notes", "text/plain", "a long note")
+	expect(t, len(lines), 1)
+	expect(t, lines[0], "request body of POST /api/notes (text/plain): a long n (truncated)")
+}
+
+func TestDebugBodyLoggerRedact(t *testing.T) {
+	d := NewDebugBodyLogger()
+
+	expect(t, d.Redact("application/x-www-form-urlencoded", []byte("user=frank&Password=hunter2&token")), "user=frank&Password=[REDACTED]&token=[REDACTED]")
+	expect(t, d.Redact("application/json", []byte(`{"nested":{"Secret":"x\"y"},"api_key":12345,"list":[{"token":null}]}`)),
+		`{"nested":{"Secret":"[REDACTED]"},"api_key":"[REDACTED]","list":[{"token":"[REDACTED]"}]}`)
+	expect(t, d.Redact("application/json", []byte(`{"password":"trunc`)), `{"password":"[REDACTED]"`)
+	expect(t, d.Redact("text/plain", []byte("password=hunter2")), "password=hunter2")
+}
@@ -0,0 +1,52 @@
+package camillo
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+)
+
+type drainKey struct{}
+
+// DrainFromContext returns the Drain of the camillo stack serving the request, or nil
+// outside of a stack made by New or NewWithContext.
+func DrainFromContext(ctx context.Context) *Drain {
+	d, _ := ctx.Value(drainKey{}).(*Drain)
+	return d
+}
+
+// Drain is the shutdown state of a camillo stack, for the middleware adapting to it: the
+// readiness probes of Health fail while it drains. While the server started by Run drains,
+// from the signal to stop until it shut down, the responses have a "Connection: close"
+// header, so the clients and the load balancers reconnect to the other servers instead of
+// reusing their connections. Its methods can be called on nil.
+type Drain struct {
+	draining int32
+	inflight int64
+}
+
+// Draining returns whether the server shuts down.
+func (d *Drain) Draining() bool {
+	return d != nil && atomic.LoadInt32(&d.draining) != 0
+}
+
+// Inflight returns the number of requests the stack is serving.
+func (d *Drain) Inflight() int64 {
+	if d == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&d.inflight)
+}
+
+// Drain returns the shutdown state of the stack.
+func (n *Camillo) Drain() *Drain {
+	return &n.drain
+}
+
+func (d *Drain) set(draining bool) {
+	var v int32
+	if draining {
+		v = 1
+	}
+	atomic.StoreInt32(&d.draining, v)
+}
@@ -0,0 +1,82 @@
+package camillo
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestDrainFromContext(t *testing.T) {
+	expect(t, DrainFromContext(context.Background()) == nil, true)
+	var d *Drain
+	expect(t, d.Draining(), false)
+	expect(t, d.Inflight(), int64(0))
+
+	n := New()
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		expect(t, DrainFromContext(ctx), n.Drain())
+		expect(t, DrainFromContext(ctx).Inflight(), int64(1))
+		expect(t, DrainFromContext(ctx).Draining(), false)
+	})
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	rec := httptest.NewRecorder()
+	n.ServeHTTP(rec, req)
+	expect(t, n.Drain().Inflight(), int64(0))
+	expect(t, rec.Header().Get("Connection"), "")
+}
+
+func TestDrainShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.ShutdownDelay = 200 * time.Millisecond
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		if DrainFromContext(ctx).Draining() {
+			io.WriteString(rw, "draining")
+			return
+		}
+		io.WriteString(rw, "serving")
+	})
+
+	errs := make(chan error, 1)
+	go func() { errs <- n.Run("127.0.0.1:3029") }()
+	awaitListening(t, "127.0.0.1:3029")
+
+	res, err := http.Get("http://127.0.0.1:3029/")
+	expect(t, err, nil)
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, string(b), "serving")
+	expect(t, res.Header.Get("Connection"), "")
+
+	go http.Get("http://127.0.0.1:3029/slow")
+	<-started
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	time.Sleep(50 * time.Millisecond)
+	expect(t, n.Drain().Draining(), true)
+	expect(t, n.Drain().Inflight(), int64(1))
+
+	res, err = http.Get("http://127.0.0.1:3029/")
+	expect(t, err, nil)
+	b, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, string(b), "draining")
+	expect(t, res.Close, true)
+
+	close(release)
+	expect(t, <-errs, nil)
+	expect(t, n.Drain().Draining(), false)
+	expect(t, n.Drain().Inflight(), int64(0))
+}
@@ -0,0 +1,91 @@
+package camillo
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultErrorPage is the template used by NewErrorPages for the statuses it intercepts.
+var DefaultErrorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Status}} {{.StatusText}}</title></head>
+<body><h1>{{.Status}} {{.StatusText}}</h1></body>
+</html>
+`))
+
+// ErrorPage is the data passed to the error page templates.
+type ErrorPage struct {
+	Status     int
+	StatusText string
+	Method     string
+	Path       string
+}
+
+// ErrorPages is a middleware handler that replaces the bodies of responses with the
+// configured statuses by a rendered template. The headers set by the handler, such as
+// Retry-After, are kept. Responses that were already sent to the client, because they
+// were flushed or exceeded MaxSize, are passed through unchanged.
+type ErrorPages struct {
+	// Pages maps a status code to the template rendered for it.
+	Pages map[int]*template.Template
+	// MaxSize is the maximum number of bytes of a response that are buffered.
+	MaxSize int
+	// Logger is used to log templates that fail to render
+	Logger LogSink
+}
+
+// NewErrorPages returns a new instance of ErrorPages rendering DefaultErrorPage for
+// 404, 500 and 503 responses
+func NewErrorPages() *ErrorPages {
+	return &ErrorPages{
+		Pages: map[int]*template.Template{
+			http.StatusNotFound:            DefaultErrorPage,
+			http.StatusInternalServerError: DefaultErrorPage,
+			http.StatusServiceUnavailable:  DefaultErrorPage,
+		},
+		MaxSize: 1024 * 1024 * 4,
+		Logger:  log.New(os.Stdout, "[camillo] ", 0),
+	}
+}
+
+func (e *ErrorPages) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	b := NewBufferedResponseWriter(rw)
+	b.MaxSize = e.MaxSize
+	defer b.Commit()
+
+	next(ctx, b, r)
+
+	tmpl, ok := e.Pages[b.Status()]
+	if !ok || b.Committed() {
+		return
+	}
+
+	status := b.Status()
+	var page bytes.Buffer
+	err := tmpl.Execute(&page, ErrorPage{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+	})
+	if err != nil {
+		logf(e.Logger, LogLevelError, "failed to render the error page for %d: %s", status, err)
+		return
+	}
+
+	header := b.Header().Clone()
+	b.Rollback()
+	for k, v := range header {
+		b.Header()[k] = v
+	}
+	b.Header().Del("Content-Length")
+	b.Header().Del("Content-Encoding")
+	b.Header().Set("Content-Type", "text/html; charset=utf-8")
+	b.WriteHeader(status)
+	b.Write(page.Bytes())
+}
@@ -0,0 +1,89 @@
+package camillo
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveErrorPages(t *testing.T, e *ErrorPages, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	n := New(e)
+	n.UseHandlerFunc(handler)
+
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:3000/missing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestErrorPages(t *testing.T) {
+	recorder := serveErrorPages(t, NewErrorPages(), func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Retry-After", "120")
+		http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	expect(t, recorder.Code, http.StatusServiceUnavailable)
+	expect(t, recorder.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	expect(t, recorder.Header().Get("Retry-After"), "120")
+	expect(t, strings.Contains(recorder.Body.String(), "<h1>503 Service Unavailable</h1>"), true)
+	expect(t, strings.Contains(recorder.Body.String(), "unavailable\n"), false)
+}
+
+func TestErrorPagesCustomTemplate(t *testing.T) {
+	e := NewErrorPages()
+	e.Pages = map[int]*template.Template{
+		http.StatusNotFound: template.Must(template.New("404").Parse(`{{.Path}} not found`)),
+	}
+
+	recorder := serveErrorPages(t, e, func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+	})
+	expect(t, recorder.Code, http.StatusNotFound)
+	expect(t, recorder.Body.String(), "/missing not found")
+
+	recorder = serveErrorPages(t, e, func(rw http.ResponseWriter, r *http.Request) {
+		http.Error(rw, "boom", http.StatusInternalServerError)
+	})
+	expect(t, recorder.Code, http.StatusInternalServerError)
+	expect(t, recorder.Body.String(), "boom\n")
+}
+
+func TestErrorPagesPassthrough(t *testing.T) {
+	recorder := serveErrorPages(t, NewErrorPages(), func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("hello"))
+	})
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, recorder.Body.String(), "hello")
+}
+
+func TestErrorPagesCommitted(t *testing.T) {
+	recorder := serveErrorPages(t, NewErrorPages(), func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+		rw.Write([]byte("streamed"))
+		rw.(http.Flusher).Flush()
+	})
+	expect(t, recorder.Code, http.StatusNotFound)
+	expect(t, recorder.Body.String(), "streamed")
+}
+
+func TestErrorPagesTemplateError(t *testing.T) {
+	var lines leveledRecorder
+	e := NewErrorPages()
+	e.Logger = &lines
+	e.Pages = map[int]*template.Template{
+		http.StatusNotFound: template.Must(template.New("404").Parse(`{{.Missing}}`)),
+	}
+
+	recorder := serveErrorPages(t, e, func(rw http.ResponseWriter, r *http.Request) {
+		http.NotFound(rw, r)
+	})
+	expect(t, recorder.Code, http.StatusNotFound)
+	expect(t, recorder.Body.String(), "404 page not found\n")
+	expect(t, len(lines), 1)
+	expect(t, strings.HasPrefix(lines[0], "error failed to render the error page for 404"), true)
+}
@@ -0,0 +1,104 @@
+package camillo
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+
+	"golang.org/x/net/context"
+)
+
+// Errors is a middleware handler answering the errors that the handlers after it hand to
+// it with Fail, so every failure gets a consistent response. Errors are answered like the
+// panics recovered by Recovery: an HTTPError with its code, message and fields, and any
+// other error with a 500. The internal causes are logged, and the 5xx errors reported to
+// Reporter.
+//
+//	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next camillo.NextFunc) {
+//		order, err := loadOrder(r)
+//		if err != nil {
+//			camillo.Fail(ctx, err)
+//			return
+//		}
+//		...
+//	})
+type Errors struct {
+	// Logger receives the internal causes of the errors
+	Logger LogSink
+	// Mode selects how errors are rendered to browsers, like the mode of Recovery:
+	// DefaultErrorPage in the production and development modes, and plain text otherwise.
+	Mode RecoveryMode
+	// Reporter reports the 5xx errors to an error tracking system when set, with the stack
+	// of the Fail call.
+	Reporter Reporter
+	// StackSize is the maximum size of the stacks passed to Reporter.
+	StackSize int
+}
+
+// NewErrors returns a new instance of Errors
+func NewErrors() *Errors {
+	return &Errors{
+		Logger:    log.New(os.Stdout, "[camillo] ", 0),
+		Mode:      recoveryModeFromEnv(),
+		StackSize: 1024 * 8,
+	}
+}
+
+type errorsKey struct{}
+
+// failure is the error handed to an Errors middleware.
+type failure struct {
+	errors *Errors
+	err    error
+	stack  []byte
+}
+
+func (e *Errors) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	f := &failure{errors: e}
+	next(context.WithValue(ctx, errorsKey{}, f), rw, r)
+	if f.err == nil {
+		return
+	}
+
+	id := RequestIDFromContext(ctx)
+	he, ok := asHTTPError(f.err)
+	if !ok {
+		he = HTTPError{Code: http.StatusInternalServerError, Err: f.err}
+	}
+	if he.Code == 0 {
+		he.Code = http.StatusInternalServerError
+	}
+	if he.Err != nil || he.Code >= 500 {
+		LogError(ctx, f.err)
+		logf(e.Logger, LogLevelError, "%sERROR: %s %s: %s", logPrefix(id), r.Method, r.URL.Path, f.err)
+	}
+	if he.Code >= 500 && e.Reporter != nil {
+		defer e.Reporter.Report(ctx, r, f.err, f.stack)
+	}
+
+	if w, ok := rw.(ResponseWriter); ok && w.Written() {
+		if b, ok := rw.(*BufferedResponseWriter); !ok || b.Rollback() != nil {
+			logf(e.Logger, LogLevelError, "%sfailed to answer the error: the response was already written", logPrefix(id))
+			return
+		}
+	}
+	writeHTTPError(rw, r, id, he, e.Mode != RecoveryModeText)
+}
+
+// Fail hands err to the Errors middleware serving ctx, which answers the request with it
+// once the handler returns. The handler must not write the response itself. The last
+// error handed to a request wins. Fail returns false when ctx isn't served by an Errors
+// middleware, in which case the handler must answer the request.
+func Fail(ctx context.Context, err error) bool {
+	f, ok := ctx.Value(errorsKey{}).(*failure)
+	if !ok {
+		return false
+	}
+	f.err = err
+	if f.errors.Reporter != nil {
+		f.stack = make([]byte, f.errors.StackSize)
+		f.stack = f.stack[:runtime.Stack(f.stack, false)]
+	}
+	return true
+}
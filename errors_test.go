@@ -0,0 +1,96 @@
+package camillo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestErrors(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	var reported []string
+
+	e := NewErrors()
+	e.Logger = log.New(buff, "", 0)
+	e.Mode = RecoveryModeProduction
+	e.Reporter = ReporterFunc(func(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+		reported = append(reported, fmt.Sprint(err))
+		expect(t, strings.Contains(string(stack), "TestErrors"), true)
+	})
+
+	serve := func(err error, accept string) *httptest.ResponseRecorder {
+		n := New(e)
+		n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+			expect(t, Fail(ctx, err), true)
+		})
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "http://localhost:3000/users", nil)
+		req.Header.Set("Accept", accept)
+		n.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := serve(HTTPError{Code: http.StatusUnprocessableEntity, Message: "invalid user", Fields: map[string]string{"email": "is missing"}}, "application/json")
+	expect(t, recorder.Code, http.StatusUnprocessableEntity)
+	expect(t, recorder.Body.String(), `{"error":"invalid user","fields":{"email":"is missing"}}`+"\n")
+	expect(t, buff.Len(), 0)
+
+	recorder = serve(fmt.Errorf("saving user: %w", HTTPError{Code: http.StatusConflict, Message: "email taken", Err: errors.New("duplicate key")}), "")
+	expect(t, recorder.Code, http.StatusConflict)
+	expect(t, recorder.Body.String(), "email taken\n")
+	expect(t, buff.String(), "ERROR: POST /users: saving user: 409 email taken: duplicate key\n")
+	expect(t, len(reported), 0)
+
+	buff.Reset()
+	recorder = serve(errors.New("database is down"), "text/html")
+	expect(t, recorder.Code, http.StatusInternalServerError)
+	expect(t, strings.Contains(recorder.Body.String(), "<h1>500 Internal Server Error</h1>"), true)
+	expect(t, strings.Contains(recorder.Body.String(), "database"), false)
+	expect(t, buff.String(), "ERROR: POST /users: database is down\n")
+	expect(t, len(reported), 1)
+	expect(t, reported[0], "database is down")
+}
+
+func TestErrorsWithoutFailure(t *testing.T) {
+	e := NewErrors()
+	e.Logger = log.New(ioutil.Discard, "", 0)
+
+	n := New(e)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusCreated)
+	})
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	n.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusCreated)
+}
+
+func TestFailWithoutErrors(t *testing.T) {
+	expect(t, Fail(context.Background(), errors.New("boom")), false)
+}
+
+func TestErrorsAfterWrite(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	e := NewErrors()
+	e.Logger = log.New(buff, "", 0)
+
+	n := New(e)
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		rw.Write([]byte("partial"))
+		Fail(ctx, HTTPError{Code: http.StatusBadRequest})
+	})
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	n.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, recorder.Body.String(), "partial")
+	expect(t, buff.String(), "failed to answer the error: the response was already written\n")
+}
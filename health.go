@@ -0,0 +1,229 @@
+package camillo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// HealthCheck checks a dependency of the server, such as a database, and returns an error
+// when it is unavailable. It should return once ctx is done.
+type HealthCheck func(ctx context.Context) error
+
+// Health is a middleware handler answering the liveness and readiness probes of the
+// orchestrators and load balancers, on LivePath and ReadyPath:
+//
+//	h := camillo.NewHealth()
+//	h.Check("db", camillo.PingCheck(db))
+//	h.CheckTimeout("search", time.Second, camillo.URLCheck("http://search:9200/"))
+//	n.Use(h)
+//
+// The liveness probe answers as long as the process serves requests, while the readiness
+// probe runs the checks and fails when one of them fails, and while the server started by
+// Run shuts down. Both answer with a JSON body detailing the results:
+//
+//	{"status":"failing","checks":{"db":{"status":"ok","duration":"1.2ms"},"search":{"status":"failing","error":"context deadline exceeded","duration":"1s"}}}
+type Health struct {
+	// LivePath is the path of the liveness probe.
+	LivePath string
+	// ReadyPath is the path of the readiness probe.
+	ReadyPath string
+	// Timeout is the time limit of the checks registered with Check.
+	Timeout time.Duration
+	// CacheFor is how long the result of a check is reused by the following probes, so
+	// frequent probes don't load the dependencies.
+	CacheFor time.Duration
+	// Clock is used to expire the results. It should be set before the Health is used.
+	Clock Clock
+
+	mtx    sync.Mutex
+	checks []*healthCheck
+}
+
+// HealthStatus is the JSON body of the probes of Health.
+type HealthStatus struct {
+	// Status is "ok", "failing", or "draining" while the server shuts down.
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks,omitempty"`
+	// Inflight is the number of requests in flight while the server drains.
+	Inflight int64 `json:"inflight,omitempty"`
+}
+
+// HealthCheckResult is the result of a check in a HealthStatus.
+type HealthCheckResult struct {
+	// Status is "ok" or "failing".
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+type healthCheck struct {
+	name    string
+	check   HealthCheck
+	timeout time.Duration
+
+	// mtx is held while the check runs, so concurrent probes share its result
+	mtx    sync.Mutex
+	result HealthCheckResult
+	at     time.Time
+	done   bool
+}
+
+// NewHealth returns a new instance of Health on /healthz and /readyz, with checks timing
+// out after 5s and results cached for 1s
+func NewHealth() *Health {
+	return &Health{
+		LivePath:  "/healthz",
+		ReadyPath: "/readyz",
+		Timeout:   5 * time.Second,
+		CacheFor:  time.Second,
+		Clock:     SystemClock,
+	}
+}
+
+// Check registers the check name of the readiness probe, timing out after Timeout.
+func (h *Health) Check(name string, check HealthCheck) {
+	h.CheckTimeout(name, h.Timeout, check)
+}
+
+// CheckTimeout registers the check name of the readiness probe, timing out after timeout.
+// It replaces the check already registered as name.
+func (h *Health) CheckTimeout(name string, timeout time.Duration, check HealthCheck) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	c := &healthCheck{name: name, check: check, timeout: timeout}
+	for i, old := range h.checks {
+		if old.name == name {
+			h.checks[i] = c
+			return
+		}
+	}
+	h.checks = append(h.checks, c)
+}
+
+func (h *Health) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	if r.URL.Path != h.LivePath && r.URL.Path != h.ReadyPath {
+		next(ctx, rw, r)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		rw.Header().Set("Allow", "GET, HEAD")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := HealthStatus{Status: "ok"}
+	if d := DrainFromContext(ctx); r.URL.Path == h.ReadyPath && d.Draining() {
+		status = HealthStatus{Status: "draining", Inflight: d.Inflight()}
+	} else if r.URL.Path == h.ReadyPath {
+		status = h.Ready()
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	if status.Status != "ok" {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if r.Method != "HEAD" {
+		rw.Write(append(b, '\n'))
+	}
+}
+
+// Ready runs the checks, or reuses their recent results, and returns their status.
+func (h *Health) Ready() HealthStatus {
+	h.mtx.Lock()
+	checks := append([]*healthCheck(nil), h.checks...)
+	h.mtx.Unlock()
+
+	status := HealthStatus{Status: "ok", Checks: make(map[string]HealthCheckResult, len(checks))}
+	results := make([]HealthCheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *healthCheck) {
+			defer wg.Done()
+			results[i] = h.run(c)
+		}(i, c)
+	}
+	wg.Wait()
+	for i, c := range checks {
+		status.Checks[c.name] = results[i]
+		if results[i].Status != "ok" {
+			status.Status = "failing"
+		}
+	}
+	return status
+}
+
+// run returns the result of c, running it when its last result expired.
+func (h *Health) run(c *healthCheck) HealthCheckResult {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.done && clockNow(h.Clock).Sub(c.at) < h.CacheFor {
+		return c.result
+	}
+	// the checks don't run with the context of the probe, whose result is shared
+	ctx := context.Background()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() { errs <- c.check(ctx) }()
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		// a check ignoring ctx can't hold up the probes
+		err = ctx.Err()
+	}
+
+	c.result = HealthCheckResult{Status: "ok", Duration: time.Since(start).Round(time.Microsecond).String()}
+	if err != nil {
+		c.result.Status = "failing"
+		c.result.Error = err.Error()
+	}
+	c.at, c.done = clockNow(h.Clock), true
+	return c.result
+}
+
+// PingCheck returns a check pinging p, such as a *sql.DB.
+func PingCheck(p interface {
+	PingContext(ctx context.Context) error
+}) HealthCheck {
+	return p.PingContext
+}
+
+// URLCheck returns a check requesting url, such as the health endpoint of a downstream
+// service, and failing unless it answers with a 2xx or 3xx status.
+func URLCheck(url string) HealthCheck {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode >= 400 {
+			return fmt.Errorf("%s answered %d %s", url, res.StatusCode, http.StatusText(res.StatusCode))
+		}
+		return nil
+	}
+}
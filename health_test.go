@@ -0,0 +1,169 @@
+package camillo
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func probe(t *testing.T, n http.Handler, method, path string) (*httptest.ResponseRecorder, HealthStatus) {
+	req, err := http.NewRequest(method, "http://localhost:3000"+path, nil)
+	expect(t, err, nil)
+	rec := httptest.NewRecorder()
+	n.ServeHTTP(rec, req)
+	var status HealthStatus
+	if rec.Body.Len() > 0 && rec.Header().Get("Content-Type") == "application/json" {
+		expect(t, json.Unmarshal(rec.Body.Bytes(), &status), nil)
+	}
+	return rec, status
+}
+
+func TestHealth(t *testing.T) {
+	var dbErr error
+	h := NewHealth()
+	h.Check("db", func(ctx context.Context) error { return dbErr })
+	h.Check("cache", func(ctx context.Context) error { return nil })
+	h.CacheFor = 0
+
+	n := New()
+	n.Use(h)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, "app")
+	})
+
+	rec, status := probe(t, n, "GET", "/healthz")
+	expect(t, rec.Code, http.StatusOK)
+	expect(t, rec.Header().Get("Content-Type"), "application/json")
+	expect(t, status.Status, "ok")
+
+	rec, status = probe(t, n, "GET", "/readyz")
+	expect(t, rec.Code, http.StatusOK)
+	expect(t, status.Status, "ok")
+	expect(t, status.Checks["db"].Status, "ok")
+	expect(t, status.Checks["cache"].Status, "ok")
+
+	dbErr = errors.New("connection refused")
+	rec, status = probe(t, n, "GET", "/readyz")
+	expect(t, rec.Code, http.StatusServiceUnavailable)
+	expect(t, status.Status, "failing")
+	expect(t, status.Checks["db"].Status, "failing")
+	expect(t, status.Checks["db"].Error, "connection refused")
+	expect(t, status.Checks["cache"].Status, "ok")
+
+	// the liveness probe doesn't depend on the checks
+	rec, _ = probe(t, n, "GET", "/healthz")
+	expect(t, rec.Code, http.StatusOK)
+
+	rec, _ = probe(t, n, "HEAD", "/readyz")
+	expect(t, rec.Code, http.StatusServiceUnavailable)
+	expect(t, rec.Body.Len(), 0)
+
+	rec, _ = probe(t, n, "POST", "/readyz")
+	expect(t, rec.Code, http.StatusMethodNotAllowed)
+	expect(t, rec.Header().Get("Allow"), "GET, HEAD")
+
+	req, _ := http.NewRequest("GET", "http://localhost:3000/other", nil)
+	other := httptest.NewRecorder()
+	n.ServeHTTP(other, req)
+	expect(t, other.Body.String(), "app")
+}
+
+func TestHealthCache(t *testing.T) {
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+	var runs int32
+	h := NewHealth()
+	h.Clock = clock
+	h.Check("db", func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	})
+
+	h.Ready()
+	h.Ready()
+	expect(t, atomic.LoadInt32(&runs), int32(1))
+
+	clock.Advance(h.CacheFor)
+	h.Ready()
+	expect(t, atomic.LoadInt32(&runs), int32(2))
+}
+
+func TestHealthTimeout(t *testing.T) {
+	h := NewHealth()
+	h.Check("fast", func(ctx context.Context) error { return nil })
+	h.CheckTimeout("slow", 10*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	// a check ignoring its context times out too
+	h.CheckTimeout("stuck", 10*time.Millisecond, func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	start := time.Now()
+	status := h.Ready()
+	expect(t, time.Since(start) < 500*time.Millisecond, true)
+	expect(t, status.Status, "failing")
+	expect(t, status.Checks["fast"].Status, "ok")
+	expect(t, status.Checks["slow"].Error, "context deadline exceeded")
+	expect(t, status.Checks["stuck"].Error, "context deadline exceeded")
+}
+
+func TestURLCheck(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	expect(t, URLCheck(up.URL)(context.Background()), nil)
+	err := URLCheck(down.URL)(context.Background())
+	refute(t, err, nil)
+	expect(t, err.Error(), down.URL+" answered 503 Service Unavailable")
+}
+
+func TestHealthDraining(t *testing.T) {
+	h := NewHealth()
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.ShutdownDelay = 200 * time.Millisecond
+	n.Use(h)
+
+	errs := make(chan error, 1)
+	go func() { errs <- n.Run("127.0.0.1:3028") }()
+	awaitListening(t, "127.0.0.1:3028")
+
+	res, err := http.Get("http://127.0.0.1:3028/readyz")
+	expect(t, err, nil)
+	res.Body.Close()
+	expect(t, res.StatusCode, http.StatusOK)
+
+	// the server keeps serving for ShutdownDelay, out of rotation
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	time.Sleep(50 * time.Millisecond)
+	res, err = http.Get("http://127.0.0.1:3028/readyz")
+	expect(t, err, nil)
+	var status HealthStatus
+	json.NewDecoder(res.Body).Decode(&status)
+	res.Body.Close()
+	expect(t, res.StatusCode, http.StatusServiceUnavailable)
+	expect(t, status.Status, "draining")
+
+	res, err = http.Get("http://127.0.0.1:3028/healthz")
+	expect(t, err, nil)
+	res.Body.Close()
+	expect(t, res.StatusCode, http.StatusOK)
+
+	expect(t, <-errs, nil)
+}
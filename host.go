@@ -0,0 +1,80 @@
+package camillo
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// HostAllowlist is a middleware handler that rejects requests whose Host header is not on
+// the allowlist with a 400. This prevents Host header poisoning of generated absolute URLs
+// and cache keys.
+type HostAllowlist struct {
+	// Hosts are the allowed hosts. An entry without a port allows any port, and an entry
+	// starting with "*." allows every subdomain of the rest of the entry.
+	Hosts []string
+	// BypassPaths are path.Match patterns of request paths that are never rejected, such as
+	// health checks from load balancers that connect by IP.
+	BypassPaths []string
+}
+
+// NewHostAllowlist returns a new instance of HostAllowlist
+func NewHostAllowlist(hosts ...string) *HostAllowlist {
+	return &HostAllowlist{
+		Hosts: hosts,
+	}
+}
+
+func (h *HostAllowlist) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	if h.Allowed(r.Host) || h.bypass(r.URL.Path) {
+		next(ctx, rw, r)
+		return
+	}
+	http.Error(rw, "invalid host", http.StatusBadRequest)
+}
+
+// Allowed returns whether or not the host, as found in a Host header, is on the allowlist.
+func (h *HostAllowlist) Allowed(host string) bool {
+	name, port := splitHost(host)
+	if name == "" {
+		return false
+	}
+
+	for _, allowed := range h.Hosts {
+		allowedName, allowedPort := splitHost(allowed)
+		if allowedPort != "" && allowedPort != port {
+			continue
+		}
+		if strings.HasPrefix(allowedName, "*.") {
+			if strings.HasSuffix(name, allowedName[1:]) {
+				return true
+			}
+			continue
+		}
+		if name == allowedName {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *HostAllowlist) bypass(p string) bool {
+	for _, pattern := range h.BypassPaths {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHost splits a host into its normalized name and port.
+func splitHost(host string) (string, string) {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = strings.Trim(host, "[]"), ""
+	}
+	return strings.TrimSuffix(strings.ToLower(name), "."), port
+}
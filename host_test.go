@@ -0,0 +1,58 @@
+package camillo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHostAllowlist(t *testing.T) {
+	h := NewHostAllowlist("example.com", "*.example.org", "localhost:3000", "[::1]")
+
+	for host, allowed := range map[string]bool{
+		"example.com":          true,
+		"EXAMPLE.com.":         true,
+		"example.com:8080":     true,
+		"www.example.com":      false,
+		"api.example.org":      true,
+		"a.b.example.org:443":  true,
+		"example.org":          false,
+		"evilexample.org":      false,
+		"localhost:3000":       true,
+		"localhost":            false,
+		"localhost:3001":       false,
+		"[::1]:8080":           true,
+		"":                     false,
+		"example.com.evil.com": false,
+	} {
+		if h.Allowed(host) != allowed {
+			t.Errorf("Expected Allowed(%q) to be %v", host, allowed)
+		}
+	}
+}
+
+func TestHostAllowlistServeHTTP(t *testing.T) {
+	h := NewHostAllowlist("example.com")
+	h.BypassPaths = []string{"/healthz"}
+
+	n := New(h)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	for url, status := range map[string]int{
+		"http://example.com/":     http.StatusOK,
+		"http://evil.com/":        http.StatusBadRequest,
+		"http://10.0.0.1/healthz": http.StatusOK,
+	} {
+		recorder := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		n.ServeHTTP(recorder, req)
+		if recorder.Code != status {
+			t.Errorf("Expected %d for %s - Got %d", status, url, recorder.Code)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+//go:build http3
+
+package camillo
+
+import (
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func init() {
+	newHTTP3 = listenHTTP3
+}
+
+// listenHTTP3 returns the HTTP/3 server serving the handler of srv over QUIC, on the UDP
+// port of its address, for RunTLS. The camillo stack runs unchanged on the QUIC streams,
+// whose ResponseWriter flushes like the HTTP/1 and HTTP/2 ones but can't be hijacked.
+func listenHTTP3(srv *http.Server, certFile, keyFile string) (serving, http.Handler) {
+	h3 := &http3.Server{
+		Addr:      srv.Addr,
+		Handler:   srv.Handler,
+		TLSConfig: srv.TLSConfig,
+	}
+	handler := srv.Handler
+	advertise := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		h3.SetQUICHeaders(rw.Header())
+		handler.ServeHTTP(rw, r)
+	})
+	return serving{h3, func() error { return h3.ListenAndServeTLS(certFile, keyFile) }}, advertise
+}
@@ -0,0 +1,33 @@
+//go:build http3
+
+package camillo
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRunTLSAdvertisesHTTP3(t *testing.T) {
+	certFile, keyFile, pool := writeTestCert(t)
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+
+	errs := make(chan error, 1)
+	go func() { errs <- n.RunTLS("127.0.0.1:3023", certFile, keyFile) }()
+	awaitListening(t, "127.0.0.1:3023")
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	res, err := client.Get("https://127.0.0.1:3023/")
+	expect(t, err, nil)
+	res.Body.Close()
+	expect(t, strings.HasPrefix(res.Header.Get("Alt-Svc"), `h3=":3023"`), true)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	expect(t, <-errs, nil)
+}
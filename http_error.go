@@ -0,0 +1,112 @@
+package camillo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// HTTPError is an error answered with an HTTP status. Handlers can hand an HTTPError to
+// the Errors middleware with Fail, or panic with one to end the request early from deeply
+// nested code, and Recovery answers with its code and message:
+//
+//	if user == nil {
+//		panic(camillo.HTTPError{Code: http.StatusNotFound, Message: "no such user"})
+//	}
+type HTTPError struct {
+	// Code is the status of the response
+	Code int
+	// Message is shown to the client. The status text is shown when it is empty.
+	Message string
+	// Err is the internal cause of the error. It is logged but never shown to the client.
+	Err error
+	// Fields are the errors of individual fields, such as form or JSON fields failing
+	// validation, in the JSON responses.
+	Fields map[string]string
+}
+
+func (e HTTPError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = http.StatusText(e.Code)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %s", e.Code, message, e.Err)
+	}
+	return fmt.Sprintf("%d %s", e.Code, message)
+}
+
+// Unwrap returns the internal cause of the error.
+func (e HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// asHTTPError returns the HTTPError in the chain of err, as a value or a pointer.
+func asHTTPError(err error) (HTTPError, bool) {
+	var e HTTPError
+	if errors.As(err, &e) {
+		return e, true
+	}
+	var p *HTTPError
+	if errors.As(err, &p) && p != nil {
+		return *p, true
+	}
+	return HTTPError{}, false
+}
+
+// errorResponse is the body of the error responses to the clients accepting JSON. The
+// panic and its stack are only included by Recovery when its mode shows them.
+type errorResponse struct {
+	Error      string            `json:"error"`
+	RequestID  string            `json:"request_id,omitempty"`
+	IncidentID string            `json:"incident_id,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"`
+	Panic      string            `json:"panic,omitempty"`
+	Stack      string            `json:"stack,omitempty"`
+}
+
+// writeHTTPError answers r with e in the format negotiated with the Accept header: JSON
+// for API clients, DefaultErrorPage for browsers when html is set, and plain text
+// otherwise. The internal cause of e is never written.
+func writeHTTPError(rw http.ResponseWriter, r *http.Request, id string, e HTTPError, html bool) {
+	var accept string
+	if r != nil {
+		accept = r.Header.Get("Accept")
+	}
+
+	switch negotiate(accept, "text/plain", "text/html", "application/json") {
+	case "application/json":
+		body := errorResponse{Error: e.Message, RequestID: id, Fields: e.Fields}
+		if body.Error == "" {
+			body.Error = strings.ToLower(http.StatusText(e.Code))
+		}
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		rw.WriteHeader(e.Code)
+		json.NewEncoder(rw).Encode(body)
+		return
+	case "text/html":
+		if html {
+			page := ErrorPage{Status: e.Code, StatusText: e.Message}
+			if page.StatusText == "" {
+				page.StatusText = http.StatusText(e.Code)
+			}
+			if r != nil {
+				page.Method, page.Path = r.Method, r.URL.Path
+			}
+			rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+			rw.WriteHeader(e.Code)
+			DefaultErrorPage.Execute(rw, page)
+			return
+		}
+	}
+
+	message := e.Message
+	if message == "" {
+		message = http.StatusText(e.Code)
+	}
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.WriteHeader(e.Code)
+	fmt.Fprintln(rw, message)
+}
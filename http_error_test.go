@@ -0,0 +1,31 @@
+package camillo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestHTTPError(t *testing.T) {
+	expect(t, HTTPError{Code: http.StatusNotFound}.Error(), "404 Not Found")
+	expect(t, HTTPError{Code: http.StatusNotFound, Message: "no such user"}.Error(), "404 no such user")
+
+	cause := errors.New("connection refused")
+	err := HTTPError{Code: http.StatusServiceUnavailable, Err: cause}
+	expect(t, err.Error(), "503 Service Unavailable: connection refused")
+	expect(t, errors.Is(err, cause), true)
+}
+
+func TestAsHTTPError(t *testing.T) {
+	e, ok := asHTTPError(fmt.Errorf("loading user: %w", HTTPError{Code: http.StatusNotFound}))
+	expect(t, ok, true)
+	expect(t, e.Code, http.StatusNotFound)
+
+	e, ok = asHTTPError(fmt.Errorf("loading user: %w", &HTTPError{Code: http.StatusGone}))
+	expect(t, ok, true)
+	expect(t, e.Code, http.StatusGone)
+
+	_, ok = asHTTPError(errors.New("boom"))
+	expect(t, ok, false)
+}
@@ -0,0 +1,105 @@
+package camillo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// JournaldSink is a LeveledLogSink sending messages to systemd-journald with its native
+// protocol, so multi-line messages such as panic stacks stay one journal entry. Levels are
+// mapped to the PRIORITY field like syslog severities.
+type JournaldSink struct {
+	// Socket is the path of the journald socket.
+	Socket string
+	// Identifier is the SYSLOG_IDENTIFIER field of the messages.
+	Identifier string
+	// Level is the level of the messages logged with Printf.
+	Level LogLevel
+	// Fields are additional journal fields of every message, such as "SERVICE_VERSION".
+	// Names are upper case letters, digits and underscores.
+	Fields map[string]string
+
+	mtx  sync.Mutex
+	conn *net.UnixConn
+}
+
+// NewJournaldSink returns a new JournaldSink sending messages identified by identifier to
+// the local journald
+func NewJournaldSink(identifier string) *JournaldSink {
+	return &JournaldSink{
+		Socket:     "/run/systemd/journal/socket",
+		Identifier: identifier,
+		Level:      LogLevelInfo,
+	}
+}
+
+// Printf sends the message at Level.
+func (s *JournaldSink) Printf(format string, v ...interface{}) {
+	s.Logf(s.Level, format, v...)
+}
+
+// Logf sends the message at the priority of level. Messages that can't be sent are written
+// to stderr.
+func (s *JournaldSink) Logf(level LogLevel, format string, v ...interface{}) {
+	msg := strings.TrimSuffix(fmt.Sprintf(format, v...), "\n")
+
+	var buf bytes.Buffer
+	journalField(&buf, "MESSAGE", msg)
+	journalField(&buf, "PRIORITY", strconv.Itoa(syslogSeverity(level)))
+	if s.Identifier != "" {
+		journalField(&buf, "SYSLOG_IDENTIFIER", s.Identifier)
+	}
+	for name, value := range s.Fields {
+		journalField(&buf, name, value)
+	}
+
+	if err := s.send(buf.Bytes()); err != nil {
+		fmt.Fprintf(os.Stderr, "camillo: journald: %s: %s\n", err, msg)
+	}
+}
+
+// Close closes the connection to journald.
+func (s *JournaldSink) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// journalField appends a field to a journal entry. Values with newlines are written with
+// their length as a little endian uint64.
+func journalField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(name + "=" + value + "\n")
+		return
+	}
+	buf.WriteString(name + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+func (s *JournaldSink) send(entry []byte) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.conn == nil {
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: s.Socket, Net: "unixgram"})
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	_, err := s.conn.Write(entry)
+	return err
+}
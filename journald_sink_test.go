@@ -0,0 +1,37 @@
+package camillo
+
+import (
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestJournaldSink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("journald is not available on windows")
+	}
+
+	socket := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink := NewJournaldSink("app")
+	sink.Socket = socket
+	defer sink.Close()
+
+	sink.Printf("Started GET /\n")
+	logf(sink, LogLevelError, "PANIC: %s\n%s", "boom", "goroutine 1")
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	expect(t, err, nil)
+	expect(t, string(buf[:n]), "MESSAGE=Started GET /\nPRIORITY=6\nSYSLOG_IDENTIFIER=app\n")
+
+	n, err = conn.Read(buf)
+	expect(t, err, nil)
+	expect(t, string(buf[:n]), "MESSAGE\n\x17\x00\x00\x00\x00\x00\x00\x00PANIC: boom\ngoroutine 1\nPRIORITY=3\nSYSLOG_IDENTIFIER=app\n")
+}
@@ -0,0 +1,89 @@
+package camillo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// LogSink receives the messages logged by the Logger, Recovery and other middleware. A
+// *log.Logger satisfies it, as do the loggers of logrus (*logrus.Logger, *logrus.Entry)
+// and zerolog (*zerolog.Logger). Other loggers can be adapted with LogSinkFunc, for
+// example a zap logger with LogSinkFunc(zapLogger.Sugar().Infof).
+type LogSink interface {
+	Printf(format string, v ...interface{})
+}
+
+// LogSinkFunc is an adapter to allow the use of ordinary functions as a LogSink.
+type LogSinkFunc func(format string, v ...interface{})
+
+// Printf calls f(format, v...).
+func (f LogSinkFunc) Printf(format string, v ...interface{}) {
+	f(format, v...)
+}
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+// String returns the lower case name of the level.
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// LeveledLogSink is a LogSink that logs messages at a level. The Logger middleware logs
+// at a level that depends on the response status when its sink is leveled.
+type LeveledLogSink interface {
+	LogSink
+	Logf(level LogLevel, format string, v ...interface{})
+}
+
+// logf logs a message at level when sink is leveled, and with Printf otherwise.
+func logf(sink LogSink, level LogLevel, format string, v ...interface{}) {
+	if leveled, ok := sink.(LeveledLogSink); ok {
+		leveled.Logf(level, format, v...)
+		return
+	}
+	sink.Printf(format, v...)
+}
+
+type slogSink struct {
+	l     *slog.Logger
+	level slog.Level
+}
+
+// NewSlogSink returns a LeveledLogSink that writes the messages to l. Printf logs at level.
+func NewSlogSink(l *slog.Logger, level slog.Level) LeveledLogSink {
+	return &slogSink{l: l, level: level}
+}
+
+func (s *slogSink) Printf(format string, v ...interface{}) {
+	s.l.Log(context.Background(), s.level, fmt.Sprintf(format, v...))
+}
+
+var slogLevels = map[LogLevel]slog.Level{
+	LogLevelDebug: slog.LevelDebug,
+	LogLevelInfo:  slog.LevelInfo,
+	LogLevelWarn:  slog.LevelWarn,
+	LogLevelError: slog.LevelError,
+}
+
+func (s *slogSink) Logf(level LogLevel, format string, v ...interface{}) {
+	s.l.Log(context.Background(), slogLevels[level], fmt.Sprintf(format, v...))
+}
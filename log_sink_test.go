@@ -0,0 +1,96 @@
+package camillo
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogSinkFunc(t *testing.T) {
+	var lines []string
+	sink := LogSinkFunc(func(format string, v ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, v...))
+	})
+
+	l := NewLogger()
+	l.Logger = sink
+	n := New(l)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost:3000/foobar", nil)
+	n.ServeHTTP(httptest.NewRecorder(), req)
+
+	expect(t, len(lines), 2)
+	expect(t, lines[0], "Started GET /foobar")
+	expect(t, strings.HasPrefix(lines[1], "Completed 200 OK in "), true)
+}
+
+func TestSlogSink(t *testing.T) {
+	var buff bytes.Buffer
+	sink := NewSlogSink(slog.New(slog.NewTextHandler(&buff, nil)), slog.LevelWarn)
+
+	rec := NewRecovery()
+	rec.Logger = sink
+	rec.PrintStack = false
+	n := New(rec)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		panic("here's your panic!")
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	n.ServeHTTP(httptest.NewRecorder(), req)
+
+	expect(t, strings.Contains(buff.String(), "level=ERROR"), true)
+	expect(t, strings.Contains(buff.String(), "PANIC: here's your panic!"), true)
+}
+
+type leveledRecorder []string
+
+func (s *leveledRecorder) Printf(format string, v ...interface{}) {
+	s.Logf(LogLevelInfo, format, v...)
+}
+
+func (s *leveledRecorder) Logf(level LogLevel, format string, v ...interface{}) {
+	*s = append(*s, level.String()+" "+fmt.Sprintf(format, v...))
+}
+
+func TestLoggerStatusLevels(t *testing.T) {
+	var lines leveledRecorder
+	l := NewLogger()
+	l.Logger = &lines
+
+	n := New(l)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			rw.WriteHeader(http.StatusNotFound)
+		case "/error":
+			rw.WriteHeader(http.StatusInternalServerError)
+		default:
+			rw.WriteHeader(http.StatusFound)
+		}
+	})
+
+	for _, path := range []string{"/", "/missing", "/error"} {
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expect(t, len(lines), 6)
+	expect(t, lines[0], "info Started GET /")
+	expect(t, strings.HasPrefix(lines[1], "info Completed 302"), true)
+	expect(t, strings.HasPrefix(lines[3], "warn Completed 404"), true)
+	expect(t, strings.HasPrefix(lines[5], "error Completed 500"), true)
+
+	lines = nil
+	l.StatusLevels = map[int]LogLevel{4: LogLevelDebug}
+	req, _ := http.NewRequest("GET", "http://localhost:3000/missing", nil)
+	n.ServeHTTP(httptest.NewRecorder(), req)
+	expect(t, strings.HasPrefix(lines[1], "debug Completed 404"), true)
+}
@@ -1,31 +1,412 @@
 package camillo
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"golang.org/x/net/context"
 )
 
+// LoggerFormat is the output format of the Logger middleware.
+type LoggerFormat int
+
+const (
+	// LoggerFormatText logs a line when a request starts and another when it completes.
+	LoggerFormatText LoggerFormat = iota
+	// LoggerFormatJSON logs one JSON object per request when it completes.
+	LoggerFormatJSON
+	// LoggerFormatTemplate logs one line per request when it completes, formatted by the
+	// Logger's Template.
+	LoggerFormatTemplate
+	// LoggerFormatCommon logs one line per request in the NCSA Common Log Format.
+	LoggerFormatCommon
+	// LoggerFormatCombined logs one line per request in the NCSA Combined Log Format, which
+	// adds the Referer and User-Agent to the Common Log Format.
+	LoggerFormatCombined
+)
+
+// LoggerEntry describes a completed request. It is the data of the JSON and template formats.
+// Bytes is the size of the response body and RequestBytes the Content-Length of the request,
+// or -1 when it is unknown. Error is the error recorded with LogError.
+type LoggerEntry struct {
+	Time         time.Time     `json:"time"`
+	Method       string        `json:"method"`
+	Path         string        `json:"path"`
+	Status       int           `json:"status"`
+	Duration     time.Duration `json:"-"`
+	DurationMS   float64       `json:"duration_ms"`
+	Bytes        int           `json:"bytes"`
+	RequestBytes int64         `json:"request_bytes"`
+	RemoteIP     string        `json:"remote_ip"`
+	RequestID    string        `json:"request_id,omitempty"`
+	Slow         bool          `json:"slow,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
+type logErrorKey struct{}
+
+type logError struct {
+	err error
+}
+
+// LogError records err as the error of the request served with ctx, which the Logger
+// includes in its entries. The last recorded error wins. It does nothing when the request
+// isn't served by a Logger.
+func LogError(ctx context.Context, err error) {
+	if e, ok := ctx.Value(logErrorKey{}).(*logError); ok {
+		e.err = err
+	}
+}
+
+// LoggerStatusFilter matches completed requests by status class and duration.
+type LoggerStatusFilter struct {
+	// Class is the status class, such as 2 for 2xx statuses.
+	Class int
+	// Below only matches requests that completed faster than it. Zero matches any duration.
+	Below time.Duration
+}
+
+func (f LoggerStatusFilter) match(status int, duration time.Duration) bool {
+	return status/100 == f.Class && (f.Below == 0 || duration < f.Below)
+}
+
 // Logger is a middleware handler that logs the request as it goes in and the response as it goes out.
 type Logger struct {
-	// Logger inherits from log.Logger used to log messages with the Logger middleware
-	*log.Logger
+	// Logger receives the messages of the Logger middleware
+	Logger LogSink
+	// Clock is used to time requests
+	Clock Clock
+	// Format is the output format. With the JSON, Common and Combined formats a log.Logger
+	// should have no prefix and no flags, so log analyzers can parse every line.
+	Format LoggerFormat
+	// Template formats the lines of LoggerFormatTemplate. It is executed with a LoggerEntry.
+	Template *template.Template
+	// ExcludePaths are path.Match patterns of the request paths that are not logged, such
+	// as "/healthz".
+	ExcludePaths []string
+	// ExcludeStatuses are the completed requests that are not logged, such as 2xx
+	// responses below 100ms. In the text format the Started line has been logged already.
+	ExcludeStatuses []LoggerStatusFilter
+	// ExcludedSampleEvery logs one in every ExcludedSampleEvery excluded requests instead of
+	// dropping all of them. Zero drops all excluded requests.
+	ExcludedSampleEvery int
+	// ClientIP resolves the client IP addresses that are logged behind trusted proxies. The
+	// address of the peer is logged when it is nil. The text format only includes the
+	// address when it is set.
+	ClientIP *ClientIP
+	// SampleEvery logs one in every SampleEvery successful requests. Requests that fail with
+	// a 4xx or 5xx status or take at least SlowThreshold are always logged. In the text format
+	// only sampled requests have a Started line. Zero or one logs every request.
+	SampleEvery int
+	// SlowThreshold is the duration from which requests are considered slow.
+	SlowThreshold time.Duration
+	// OnSlow is called with the entry and the request of every slow request, whether it is
+	// logged or not, after the response has been written. Requests of excluded paths are
+	// not timed.
+	OnSlow func(entry LoggerEntry, r *http.Request)
+	// OnRequestStart is called with the entry of every request before it is served, whether
+	// it is logged or not. Only the request fields of the entry are set.
+	OnRequestStart func(entry LoggerEntry, r *http.Request)
+	// OnRequestEnd is called with the entry of every request after the response has been
+	// written, whether it is logged or not. Requests that panic past the Logger don't end,
+	// Recovery should come after the Logger in the stack.
+	OnRequestEnd func(entry LoggerEntry, r *http.Request)
+	// StatusLevels maps status classes, such as 4 for 4xx statuses, to the level of the
+	// Completed line when the sink is a LeveledLogSink. DefaultStatusLevels is used when it
+	// is nil, other lines are logged at LogLevelInfo.
+	StatusLevels map[int]LogLevel
+	// RateLimit is the maximum number of sampled requests that are logged per second. Failed
+	// and slow requests are not limited. Zero means no limit.
+	RateLimit int
+
+	excluded uint64
+	requests uint64
+	mtx      sync.Mutex
+	window   time.Time
+	logged   int
 }
 
 // NewLogger returns a new Logger instance
 func NewLogger() *Logger {
-	return &Logger{log.New(os.Stdout, "[camillo] ", 0)}
+	return &Logger{Logger: log.New(os.Stdout, "[camillo] ", 0), Clock: SystemClock}
+}
+
+// NewJSONLogger returns a new Logger instance writing JSON lines to stdout
+func NewJSONLogger() *Logger {
+	return &Logger{Logger: log.New(os.Stdout, "", 0), Clock: SystemClock, Format: LoggerFormatJSON}
+}
+
+// NewTemplateLogger returns a new Logger instance formatting its lines with the text/template
+// format, such as "{{.Status}} {{.Duration}} {{.Path}}". The template is executed with a
+// LoggerEntry.
+func NewTemplateLogger(format string) (*Logger, error) {
+	tmpl, err := template.New("logger").Parse(format)
+	if err != nil {
+		return nil, err
+	}
+	return &Logger{
+		Logger:   log.New(os.Stdout, "[camillo] ", 0),
+		Clock:    SystemClock,
+		Format:   LoggerFormatTemplate,
+		Template: tmpl,
+	}, nil
 }
 
 func (l *Logger) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
-	start := time.Now()
-	l.Printf("Started %s %s", r.Method, r.URL.Path)
+	start := clockNow(l.Clock)
+	excludedPath := l.excludedPath(r.URL.Path)
+	hooked := l.OnRequestStart != nil || l.OnRequestEnd != nil
+	logged := !excludedPath || l.sampleExcluded()
+	if !logged && !hooked {
+		next(ctx, rw, r)
+		return
+	}
+	sampled := logged && l.sample(start)
+
+	loggedErr := &logError{}
+	ctx = context.WithValue(ctx, logErrorKey{}, loggedErr)
+	if l.OnRequestStart != nil {
+		l.OnRequestStart(LoggerEntry{
+			Time:         start,
+			Method:       r.Method,
+			Path:         r.URL.Path,
+			RequestBytes: r.ContentLength,
+			RemoteIP:     l.clientIP(r),
+			RequestID:    requestID(ctx, r, rw.(ResponseWriter)),
+		}, r)
+	}
+	if l.Format == LoggerFormatText && sampled {
+		line := fmt.Sprintf("%sStarted %s %s", logPrefix(RequestIDFromContext(ctx)), r.Method, r.URL.Path)
+		if l.ClientIP != nil {
+			line += " for " + l.ClientIP.Resolve(r)
+		}
+		if r.ContentLength > 0 {
+			line += fmt.Sprintf(", %d bytes", r.ContentLength)
+		}
+		logf(l.Logger, LogLevelInfo, "%s", line)
+	}
 
 	next(ctx, rw, r)
 
 	res := rw.(ResponseWriter)
-	l.Printf("Completed %v %s in %v", res.Status(), http.StatusText(res.Status()), time.Since(start))
+	duration := clockNow(l.Clock).Sub(start)
+
+	slow := l.SlowThreshold > 0 && duration >= l.SlowThreshold
+	if slow && logged && l.OnSlow != nil {
+		l.OnSlow(l.entry(ctx, r, res, start, duration, loggedErr.err), r)
+	}
+	if l.OnRequestEnd != nil {
+		l.OnRequestEnd(l.entry(ctx, r, res, start, duration, loggedErr.err), r)
+	}
+	if !logged {
+		return
+	}
+	if !sampled && res.Status() < 400 && !slow {
+		return
+	}
+	if !excludedPath && l.excludedStatus(res.Status(), duration) && !l.sampleExcluded() {
+		return
+	}
+
+	level := l.level(res.Status())
+	if l.Format == LoggerFormatText {
+		logf(l.Logger, level, "%sCompleted %v %s in %v, %d bytes", logPrefix(requestID(ctx, r, res)), res.Status(), http.StatusText(res.Status()), duration, res.Size())
+		return
+	}
+
+	entry := l.entry(ctx, r, res, start, duration, loggedErr.err)
+	switch l.Format {
+	case LoggerFormatJSON:
+		b, err := json.Marshal(entry)
+		if err != nil {
+			logf(l.Logger, LogLevelError, "failed to encode log entry: %s", err)
+			return
+		}
+		logf(l.Logger, level, "%s", b)
+	case LoggerFormatTemplate:
+		var buf bytes.Buffer
+		if err := l.Template.Execute(&buf, entry); err != nil {
+			logf(l.Logger, LogLevelError, "failed to format log entry: %s", err)
+			return
+		}
+		logf(l.Logger, level, "%s", buf.Bytes())
+	case LoggerFormatCommon, LoggerFormatCombined:
+		line := commonLogLine(entry, r)
+		if l.Format == LoggerFormatCombined {
+			line += " " + logQuote(r.Referer()) + " " + logQuote(r.UserAgent())
+		}
+		logf(l.Logger, level, "%s", line)
+	}
+}
+
+func (l *Logger) entry(ctx context.Context, r *http.Request, res ResponseWriter, start time.Time, duration time.Duration, err error) LoggerEntry {
+	entry := LoggerEntry{
+		Time:         start,
+		Method:       r.Method,
+		Path:         r.URL.Path,
+		Status:       res.Status(),
+		Duration:     duration,
+		DurationMS:   float64(duration) / float64(time.Millisecond),
+		Bytes:        res.Size(),
+		RequestBytes: r.ContentLength,
+		RemoteIP:     l.clientIP(r),
+		RequestID:    requestID(ctx, r, res),
+		Slow:         l.SlowThreshold > 0 && duration >= l.SlowThreshold,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+// commonLogLine formats a request in the NCSA Common Log Format:
+//
+//	host ident authuser [date] "request line" status bytes
+func commonLogLine(entry LoggerEntry, r *http.Request) string {
+	user := "-"
+	if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = name
+	} else if r.URL.User != nil && r.URL.User.Username() != "" {
+		user = r.URL.User.Username()
+	}
+	size := "-"
+	if entry.Bytes > 0 {
+		size = strconv.Itoa(entry.Bytes)
+	}
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	// the responses without status are sent with a 200
+	status := entry.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	return fmt.Sprintf("%s - %s [%s] %s %d %s",
+		entry.RemoteIP,
+		logEscape(user),
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		logQuote(r.Method+" "+uri+" "+r.Proto),
+		status,
+		size,
+	)
+}
+
+// logEscape escapes an unquoted field of the Common Log Format like logQuote, and its
+// spaces, so it can't forge lines or fields.
+func logEscape(s string) string {
+	s = strconv.Quote(s)
+	return strings.ReplaceAll(s[1:len(s)-1], " ", "%20")
+}
+
+// logQuote quotes a field of the Common Log Format, logging empty fields as "-".
+func logQuote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+// DefaultStatusLevels logs 2xx and 3xx responses at info level, 4xx responses at warn
+// level and 5xx responses at error level.
+var DefaultStatusLevels = map[int]LogLevel{
+	2: LogLevelInfo,
+	3: LogLevelInfo,
+	4: LogLevelWarn,
+	5: LogLevelError,
+}
+
+func (l *Logger) level(status int) LogLevel {
+	levels := l.StatusLevels
+	if levels == nil {
+		levels = DefaultStatusLevels
+	}
+	if level, ok := levels[status/100]; ok {
+		return level
+	}
+	return LogLevelInfo
+}
+
+func (l *Logger) clientIP(r *http.Request) string {
+	if l.ClientIP == nil {
+		return remoteIP(r)
+	}
+	return l.ClientIP.Resolve(r)
+}
+
+func (l *Logger) excludedPath(p string) bool {
+	for _, pattern := range l.ExcludePaths {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Logger) excludedStatus(status int, duration time.Duration) bool {
+	for _, f := range l.ExcludeStatuses {
+		if f.match(status, duration) {
+			return true
+		}
+	}
+	return false
+}
+
+// sample returns whether a request is sampled for logging.
+func (l *Logger) sample(now time.Time) bool {
+	if l.SampleEvery > 1 && atomic.AddUint64(&l.requests, 1)%uint64(l.SampleEvery) != 1 {
+		return false
+	}
+	if l.RateLimit <= 0 {
+		return true
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if window := now.Truncate(time.Second); !window.Equal(l.window) {
+		l.window = window
+		l.logged = 0
+	}
+	if l.logged >= l.RateLimit {
+		return false
+	}
+	l.logged++
+	return true
+}
+
+// sampleExcluded returns whether an excluded request is logged anyway.
+func (l *Logger) sampleExcluded() bool {
+	if l.ExcludedSampleEvery <= 0 {
+		return false
+	}
+	return atomic.AddUint64(&l.excluded, 1)%uint64(l.ExcludedSampleEvery) == 0
+}
+
+// requestID returns the ID set by RequestID, or else the X-Request-Id of the request, or
+// of the response when the request didn't have a valid one. The IDs that could forge log
+// lines are dropped, as RequestID would replace them.
+func requestID(ctx context.Context, r *http.Request, res ResponseWriter) string {
+	if id := RequestIDFromContext(ctx); id != "" {
+		return id
+	}
+	if id := r.Header.Get("X-Request-Id"); validRequestID(id, maxRequestIDLength) {
+		return id
+	}
+	if id := res.Header().Get("X-Request-Id"); validRequestID(id, maxRequestIDLength) {
+		return id
+	}
+	return ""
 }
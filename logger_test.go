@@ -2,10 +2,17 @@ package camillo
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/net/context"
 )
 
 func Test_Logger(t *testing.T) {
@@ -31,3 +38,443 @@ func Test_Logger(t *testing.T) {
 	expect(t, recorder.Code, http.StatusNotFound)
 	refute(t, len(buff.String()), 0)
 }
+
+func Test_LoggerForgedRequestID(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	l := NewLogger()
+	l.Logger = log.New(buff, "", 0)
+
+	n := New(l)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+
+	req, _ := http.NewRequest("GET", "http://localhost:3000/foobar", nil)
+	req.Header.Set("X-Request-Id", "abc\r\nCompleted 200 OK in 1ms")
+	n.ServeHTTP(httptest.NewRecorder(), req)
+	expect(t, strings.Count(buff.String(), "\n"), 2)
+	expect(t, strings.Contains(buff.String(), "abc"), false)
+}
+
+func Test_LoggerClock(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	recorder := httptest.NewRecorder()
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	l := NewLogger()
+	l.Logger = log.New(buff, "[camillo] ", 0)
+	l.Clock = clock
+
+	n := New()
+	n.Use(l)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		clock.Advance(1500 * time.Millisecond)
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	req, err := http.NewRequest("GET", "http://localhost:3000/foobar", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	n.ServeHTTP(recorder, req)
+	expect(t, strings.Contains(buff.String(), "Completed 200 OK in 1.5s"), true)
+}
+
+func Test_LoggerJSON(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	recorder := httptest.NewRecorder()
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	l := NewJSONLogger()
+	l.Logger = log.New(buff, "", 0)
+	l.Clock = clock
+
+	n := New()
+	n.Use(l)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		clock.Advance(1500 * time.Microsecond)
+		rw.Header().Set("X-Request-Id", "abc")
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("created"))
+	}))
+
+	req, err := http.NewRequest("POST", "http://localhost:3000/foobar", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.RemoteAddr = "10.0.0.1:1234"
+
+	n.ServeHTTP(recorder, req)
+	expect(t, buff.String(), `{"time":"2015-06-01T12:00:00Z","method":"POST","path":"/foobar","status":201,"duration_ms":1.5,"bytes":7,"request_bytes":0,"remote_ip":"10.0.0.1","request_id":"abc"}`+"\n")
+
+	var entry LoggerEntry
+	expect(t, json.Unmarshal(buff.Bytes(), &entry), nil)
+	expect(t, entry.Status, http.StatusCreated)
+}
+
+func Test_LoggerTemplate(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	recorder := httptest.NewRecorder()
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	l, err := NewTemplateLogger(`{{.Time.Format "2006-01-02"}} {{.Method}} {{.Path}} {{.Status}} {{.Duration}} {{.Bytes}}`)
+	expect(t, err, nil)
+	l.Logger = log.New(buff, "", 0)
+	l.Clock = clock
+
+	n := New()
+	n.Use(l)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		clock.Advance(20 * time.Millisecond)
+		rw.Write([]byte("hello"))
+	}))
+
+	req, err := http.NewRequest("GET", "http://localhost:3000/foobar", nil)
+	if err != nil {
+		t.Error(err)
+	}
+
+	n.ServeHTTP(recorder, req)
+	expect(t, buff.String(), "2015-06-01 GET /foobar 200 20ms 5\n")
+}
+
+func Test_LoggerTemplateInvalid(t *testing.T) {
+	_, err := NewTemplateLogger(`{{.Status`)
+	refute(t, err, nil)
+}
+
+func Test_LoggerExclusions(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	l := NewLogger()
+	l.Logger = log.New(buff, "", 0)
+	l.Clock = clock
+	l.ExcludePaths = []string{"/healthz", "/assets/*"}
+	l.ExcludeStatuses = []LoggerStatusFilter{{Class: 2, Below: 100 * time.Millisecond}}
+
+	n := New()
+	n.Use(l)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			clock.Advance(time.Second)
+		}
+		if r.URL.Path == "/missing" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string) string {
+		buff.Reset()
+		req, err := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		n.ServeHTTP(httptest.NewRecorder(), req)
+		return buff.String()
+	}
+
+	expect(t, serve("/healthz"), "")
+	expect(t, serve("/assets/app.js"), "")
+	expect(t, serve("/fast"), "Started GET /fast\n")
+	expect(t, serve("/slow"), "Started GET /slow\nCompleted 200 OK in 1s, 0 bytes\n")
+	expect(t, serve("/missing"), "Started GET /missing\nCompleted 404 Not Found in 0s, 0 bytes\n")
+}
+
+func Test_LoggerExclusionSampling(t *testing.T) {
+	buff := bytes.NewBufferString("")
+
+	l := NewJSONLogger()
+	l.Logger = log.New(buff, "", 0)
+	l.ExcludePaths = []string{"/healthz"}
+	l.ExcludedSampleEvery = 3
+
+	n := New()
+	n.Use(l)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 9; i++ {
+		req, err := http.NewRequest("GET", "http://localhost:3000/healthz", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	expect(t, strings.Count(buff.String(), "\n"), 3)
+}
+
+func Test_LoggerClientIP(t *testing.T) {
+	buff := bytes.NewBufferString("")
+
+	l := NewLogger()
+	l.Logger = log.New(buff, "", 0)
+	l.ClientIP, _ = NewClientIP("10.0.0.0/8")
+
+	n := New()
+	n.Use(l)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	req, err := http.NewRequest("GET", "http://localhost:3000/foobar", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	req.RemoteAddr = "10.1.2.3:5678"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	n.ServeHTTP(httptest.NewRecorder(), req)
+	expect(t, strings.HasPrefix(buff.String(), "Started GET /foobar for 203.0.113.7\n"), true)
+}
+
+func Test_LoggerSizes(t *testing.T) {
+	buff := bytes.NewBufferString("")
+
+	l := NewLogger()
+	l.Logger = log.New(buff, "", 0)
+
+	n := New()
+	n.Use(l)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("Hello world"))
+	}))
+
+	req, err := http.NewRequest("POST", "http://localhost:3000/upload", strings.NewReader("payload"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	n.ServeHTTP(httptest.NewRecorder(), req)
+	lines := strings.Split(buff.String(), "\n")
+	expect(t, lines[0], "Started POST /upload, 7 bytes")
+	expect(t, strings.HasSuffix(lines[1], ", 11 bytes"), true)
+}
+
+func Test_LoggerSampling(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	l := NewLogger()
+	l.Logger = log.New(buff, "", 0)
+	l.Clock = clock
+	l.SampleEvery = 10
+	l.SlowThreshold = time.Second
+
+	n := New()
+	n.Use(l)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			clock.Advance(2 * time.Second)
+		case "/error":
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string) {
+		req, err := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for i := 0; i < 20; i++ {
+		serve("/ok")
+	}
+	expect(t, strings.Count(buff.String(), "Completed 200 OK"), 2)
+	expect(t, strings.Count(buff.String(), "Started GET /ok"), 2)
+
+	buff.Reset()
+	serve("/error")
+	serve("/slow")
+	expect(t, strings.Contains(buff.String(), "Completed 500 Internal Server Error"), true)
+	expect(t, strings.Contains(buff.String(), "Completed 200 OK in 2s"), true)
+}
+
+func Test_LoggerOnSlow(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	var slow []LoggerEntry
+	l := NewJSONLogger()
+	l.Logger = log.New(buff, "", 0)
+	l.Clock = clock
+	l.SlowThreshold = time.Second
+	l.ExcludeStatuses = []LoggerStatusFilter{{Class: 2}}
+	l.OnSlow = func(entry LoggerEntry, r *http.Request) {
+		expect(t, r.URL.Query().Get("q"), "x")
+		slow = append(slow, entry)
+	}
+
+	n := New()
+	n.Use(l)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			clock.Advance(1500 * time.Millisecond)
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	for _, path := range []string{"/fast?q=x", "/slow?q=x"} {
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expect(t, len(slow), 1)
+	expect(t, slow[0].Path, "/slow")
+	expect(t, slow[0].Duration, 1500*time.Millisecond)
+	expect(t, slow[0].Slow, true)
+	// excluded by status, but the hook still fires
+	expect(t, buff.String(), "")
+}
+
+func Test_LoggerHooks(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	var started, ended []LoggerEntry
+	l := NewJSONLogger()
+	l.Logger = log.New(buff, "", 0)
+	l.Clock = clock
+	l.ExcludePaths = []string{"/healthz"}
+	l.OnRequestStart = func(entry LoggerEntry, r *http.Request) {
+		started = append(started, entry)
+	}
+	l.OnRequestEnd = func(entry LoggerEntry, r *http.Request) {
+		ended = append(ended, entry)
+	}
+
+	rec := NewRecovery()
+	rec.Logger = log.New(ioutil.Discard, "", 0)
+	rec.PrintStack = false
+
+	n := New(l, rec)
+	n.Use(HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		clock.Advance(time.Second)
+		switch r.URL.Path {
+		case "/panic":
+			panic("boom")
+		case "/invalid":
+			LogError(ctx, errors.New("invalid input"))
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rw.Write([]byte("ok"))
+	}))
+
+	for _, path := range []string{"/healthz", "/invalid", "/panic"} {
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expect(t, len(started), 3)
+	expect(t, started[0].Path, "/healthz")
+	expect(t, started[0].Status, 0)
+	expect(t, len(ended), 3)
+	expect(t, ended[0].Status, http.StatusOK)
+	expect(t, ended[0].Bytes, 2)
+	expect(t, ended[0].Duration, time.Second)
+	expect(t, ended[1].Status, http.StatusBadRequest)
+	expect(t, ended[1].Error, "invalid input")
+	expect(t, ended[2].Status, http.StatusInternalServerError)
+	expect(t, ended[2].Error, "panic: boom")
+
+	// the excluded path is not logged
+	expect(t, strings.Count(buff.String(), "\n"), 2)
+	expect(t, strings.Contains(buff.String(), `"error":"invalid input"`), true)
+}
+
+func Test_LoggerRateLimit(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	l := NewLogger()
+	l.Logger = log.New(buff, "", 0)
+	l.Clock = clock
+	l.RateLimit = 2
+
+	n := New()
+	n.Use(l)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error" {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	serve := func(path string) {
+		req, err := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	for i := 0; i < 5; i++ {
+		serve("/ok")
+	}
+	serve("/error")
+	expect(t, strings.Count(buff.String(), "Completed 200 OK"), 2)
+	expect(t, strings.Count(buff.String(), "Completed 502 Bad Gateway"), 1)
+
+	clock.Advance(time.Second)
+	serve("/ok")
+	expect(t, strings.Count(buff.String(), "Completed 200 OK"), 3)
+}
+
+func Test_LoggerCommonFormats(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	clock := NewManualClock(time.Date(2000, 10, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60)))
+
+	l := NewLogger()
+	l.Logger = log.New(buff, "", 0)
+	l.Clock = clock
+
+	n := New()
+	n.Use(l)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/empty" {
+			rw.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.URL.Path == "/nothing" {
+			return
+		}
+		rw.Write([]byte("Hello world"))
+	}))
+
+	user := "frank"
+	serve := func(path string) string {
+		buff.Reset()
+		req, err := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		req.RemoteAddr = "127.0.0.1:1234"
+		req.Header.Set("Referer", "http://www.example.com/start.html")
+		req.Header.Set("User-Agent", `Mozilla/4.08 "quoted"`)
+		req.SetBasicAuth(user, "secret")
+		n.ServeHTTP(httptest.NewRecorder(), req)
+		return buff.String()
+	}
+
+	l.Format = LoggerFormatCommon
+	expect(t, serve("/apache_pb.gif?a=1"), `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif?a=1 HTTP/1.1" 200 11`+"\n")
+	expect(t, serve("/empty"), `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /empty HTTP/1.1" 204 -`+"\n")
+	expect(t, serve("/nothing"), `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /nothing HTTP/1.1" 200 -`+"\n")
+
+	// the users can't forge lines
+	user = "frank - [10/Oct/2000] \"GET / HTTP/1.1\" 200 1\n127.0.0.1 - \"x\""
+	expect(t, serve("/empty"), `127.0.0.1 - frank%20-%20[10/Oct/2000]%20\"GET%20/%20HTTP/1.1\"%20200%201\n127.0.0.1%20-%20\"x\" [10/Oct/2000:13:55:36 -0700] "GET /empty HTTP/1.1" 204 -`+"\n")
+	user = "frank"
+
+	l.Format = LoggerFormatCombined
+	expect(t, serve("/apache_pb.gif"), `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.1" 200 11 "http://www.example.com/start.html" "Mozilla/4.08 \"quoted\""`+"\n")
+}
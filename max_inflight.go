@@ -0,0 +1,108 @@
+package camillo
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// MaxInflight is a middleware handler capping the number of requests served at once, so
+// a burst of requests, such as the clients retrying together after an outage, queues up
+// instead of overloading a small service. The requests beyond Limit wait for their turn,
+// up to Queue of them and for up to Timeout, and the others are answered with a 503 and a
+// Retry-After header. It should come early in the stack, before the expensive middleware:
+//
+//	n := camillo.New(camillo.NewMaxInflight(64), camillo.NewLogger())
+type MaxInflight struct {
+	// Limit is the number of requests served at once. Zero means no limit. It should be
+	// set before the MaxInflight is used.
+	Limit int
+	// Queue is the number of requests waiting for their turn.
+	Queue int
+	// Timeout is how long a request waits for its turn.
+	Timeout time.Duration
+	// RetryAfter is sent to the refused clients as the delay before retrying, rounded up to
+	// the second.
+	RetryAfter time.Duration
+
+	once    sync.Once
+	slots   chan struct{}
+	waiting int64
+}
+
+// NewMaxInflight returns a new instance of MaxInflight serving limit requests at once,
+// with as many waiting for up to 1s, and asking the refused clients to retry after 1s
+func NewMaxInflight(limit int) *MaxInflight {
+	return &MaxInflight{
+		Limit:      limit,
+		Queue:      limit,
+		Timeout:    time.Second,
+		RetryAfter: time.Second,
+	}
+}
+
+func (m *MaxInflight) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	if m.Limit <= 0 {
+		next(ctx, rw, r)
+		return
+	}
+	slots := m.init()
+	select {
+	case slots <- struct{}{}:
+	default:
+		if !m.wait(ctx) {
+			m.refuse(rw)
+			return
+		}
+	}
+	defer func() { <-slots }()
+	next(ctx, rw, r)
+}
+
+// init returns the slots of the requests served at once, made for Limit the first time.
+func (m *MaxInflight) init() chan struct{} {
+	m.once.Do(func() { m.slots = make(chan struct{}, m.Limit) })
+	return m.slots
+}
+
+// wait waits for the turn of the request of ctx in the queue, and returns whether it got
+// one.
+func (m *MaxInflight) wait(ctx context.Context) bool {
+	if atomic.AddInt64(&m.waiting, 1) > int64(m.Queue) {
+		atomic.AddInt64(&m.waiting, -1)
+		return false
+	}
+	defer atomic.AddInt64(&m.waiting, -1)
+
+	timer := time.NewTimer(m.Timeout)
+	defer timer.Stop()
+	select {
+	case m.init() <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (m *MaxInflight) refuse(rw http.ResponseWriter) {
+	if m.RetryAfter > 0 {
+		rw.Header().Set("Retry-After", strconv.Itoa(int((m.RetryAfter+time.Second-1)/time.Second)))
+	}
+	http.Error(rw, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
+
+// Inflight returns the number of requests being served.
+func (m *MaxInflight) Inflight() int {
+	return len(m.init())
+}
+
+// Queued returns the number of requests waiting for their turn.
+func (m *MaxInflight) Queued() int {
+	return int(atomic.LoadInt64(&m.waiting))
+}
@@ -0,0 +1,126 @@
+package camillo
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestMaxInflight(t *testing.T) {
+	m := NewMaxInflight(1)
+	m.RetryAfter = 1500 * time.Millisecond
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	n := New()
+	n.Use(m)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		io.WriteString(rw, "done")
+	})
+
+	serve := func() <-chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+			rec := httptest.NewRecorder()
+			n.ServeHTTP(rec, req)
+			done <- rec
+		}()
+		return done
+	}
+
+	first := serve()
+	<-started
+	second := serve()
+	for m.Queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	expect(t, m.Inflight(), 1)
+
+	// the queue is full
+	rec := <-serve()
+	expect(t, rec.Code, http.StatusServiceUnavailable)
+	expect(t, rec.Header().Get("Retry-After"), "2")
+
+	// the queued request is served once the first completes
+	release <- struct{}{}
+	expect(t, (<-first).Body.String(), "done")
+	<-started
+	release <- struct{}{}
+	expect(t, (<-second).Body.String(), "done")
+	expect(t, m.Inflight(), 0)
+	expect(t, m.Queued(), 0)
+}
+
+func TestMaxInflightTimeout(t *testing.T) {
+	m := NewMaxInflight(1)
+	m.Timeout = 20 * time.Millisecond
+	started := make(chan struct{})
+	release := make(chan struct{})
+	n := New()
+	n.Use(m)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	go func() {
+		req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-started
+
+	start := time.Now()
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	rec := httptest.NewRecorder()
+	n.ServeHTTP(rec, req)
+	expect(t, rec.Code, http.StatusServiceUnavailable)
+	expect(t, rec.Header().Get("Retry-After"), "1")
+	expect(t, time.Since(start) >= m.Timeout, true)
+	close(release)
+}
+
+func TestMaxInflightNoLimit(t *testing.T) {
+	m := NewMaxInflight(0)
+	n := New(m)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	rec := httptest.NewRecorder()
+	n.ServeHTTP(rec, req)
+	expect(t, rec.Code, http.StatusOK)
+}
+
+func TestMaxInflightCanceled(t *testing.T) {
+	m := NewMaxInflight(1)
+	m.Timeout = time.Hour
+	started := make(chan struct{})
+	release := make(chan struct{})
+	n := New()
+	n.Use(m)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	defer close(release)
+
+	go func() {
+		req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-started
+
+	// a waiting request gives up when the context of the stack is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	waiting := NewWithContext(ctx, m)
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	rec := httptest.NewRecorder()
+	waiting.ServeHTTP(rec, req)
+	expect(t, rec.Code, http.StatusServiceUnavailable)
+}
@@ -0,0 +1,325 @@
+package camillo
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/net/context"
+)
+
+// maxOCSPResponses bounds the OCSP responses cached by MTLS.
+const maxOCSPResponses = 4096
+
+type clientIdentityKey struct{}
+
+// ClientIdentityFromContext returns the identity of the client certificate verified by
+// MTLS, or nil when there is none.
+func ClientIdentityFromContext(ctx context.Context) *ClientIdentity {
+	id, _ := ctx.Value(clientIdentityKey{}).(*ClientIdentity)
+	return id
+}
+
+// ClientIdentity is the identity of a verified client certificate.
+type ClientIdentity struct {
+	// Subject is the distinguished name of the certificate, such as
+	// "CN=billing,OU=services,O=Example".
+	Subject string
+	// CommonName is the common name of the subject.
+	CommonName string
+	// DNSNames, EmailAddresses and URIs are the subject alternative names, the URIs holding
+	// the SPIFFE IDs of the services.
+	DNSNames       []string
+	EmailAddresses []string
+	URIs           []string
+	// Certificate is the verified certificate, and Chain its chain up to the CA.
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+}
+
+// MTLS is a middleware handler authenticating the clients with TLS client certificates,
+// for the services calling each other over mutual TLS. The certificates must be valid for
+// client authentication, chain to ClientCAs and not be revoked, and the identity of the
+// client is then available to the next handlers with ClientIdentityFromContext. The
+// requests without certificate are answered with a 401, and those with an invalid,
+// revoked or unauthorized certificate with a 403.
+//
+// The server must request the certificates, with TLSConfig:
+//
+//	pool, err := camillo.LoadCertPool("clients-ca.pem")
+//	m := camillo.NewMTLS(pool)
+//	m.Authorize = func(id *camillo.ClientIdentity, r *http.Request) bool {
+//		return id.CommonName == "billing"
+//	}
+//	n.Use(m)
+//	srv := n.NewServer(":8443")
+//	srv.TLSConfig = m.TLSConfig()
+//	srv.TLSConfig.Certificates = []tls.Certificate{cert}
+//	n.RunServer(srv)
+type MTLS struct {
+	// ClientCAs are the CAs issuing the client certificates. Every certificate is rejected
+	// when nil.
+	ClientCAs *x509.CertPool
+	// Authorize returns whether the client identified by id may make r. Every verified
+	// client is authorized when nil.
+	Authorize func(id *ClientIdentity, r *http.Request) bool
+	// OCSP checks the certificates with the OCSP responders named in them, caching the
+	// responses until their next update.
+	OCSP bool
+	// OCSPSoftFail accepts the certificates whose responder can't be reached, doesn't know
+	// them or answers with an outdated response, and those whose CA has an expired
+	// revocation list, instead of rejecting them.
+	OCSPSoftFail bool
+	// OCSPTimeout is the time limit of the requests to the OCSP responders.
+	OCSPTimeout time.Duration
+	// Client makes the requests to the OCSP responders, http.DefaultClient when nil.
+	Client *http.Client
+	// Clock is used to check the validity of the certificates and of the revocation
+	// information. It should be set before the MTLS is used.
+	Clock Clock
+
+	mtx       sync.Mutex
+	crls      []*x509.RevocationList
+	responses map[string]*ocsp.Response
+}
+
+// NewMTLS returns a new instance of MTLS for the client certificates issued by clientCAs,
+// with a 5s timeout for the OCSP responders
+func NewMTLS(clientCAs *x509.CertPool) *MTLS {
+	return &MTLS{
+		ClientCAs:   clientCAs,
+		OCSPTimeout: 5 * time.Second,
+		Clock:       SystemClock,
+	}
+}
+
+// LoadCertPool returns a pool of the PEM certificates of files, for the ClientCAs of MTLS.
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("camillo: no certificate in %s", file)
+		}
+	}
+	return pool, nil
+}
+
+// TLSConfig returns the configuration of the servers requesting the client certificates
+// issued by ClientCAs, its certificates still to be set. The handshakes with the clients
+// without certificate succeed, so they are answered with a 401, and the routes outside of
+// the MTLS stack can serve them.
+func (m *MTLS) TLSConfig() *tls.Config {
+	return &tls.Config{
+		ClientCAs:  m.ClientCAs,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+// AddCRL adds the revocation list of a CA, replacing the one of the same CA, so it can be
+// refreshed while serving. The certificates it lists are rejected.
+func (m *MTLS) AddCRL(crl *x509.RevocationList) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	// the list is copied, as the requests read the previous one without the lock
+	crls := []*x509.RevocationList{crl}
+	for _, old := range m.crls {
+		if !bytes.Equal(old.RawIssuer, crl.RawIssuer) {
+			crls = append(crls, old)
+		}
+	}
+	m.crls = crls
+}
+
+// LoadCRL adds the revocation list of the PEM or DER file like AddCRL.
+func (m *MTLS) LoadCRL(file string) error {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(b)
+	if err != nil {
+		return fmt.Errorf("camillo: %s: %w", file, err)
+	}
+	m.AddCRL(crl)
+	return nil
+}
+
+func (m *MTLS) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	id, err := m.verify(ctx, r.TLS.PeerCertificates)
+	if err == nil && m.Authorize != nil && !m.Authorize(id, r) {
+		err = errors.New("not authorized")
+	}
+	if err != nil {
+		LogError(ctx, fmt.Errorf("camillo: client certificate %q rejected: %w", r.TLS.PeerCertificates[0].Subject, err))
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	next(context.WithValue(ctx, clientIdentityKey{}, id), rw, r)
+}
+
+// verify verifies the certificates sent by a client, its certificate first, and returns
+// its identity.
+func (m *MTLS) verify(ctx context.Context, certs []*x509.Certificate) (*ClientIdentity, error) {
+	// without pool the system roots would be trusted, and any public certificate with them
+	if m.ClientCAs == nil {
+		return nil, errors.New("no ClientCAs configured")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         m.ClientCAs,
+		Intermediates: intermediates,
+		CurrentTime:   clockNow(m.Clock),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, err
+	}
+	chain := chains[0]
+	// the CA itself isn't checked for revocation
+	for i := 0; i+1 < len(chain); i++ {
+		if err := m.revoked(ctx, chain[i], chain[i+1]); err != nil {
+			return nil, err
+		}
+	}
+
+	cert := chain[0]
+	id := &ClientIdentity{
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		Certificate:    cert,
+		Chain:          chain,
+	}
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+	}
+	return id, nil
+}
+
+// revoked returns an error when cert, issued by issuer, is revoked.
+func (m *MTLS) revoked(ctx context.Context, cert, issuer *x509.Certificate) error {
+	m.mtx.Lock()
+	crls := m.crls
+	m.mtx.Unlock()
+	now := clockNow(m.Clock)
+	for _, crl := range crls {
+		if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) || crl.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("certificate %s revoked at %s", cert.SerialNumber, entry.RevocationTime.Format(time.RFC3339))
+			}
+		}
+		if !crl.NextUpdate.IsZero() && now.After(crl.NextUpdate) && !m.OCSPSoftFail {
+			return fmt.Errorf("revocation list of %s expired at %s", issuer.Subject, crl.NextUpdate.Format(time.RFC3339))
+		}
+	}
+
+	if !m.OCSP || len(cert.OCSPServer) == 0 {
+		return nil
+	}
+	res, err := m.ocspResponse(ctx, cert, issuer)
+	if err == nil && res.Status == ocsp.Unknown {
+		err = fmt.Errorf("certificate %s unknown to its OCSP responder", cert.SerialNumber)
+	}
+	if err != nil {
+		if m.OCSPSoftFail {
+			return nil
+		}
+		return err
+	}
+	if res.Status == ocsp.Revoked {
+		return fmt.Errorf("certificate %s revoked at %s", cert.SerialNumber, res.RevokedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ocspResponse returns the OCSP response for cert, cached until its next update.
+func (m *MTLS) ocspResponse(ctx context.Context, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	key := string(issuer.RawSubject) + cert.SerialNumber.String()
+	now := clockNow(m.Clock)
+	m.mtx.Lock()
+	res, ok := m.responses[key]
+	m.mtx.Unlock()
+	if ok && now.Before(res.NextUpdate) {
+		return res, nil
+	}
+
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	if m.OCSPTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.OCSPTimeout)
+		defer cancel()
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", cert.OCSPServer[0], bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	client := m.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	httpRes, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer httpRes.Body.Close()
+	if httpRes.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder answered %d %s", httpRes.StatusCode, http.StatusText(httpRes.StatusCode))
+	}
+	b, err := io.ReadAll(io.LimitReader(httpRes.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	res, err = ocsp.ParseResponseForCert(b, cert, issuer)
+	if err != nil {
+		return nil, err
+	}
+	// a stale or replayed response says nothing of the certificate now
+	if now.Before(res.ThisUpdate) || !res.NextUpdate.IsZero() && !now.Before(res.NextUpdate) {
+		return nil, fmt.Errorf("OCSP response for certificate %s not valid at %s", cert.SerialNumber, now.Format(time.RFC3339))
+	}
+
+	// the responses without next update are reused for an hour
+	if res.NextUpdate.IsZero() {
+		res.NextUpdate = now.Add(time.Hour)
+	}
+	m.mtx.Lock()
+	if m.responses == nil || len(m.responses) >= maxOCSPResponses {
+		m.responses = make(map[string]*ocsp.Response)
+	}
+	m.responses[key] = res
+	m.mtx.Unlock()
+	return res, nil
+}
@@ -0,0 +1,297 @@
+package camillo
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/net/context"
+)
+
+// testCA issues client certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	expect(t, err, nil)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "camillo test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	expect(t, err, nil)
+	cert, err := x509.ParseCertificate(der)
+	expect(t, err, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a client certificate, for authenticating as a client unless usage says
+// otherwise.
+func (ca *testCA) issue(t *testing.T, serial int64, cn string, usage x509.ExtKeyUsage, ocspServer string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	expect(t, err, nil)
+	spiffe, _ := url.Parse("spiffe://example.com/" + cn)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Example"}},
+		DNSNames:     []string{cn + ".example.com"},
+		URIs:         []*url.URL{spiffe},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	if ocspServer != "" {
+		template.OCSPServer = []string{ocspServer}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	expect(t, err, nil)
+	cert, err := x509.ParseCertificate(der)
+	expect(t, err, nil)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+// mtlsRequest serves a request sent with cert, or without certificate when cert is nil,
+// and returns the response.
+func mtlsRequest(n http.Handler, cert *tls.Certificate) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", "https://localhost:3000/", nil)
+	req.TLS = &tls.ConnectionState{}
+	if cert != nil {
+		req.TLS.PeerCertificates = []*x509.Certificate{cert.Leaf}
+	}
+	rec := httptest.NewRecorder()
+	n.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMTLS(t *testing.T) {
+	ca := newTestCA(t)
+	m := NewMTLS(ca.pool)
+	m.Authorize = func(id *ClientIdentity, r *http.Request) bool {
+		return id.CommonName != "intruder"
+	}
+	n := New()
+	n.Use(m)
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		id := ClientIdentityFromContext(ctx)
+		io.WriteString(rw, id.Subject+" "+strings.Join(id.DNSNames, ",")+" "+strings.Join(id.URIs, ","))
+	})
+
+	billing := ca.issue(t, 2, "billing", x509.ExtKeyUsageClientAuth, "")
+	rec := mtlsRequest(n, &billing)
+	expect(t, rec.Code, http.StatusOK)
+	expect(t, rec.Body.String(), "CN=billing,O=Example billing.example.com spiffe://example.com/billing")
+
+	// no certificate
+	expect(t, mtlsRequest(n, nil).Code, http.StatusUnauthorized)
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	rec = httptest.NewRecorder()
+	n.ServeHTTP(rec, req)
+	expect(t, rec.Code, http.StatusUnauthorized)
+
+	// not authorized
+	intruder := ca.issue(t, 3, "intruder", x509.ExtKeyUsageClientAuth, "")
+	expect(t, mtlsRequest(n, &intruder).Code, http.StatusForbidden)
+
+	// not for clients
+	server := ca.issue(t, 4, "server", x509.ExtKeyUsageServerAuth, "")
+	expect(t, mtlsRequest(n, &server).Code, http.StatusForbidden)
+
+	// another CA
+	other := newTestCA(t).issue(t, 2, "billing", x509.ExtKeyUsageClientAuth, "")
+	expect(t, mtlsRequest(n, &other).Code, http.StatusForbidden)
+
+	// expired
+	m.Clock = NewManualClock(time.Now().Add(2 * time.Hour))
+	expect(t, mtlsRequest(n, &billing).Code, http.StatusForbidden)
+
+	// the system roots aren't trusted without ClientCAs
+	m = NewMTLS(nil)
+	expect(t, mtlsRequest(New(m), &billing).Code, http.StatusForbidden)
+}
+
+func TestMTLSCRL(t *testing.T) {
+	ca := newTestCA(t)
+	m := NewMTLS(ca.pool)
+	n := New()
+	n.Use(m)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+
+	good := ca.issue(t, 2, "good", x509.ExtKeyUsageClientAuth, "")
+	revoked := ca.issue(t, 3, "revoked", x509.ExtKeyUsageClientAuth, "")
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(3), RevocationTime: time.Now().Add(-time.Minute)},
+		},
+	}, ca.cert, ca.key)
+	expect(t, err, nil)
+	file := filepath.Join(t.TempDir(), "ca.crl")
+	os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600)
+	expect(t, m.LoadCRL(file), nil)
+
+	expect(t, mtlsRequest(n, &good).Code, http.StatusOK)
+	expect(t, mtlsRequest(n, &revoked).Code, http.StatusForbidden)
+
+	// a newer list of the CA replaces it
+	der, err = x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(2),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}, ca.cert, ca.key)
+	expect(t, err, nil)
+	crl, err := x509.ParseRevocationList(der)
+	expect(t, err, nil)
+	m.AddCRL(crl)
+	expect(t, mtlsRequest(n, &revoked).Code, http.StatusOK)
+
+	// an expired list rejects every certificate of the CA
+	der, err = x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(3),
+		ThisUpdate: time.Now().Add(-2 * time.Hour),
+		NextUpdate: time.Now().Add(-time.Minute),
+	}, ca.cert, ca.key)
+	expect(t, err, nil)
+	crl, err = x509.ParseRevocationList(der)
+	expect(t, err, nil)
+	m.AddCRL(crl)
+	expect(t, mtlsRequest(n, &good).Code, http.StatusForbidden)
+	m.OCSPSoftFail = true
+	expect(t, mtlsRequest(n, &good).Code, http.StatusOK)
+}
+
+func TestMTLSOCSP(t *testing.T) {
+	ca := newTestCA(t)
+	var queries int32
+	responder := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&queries, 1)
+		b, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(b)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		status := ocsp.Good
+		if req.SerialNumber.Int64() == 3 {
+			status = ocsp.Revoked
+		}
+		thisUpdate, nextUpdate := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+		if req.SerialNumber.Int64() == 5 {
+			// a stale response, replayed by an attacker
+			thisUpdate, nextUpdate = time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour)
+		}
+		res, _ := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   thisUpdate,
+			NextUpdate:   nextUpdate,
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, ca.key)
+		rw.Header().Set("Content-Type", "application/ocsp-response")
+		rw.Write(res)
+	}))
+	defer responder.Close()
+
+	m := NewMTLS(ca.pool)
+	m.OCSP = true
+	n := New()
+	n.Use(m)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+
+	good := ca.issue(t, 2, "good", x509.ExtKeyUsageClientAuth, responder.URL)
+	revoked := ca.issue(t, 3, "revoked", x509.ExtKeyUsageClientAuth, responder.URL)
+	expect(t, mtlsRequest(n, &good).Code, http.StatusOK)
+	expect(t, mtlsRequest(n, &revoked).Code, http.StatusForbidden)
+
+	// the responses are cached
+	expect(t, mtlsRequest(n, &good).Code, http.StatusOK)
+	expect(t, atomic.LoadInt32(&queries), int32(2))
+
+	// stale response
+	stale := ca.issue(t, 5, "stale", x509.ExtKeyUsageClientAuth, responder.URL)
+	expect(t, mtlsRequest(n, &stale).Code, http.StatusForbidden)
+
+	// unreachable responder
+	unreachable := ca.issue(t, 4, "unreachable", x509.ExtKeyUsageClientAuth, "http://127.0.0.1:1/")
+	expect(t, mtlsRequest(n, &unreachable).Code, http.StatusForbidden)
+	m.OCSPSoftFail = true
+	expect(t, mtlsRequest(n, &unreachable).Code, http.StatusOK)
+}
+
+func TestMTLSTLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+	certFile, keyFile, serverPool := writeTestCert(t)
+	serverCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	expect(t, err, nil)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0600)
+	pool, err := LoadCertPool(caFile)
+	expect(t, err, nil)
+	_, err = LoadCertPool(keyFile)
+	refute(t, err, nil)
+
+	m := NewMTLS(pool)
+	n := New()
+	n.Use(m)
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		io.WriteString(rw, ClientIdentityFromContext(ctx).CommonName)
+	})
+	srv := httptest.NewUnstartedServer(n)
+	srv.TLS = m.TLSConfig()
+	srv.TLS.Certificates = []tls.Certificate{serverCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(certs []tls.Certificate) *http.Response {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      serverPool,
+			Certificates: certs,
+		}}}
+		res, err := client.Get(srv.URL)
+		expect(t, err, nil)
+		return res
+	}
+
+	res := get([]tls.Certificate{ca.issue(t, 2, "billing", x509.ExtKeyUsageClientAuth, "")})
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, res.StatusCode, http.StatusOK)
+	expect(t, string(b), "billing")
+
+	res = get(nil)
+	res.Body.Close()
+	expect(t, res.StatusCode, http.StatusUnauthorized)
+}
@@ -0,0 +1,16 @@
+package camillo
+
+import "testing"
+
+func TestNegotiate(t *testing.T) {
+	offers := []string{"text/plain", "text/html", "application/json"}
+
+	expect(t, negotiate("", offers...), "text/plain")
+	expect(t, negotiate("*/*", offers...), "text/plain")
+	expect(t, negotiate("application/json", offers...), "application/json")
+	expect(t, negotiate("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", offers...), "text/html")
+	expect(t, negotiate("application/json;q=0.5, text/*;q=0.4", offers...), "application/json")
+	expect(t, negotiate("text/*;q=0.9, text/plain;q=0.1", offers...), "text/html")
+	expect(t, negotiate("image/png", offers...), "")
+	expect(t, negotiate("*/*;q=0", offers...), "")
+}
@@ -0,0 +1,217 @@
+// Package objectproxy streams objects between clients and an S3-style object store, so
+// large downloads and uploads pass through the application without being buffered in
+// memory.
+//
+// The Handler is a Camillo middleware serving the objects under a prefix:
+//
+//	n := camillo.Classic()
+//	endpoint, _ := url.Parse("https://bucket.s3.eu-west-1.amazonaws.com")
+//	objects := objectproxy.New(endpoint)
+//	objects.Prefix = "/downloads"
+//	objects.Sign = signV4 // adds the credentials of the upstream requests
+//	n.Use(objects)
+package objectproxy
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/fd/camillo"
+	"golang.org/x/net/context"
+)
+
+// forwardedRequestHeaders are the client request headers passed on to the object store.
+var forwardedRequestHeaders = []string{
+	"Range",
+	"If-Range",
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+	"Content-Type",
+	"Content-MD5",
+	"Content-Encoding",
+	"Content-Disposition",
+	"Cache-Control",
+}
+
+// forwardedResponseHeaders are the object store response headers passed on to the client.
+var forwardedResponseHeaders = []string{
+	"Accept-Ranges",
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Length",
+	"Content-Range",
+	"Content-Type",
+	"ETag",
+	"Expires",
+	"Last-Modified",
+}
+
+// Handler is a middleware handler that proxies GET and HEAD requests, and optionally PUT
+// requests, under Prefix to the object store and passes every other request on. Ranges
+// and conditional requests are handled by the object store.
+type Handler struct {
+	// Prefix is the path under which the objects are served. The rest of the path is
+	// the object key.
+	Prefix string
+	// Endpoint is the base URL of the bucket, such as a virtual-hosted S3 bucket URL.
+	Endpoint *url.URL
+	// Sign authorizes an upstream request before it is sent, for example by signing it
+	// with AWS Signature Version 4 or by adding the query of a presigned URL. Requests
+	// are sent unsigned when it is nil.
+	Sign func(*http.Request) error
+	// Client sends the upstream requests.
+	Client *http.Client
+	// AllowPut enables uploads with PUT requests.
+	AllowPut bool
+	// MaxUploadSize is the maximum size of an upload. Zero means no limit.
+	MaxUploadSize int64
+	// BytesPerSecond limits the bandwidth of every download and upload. Zero means no limit.
+	BytesPerSecond int64
+}
+
+// New returns a new Handler proxying the objects under /objects to endpoint
+func New(endpoint *url.URL) *Handler {
+	return &Handler{
+		Prefix:   "/objects",
+		Endpoint: endpoint,
+		Client:   http.DefaultClient,
+	}
+}
+
+func (h *Handler) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next camillo.NextFunc) {
+	prefix := strings.TrimSuffix(h.Prefix, "/") + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		next(ctx, rw, r)
+		return
+	}
+	key := r.URL.Path[len(prefix):]
+	if key == "" || path.Clean("/"+key) != "/"+key {
+		http.NotFound(rw, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET", "HEAD":
+		h.proxy(ctx, rw, r, key, nil)
+	case "PUT":
+		if !h.AllowPut {
+			h.methodNotAllowed(rw)
+			return
+		}
+		if r.ContentLength < 0 {
+			http.Error(rw, http.StatusText(http.StatusLengthRequired), http.StatusLengthRequired)
+			return
+		}
+		if h.MaxUploadSize > 0 && r.ContentLength > h.MaxUploadSize {
+			http.Error(rw, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		var body io.Reader = http.NoBody
+		if r.ContentLength > 0 {
+			body = h.throttle(r.Body)
+		}
+		h.proxy(ctx, rw, r, key, body)
+	default:
+		h.methodNotAllowed(rw)
+	}
+}
+
+func (h *Handler) proxy(ctx context.Context, rw http.ResponseWriter, r *http.Request, key string, body io.Reader) {
+	u := *h.Endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	u.RawPath = ""
+
+	req, err := http.NewRequestWithContext(ctx, r.Method, u.String(), body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if body != nil {
+		req.ContentLength = r.ContentLength
+	}
+	for _, k := range forwardedRequestHeaders {
+		k = http.CanonicalHeaderKey(k)
+		if v, ok := r.Header[k]; ok {
+			req.Header[k] = v
+		}
+	}
+	if h.Sign != nil {
+		if err := h.Sign(req); err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	res, err := h.Client.Do(req)
+	if err != nil {
+		http.Error(rw, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	for _, k := range forwardedResponseHeaders {
+		k = http.CanonicalHeaderKey(k)
+		if v, ok := res.Header[k]; ok {
+			rw.Header()[k] = v
+		}
+	}
+	rw.WriteHeader(res.StatusCode)
+	if r.Method != "HEAD" {
+		io.Copy(rw, h.throttle(res.Body))
+	}
+}
+
+func (h *Handler) methodNotAllowed(rw http.ResponseWriter) {
+	allow := "GET, HEAD"
+	if h.AllowPut {
+		allow += ", PUT"
+	}
+	rw.Header().Set("Allow", allow)
+	http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+func (h *Handler) throttle(r io.Reader) io.Reader {
+	if h.BytesPerSecond <= 0 {
+		return r
+	}
+	return &throttledReader{r: r, rate: float64(h.BytesPerSecond)}
+}
+
+// throttledReader limits the rate at which bytes are read from r with a token bucket of
+// bytes, holding at most a tenth of a second worth of them. The bucket is counted in
+// floats, like the one of camillo.Static, so it doesn't overflow on large objects.
+type throttledReader struct {
+	r      io.Reader
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	burst := t.rate / 10
+	if t.last.IsZero() {
+		t.last = time.Now()
+	}
+	// read at most a tenth of a second worth of bytes at a time to keep the rate smooth
+	if chunk := int(burst); chunk > 0 && len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := t.r.Read(p)
+
+	now := time.Now()
+	t.tokens = math.Min(burst, t.tokens+now.Sub(t.last).Seconds()*t.rate) - float64(n)
+	t.last = now
+	if t.tokens < 0 {
+		time.Sleep(time.Duration(-t.tokens / t.rate * float64(time.Second)))
+	}
+	return n, err
+}
@@ -0,0 +1,188 @@
+package objectproxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fd/camillo"
+	"golang.org/x/net/context"
+)
+
+func expect(t *testing.T, a interface{}, b interface{}) {
+	if a != b {
+		t.Errorf("Expected %v (type %T) - Got %v (type %T)", b, b, a, a)
+	}
+}
+
+// bucket is a minimal object store serving and storing objects by path.
+type bucket struct {
+	mtx     sync.Mutex
+	objects map[string][]byte
+	auth    []string
+}
+
+func (b *bucket) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.auth = append(b.auth, r.Header.Get("Authorization"))
+	switch r.Method {
+	case "PUT":
+		body, _ := ioutil.ReadAll(r.Body)
+		if int64(len(body)) != r.ContentLength {
+			http.Error(rw, "length mismatch", http.StatusBadRequest)
+			return
+		}
+		b.objects[r.URL.Path] = body
+		rw.Header().Set("ETag", `"new"`)
+	default:
+		object, ok := b.objects[r.URL.Path]
+		if !ok {
+			http.NotFound(rw, r)
+			return
+		}
+		rw.Header().Set("ETag", `"v1"`)
+		rw.Header().Set("X-Amz-Request-Id", "internal")
+		http.ServeContent(rw, r, "", time.Time{}, bytes.NewReader(object))
+	}
+}
+
+func setup(t *testing.T) (*bucket, *Handler, http.Handler, func()) {
+	b := &bucket{objects: map[string][]byte{"/bucket/docs/report 1.txt": []byte("Hello world")}}
+	server := httptest.NewServer(b)
+	endpoint, _ := url.Parse(server.URL + "/bucket/")
+
+	h := New(endpoint)
+	h.Sign = func(r *http.Request) error {
+		r.Header.Set("Authorization", "signed "+r.Method)
+		return nil
+	}
+	n := camillo.New(h)
+	n.UseHandler(http.NotFoundHandler())
+	return b, h, n, server.Close
+}
+
+func do(n http.Handler, method, path string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, "http://localhost:3000"+path, body)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	recorder := httptest.NewRecorder()
+	n.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestGet(t *testing.T) {
+	b, _, n, done := setup(t)
+	defer done()
+
+	res := do(n, "GET", "/objects/docs/report%201.txt", nil, nil)
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Body.String(), "Hello world")
+	expect(t, res.Header().Get("ETag"), `"v1"`)
+	expect(t, res.Header().Get("Content-Length"), "11")
+	expect(t, res.Header().Get("X-Amz-Request-Id"), "")
+	expect(t, b.auth[0], "signed GET")
+
+	res = do(n, "GET", "/objects/docs/report%201.txt", nil, map[string]string{"Range": "bytes=6-"})
+	expect(t, res.Code, http.StatusPartialContent)
+	expect(t, res.Body.String(), "world")
+	expect(t, res.Header().Get("Content-Range"), "bytes 6-10/11")
+
+	res = do(n, "GET", "/objects/docs/report%201.txt", nil, map[string]string{"If-None-Match": `"v1"`})
+	expect(t, res.Code, http.StatusNotModified)
+
+	res = do(n, "HEAD", "/objects/docs/report%201.txt", nil, nil)
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Body.Len(), 0)
+
+	res = do(n, "GET", "/objects/missing", nil, nil)
+	expect(t, res.Code, http.StatusNotFound)
+}
+
+func TestPut(t *testing.T) {
+	b, h, n, done := setup(t)
+	defer done()
+
+	res := do(n, "PUT", "/objects/new.txt", strings.NewReader("uploaded"), nil)
+	expect(t, res.Code, http.StatusMethodNotAllowed)
+	expect(t, res.Header().Get("Allow"), "GET, HEAD")
+
+	h.AllowPut = true
+	h.MaxUploadSize = 10
+	res = do(n, "PUT", "/objects/new.txt", strings.NewReader("uploaded"), nil)
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Header().Get("ETag"), `"new"`)
+	expect(t, string(b.objects["/bucket/new.txt"]), "uploaded")
+
+	res = do(n, "PUT", "/objects/empty.txt", strings.NewReader(""), nil)
+	expect(t, res.Code, http.StatusOK)
+	expect(t, string(b.objects["/bucket/empty.txt"]), "")
+
+	res = do(n, "PUT", "/objects/big.txt", strings.NewReader("too large upload"), nil)
+	expect(t, res.Code, http.StatusRequestEntityTooLarge)
+}
+
+func TestInvalidKeys(t *testing.T) {
+	_, _, n, done := setup(t)
+	defer done()
+
+	for _, path := range []string{"/objects/", "/objects/a/../b", "/objects/a//b"} {
+		res := do(n, "GET", path, nil, nil)
+		expect(t, res.Code, http.StatusNotFound)
+	}
+
+	res := do(n, "GET", "/other", nil, nil)
+	expect(t, res.Code, http.StatusNotFound)
+	expect(t, res.Body.String(), "404 page not found\n")
+}
+
+func TestSignError(t *testing.T) {
+	_, h, n, done := setup(t)
+	defer done()
+
+	h.Sign = func(r *http.Request) error { return errors.New("no credentials") }
+	res := do(n, "GET", "/objects/docs/report%201.txt", nil, nil)
+	expect(t, res.Code, http.StatusInternalServerError)
+}
+
+func TestUnreachableStore(t *testing.T) {
+	endpoint, _ := url.Parse("http://127.0.0.1:1/bucket")
+	n := camillo.New(New(endpoint))
+
+	res := do(n, "GET", "/objects/a.txt", nil, nil)
+	expect(t, res.Code, http.StatusBadGateway)
+}
+
+func TestCanceledContext(t *testing.T) {
+	b, h, _, done := setup(t)
+	defer done()
+
+	// the object store isn't called for the requests of a canceled stack
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res := do(camillo.NewWithContext(ctx, h), "GET", "/objects/docs/report%201.txt", nil, nil)
+	expect(t, res.Code, http.StatusBadGateway)
+	expect(t, len(b.auth), 0)
+}
+
+func TestThrottle(t *testing.T) {
+	h := &Handler{BytesPerSecond: 1000}
+
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, h.throttle(bytes.NewReader(make([]byte, 300))))
+	expect(t, err, nil)
+	expect(t, n, int64(300))
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("Expected reading 300 bytes at 1000 B/s to take at least 250ms, took %s", elapsed)
+	}
+}
@@ -0,0 +1,200 @@
+package camillo
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// PageParams are the pagination parameters of a request.
+type PageParams struct {
+	// Page is the requested 1-based page number.
+	Page int
+	// Limit is the requested number of items per page, capped at the maximum limit.
+	Limit int
+	// Cursor is the requested cursor for cursor based pagination.
+	Cursor string
+}
+
+// Offset returns the offset of the first item of the requested page.
+func (p PageParams) Offset() int {
+	return (p.Page - 1) * p.Limit
+}
+
+// Page describes the page of a collection a response contains.
+type Page struct {
+	// Number is the 1-based page number. It is ignored when cursors are used.
+	Number int
+	// Size is the number of items per page.
+	Size int
+	// Total is the total number of items in the collection, or negative when it is unknown.
+	Total int64
+	// HasNext reports that there is a next page when the total is unknown.
+	HasNext bool
+	// NextCursor and PrevCursor are the cursors of the next and previous pages for cursor
+	// based pagination.
+	NextCursor string
+	PrevCursor string
+}
+
+type pageParamsKey struct{}
+type pageKey struct{}
+
+// Pagination is a middleware handler that parses the pagination query parameters of a
+// request and emits RFC 8288 Link headers (first, prev, next, last) and an X-Total-Count
+// header for the page that a handler reports with SetPage.
+type Pagination struct {
+	// DefaultLimit is the number of items per page when the request doesn't specify one.
+	DefaultLimit int
+	// MaxLimit is the maximum number of items per page a request may ask for.
+	MaxLimit int
+	// PageParam, LimitParam and CursorParam are the names of the query parameters.
+	PageParam   string
+	LimitParam  string
+	CursorParam string
+}
+
+// NewPagination returns a new instance of Pagination
+func NewPagination() *Pagination {
+	return &Pagination{
+		DefaultLimit: 30,
+		MaxLimit:     100,
+		PageParam:    "page",
+		LimitParam:   "per_page",
+		CursorParam:  "cursor",
+	}
+}
+
+func (p *Pagination) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	params, err := p.Parse(r)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page := new(Page)
+	page.Total = -1
+	ctx = context.WithValue(ctx, pageParamsKey{}, params)
+	ctx = context.WithValue(ctx, pageKey{}, page)
+
+	if res, ok := rw.(ResponseWriter); ok {
+		res.Before(func(res ResponseWriter) {
+			if page.Size > 0 || page.NextCursor != "" || page.PrevCursor != "" {
+				p.setHeaders(res.Header(), r.URL, *page)
+			}
+		})
+	}
+
+	next(ctx, rw, r)
+}
+
+// Parse returns the pagination parameters of r.
+func (p *Pagination) Parse(r *http.Request) (PageParams, error) {
+	q := r.URL.Query()
+	params := PageParams{Page: 1, Limit: p.DefaultLimit, Cursor: q.Get(p.CursorParam)}
+
+	if v := q.Get(p.PageParam); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return params, fmt.Errorf("invalid %s parameter", p.PageParam)
+		}
+		params.Page = n
+	}
+	if v := q.Get(p.LimitParam); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return params, fmt.Errorf("invalid %s parameter", p.LimitParam)
+		}
+		params.Limit = n
+	}
+	if p.MaxLimit > 0 && params.Limit > p.MaxLimit {
+		params.Limit = p.MaxLimit
+	}
+	return params, nil
+}
+
+// linkPathEscaper escapes the separators of the Link header left in the escaped paths.
+var linkPathEscaper = strings.NewReplacer(";", "%3B", ",", "%2C")
+
+func (p *Pagination) setHeaders(h http.Header, u *url.URL, page Page) {
+	// the path comes from the client, so it must neither end the link nor make it point to
+	// another host
+	path := linkPathEscaper.Replace(u.EscapedPath())
+	if strings.HasPrefix(path, "//") {
+		path = "/" + strings.TrimLeft(path, "/")
+	}
+	var links []string
+	link := func(rel string, set map[string]string) {
+		q := u.Query()
+		for k, v := range set {
+			if v == "" {
+				q.Del(k)
+			} else {
+				q.Set(k, v)
+			}
+		}
+		if page.Size > 0 {
+			q.Set(p.LimitParam, strconv.Itoa(page.Size))
+		}
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, path, q.Encode(), rel))
+	}
+	if page.NextCursor != "" || page.PrevCursor != "" {
+		if page.PrevCursor != "" {
+			link("prev", map[string]string{p.CursorParam: page.PrevCursor, p.PageParam: ""})
+		}
+		if page.NextCursor != "" {
+			link("next", map[string]string{p.CursorParam: page.NextCursor, p.PageParam: ""})
+		}
+	} else {
+		number := page.Number
+		if number < 1 {
+			number = 1
+		}
+		last := 0
+		if page.Total >= 0 {
+			last = int((page.Total + int64(page.Size) - 1) / int64(page.Size))
+			if last < 1 {
+				last = 1
+			}
+		}
+
+		pageLink := func(rel string, n int) {
+			link(rel, map[string]string{p.PageParam: strconv.Itoa(n), p.CursorParam: ""})
+		}
+		pageLink("first", 1)
+		if number > 1 {
+			pageLink("prev", number-1)
+		}
+		if (page.Total >= 0 && number < last) || (page.Total < 0 && page.HasNext) {
+			pageLink("next", number+1)
+		}
+		if page.Total >= 0 {
+			pageLink("last", last)
+		}
+	}
+
+	if len(links) > 0 {
+		h.Set("Link", strings.Join(links, ", "))
+	}
+	if page.Total >= 0 {
+		h.Set("X-Total-Count", strconv.FormatInt(page.Total, 10))
+	}
+}
+
+// PageParamsFromContext returns the pagination parameters parsed by Pagination.
+func PageParamsFromContext(ctx context.Context) (PageParams, bool) {
+	params, ok := ctx.Value(pageParamsKey{}).(PageParams)
+	return params, ok
+}
+
+// SetPage reports the page of the collection that the response contains. It must be
+// called before the response is written and has no effect without Pagination.
+func SetPage(ctx context.Context, page Page) {
+	if p, ok := ctx.Value(pageKey{}).(*Page); ok {
+		*p = page
+	}
+}
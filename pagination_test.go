@@ -0,0 +1,115 @@
+package camillo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestPaginationParse(t *testing.T) {
+	p := NewPagination()
+
+	for query, want := range map[string]PageParams{
+		"":                      {Page: 1, Limit: 30},
+		"page=3&per_page=10":    {Page: 3, Limit: 10},
+		"per_page=1000":         {Page: 1, Limit: 100},
+		"cursor=abc&per_page=5": {Page: 1, Limit: 5, Cursor: "abc"},
+	} {
+		req, _ := http.NewRequest("GET", "http://localhost:3000/items?"+query, nil)
+		params, err := p.Parse(req)
+		expect(t, err, nil)
+		expect(t, params, want)
+	}
+
+	for _, query := range []string{"page=0", "page=x", "per_page=-1"} {
+		req, _ := http.NewRequest("GET", "http://localhost:3000/items?"+query, nil)
+		_, err := p.Parse(req)
+		refute(t, err, nil)
+	}
+
+	expect(t, PageParams{Page: 3, Limit: 10}.Offset(), 20)
+}
+
+func servePagination(t *testing.T, url string, handler func(context.Context, http.ResponseWriter, *http.Request)) *httptest.ResponseRecorder {
+	n := New(NewPagination())
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		handler(ctx, rw, r)
+	})
+
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestPaginationLinks(t *testing.T) {
+	recorder := servePagination(t, "http://localhost:3000/items?page=2&per_page=10&sort=name", func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		params, ok := PageParamsFromContext(ctx)
+		expect(t, ok, true)
+		SetPage(ctx, Page{Number: params.Page, Size: params.Limit, Total: 45})
+		rw.Write([]byte("[]"))
+	})
+
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, recorder.Header().Get("X-Total-Count"), "45")
+	expect(t, recorder.Header().Get("Link"), `</items?page=1&per_page=10&sort=name>; rel="first", `+
+		`</items?page=1&per_page=10&sort=name>; rel="prev", `+
+		`</items?page=3&per_page=10&sort=name>; rel="next", `+
+		`</items?page=5&per_page=10&sort=name>; rel="last"`)
+}
+
+func TestPaginationLinksUnknownTotal(t *testing.T) {
+	recorder := servePagination(t, "http://localhost:3000/items", func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		SetPage(ctx, Page{Number: 1, Size: 30, Total: -1, HasNext: true})
+		rw.Write([]byte("[]"))
+	})
+
+	expect(t, recorder.Header().Get("X-Total-Count"), "")
+	expect(t, recorder.Header().Get("Link"), `</items?page=1&per_page=30>; rel="first", </items?page=2&per_page=30>; rel="next"`)
+}
+
+func TestPaginationCursorLinks(t *testing.T) {
+	recorder := servePagination(t, "http://localhost:3000/items?cursor=b&page=4", func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		SetPage(ctx, Page{Size: 20, Total: -1, PrevCursor: "a", NextCursor: "c"})
+		rw.Write([]byte("[]"))
+	})
+
+	expect(t, recorder.Header().Get("Link"), `</items?cursor=a&per_page=20>; rel="prev", </items?cursor=c&per_page=20>; rel="next"`)
+}
+
+func TestPaginationLinksEscaped(t *testing.T) {
+	handler := func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		SetPage(ctx, Page{Size: 20, Total: -1, NextCursor: "c"})
+		rw.Write([]byte("[]"))
+	}
+
+	// the path can't add links
+	recorder := servePagination(t, "http://localhost:3000/items%3E;%20rel=%22next%22,%20%3Chttps://evil.example/x", handler)
+	expect(t, recorder.Header().Get("Link"), `</items%3E%3B%20rel=%22next%22%2C%20%3Chttps://evil.example/x?cursor=c&per_page=20>; rel="next"`)
+
+	// nor point to another host
+	recorder = servePagination(t, "http://localhost:3000//evil.example/items", handler)
+	expect(t, recorder.Header().Get("Link"), `</evil.example/items?cursor=c&per_page=20>; rel="next"`)
+}
+
+func TestPaginationWithoutPage(t *testing.T) {
+	recorder := servePagination(t, "http://localhost:3000/items", func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("{}"))
+	})
+
+	expect(t, recorder.Header().Get("Link"), "")
+	expect(t, recorder.Header().Get("X-Total-Count"), "")
+}
+
+func TestPaginationInvalidParams(t *testing.T) {
+	recorder := servePagination(t, "http://localhost:3000/items?page=-2", func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+		t.Error("handler should not be called")
+	})
+
+	expect(t, recorder.Code, http.StatusBadRequest)
+}
@@ -0,0 +1,96 @@
+package camillo
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxPanicWindows is the number of fingerprints a PanicLimiter tracks, the expired and then
+// the oldest windows being dropped beyond it.
+const maxPanicWindows = 1024
+
+// PanicLimiter rate limits the logging and reporting of panics per fingerprint, the panic
+// message and the application frame that panicked, so a hot code path panicking thousands
+// of times a minute doesn't flood the logs and the error tracking systems.
+type PanicLimiter struct {
+	// Burst is the number of panics of a fingerprint let through per Interval.
+	Burst int
+	// Interval is the length of the windows the panics are counted in.
+	Interval time.Duration
+	// Clock is the source of time, the system clock when nil.
+	Clock Clock
+
+	mtx     sync.Mutex
+	windows map[string]*panicWindow
+}
+
+type panicWindow struct {
+	start      time.Time
+	allowed    int
+	suppressed int
+}
+
+// NewPanicLimiter returns a new PanicLimiter letting through burst panics of every
+// fingerprint per interval.
+func NewPanicLimiter(burst int, interval time.Duration) *PanicLimiter {
+	return &PanicLimiter{
+		Burst:    burst,
+		Interval: interval,
+	}
+}
+
+// Allow returns whether a panic with the fingerprint should be logged and reported, and
+// when it should, the number of panics of the fingerprint suppressed before it.
+func (l *PanicLimiter) Allow(fingerprint string) (bool, int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := clockNow(l.Clock)
+	w, ok := l.windows[fingerprint]
+	if !ok {
+		if l.windows == nil {
+			l.windows = make(map[string]*panicWindow)
+		}
+		if len(l.windows) >= maxPanicWindows {
+			l.prune(now)
+		}
+		w = &panicWindow{start: now}
+		l.windows[fingerprint] = w
+	}
+	suppressed := 0
+	if now.Sub(w.start) >= l.Interval {
+		suppressed = w.suppressed
+		*w = panicWindow{start: now}
+	}
+	if w.allowed >= l.Burst {
+		w.suppressed++
+		return false, 0
+	}
+	w.allowed++
+	return true, suppressed
+}
+
+// prune drops the windows that expired, or the oldest one when none did, as the panic
+// values and so the fingerprints can be unique.
+func (l *PanicLimiter) prune(now time.Time) {
+	var oldest string
+	for fingerprint, w := range l.windows {
+		if now.Sub(w.start) >= l.Interval {
+			delete(l.windows, fingerprint)
+		} else if oldest == "" || w.start.Before(l.windows[oldest].start) {
+			oldest = fingerprint
+		}
+	}
+	if len(l.windows) >= maxPanicWindows {
+		delete(l.windows, oldest)
+	}
+}
+
+// panicFingerprint identifies the panics with the same message at the same site.
+func panicFingerprint(err interface{}, stack []byte) string {
+	if site, ok := panicSite(stack); ok {
+		return fmt.Sprintf("%v at %s:%d", err, site.File, site.Line)
+	}
+	return fmt.Sprint(err)
+}
@@ -0,0 +1,69 @@
+package camillo
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPanicLimiter(t *testing.T) {
+	clock := NewManualClock(time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC))
+	l := NewPanicLimiter(2, time.Minute)
+	l.Clock = clock
+
+	allow := func(fingerprint string) string {
+		ok, suppressed := l.Allow(fingerprint)
+		if !ok {
+			return "suppressed"
+		}
+		return fmt.Sprintf("allowed %d", suppressed)
+	}
+
+	expect(t, allow("a"), "allowed 0")
+	expect(t, allow("a"), "allowed 0")
+	expect(t, allow("a"), "suppressed")
+	expect(t, allow("a"), "suppressed")
+	expect(t, allow("b"), "allowed 0")
+
+	clock.Advance(time.Minute)
+	expect(t, allow("a"), "allowed 2")
+	expect(t, allow("a"), "allowed 0")
+	expect(t, allow("a"), "suppressed")
+}
+
+func TestPanicLimiterZeroValue(t *testing.T) {
+	l := &PanicLimiter{Burst: 1, Interval: time.Minute}
+	ok, _ := l.Allow("a")
+	expect(t, ok, true)
+	ok, _ = l.Allow("a")
+	expect(t, ok, false)
+}
+
+func TestPanicLimiterPrune(t *testing.T) {
+	clock := NewManualClock(time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC))
+	l := NewPanicLimiter(1, time.Minute)
+	l.Clock = clock
+
+	for i := 0; i < maxPanicWindows; i++ {
+		l.Allow(string(rune(i)))
+	}
+	clock.Advance(time.Minute)
+	l.Allow("new")
+	expect(t, len(l.windows), 1)
+}
+
+func TestPanicLimiterEvict(t *testing.T) {
+	clock := NewManualClock(time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC))
+	l := NewPanicLimiter(1, time.Minute)
+	l.Clock = clock
+
+	l.Allow("oldest")
+	clock.Advance(time.Second)
+	for i := 1; i < maxPanicWindows; i++ {
+		l.Allow(fmt.Sprint(i))
+	}
+	l.Allow("new")
+	expect(t, len(l.windows), maxPanicWindows)
+	_, ok := l.windows["oldest"]
+	expect(t, ok, false)
+}
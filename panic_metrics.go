@@ -0,0 +1,65 @@
+package camillo
+
+import "sync"
+
+// PanicMetrics counts the panics recovered by Recovery, for example in Prometheus or
+// expvar counters. PanicCounter is an in memory implementation.
+type PanicMetrics interface {
+	// PanicRecovered is called for every recovered panic with the path of the request, or
+	// "" when there is none, and the type of the recovered value, such as "runtime.Error".
+	PanicRecovered(path, panicType string)
+}
+
+// PanicCounts is a snapshot of the counts of a PanicCounter.
+type PanicCounts struct {
+	Total  uint64            `json:"total"`
+	ByPath map[string]uint64 `json:"by_path"`
+	ByType map[string]uint64 `json:"by_type"`
+}
+
+// PanicCounter is a PanicMetrics counting the panics in memory, in total, by path and by
+// panic type. Paths with IDs in them should be normalized by a wrapping PanicMetrics, so
+// the number of counters stays bounded.
+type PanicCounter struct {
+	mtx    sync.Mutex
+	total  uint64
+	byPath map[string]uint64
+	byType map[string]uint64
+}
+
+// NewPanicCounter returns a new instance of PanicCounter
+func NewPanicCounter() *PanicCounter {
+	return &PanicCounter{
+		byPath: make(map[string]uint64),
+		byType: make(map[string]uint64),
+	}
+}
+
+// PanicRecovered counts a panic.
+func (c *PanicCounter) PanicRecovered(path, panicType string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.total++
+	c.byPath[path]++
+	c.byType[panicType]++
+}
+
+// Counts returns a snapshot of the counts.
+func (c *PanicCounter) Counts() PanicCounts {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	counts := PanicCounts{
+		Total:  c.total,
+		ByPath: make(map[string]uint64, len(c.byPath)),
+		ByType: make(map[string]uint64, len(c.byType)),
+	}
+	for path, n := range c.byPath {
+		counts.ByPath[path] = n
+	}
+	for typ, n := range c.byType {
+		counts.ByType[typ] = n
+	}
+	return counts
+}
@@ -0,0 +1,45 @@
+package camillo
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPanicCounter(t *testing.T) {
+	counter := NewPanicCounter()
+	rec := NewRecovery()
+	rec.Logger = log.New(ioutil.Discard, "", 0)
+	rec.Metrics = counter
+
+	n := New(rec)
+	n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/nil":
+			var m map[string]int
+			m["x"] = 1
+		case "/error":
+			panic(errors.New("boom"))
+		}
+		panic("here is a panic!")
+	}))
+
+	for _, path := range []string{"/nil", "/error", "/string", "/string"} {
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	counts := counter.Counts()
+	expect(t, counts.Total, uint64(4))
+	expect(t, counts.ByPath["/string"], uint64(2))
+	expect(t, counts.ByPath["/nil"], uint64(1))
+	expect(t, counts.ByType["runtime.Error"], uint64(1))
+	expect(t, counts.ByType["*errors.errorString"], uint64(1))
+	expect(t, counts.ByType["string"], uint64(2))
+
+	counts.ByPath["/string"] = 0
+	expect(t, counter.Counts().ByPath["/string"], uint64(2))
+}
@@ -0,0 +1,110 @@
+package camillo
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+type resourceVersionKey struct{}
+
+// WithResourceVersion returns a copy of ctx carrying the current version of the requested
+// resource as an entity tag. The version is quoted if it isn't already.
+func WithResourceVersion(ctx context.Context, version string) context.Context {
+	if !strings.HasSuffix(version, `"`) {
+		version = `"` + version + `"`
+	}
+	return context.WithValue(ctx, resourceVersionKey{}, version)
+}
+
+// ResourceVersion returns the entity tag stored in ctx with WithResourceVersion.
+func ResourceVersion(ctx context.Context) (string, bool) {
+	version, ok := ctx.Value(resourceVersionKey{}).(string)
+	return version, ok
+}
+
+// Preconditions is a middleware handler that implements optimistic concurrency for
+// unsafe methods. Handlers before it load the current version of the resource and pass
+// it on with WithResourceVersion; Preconditions then evaluates the If-Match and
+// If-None-Match headers against that version and responds with a 412 when they fail.
+// A context without a version means the resource doesn't exist.
+type Preconditions struct {
+	// Methods are the request methods preconditions are checked for.
+	Methods []string
+	// Require responds with a 428 to requests that carry neither If-Match nor If-None-Match.
+	Require bool
+}
+
+// NewPreconditions returns a new instance of Preconditions
+func NewPreconditions() *Preconditions {
+	return &Preconditions{
+		Methods: []string{"PUT", "PATCH", "DELETE"},
+	}
+}
+
+func (p *Preconditions) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	checked := false
+	for _, m := range p.Methods {
+		if r.Method == m {
+			checked = true
+			break
+		}
+	}
+	if !checked {
+		next(ctx, rw, r)
+		return
+	}
+
+	if p.Require && r.Header.Get("If-Match") == "" && r.Header.Get("If-None-Match") == "" {
+		http.Error(rw, http.StatusText(http.StatusPreconditionRequired), http.StatusPreconditionRequired)
+		return
+	}
+
+	version, exists := ResourceVersion(ctx)
+	if status := CheckPreconditions(r, version, exists); status != 0 {
+		http.Error(rw, http.StatusText(status), status)
+		return
+	}
+
+	next(ctx, rw, r)
+}
+
+// CheckPreconditions evaluates the If-Match and If-None-Match headers of r against the
+// entity tag of the current resource, or against a missing resource when exists is
+// false. It returns http.StatusPreconditionFailed when a precondition fails and 0
+// otherwise.
+func CheckPreconditions(r *http.Request, etag string, exists bool) int {
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !exists || !matchETag(im, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if exists && matchETag(inm, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	return 0
+}
+
+// matchETag reports whether etag matches the list of entity tags in header, using weak
+// or strong comparison. The list "*" matches any entity tag.
+func matchETag(header, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+		if !strings.HasPrefix(candidate, "W/") && !strings.HasPrefix(etag, "W/") && candidate == etag {
+			return true
+		}
+	}
+	return false
+}
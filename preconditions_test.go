@@ -0,0 +1,88 @@
+package camillo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func newPreconditionsTest(version string, p *Preconditions) *Camillo {
+	n := New()
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		if version != "" {
+			ctx = WithResourceVersion(ctx, version)
+		}
+		next(ctx, rw, r)
+	})
+	n.Use(p)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	return n
+}
+
+func TestPreconditions(t *testing.T) {
+	tests := []struct {
+		method  string
+		version string
+		header  string
+		value   string
+		status  int
+	}{
+		{"PUT", "v1", "If-Match", `"v1"`, http.StatusNoContent},
+		{"PUT", "v1", "If-Match", `"v0", "v1"`, http.StatusNoContent},
+		{"PUT", "v1", "If-Match", `"v0"`, http.StatusPreconditionFailed},
+		{"PUT", "v1", "If-Match", `W/"v1"`, http.StatusPreconditionFailed},
+		{"PUT", "v1", "If-Match", `*`, http.StatusNoContent},
+		{"PUT", "", "If-Match", `*`, http.StatusPreconditionFailed},
+		{"PUT", "", "If-None-Match", `*`, http.StatusNoContent},
+		{"PUT", "v1", "If-None-Match", `*`, http.StatusPreconditionFailed},
+		{"PATCH", "v1", "If-None-Match", `W/"v1"`, http.StatusPreconditionFailed},
+		{"PATCH", `W/"v1"`, "If-None-Match", `"v2"`, http.StatusNoContent},
+		{"GET", "v1", "If-Match", `"v0"`, http.StatusNoContent},
+		{"DELETE", "v1", "", "", http.StatusNoContent},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		req, err := http.NewRequest(test.method, "http://localhost:3000/users/1", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if test.header != "" {
+			req.Header.Set(test.header, test.value)
+		}
+
+		newPreconditionsTest(test.version, NewPreconditions()).ServeHTTP(recorder, req)
+		if recorder.Code != test.status {
+			t.Errorf("%s %s=%s against %s: expected %d - got %d", test.method, test.header, test.value, test.version, test.status, recorder.Code)
+		}
+	}
+}
+
+func TestPreconditionsRequire(t *testing.T) {
+	p := NewPreconditions()
+	p.Require = true
+
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("PUT", "http://localhost:3000/users/1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	newPreconditionsTest("v1", p).ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusPreconditionRequired)
+}
+
+func TestResourceVersion(t *testing.T) {
+	_, ok := ResourceVersion(context.Background())
+	expect(t, ok, false)
+
+	version, ok := ResourceVersion(WithResourceVersion(context.Background(), "v1"))
+	expect(t, ok, true)
+	expect(t, version, `"v1"`)
+
+	version, _ = ResourceVersion(WithResourceVersion(context.Background(), `W/"v1"`))
+	expect(t, version, `W/"v1"`)
+}
@@ -0,0 +1,267 @@
+package camillo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyV2Signature starts the headers of the version 2 of the PROXY protocol.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyListener is a listener accepting the connections of the TCP load balancers, such
+// as AWS NLB or HAProxy in TCP mode, speaking the PROXY protocol version 1 or 2: the
+// connections start with a header naming the client, which the RemoteAddr of the
+// connections, and so of the requests, then returns instead of the load balancer.
+//
+//	l, err := net.Listen("tcp", ":8080")
+//	pl, err := camillo.NewProxyListener(l, "10.0.0.0/8")
+//	n.Serve(pl)
+//
+// The headers are only believed from the trusted sources, so clients can't spoof their
+// address, and the connections of the other sources are served as they are. The
+// connections of the trusted sources without header, such as health checks, are kept.
+type ProxyListener struct {
+	net.Listener
+	// HeaderTimeout is how long a trusted source has to send the header once connected.
+	HeaderTimeout time.Duration
+
+	trusted *ClientIP
+	once    sync.Once
+	conns   chan proxyAccept
+	done    chan struct{}
+	closed  sync.Once
+}
+
+type proxyAccept struct {
+	conn net.Conn
+	err  error
+}
+
+// NewProxyListener returns a ProxyListener accepting the connections of l, and trusting the
+// headers of the sources in the given CIDR ranges, such as "10.0.0.0/8", or single
+// addresses. The headers are read within 10s
+func NewProxyListener(l net.Listener, trustedSources ...string) (*ProxyListener, error) {
+	trusted, err := NewClientIP(trustedSources...)
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyListener{
+		Listener:      l,
+		HeaderTimeout: 10 * time.Second,
+		trusted:       trusted,
+		conns:         make(chan proxyAccept),
+		done:          make(chan struct{}),
+	}, nil
+}
+
+// Accept returns the next connection whose header was read. The headers are read in the
+// background, so a slow source doesn't hold up the others.
+func (p *ProxyListener) Accept() (net.Conn, error) {
+	p.once.Do(func() { go p.accept() })
+	select {
+	case a := <-p.conns:
+		return a.conn, a.err
+	case <-p.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener. The connections whose header is still being read are closed
+// once it is read.
+func (p *ProxyListener) Close() error {
+	p.closed.Do(func() { close(p.done) })
+	return p.Listener.Close()
+}
+
+// accept accepts the connections of the listener until it is closed.
+func (p *ProxyListener) accept() {
+	for {
+		c, err := p.Listener.Accept()
+		if err != nil {
+			// the errors go to Accept, which decides to retry
+			select {
+			case p.conns <- proxyAccept{err: err}:
+			case <-p.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go func(c net.Conn) {
+			pc, err := p.handshake(c)
+			if err != nil {
+				c.Close()
+				return
+			}
+			select {
+			case p.conns <- proxyAccept{conn: pc}:
+			case <-p.done:
+				c.Close()
+			}
+		}(c)
+	}
+}
+
+// handshake reads the header of c when it comes from a trusted source.
+func (p *ProxyListener) handshake(c net.Conn) (net.Conn, error) {
+	addr, ok := c.RemoteAddr().(*net.TCPAddr)
+	if !ok || !p.trusted.Trusted(addr.IP) {
+		return c, nil
+	}
+	if p.HeaderTimeout > 0 {
+		c.SetReadDeadline(time.Now().Add(p.HeaderTimeout))
+	}
+	pc := &proxyConn{Conn: c, r: bufio.NewReader(c)}
+	if err := pc.readHeader(); err != nil {
+		return nil, err
+	}
+	c.SetReadDeadline(time.Time{})
+	return pc, nil
+}
+
+// proxyConn is a connection started by a PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	r          *bufio.Reader
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the address of the client named by the header, or of the source when
+// there is none.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to named by the header, or the one
+// of the listener when there is none.
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readHeader reads the header starting the connection, if any.
+func (c *proxyConn) readHeader() error {
+	b, err := c.r.Peek(1)
+	if err != nil {
+		return err
+	}
+	switch b[0] {
+	case 'P':
+		if b, _ := c.r.Peek(6); string(b) == "PROXY " {
+			return c.readV1()
+		}
+	case '\r':
+		if b, _ := c.r.Peek(len(proxyV2Signature)); bytes.Equal(b, proxyV2Signature) {
+			return c.readV2()
+		}
+	}
+	return nil
+}
+
+// readV1 reads a header of the version 1 of the protocol, such as
+// "PROXY TCP4 192.0.2.1 10.0.0.1 56324 443\r\n".
+func (c *proxyConn) readV1() error {
+	var line []byte
+	for len(line) < 107 {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errors.New("camillo: PROXY header too long")
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return fmt.Errorf("camillo: invalid PROXY header %q", line)
+	}
+	src, err := proxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := proxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.remoteAddr, c.localAddr = src, dst
+	return nil
+}
+
+func proxyV1Addr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("camillo: invalid PROXY address %s:%s", host, port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 reads a binary header of the version 2 of the protocol.
+func (c *proxyConn) readV2() error {
+	var header [16]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return err
+	}
+	if header[12]>>4 != 2 {
+		return fmt.Errorf("camillo: unsupported PROXY version %d", header[12]>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return err
+	}
+	// the LOCAL command, of the health checks of the load balancer, keeps the source
+	if header[12]&0xf == 0 {
+		return nil
+	}
+
+	var size int
+	switch header[13] >> 4 {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		// unix sockets and unspecified families keep the source
+		return nil
+	}
+	if len(body) < 2*size+4 {
+		return errors.New("camillo: truncated PROXY header")
+	}
+	c.remoteAddr = &net.TCPAddr{
+		IP:   net.IP(body[:size]),
+		Port: int(binary.BigEndian.Uint16(body[2*size:])),
+	}
+	c.localAddr = &net.TCPAddr{
+		IP:   net.IP(body[size : 2*size]),
+		Port: int(binary.BigEndian.Uint16(body[2*size+2:])),
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package camillo
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveProxy serves the remote addresses of the requests on a ProxyListener trusting
+// trustedSources, and returns its address.
+func serveProxy(t *testing.T, trustedSources ...string) (*ProxyListener, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	expect(t, err, nil)
+	pl, err := NewProxyListener(l, trustedSources...)
+	expect(t, err, nil)
+	pl.HeaderTimeout = 100 * time.Millisecond
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			io.WriteString(rw, r.RemoteAddr)
+		}),
+		ErrorLog: log.New(ioutil.Discard, "", 0),
+	}
+	go srv.Serve(pl)
+	t.Cleanup(func() { srv.Close() })
+	return pl, l.Addr().String()
+}
+
+// proxyRequest sends a request after header on a new connection to addr, and returns the
+// body of the response, or "" when the connection was closed.
+func proxyRequest(t *testing.T, addr string, header []byte) string {
+	c, err := net.Dial("tcp", addr)
+	expect(t, err, nil)
+	defer c.Close()
+	c.Write(header)
+	io.WriteString(c, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	res, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		return ""
+	}
+	defer res.Body.Close()
+	b, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return res.Status
+	}
+	return string(b)
+}
+
+func proxyV2Header(command byte, family byte, addrs []byte) []byte {
+	header := append([]byte(nil), proxyV2Signature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func TestProxyListenerV1(t *testing.T) {
+	_, addr := serveProxy(t, "127.0.0.1")
+
+	expect(t, proxyRequest(t, addr, []byte("PROXY TCP4 192.0.2.1 10.0.0.1 56324 443\r\n")), "192.0.2.1:56324")
+	expect(t, proxyRequest(t, addr, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n")), "[2001:db8::1]:56324")
+	// the source is kept without a header, or with an unknown one
+	expect(t, strings.HasPrefix(proxyRequest(t, addr, nil), "127.0.0.1:"), true)
+	expect(t, strings.HasPrefix(proxyRequest(t, addr, []byte("PROXY UNKNOWN\r\n")), "127.0.0.1:"), true)
+
+	// invalid headers close the connection
+	expect(t, proxyRequest(t, addr, []byte("PROXY TCP4 192.0.2.1 10.0.0.1 port 443\r\n")), "")
+	expect(t, proxyRequest(t, addr, []byte("PROXY TCP4 "+strings.Repeat("1", 120)+"\r\n")), "")
+}
+
+func TestProxyListenerV2(t *testing.T) {
+	_, addr := serveProxy(t, "127.0.0.0/8")
+
+	tcp4 := []byte{192, 0, 2, 1, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb}
+	expect(t, proxyRequest(t, addr, proxyV2Header(1, 0x11, tcp4)), "192.0.2.1:56324")
+
+	// the TLVs after the addresses are skipped
+	tlv := append(append([]byte(nil), tcp4...), 0x04, 0x00, 0x03, 'a', 'b', 'c')
+	expect(t, proxyRequest(t, addr, proxyV2Header(1, 0x11, tlv)), "192.0.2.1:56324")
+
+	tcp6 := make([]byte, 36)
+	copy(tcp6, net.ParseIP("2001:db8::1"))
+	copy(tcp6[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(tcp6[32:], 56324)
+	binary.BigEndian.PutUint16(tcp6[34:], 443)
+	expect(t, proxyRequest(t, addr, proxyV2Header(1, 0x21, tcp6)), "[2001:db8::1]:56324")
+
+	// the LOCAL command keeps the source
+	expect(t, strings.HasPrefix(proxyRequest(t, addr, proxyV2Header(0, 0x00, nil)), "127.0.0.1:"), true)
+
+	expect(t, proxyRequest(t, addr, proxyV2Header(1, 0x11, tcp4[:6])), "")
+}
+
+func TestProxyListenerUntrusted(t *testing.T) {
+	_, addr := serveProxy(t, "10.0.0.0/8")
+
+	// the header of an untrusted source isn't believed
+	expect(t, proxyRequest(t, addr, []byte("PROXY TCP4 192.0.2.1 10.0.0.1 56324 443\r\n")), "400 Bad Request")
+	expect(t, strings.HasPrefix(proxyRequest(t, addr, nil), "127.0.0.1:"), true)
+}
+
+func TestProxyListenerHeaderTimeout(t *testing.T) {
+	_, addr := serveProxy(t, "127.0.0.1")
+
+	// a silent source doesn't hold up the others
+	silent, err := net.Dial("tcp", addr)
+	expect(t, err, nil)
+	defer silent.Close()
+	expect(t, proxyRequest(t, addr, []byte("PROXY TCP4 192.0.2.1 10.0.0.1 56324 443\r\n")), "192.0.2.1:56324")
+
+	silent.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = silent.Read(make([]byte, 1))
+	expect(t, err, io.EOF)
+}
+
+func TestProxyListenerClose(t *testing.T) {
+	pl, _ := serveProxy(t)
+	expect(t, pl.Close(), nil)
+	_, err := pl.Accept()
+	expect(t, err, net.ErrClosed)
+
+	_, err = NewProxyListener(pl, "not an address")
+	refute(t, err, nil)
+}
@@ -1,48 +1,494 @@
 package camillo
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 )
 
+// Reporter reports panics to an error tracking system. The reporters package has reporters
+// for Sentry and webhooks.
+type Reporter interface {
+	// Report is called with the request, the recovered value and the stack of every panic,
+	// after the response has been written. r is nil when the panic happened outside of a
+	// request. Report runs on the request goroutine and should hand slow work off.
+	Report(ctx context.Context, r *http.Request, err interface{}, stack []byte)
+}
+
+// ReporterFunc is an adapter to allow the use of ordinary functions as a Reporter.
+type ReporterFunc func(ctx context.Context, r *http.Request, err interface{}, stack []byte)
+
+// Report calls f(ctx, r, err, stack).
+func (f ReporterFunc) Report(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+	f(ctx, r, err, stack)
+}
+
+// RecoveryMode selects how Recovery renders panics to the client.
+type RecoveryMode int
+
+const (
+	// RecoveryModeText writes a plain text or JSON 500 response with the panic and its stack
+	// when PrintStack is set.
+	RecoveryModeText RecoveryMode = iota
+	// RecoveryModeProduction writes a generic 500 response that never includes the panic
+	// or its stack.
+	RecoveryModeProduction
+	// RecoveryModeDevelopment renders an HTML page with the panic, its stack with the
+	// frames of the application highlighted, and the request.
+	RecoveryModeDevelopment
+)
+
 // Recovery is a Camillo middleware that recovers from any panics and writes a 500 if there was one.
 type Recovery struct {
-	Logger     *log.Logger
+	Logger LogSink
+	// Mode selects how panics are rendered to the client, in the format negotiated with
+	// the Accept header. NewRecovery selects it from the CAMILLO_ENV environment variable,
+	// "production" or "development".
+	Mode       RecoveryMode
 	PrintStack bool
 	StackAll   bool
 	StackSize  int
+	// Repro saves a repro bundle of every request that panics when set, unless Limiter
+	// suppresses the panic. The incident ID of the bundle is returned in the X-Incident-Id
+	// header and the response body.
+	Repro ReproStore
+	// ReproBodySize is the maximum number of request body bytes kept in a repro bundle.
+	ReproBodySize int
+	// ReproRedactHeaders are the request headers whose values are redacted from repro
+	// bundles, request dumps and reports. Reporters get them with
+	// RedactHeadersFromContext.
+	ReproRedactHeaders []string
+	// DumpRequest adds a dump of the request to the panic log line and the reports, with
+	// the headers in ReproRedactHeaders redacted and up to DumpBodySize bytes of the body
+	// that the handlers read. Reporters get it with RequestDumpFromContext.
+	DumpRequest bool
+	// DumpBodySize is the maximum number of request body bytes in a request dump.
+	DumpBodySize int
+	// ErrorHandlerFunc writes the response to the client when set, instead of the plain text
+	// 500 response. It is called with the recovered value and the stack of the panic after
+	// the panic has been logged, and is responsible for the status, headers and body. Early
+	// exits, see StatusCode, are passed with a nil stack.
+	ErrorHandlerFunc func(ctx context.Context, rw http.ResponseWriter, r *http.Request, err interface{}, stack []byte)
+	// Reporter reports the panics to an error tracking system when set.
+	Reporter Reporter
+	// Observers are additional reporters of the panics, such as metrics or chat webhooks,
+	// called in order after Reporter. A panicking observer is logged and doesn't keep the
+	// next ones from being called.
+	Observers []Reporter
+	// Metrics counts the panics when set.
+	Metrics PanicMetrics
+	// Limiter rate limits the panics that are logged, reported, dumped and saved as repro
+	// bundles when set. Metrics still count every panic and every request is still
+	// answered, without incident ID when no bundle was saved.
+	Limiter *PanicLimiter
+	// StackFormatter formats the stacks that are logged, passed to ErrorHandlerFunc and
+	// written to the client, such as FormatStack. The stacks are used as is when it is nil.
+	// Reporters, repro bundles and the development page get the complete stack.
+	StackFormatter func(stack []byte) []byte
+	// StatusCode maps the recovered values to the status of the response, or 0 for the
+	// default: the code of an HTTPError, and 500 otherwise. Panics with a status below 500
+	// are early exits, answered without being logged, counted or reported.
+	StatusCode func(err interface{}) int
+	// RepanicAbort panics again with http.ErrAbortHandler when a handler aborts with it, so
+	// the server aborts the response. Otherwise the abort ends the request as if the handler
+	// returned. Aborts are never logged, reported or answered with a 500.
+	//
+	// Panics after the response was written are logged and reported, then aborted the
+	// same way instead of being answered, as a partial response can't be replaced. Behind
+	// a Buffer, the partial responses that weren't committed yet are discarded instead,
+	// and the panics answered as usual.
+	RepanicAbort bool
 }
 
 // NewRecovery returns a new instance of Recovery
 func NewRecovery() *Recovery {
 	return &Recovery{
-		Logger:     log.New(os.Stdout, "[camillo] ", 0),
-		PrintStack: true,
-		StackAll:   false,
-		StackSize:  1024 * 8,
+		Logger:             log.New(os.Stdout, "[camillo] ", 0),
+		Mode:               recoveryModeFromEnv(),
+		PrintStack:         true,
+		RepanicAbort:       true,
+		StackAll:           false,
+		StackSize:          1024 * 8,
+		ReproBodySize:      1024 * 64,
+		DumpBodySize:       1024 * 4,
+		ReproRedactHeaders: DefaultReproRedactHeaders,
 	}
 }
 
 func (rec *Recovery) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	var body *capturedBody
+	if (rec.Repro != nil || rec.DumpRequest) && r.Body != nil {
+		limit := 0
+		if rec.Repro != nil {
+			limit = rec.ReproBodySize
+		}
+		if rec.DumpRequest && rec.DumpBodySize > limit {
+			limit = rec.DumpBodySize
+		}
+		body = &capturedBody{ReadCloser: r.Body, capture: captureBuffer{limit: limit}}
+		r.Body = body
+	}
+
 	defer func() {
 		if err := recover(); err != nil {
-			rw.WriteHeader(http.StatusInternalServerError)
+			if err == http.ErrAbortHandler {
+				if rec.RepanicAbort {
+					panic(err)
+				}
+				return
+			}
+
+			id := RequestIDFromContext(ctx)
+			e := rec.httpError(err)
+			// a buffered response that wasn't sent yet is replaced by the error response
+			if b, ok := rw.(*BufferedResponseWriter); ok {
+				b.Rollback()
+			}
+			written := false
+			if w, ok := rw.(ResponseWriter); ok {
+				written = w.Written()
+			}
+			if e.Code < 500 {
+				if written {
+					rec.abort()
+					return
+				}
+				if rec.ErrorHandlerFunc != nil {
+					rec.ErrorHandlerFunc(ctx, rw, r, err, nil)
+					return
+				}
+				writeHTTPError(rw, r, id, e, rec.Mode != RecoveryModeText)
+				return
+			}
+
 			stack := make([]byte, rec.StackSize)
 			stack = stack[:runtime.Stack(stack, rec.StackAll)]
 
-			f := "PANIC: %s\n%s"
-			rec.Logger.Printf(f, err, stack)
-
-			if rec.PrintStack {
-				fmt.Fprintf(rw, f, err, stack)
+			LogError(ctx, fmt.Errorf("panic: %v", err))
+			// the suppressed panics don't pay for the repro bundles and the request dumps
+			logged, suppressed := rec.allow(r, err, stack)
+			var incident string
+			if logged && rec.Repro != nil {
+				incident = rec.saveRepro(r, id, body, err)
+				rw.Header().Set("X-Incident-Id", incident)
+			}
+			if logged && rec.DumpRequest {
+				ctx = context.WithValue(ctx, requestDumpKey{}, rec.dumpRequest(r, body))
+			}
+			if logged {
+				rec.record(ctx, err, stack, suppressed)
+				defer rec.report(ctx, r, err, stack)
 			}
+			if written {
+				if logged {
+					logf(rec.Logger, LogLevelError, "%saborted the response: the panic happened after it was written", logPrefix(id))
+				}
+				rec.abort()
+				return
+			}
+			if rec.ErrorHandlerFunc != nil {
+				rec.ErrorHandlerFunc(ctx, rw, r, err, rec.formatStack(stack))
+				return
+			}
+
+			rec.respond(rw, r, id, incident, e, err, stack)
+		}
+	}()
+
+	next(context.WithValue(ctx, recoveryKey{}, rec), rw, r)
+}
+
+// allow counts a recovered panic, and returns whether it should be logged and reported,
+// with the number of similar panics suppressed before it. r is nil for panics outside of a
+// request.
+func (rec *Recovery) allow(r *http.Request, err interface{}, stack []byte) (bool, int) {
+	if rec.Metrics != nil {
+		var path string
+		if r != nil {
+			path = r.URL.Path
+		}
+		rec.Metrics.PanicRecovered(path, panicType(err))
+	}
+	if rec.Limiter == nil {
+		return true, 0
+	}
+	return rec.Limiter.Allow(panicFingerprint(err, stack))
+}
+
+// record logs a recovered panic allowed after suppressed similar ones.
+func (rec *Recovery) record(ctx context.Context, err interface{}, stack []byte, suppressed int) {
+	stack = rec.formatStack(stack)
+	if dump := RequestDumpFromContext(ctx); dump != "" {
+		stack = append(append(stack[:len(stack):len(stack)], "\nRequest:\n"...), dump...)
+	}
+	var similar string
+	if suppressed > 0 {
+		similar = fmt.Sprintf(" (%d similar panics suppressed)", suppressed)
+	}
+	logf(rec.Logger, LogLevelError, "%sPANIC: %s%s\n%s", logPrefix(RequestIDFromContext(ctx)), err, similar, stack)
+}
+
+type requestDumpKey struct{}
+
+// RequestDumpFromContext returns the request dump of a panic in the context passed to the
+// Reporters of a Recovery with DumpRequest set, or "" when there is none.
+func RequestDumpFromContext(ctx context.Context) string {
+	dump, _ := ctx.Value(requestDumpKey{}).(string)
+	return dump
+}
+
+// dumpRequest returns the request line, the redacted headers and the captured body of r.
+func (rec *Recovery) dumpRequest(r *http.Request, body *capturedBody) string {
+	if r == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s %s\n", r.Method, r.URL.RequestURI(), r.Proto)
+	fmt.Fprintf(&buf, "Host: %s\n", r.Host)
+	var header bytes.Buffer
+	redactHeader(r.Header, rec.ReproRedactHeaders).Write(&header)
+	buf.WriteString(strings.Replace(header.String(), "\r\n", "\n", -1))
+	if body != nil && len(body.capture.buf) > 0 {
+		b := body.capture.buf
+		truncated := body.capture.truncated
+		if len(b) > rec.DumpBodySize {
+			b, truncated = b[:rec.DumpBodySize], true
+		}
+		buf.WriteString("\n")
+		buf.Write(b)
+		if truncated {
+			buf.WriteString("... (truncated)")
+		}
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+type redactHeadersKey struct{}
+
+// RedactHeadersFromContext returns the request headers whose values are redacted in the
+// context passed to the Reporters of a Recovery, its ReproRedactHeaders, or
+// DefaultReproRedactHeaders when there is none.
+func RedactHeadersFromContext(ctx context.Context) []string {
+	if redact, ok := ctx.Value(redactHeadersKey{}).([]string); ok {
+		return redact
+	}
+	return DefaultReproRedactHeaders
+}
+
+// report calls the Reporter and the Observers, recovering each of them.
+func (rec *Recovery) report(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+	ctx = context.WithValue(ctx, redactHeadersKey{}, rec.ReproRedactHeaders)
+	if rec.Reporter != nil {
+		rec.observe(ctx, rec.Reporter, r, err, stack)
+	}
+	for _, observer := range rec.Observers {
+		rec.observe(ctx, observer, r, err, stack)
+	}
+}
+
+func (rec *Recovery) observe(ctx context.Context, observer Reporter, r *http.Request, err interface{}, stack []byte) {
+	defer func() {
+		if failure := recover(); failure != nil {
+			logf(rec.Logger, LogLevelError, "%spanic observer %T failed: %v", logPrefix(RequestIDFromContext(ctx)), observer, failure)
 		}
 	}()
+	observer.Report(ctx, r, err, stack)
+}
+
+func (rec *Recovery) formatStack(stack []byte) []byte {
+	if rec.StackFormatter == nil {
+		return stack
+	}
+	return rec.StackFormatter(stack)
+}
 
-	next(ctx, rw, r)
+type recoveryKey struct{}
+
+// defaultRecovery recovers the panics of Go outside of a Recovery.
+var defaultRecovery = &Recovery{Logger: log.New(os.Stdout, "[camillo] ", 0), StackSize: 1024 * 8}
+
+// Go runs f in a new goroutine, recovering its panics with the Recovery serving ctx. The
+// panics are logged, counted and reported like the panics of the request, instead of
+// crashing the process. Outside of a Recovery the panics are logged to stdout.
+//
+//	camillo.Go(ctx, func(ctx context.Context) {
+//		sendWelcomeEmail(ctx, user)
+//	})
+//
+// The goroutine may outlive the request, so f shouldn't use its ResponseWriter. Its ctx
+// keeps the values of the request, such as its ID, but isn't cancelled when it ends.
+func Go(ctx context.Context, f func(ctx context.Context)) {
+	rec, ok := ctx.Value(recoveryKey{}).(*Recovery)
+	if !ok {
+		rec = defaultRecovery
+	}
+	ctx = detachedContext{ctx}
+
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					return
+				}
+				stack := make([]byte, rec.StackSize)
+				stack = stack[:runtime.Stack(stack, rec.StackAll)]
+
+				if ok, suppressed := rec.allow(nil, err, stack); ok {
+					rec.record(ctx, err, stack, suppressed)
+					rec.report(ctx, nil, err, stack)
+				}
+			}
+		}()
+		f(ctx)
+	}()
+}
+
+// detachedContext is a context keeping the values of its parent without its deadline
+// and cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// abort aborts the response of a panicking request, see RepanicAbort.
+func (rec *Recovery) abort() {
+	if rec.RepanicAbort {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// httpError returns the HTTPError answering a recovered value. Its message is empty
+// unless err is an HTTPError.
+func (rec *Recovery) httpError(err interface{}) HTTPError {
+	var e HTTPError
+	if v, ok := err.(error); ok {
+		e, _ = asHTTPError(v)
+	}
+	if rec.StatusCode != nil {
+		if code := rec.StatusCode(err); code != 0 {
+			e.Code = code
+		}
+	}
+	if e.Code == 0 {
+		e.Code = http.StatusInternalServerError
+	}
+	return e
+}
+
+// respond writes the error response to a panic in the format negotiated with the Accept
+// header: JSON for API clients, HTML for browsers in the production and development
+// modes, and plain text otherwise.
+func (rec *Recovery) respond(rw http.ResponseWriter, r *http.Request, id, incident string, e HTTPError, err interface{}, stack []byte) {
+	var accept string
+	if r != nil {
+		accept = r.Header.Get("Accept")
+	}
+	detailed := rec.Mode == RecoveryModeDevelopment || (rec.Mode == RecoveryModeText && rec.PrintStack)
+
+	switch negotiate(accept, "text/plain", "text/html", "application/json") {
+	case "application/json":
+		body := errorResponse{Error: e.Message, RequestID: id, IncidentID: incident, Fields: e.Fields}
+		if body.Error == "" {
+			body.Error = strings.ToLower(http.StatusText(e.Code))
+		}
+		if detailed {
+			body.Panic = fmt.Sprint(err)
+			body.Stack = string(rec.formatStack(stack))
+		}
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		rw.WriteHeader(e.Code)
+		json.NewEncoder(rw).Encode(body)
+		return
+	case "text/html":
+		switch rec.Mode {
+		case RecoveryModeDevelopment:
+			rec.renderDevelopmentPage(rw, r, incident, e.Code, err, stack)
+			return
+		case RecoveryModeProduction:
+			writeHTTPError(rw, r, id, e, true)
+			return
+		}
+	}
+
+	if rec.Mode != RecoveryModeText {
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	rw.WriteHeader(e.Code)
+	if rec.Mode == RecoveryModeProduction || e.Message != "" {
+		message := e.Message
+		if message == "" {
+			message = http.StatusText(e.Code)
+		}
+		fmt.Fprintln(rw, message)
+	}
+	if incident != "" {
+		fmt.Fprintf(rw, "Incident ID: %s\n", incident)
+	}
+	if detailed {
+		fmt.Fprintf(rw, "PANIC: %s\n%s", err, rec.formatStack(stack))
+	}
+}
+
+// panicType returns the type of a recovered value, reporting runtime errors, such as nil
+// dereferences, as "runtime.Error".
+func panicType(err interface{}) string {
+	if _, ok := err.(runtime.Error); ok {
+		return "runtime.Error"
+	}
+	return fmt.Sprintf("%T", err)
+}
+
+func recoveryModeFromEnv() RecoveryMode {
+	switch os.Getenv("CAMILLO_ENV") {
+	case "production":
+		return RecoveryModeProduction
+	case "development":
+		return RecoveryModeDevelopment
+	}
+	return RecoveryModeText
+}
+
+// saveRepro saves a repro bundle of the panicking request and returns its incident ID.
+func (rec *Recovery) saveRepro(r *http.Request, id string, body *capturedBody, err interface{}) string {
+	stack := make([]byte, rec.StackSize)
+	stack = stack[:runtime.Stack(stack, false)]
+	goroutines := make([]byte, 1024*1024)
+	goroutines = goroutines[:runtime.Stack(goroutines, true)]
+
+	bundle := &ReproBundle{
+		IncidentID: newIncidentID(),
+		RequestID:  id,
+		Time:       time.Now(),
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		Proto:      r.Proto,
+		Host:       r.Host,
+		RemoteAddr: r.RemoteAddr,
+		Header:     redactHeader(r.Header, rec.ReproRedactHeaders),
+		Panic:      fmt.Sprint(err),
+		Stack:      string(stack),
+		Goroutines: string(goroutines),
+	}
+	if body != nil {
+		body.rest()
+		bundle.Body = body.capture.buf
+		bundle.BodyTruncated = body.capture.truncated
+	}
+
+	if err := rec.Repro.SaveRepro(bundle); err != nil {
+		logf(rec.Logger, LogLevelError, "%sfailed to save the repro bundle of incident %s: %s", logPrefix(id), bundle.IncidentID, err)
+	}
+	return bundle.IncidentID
 }
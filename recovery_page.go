@@ -0,0 +1,99 @@
+package camillo
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+)
+
+var recoveryPage = template.Must(template.New("panic").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>PANIC: {{.Panic}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { color: #b00; }
+pre { background: #f6f6f6; padding: 1em; overflow: auto; }
+.app { background: #fff3b0; font-weight: bold; }
+.current { background: #ffd0d0; }
+td { padding: 0.2em 1em 0.2em 0; vertical-align: top; font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>PANIC: {{.Panic}}</h1>
+{{if .Incident}}<p>Incident ID: {{.Incident}}</p>{{end}}
+{{if .Source}}<h2>{{.Site.File}}:{{.Site.Line}}</h2>
+<pre>{{range .Source}}<span{{if .Current}} class="current"{{end}}>{{printf "%4d" .Number}}  {{.Text}}</span>
+{{end}}</pre>
+{{end}}<h2>Stack</h2>
+<pre>{{range .Stack}}{{if .App}}<span class="app">{{.Function}}
+	{{.File}}:{{.Line}}</span>{{else}}{{.Function}}
+	{{.File}}:{{.Line}}{{end}}
+{{end}}</pre>
+{{if .Method}}<h2>Request</h2>
+<table>
+<tr><td>{{.Method}}</td><td>{{.URL}} {{.Proto}}</td></tr>
+<tr><td>Remote address</td><td>{{.RemoteAddr}}</td></tr>
+{{range .Header}}<tr><td>{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// sourceContext is the number of source lines shown around the panic site.
+const sourceContext = 5
+
+type recoveryPageData struct {
+	Panic      string
+	Incident   string
+	Site       StackFrame
+	Source     []sourceLine
+	Stack      []StackFrame
+	Method     string
+	URL        string
+	Proto      string
+	RemoteAddr string
+	Header     []headerLine
+}
+
+type headerLine struct {
+	Name  string
+	Value string
+}
+
+func (rec *Recovery) renderDevelopmentPage(rw http.ResponseWriter, r *http.Request, incident string, status int, err interface{}, stack []byte) {
+	data := recoveryPageData{
+		Panic:    fmt.Sprint(err),
+		Incident: incident,
+		Stack:    panicFrames(ParseStack(stack)),
+	}
+	if site, ok := panicSite(stack); ok {
+		data.Site = site
+		data.Source = sourceLines(site.File, site.Line, sourceContext)
+	}
+	if r != nil {
+		data.Method = r.Method
+		data.URL = r.URL.String()
+		data.Proto = r.Proto
+		data.RemoteAddr = r.RemoteAddr
+		header := redactHeader(r.Header, rec.ReproRedactHeaders)
+		for name, values := range header {
+			for _, value := range values {
+				data.Header = append(data.Header, headerLine{Name: name, Value: value})
+			}
+		}
+		sort.SliceStable(data.Header, func(i, j int) bool { return data.Header[i].Name < data.Header[j].Name })
+	}
+
+	var page bytes.Buffer
+	if err := recoveryPage.Execute(&page, data); err != nil {
+		logf(rec.Logger, LogLevelError, "failed to render the panic page: %s", err)
+		rw.WriteHeader(status)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.WriteHeader(status)
+	rw.Write(page.Bytes())
+}
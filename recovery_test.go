@@ -2,10 +2,18 @@ package camillo
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/net/context"
 )
 
 func TestRecovery(t *testing.T) {
@@ -26,3 +34,506 @@ func TestRecovery(t *testing.T) {
 	refute(t, recorder.Body.Len(), 0)
 	refute(t, len(buff.String()), 0)
 }
+
+type reproRecorder []*ReproBundle
+
+func (s *reproRecorder) SaveRepro(bundle *ReproBundle) error {
+	*s = append(*s, bundle)
+	return nil
+}
+
+func TestRecoveryRepro(t *testing.T) {
+	var bundles reproRecorder
+	recorder := httptest.NewRecorder()
+
+	rec := NewRecovery()
+	rec.Logger = log.New(ioutil.Discard, "", 0)
+	rec.PrintStack = false
+	rec.Repro = &bundles
+	rec.ReproBodySize = 8
+
+	n := New(rec)
+	n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		b := make([]byte, 3)
+		io.ReadFull(req.Body, b)
+		panic("here is a panic!")
+	}))
+
+	req, _ := http.NewRequest("POST", "http://localhost:3000/orders?id=1", strings.NewReader(`{"order":1}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Trace", "abc")
+	n.ServeHTTP(recorder, req)
+
+	expect(t, recorder.Code, http.StatusInternalServerError)
+	expect(t, len(bundles), 1)
+	bundle := bundles[0]
+	expect(t, recorder.Header().Get("X-Incident-Id"), bundle.IncidentID)
+	expect(t, recorder.Body.String(), "Incident ID: "+bundle.IncidentID+"\n")
+	expect(t, bundle.Method, "POST")
+	expect(t, bundle.URL, "http://localhost:3000/orders?id=1")
+	expect(t, bundle.Header.Get("Authorization"), "[REDACTED]")
+	expect(t, bundle.Header.Get("X-Trace"), "abc")
+	expect(t, string(bundle.Body), `{"order"`)
+	expect(t, bundle.BodyTruncated, true)
+	expect(t, bundle.Panic, "here is a panic!")
+	expect(t, strings.Contains(bundle.Stack, "TestRecoveryRepro"), true)
+	expect(t, strings.Contains(bundle.Goroutines, "goroutine "), true)
+}
+
+func TestRecoveryDumpRequest(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	var dump string
+
+	rec := NewRecovery()
+	rec.Logger = log.New(buff, "", 0)
+	rec.DumpRequest = true
+	rec.DumpBodySize = 8
+	rec.Reporter = ReporterFunc(func(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+		dump = RequestDumpFromContext(ctx)
+	})
+
+	n := New(rec)
+	n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		panic("here is a panic!")
+	}))
+
+	req, _ := http.NewRequest("POST", "http://localhost:3000/orders?id=1", strings.NewReader(`{"order":1}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	n.ServeHTTP(httptest.NewRecorder(), req)
+
+	expect(t, dump, "POST /orders?id=1 HTTP/1.1\nHost: localhost:3000\nAuthorization: [REDACTED]\n\n{\"order\"... (truncated)\n")
+	expect(t, strings.Contains(buff.String(), "\nRequest:\n"+dump), true)
+	expect(t, strings.Contains(buff.String(), "secret"), false)
+	expect(t, RequestDumpFromContext(context.Background()), "")
+}
+
+func TestRecoveryErrorHandlerFunc(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	rec := NewRecovery()
+	rec.Logger = log.New(ioutil.Discard, "", 0)
+	rec.ErrorHandlerFunc = func(ctx context.Context, rw http.ResponseWriter, r *http.Request, err interface{}, stack []byte) {
+		expect(t, r.URL.Path, "/api")
+		expect(t, strings.Contains(string(stack), "TestRecoveryErrorHandlerFunc"), true)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(rw, `{"error":%q}`, err)
+	}
+
+	n := New(rec)
+	n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic("database is down")
+	}))
+
+	req, _ := http.NewRequest("GET", "http://localhost:3000/api", nil)
+	n.ServeHTTP(recorder, req)
+
+	expect(t, recorder.Code, http.StatusServiceUnavailable)
+	expect(t, recorder.Header().Get("Content-Type"), "application/json")
+	expect(t, recorder.Body.String(), `{"error":"database is down"}`)
+}
+
+func TestRecoveryModes(t *testing.T) {
+	serve := func(mode RecoveryMode, accept string) *httptest.ResponseRecorder {
+		rec := NewRecovery()
+		rec.Logger = log.New(ioutil.Discard, "", 0)
+		rec.Mode = mode
+
+		n := New(rec)
+		n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			panic("<script>alert(1)</script>")
+		}))
+
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000/orders", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Accept", accept)
+		n.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := serve(RecoveryModeProduction, "")
+	expect(t, recorder.Code, http.StatusInternalServerError)
+	expect(t, recorder.Body.String(), "Internal Server Error\n")
+
+	recorder = serve(RecoveryModeProduction, "text/html,*/*;q=0.8")
+	expect(t, recorder.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	expect(t, strings.Contains(recorder.Body.String(), "<h1>500 Internal Server Error</h1>"), true)
+
+	recorder = serve(RecoveryModeDevelopment, "text/html")
+	body := recorder.Body.String()
+	expect(t, recorder.Code, http.StatusInternalServerError)
+	expect(t, recorder.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	expect(t, strings.Contains(body, "PANIC: &lt;script&gt;alert(1)&lt;/script&gt;"), true)
+	expect(t, strings.Contains(body, "<script>"), false)
+	expect(t, strings.Contains(body, `<span class="app">github.com/fd/camillo.TestRecoveryModes`), true)
+	// the source around the panic site
+	expect(t, strings.Contains(body, `class="current"`), true)
+	expect(t, strings.Contains(body, `panic(&#34;&lt;script&gt;alert(1)&lt;/script&gt;&#34;)`), true)
+	expect(t, strings.Contains(body, "<tr><td>Authorization</td><td>[REDACTED]</td></tr>"), true)
+	expect(t, strings.Contains(body, "<tr><td>GET</td><td>http://localhost:3000/orders HTTP/1.1</td></tr>"), true)
+}
+
+func TestRecoveryModeFromEnv(t *testing.T) {
+	t.Setenv("CAMILLO_ENV", "production")
+	expect(t, NewRecovery().Mode, RecoveryModeProduction)
+	t.Setenv("CAMILLO_ENV", "development")
+	expect(t, NewRecovery().Mode, RecoveryModeDevelopment)
+	t.Setenv("CAMILLO_ENV", "")
+	expect(t, NewRecovery().Mode, RecoveryModeText)
+}
+
+func TestRecoveryReporter(t *testing.T) {
+	var reported []string
+	rec := NewRecovery()
+	rec.Logger = log.New(ioutil.Discard, "", 0)
+	rec.Reporter = ReporterFunc(func(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+		reported = append(reported, fmt.Sprintf("%s %s: %v", r.Method, r.URL.Path, err))
+	})
+
+	n := New(rec)
+	n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic("here is a panic!")
+	}))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:3000/orders", nil)
+	n.ServeHTTP(recorder, req)
+
+	expect(t, recorder.Code, http.StatusInternalServerError)
+	expect(t, len(reported), 1)
+	expect(t, reported[0], "GET /orders: here is a panic!")
+}
+
+func TestRecoveryAbortHandler(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	rec := NewRecovery()
+	rec.Logger = log.New(buff, "", 0)
+
+	n := New(rec)
+	n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("partial"))
+		panic(http.ErrAbortHandler)
+	}))
+
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	func() {
+		defer func() {
+			expect(t, recover(), http.ErrAbortHandler)
+		}()
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	rec.RepanicAbort = false
+	recorder := httptest.NewRecorder()
+	n.ServeHTTP(recorder, req)
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, recorder.Body.String(), "partial")
+	expect(t, buff.String(), "")
+}
+
+func TestRecoveryJSON(t *testing.T) {
+	serve := func(mode RecoveryMode) map[string]string {
+		rec := NewRecovery()
+		rec.Logger = log.New(ioutil.Discard, "", 0)
+		rec.Mode = mode
+
+		n := New(NewRequestID(), rec)
+		n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			panic("here is a panic!")
+		}))
+
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000/api", nil)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("X-Request-Id", "abc")
+		n.ServeHTTP(recorder, req)
+
+		expect(t, recorder.Code, http.StatusInternalServerError)
+		expect(t, recorder.Header().Get("Content-Type"), "application/json; charset=utf-8")
+		var body map[string]string
+		expect(t, json.Unmarshal(recorder.Body.Bytes(), &body), nil)
+		return body
+	}
+
+	body := serve(RecoveryModeProduction)
+	expect(t, len(body), 2)
+	expect(t, body["error"], "internal server error")
+	expect(t, body["request_id"], "abc")
+
+	body = serve(RecoveryModeDevelopment)
+	expect(t, body["panic"], "here is a panic!")
+	expect(t, strings.Contains(body["stack"], "TestRecoveryJSON"), true)
+}
+
+func TestGo(t *testing.T) {
+	reported := make(chan string, 1)
+	buff := bytes.NewBufferString("")
+	counter := NewPanicCounter()
+
+	rec := NewRecovery()
+	rec.Logger = log.New(buff, "", 0)
+	rec.Metrics = counter
+	rec.Reporter = ReporterFunc(func(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+		expect(t, r == nil, true)
+		expect(t, strings.Contains(string(stack), "TestGo"), true)
+		reported <- fmt.Sprint(err)
+	})
+
+	n := New(NewRequestID(), rec)
+	n.Use(HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		Go(ctx, func(ctx context.Context) {
+			panic("background panic")
+		})
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://localhost:3000/jobs", nil)
+	req.Header.Set("X-Request-Id", "abc")
+	n.ServeHTTP(recorder, req)
+
+	expect(t, recorder.Code, http.StatusAccepted)
+	expect(t, <-reported, "background panic")
+	expect(t, strings.HasPrefix(buff.String(), "[abc] PANIC: background panic\n"), true)
+	expect(t, counter.Counts().ByPath[""], uint64(1))
+}
+
+func TestGoAfterRequest(t *testing.T) {
+	// the goroutine starts working after the request ended
+	start := make(chan struct{})
+	done := make(chan error, 1)
+	n := New(NewRequestID(), NewRecovery())
+	n.Use(HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		Go(ctx, func(ctx context.Context) {
+			<-start
+			expect(t, RequestIDFromContext(ctx), "abc")
+			done <- ctx.Err()
+		})
+	}))
+
+	req, _ := http.NewRequest("POST", "http://localhost:3000/jobs", nil)
+	req.Header.Set("X-Request-Id", "abc")
+	n.ServeHTTP(httptest.NewRecorder(), req)
+	close(start)
+	expect(t, <-done, nil)
+}
+
+func TestGoWithoutRecovery(t *testing.T) {
+	done := make(chan struct{})
+	Go(context.Background(), func(ctx context.Context) {
+		defer close(done)
+	})
+	<-done
+}
+
+func TestRecoveryStackFormatter(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	rec := NewRecovery()
+	rec.Logger = log.New(buff, "", 0)
+	rec.Mode = RecoveryModeText
+	rec.StackFormatter = FormatStack
+
+	n := New(rec)
+	n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic("here is a panic!")
+	}))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	n.ServeHTTP(recorder, req)
+
+	expect(t, strings.HasPrefix(buff.String(), "PANIC: here is a panic!\n-> github.com/fd/camillo.TestRecoveryStackFormatter.func1\n"), true)
+	expect(t, strings.Contains(buff.String(), "runtime."), false)
+	expect(t, strings.Contains(recorder.Body.String(), "-> github.com/fd/camillo.TestRecoveryStackFormatter.func1\n"), true)
+}
+
+func TestRecoveryObservers(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	var observed []string
+	observer := func(name string) Reporter {
+		return ReporterFunc(func(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+			observed = append(observed, name)
+		})
+	}
+
+	rec := NewRecovery()
+	rec.Logger = log.New(buff, "", 0)
+	rec.Reporter = observer("sentry")
+	rec.Observers = []Reporter{
+		observer("metrics"),
+		ReporterFunc(func(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+			panic("slack is down")
+		}),
+		observer("audit"),
+	}
+
+	n := New(rec)
+	n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic("here is a panic!")
+	}))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	n.ServeHTTP(recorder, req)
+
+	expect(t, recorder.Code, http.StatusInternalServerError)
+	expect(t, strings.Join(observed, ","), "sentry,metrics,audit")
+	expect(t, strings.Contains(buff.String(), "panic observer camillo.ReporterFunc failed: slack is down"), true)
+}
+
+func TestRecoveryStatusCode(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	var reported int
+	rec := NewRecovery()
+	rec.Logger = log.New(buff, "", 0)
+	rec.Mode = RecoveryModeProduction
+	rec.Reporter = ReporterFunc(func(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+		reported++
+	})
+	rec.StatusCode = func(err interface{}) int {
+		if err == context.DeadlineExceeded {
+			return http.StatusGatewayTimeout
+		}
+		return 0
+	}
+
+	serve := func(value interface{}, accept string) *httptest.ResponseRecorder {
+		n := New(rec)
+		n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			panic(value)
+		}))
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000/users/1", nil)
+		req.Header.Set("Accept", accept)
+		n.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := serve(HTTPError{Code: http.StatusNotFound, Message: "no such user"}, "")
+	expect(t, recorder.Code, http.StatusNotFound)
+	expect(t, recorder.Body.String(), "no such user\n")
+
+	recorder = serve(&HTTPError{Code: http.StatusForbidden}, "application/json")
+	expect(t, recorder.Code, http.StatusForbidden)
+	expect(t, recorder.Body.String(), `{"error":"forbidden"}`+"\n")
+
+	recorder = serve(HTTPError{Code: http.StatusConflict}, "text/html")
+	expect(t, recorder.Code, http.StatusConflict)
+	expect(t, strings.Contains(recorder.Body.String(), "<h1>409 Conflict</h1>"), true)
+
+	// early exits are neither logged nor reported
+	expect(t, buff.Len(), 0)
+	expect(t, reported, 0)
+
+	recorder = serve(context.DeadlineExceeded, "")
+	expect(t, recorder.Code, http.StatusGatewayTimeout)
+	expect(t, recorder.Body.String(), "Gateway Timeout\n")
+	expect(t, strings.Contains(buff.String(), "PANIC: context deadline exceeded"), true)
+	expect(t, reported, 1)
+}
+
+func TestRecoveryAfterWrite(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	var reported int
+	rec := NewRecovery()
+	rec.Logger = log.New(buff, "", 0)
+	rec.Reporter = ReporterFunc(func(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+		reported++
+	})
+
+	n := New(rec)
+	n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte("partial"))
+		panic("here is a panic!")
+	}))
+
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	recorder := httptest.NewRecorder()
+	func() {
+		defer func() {
+			expect(t, recover(), http.ErrAbortHandler)
+		}()
+		n.ServeHTTP(recorder, req)
+	}()
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, recorder.Body.String(), "partial")
+	expect(t, strings.Contains(buff.String(), "PANIC: here is a panic!"), true)
+	expect(t, strings.Contains(buff.String(), "aborted the response: the panic happened after it was written"), true)
+	expect(t, reported, 1)
+
+	rec.RepanicAbort = false
+	recorder = httptest.NewRecorder()
+	n.ServeHTTP(recorder, req)
+	expect(t, recorder.Body.String(), "partial")
+	expect(t, reported, 2)
+}
+
+func TestRecoveryBuffered(t *testing.T) {
+	rec := NewRecovery()
+	rec.Logger = log.New(ioutil.Discard, "", 0)
+	rec.Mode = RecoveryModeProduction
+
+	n := New(NewBuffer(), rec)
+	n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "text/html")
+		res.Header().Set("X-Page", "orders")
+		res.Write([]byte("<html><body>half a page"))
+		panic("template failed")
+	}))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:3000/orders", nil)
+	n.ServeHTTP(recorder, req)
+
+	expect(t, recorder.Code, http.StatusInternalServerError)
+	expect(t, recorder.Header().Get("Content-Type"), "text/plain; charset=utf-8")
+	expect(t, recorder.Header().Get("X-Page"), "")
+	expect(t, recorder.Body.String(), "Internal Server Error\n")
+}
+
+func TestRecoveryLimiter(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	var reported int
+	counter := NewPanicCounter()
+	clock := NewManualClock(time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC))
+	rec := NewRecovery()
+	rec.Logger = log.New(buff, "", 0)
+	rec.Metrics = counter
+	rec.Limiter = NewPanicLimiter(1, time.Minute)
+	rec.Limiter.Clock = clock
+	rec.Reporter = ReporterFunc(func(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+		reported++
+	})
+	var bundles reproRecorder
+	rec.Repro = &bundles
+
+	n := New(rec)
+	n.UseHandler(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		panic("here is a panic!")
+	}))
+
+	serve := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+		n.ServeHTTP(recorder, req)
+		return recorder
+	}
+	refute(t, serve().Header().Get("X-Incident-Id"), "")
+	for i := 0; i < 2; i++ {
+		recorder := serve()
+		expect(t, recorder.Code, http.StatusInternalServerError)
+		// the suppressed panics don't save a bundle
+		expect(t, recorder.Header().Get("X-Incident-Id"), "")
+	}
+	expect(t, strings.Count(buff.String(), "PANIC: "), 1)
+	expect(t, reported, 1)
+	expect(t, len(bundles), 1)
+	expect(t, counter.Counts().Total, uint64(3))
+
+	clock.Advance(time.Minute)
+	serve()
+	expect(t, strings.Contains(buff.String(), "PANIC: here is a panic! (2 similar panics suppressed)"), true)
+	expect(t, reported, 2)
+}
@@ -0,0 +1,227 @@
+package camillo
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+type renderDataKey struct{}
+
+// WithRenderData returns a copy of ctx in which value is available to the templates
+// rendered by Render.HTML as {{data "key"}}, so middleware can inject per request data,
+// such as the current user or a CSRF token, into every page.
+func WithRenderData(ctx context.Context, key string, value interface{}) context.Context {
+	data := map[string]interface{}{key: value}
+	for k, v := range RenderDataFromContext(ctx) {
+		if k != key {
+			data[k] = v
+		}
+	}
+	return context.WithValue(ctx, renderDataKey{}, data)
+}
+
+// RenderDataFromContext returns the data added by WithRenderData.
+func RenderDataFromContext(ctx context.Context) map[string]interface{} {
+	data, _ := ctx.Value(renderDataKey{}).(map[string]interface{})
+	return data
+}
+
+// Render renders the html/template trees of a filesystem and encodes values as JSON, XML
+// and text into responses. The templates are named after their path in Directory without
+// the extension, such as "users/show" for templates/users/show.html. They can include each
+// other as partials with {{template "partials/nav" .}}, and the Layout renders the page
+// where it calls {{yield}}.
+//
+// The responses are rendered before anything is written, so a failing template leaves the
+// response untouched to answer with an error instead.
+type Render struct {
+	// FS holds the templates.
+	FS fs.FS
+	// Directory is the directory of FS holding the templates.
+	Directory string
+	// Extensions are the extensions of the template files.
+	Extensions []string
+	// Layout is the name of the template rendering the pages with {{yield}}, such as
+	// "layouts/main", or "" to render the pages alone.
+	Layout string
+	// Funcs are added to the templates, next to yield and data.
+	Funcs template.FuncMap
+	// Charset is added to the content types of the responses.
+	Charset string
+	// Development parses the templates again for every page, so the changes show up
+	// without a restart.
+	Development bool
+
+	mtx       sync.Mutex
+	templates *template.Template
+}
+
+// NewRender returns a new instance of Render for the templates of fsys, in the templates
+// directory and with the .html and .tmpl extensions
+func NewRender(fsys fs.FS) *Render {
+	return &Render{
+		FS:         fsys,
+		Directory:  "templates",
+		Extensions: []string{".html", ".tmpl"},
+		Charset:    "utf-8",
+	}
+}
+
+// HTML renders the template name with data and writes it with status. The pages are
+// rendered within the Layout, and the data of ctx is available to the templates as
+// {{data "key"}}.
+func (rd *Render) HTML(ctx context.Context, rw http.ResponseWriter, status int, name string, data interface{}) error {
+	return rd.HTMLLayout(ctx, rw, status, rd.Layout, name, data)
+}
+
+// HTMLLayout renders the template name like HTML, but within layout, or alone when layout
+// is "".
+func (rd *Render) HTMLLayout(ctx context.Context, rw http.ResponseWriter, status int, layout, name string, data interface{}) error {
+	master, err := rd.load()
+	if err != nil {
+		return err
+	}
+	// the parsed templates are cloned for the functions bound to this page, as html/template
+	// templates can't be cloned once executed
+	t, err := master.Clone()
+	if err != nil {
+		return err
+	}
+	values := RenderDataFromContext(ctx)
+	page := t.Lookup(name)
+	if page == nil {
+		return fmt.Errorf("render: no template %q", name)
+	}
+	t.Funcs(template.FuncMap{
+		"data": func(key string) interface{} { return values[key] },
+		"yield": func() (template.HTML, error) {
+			var buf bytes.Buffer
+			err := page.Execute(&buf, data)
+			return template.HTML(buf.String()), err
+		},
+	})
+
+	root := page
+	if layout != "" {
+		if root = t.Lookup(layout); root == nil {
+			return fmt.Errorf("render: no layout %q", layout)
+		}
+	}
+	var buf bytes.Buffer
+	if err := root.Execute(&buf, data); err != nil {
+		return err
+	}
+	rd.write(rw, status, "text/html", buf.Bytes())
+	return nil
+}
+
+// JSON writes v encoded as JSON with status.
+func (rd *Render) JSON(rw http.ResponseWriter, status int, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	rd.write(rw, status, "application/json", append(b, '\n'))
+	return nil
+}
+
+// XML writes v encoded as XML with status, after the XML header.
+func (rd *Render) XML(rw http.ResponseWriter, status int, v interface{}) error {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	rd.write(rw, status, "application/xml", append([]byte(xml.Header), b...))
+	return nil
+}
+
+// Text writes s as plain text with status.
+func (rd *Render) Text(rw http.ResponseWriter, status int, s string) error {
+	rd.write(rw, status, "text/plain", []byte(s))
+	return nil
+}
+
+func (rd *Render) write(rw http.ResponseWriter, status int, contentType string, body []byte) {
+	if rd.Charset != "" {
+		contentType += "; charset=" + rd.Charset
+	}
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	rw.WriteHeader(status)
+	rw.Write(body)
+}
+
+// load returns the parsed templates, parsing them the first time, or every time in
+// Development.
+func (rd *Render) load() (*template.Template, error) {
+	rd.mtx.Lock()
+	defer rd.mtx.Unlock()
+
+	if rd.templates != nil && !rd.Development {
+		return rd.templates, nil
+	}
+	t, err := rd.parse()
+	if err != nil {
+		return nil, err
+	}
+	rd.templates = t
+	return t, nil
+}
+
+// parse parses the templates of Directory.
+func (rd *Render) parse() (*template.Template, error) {
+	dir := path.Clean(rd.Directory)
+	if dir == "/" || dir == "" {
+		dir = "."
+	}
+	// yield and data are bound to the page when it is rendered
+	funcs := template.FuncMap{
+		"data":  func(key string) interface{} { return nil },
+		"yield": func() (template.HTML, error) { return "", fmt.Errorf("render: yield called outside of a layout") },
+	}
+	for name, fn := range rd.Funcs {
+		funcs[name] = fn
+	}
+	t := template.New("").Funcs(funcs)
+
+	err := fs.WalkDir(rd.FS, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !rd.template(name) {
+			return err
+		}
+		b, err := fs.ReadFile(rd.FS, name)
+		if err != nil {
+			return err
+		}
+		rel := strings.TrimPrefix(name, dir+"/")
+		if dir == "." {
+			rel = name
+		}
+		_, err = t.New(strings.TrimSuffix(rel, path.Ext(rel))).Parse(string(b))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// template returns whether name has one of the Extensions.
+func (rd *Render) template(name string) bool {
+	for _, ext := range rd.Extensions {
+		if path.Ext(name) == ext {
+			return true
+		}
+	}
+	return false
+}
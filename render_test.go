@@ -0,0 +1,102 @@
+package camillo
+
+import (
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/net/context"
+)
+
+func renderFS() fstest.MapFS {
+	return fstest.MapFS{
+		"templates/layouts/main.html": {Data: []byte(`<title>{{.Title}}</title>{{template "partials/nav" .}}<main>{{yield}}</main>`)},
+		"templates/partials/nav.html": {Data: []byte(`<nav>{{with data "User"}}Hi {{.}}{{else}}Sign in{{end}}</nav>`)},
+		"templates/users/show.html":   {Data: []byte(`<h1>{{.Title | shout}}</h1>`)},
+		"templates/users/broken.tmpl": {Data: []byte(`{{.Missing.Field}}`)},
+		"templates/users/notes.txt":   {Data: []byte(`not a template`)},
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	rd := NewRender(renderFS())
+	rd.Layout = "layouts/main"
+	rd.Funcs = template.FuncMap{"shout": strings.ToUpper}
+
+	ctx := WithRenderData(context.Background(), "User", "<bob>")
+	response := httptest.NewRecorder()
+	expect(t, rd.HTML(ctx, response, http.StatusCreated, "users/show", map[string]string{"Title": "Users"}), nil)
+	expect(t, response.Code, http.StatusCreated)
+	expect(t, response.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	expect(t, response.Body.String(), `<title>Users</title><nav>Hi &lt;bob&gt;</nav><main><h1>USERS</h1></main>`)
+
+	response = httptest.NewRecorder()
+	expect(t, rd.HTMLLayout(context.Background(), response, http.StatusOK, "", "partials/nav", nil), nil)
+	expect(t, response.Body.String(), `<nav>Sign in</nav>`)
+
+	// failing templates leave the response untouched
+	response = httptest.NewRecorder()
+	refute(t, rd.HTML(ctx, response, http.StatusOK, "users/broken", struct{}{}), nil)
+	expect(t, response.Body.Len(), 0)
+	expect(t, response.Header().Get("Content-Type"), "")
+
+	expect(t, rd.HTML(ctx, response, http.StatusOK, "users/notes", nil).Error(), `render: no template "users/notes"`)
+}
+
+func TestRenderDevelopment(t *testing.T) {
+	fsys := renderFS()
+	rd := NewRender(fsys)
+	rd.Funcs = template.FuncMap{"shout": strings.ToUpper}
+	render := func() string {
+		response := httptest.NewRecorder()
+		expect(t, rd.HTML(context.Background(), response, http.StatusOK, "users/show", map[string]string{"Title": "a"}), nil)
+		return response.Body.String()
+	}
+
+	expect(t, render(), "<h1>A</h1>")
+	fsys["templates/users/show.html"] = &fstest.MapFile{Data: []byte(`<h2>{{.Title}}</h2>`)}
+	expect(t, render(), "<h1>A</h1>")
+
+	rd.Development = true
+	expect(t, render(), "<h2>a</h2>")
+}
+
+func TestRenderEncodings(t *testing.T) {
+	rd := NewRender(fstest.MapFS{})
+
+	response := httptest.NewRecorder()
+	expect(t, rd.JSON(response, http.StatusOK, map[string]int{"id": 1}), nil)
+	expect(t, response.Header().Get("Content-Type"), "application/json; charset=utf-8")
+	expect(t, response.Body.String(), "{\"id\":1}\n")
+
+	response = httptest.NewRecorder()
+	expect(t, rd.XML(response, http.StatusAccepted, renderedItem{ID: 1}), nil)
+	expect(t, response.Code, http.StatusAccepted)
+	expect(t, response.Header().Get("Content-Type"), "application/xml; charset=utf-8")
+	expect(t, response.Body.String(), "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<item><id>1</id></item>")
+
+	response = httptest.NewRecorder()
+	expect(t, rd.Text(response, http.StatusOK, "ok"), nil)
+	expect(t, response.Header().Get("Content-Type"), "text/plain; charset=utf-8")
+	expect(t, response.Header().Get("Content-Length"), "2")
+
+	refute(t, rd.JSON(httptest.NewRecorder(), http.StatusOK, func() {}), nil)
+}
+
+type renderedItem struct {
+	XMLName xml.Name `xml:"item"`
+	ID      int      `xml:"id"`
+}
+
+func TestWithRenderData(t *testing.T) {
+	ctx := WithRenderData(context.Background(), "User", "bob")
+	other := WithRenderData(ctx, "CSRF", "token")
+	expect(t, len(RenderDataFromContext(ctx)), 1)
+	expect(t, RenderDataFromContext(other)["User"], "bob")
+	expect(t, RenderDataFromContext(other)["CSRF"], "token")
+	expect(t, RenderDataFromContext(context.Background()) == nil, true)
+}
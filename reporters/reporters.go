@@ -0,0 +1,101 @@
+// Package reporters sends the panics recovered by camillo.Recovery to error tracking
+// systems, with the metadata of the request attached:
+//
+//	rec := camillo.NewRecovery()
+//	sentry, err := reporters.NewSentry(os.Getenv("SENTRY_DSN"))
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	rec.Reporter = sentry
+//
+// Reports are sent in the background, so the request isn't held up by the error tracking
+// system. Reports that can't be queued or sent are logged. Close waits for the queued
+// reports and stops the goroutine sending them.
+package reporters
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fd/camillo"
+	"golang.org/x/net/context"
+)
+
+// queueSize is the number of reports that wait to be sent before reports are dropped.
+const queueSize = 64
+
+// requestHeader returns the headers of r with the secrets redacted as the Recovery
+// reporting the panic redacts them, or nil when there is no request.
+func requestHeader(ctx context.Context, r *http.Request) map[string]string {
+	if r == nil {
+		return nil
+	}
+	header := make(map[string]string, len(r.Header))
+	for name, values := range r.Header {
+		header[name] = strings.Join(values, ", ")
+	}
+	for _, name := range camillo.RedactHeadersFromContext(ctx) {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := header[name]; ok {
+			header[name] = "[REDACTED]"
+		}
+	}
+	return header
+}
+
+// errQueueFull and errClosed are the reasons a report isn't queued.
+var (
+	errQueueFull = errors.New("the queue is full")
+	errClosed    = errors.New("the reporter is closed")
+)
+
+// sender sends reports from a background goroutine until it is closed.
+type sender struct {
+	queue chan func()
+	done  chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newSender() *sender {
+	s := &sender{queue: make(chan func(), queueSize), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		for send := range s.queue {
+			send()
+		}
+	}()
+	return s
+}
+
+// enqueue queues send, returning an error when the queue is full or closed.
+func (s *sender) enqueue(send func()) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return errClosed
+	}
+	select {
+	case s.queue <- send:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Close stops queueing reports and waits for the queued ones to be sent.
+func (s *sender) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
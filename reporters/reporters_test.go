@@ -0,0 +1,141 @@
+package reporters
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fd/camillo"
+)
+
+func expect(t *testing.T, a interface{}, b interface{}) {
+	if a != b {
+		t.Errorf("Expected %v (type %T) - Got %v (type %T)", b, b, a, a)
+	}
+}
+
+// received records the requests of a fake error tracking system.
+type received chan *http.Request
+
+func (c received) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+	c <- r
+}
+
+func panicking(reporter camillo.Reporter) {
+	rec := camillo.NewRecovery()
+	rec.Logger = log.New(ioutil.Discard, "", 0)
+	rec.Reporter = reporter
+	rec.ReproRedactHeaders = append([]string{"x-tenant-token"}, camillo.DefaultReproRedactHeaders...)
+
+	n := camillo.New(camillo.NewRequestID(), rec)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		panic("here is a panic!")
+	})
+
+	req, _ := http.NewRequest("POST", "http://localhost:3000/orders?id=1", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Tenant-Token", "secret")
+	req.Header.Set("X-Request-Id", "abc")
+	n.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestSentry(t *testing.T) {
+	requests := make(received, 1)
+	server := httptest.NewServer(requests)
+	defer server.Close()
+
+	sentry, err := NewSentry(strings.Replace(server.URL, "://", "://public@", 1) + "/42")
+	expect(t, err, nil)
+	defer sentry.Close()
+	sentry.Environment = "test"
+	panicking(sentry)
+
+	r := <-requests
+	expect(t, r.URL.Path, "/api/42/envelope/")
+	expect(t, r.Header.Get("X-Sentry-Auth"), "Sentry sentry_version=7, sentry_client=camillo/1.0, sentry_key=public")
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(nil, 1024*1024)
+	scanner.Scan()
+	scanner.Scan()
+	expect(t, strings.HasPrefix(scanner.Text(), `{"type":"event"`), true)
+	scanner.Scan()
+
+	var event sentryEvent
+	expect(t, json.Unmarshal(scanner.Bytes(), &event), nil)
+	expect(t, event.Level, "fatal")
+	expect(t, event.Environment, "test")
+	expect(t, event.Tags["request_id"], "abc")
+	expect(t, event.Request.URL, "http://localhost:3000/orders")
+	expect(t, event.Request.QueryString, "id=1")
+	expect(t, event.Request.Headers["Authorization"], "[REDACTED]")
+	expect(t, event.Request.Headers["X-Tenant-Token"], "[REDACTED]")
+	exception := event.Exception.Values[0]
+	expect(t, exception.Type, "string")
+	expect(t, exception.Value, "here is a panic!")
+
+	frames := exception.Stacktrace.Frames
+	last := frames[len(frames)-1]
+	expect(t, strings.HasPrefix(last.Function, "github.com/fd/camillo.(*Recovery).ServeHTTP"), true)
+	expect(t, last.InApp, true)
+	expect(t, strings.HasSuffix(last.Filename, "recovery.go"), true)
+}
+
+func TestNewSentryInvalidDSN(t *testing.T) {
+	_, err := NewSentry("https://o0.ingest.sentry.io/1")
+	expect(t, err.Error(), `reporters: invalid Sentry DSN "https://o0.ingest.sentry.io/1"`)
+}
+
+func TestWebhook(t *testing.T) {
+	requests := make(received, 1)
+	server := httptest.NewServer(requests)
+	defer server.Close()
+
+	webhook := NewWebhook(server.URL + "/hooks/panics")
+	defer webhook.Close()
+	webhook.Header.Set("Authorization", "Token xyz")
+	panicking(webhook)
+
+	r := <-requests
+	expect(t, r.URL.Path, "/hooks/panics")
+	expect(t, r.Header.Get("Authorization"), "Token xyz")
+	expect(t, r.Header.Get("Content-Type"), "application/json")
+
+	var report WebhookReport
+	expect(t, json.NewDecoder(r.Body).Decode(&report), nil)
+	expect(t, report.Panic, "here is a panic!")
+	expect(t, report.Method, "POST")
+	expect(t, report.URL, "http://localhost:3000/orders?id=1")
+	expect(t, report.RequestID, "abc")
+	expect(t, report.Header["Authorization"], "[REDACTED]")
+	expect(t, report.Header["X-Tenant-Token"], "[REDACTED]")
+	expect(t, strings.Contains(report.Stack, "goroutine "), true)
+}
+
+func TestWebhookClose(t *testing.T) {
+	requests := make(received, 2)
+	server := httptest.NewServer(requests)
+	defer server.Close()
+
+	var buff bytes.Buffer
+	webhook := NewWebhook(server.URL)
+	webhook.Logger = log.New(&buff, "", 0)
+	panicking(webhook)
+	panicking(webhook)
+
+	// the queued reports are sent before Close returns
+	webhook.Close()
+	expect(t, len(requests), 2)
+
+	panicking(webhook)
+	expect(t, buff.String(), "dropped the webhook report of a panic: the reporter is closed\n")
+	expect(t, webhook.Close(), nil)
+}
@@ -0,0 +1,192 @@
+package reporters
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fd/camillo"
+	"golang.org/x/net/context"
+)
+
+// Sentry is a camillo.Reporter sending panics to Sentry as events, with their stack and
+// request. Events are sent to the envelope endpoint of the project of the DSN.
+type Sentry struct {
+	// Environment is the environment of the events, such as "production".
+	Environment string
+	// Release is the release of the events, such as a version or a commit.
+	Release string
+	// Tags are added to every event.
+	Tags map[string]string
+	// Client sends the events
+	Client *http.Client
+	// Logger receives the errors sending events
+	Logger camillo.LogSink
+
+	dsn      string
+	endpoint string
+	key      string
+	sender   *sender
+}
+
+// NewSentry returns a new Sentry reporter for the project of dsn, such as
+// "https://public@o0.ingest.sentry.io/1".
+func NewSentry(dsn string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	project := strings.Trim(u.Path[strings.LastIndex(u.Path, "/")+1:], "/")
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return nil, fmt.Errorf("reporters: invalid Sentry DSN %q", dsn)
+	}
+	path := strings.TrimSuffix(u.Path[:strings.LastIndex(u.Path, "/")+1], "/")
+
+	return &Sentry{
+		Client:   defaultClient,
+		Logger:   log.New(os.Stdout, "[camillo] ", 0),
+		dsn:      dsn,
+		endpoint: u.Scheme + "://" + u.Host + path + "/api/" + project + "/envelope/",
+		key:      u.User.Username(),
+		sender:   newSender(),
+	}, nil
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Exception   struct {
+		Values []sentryException `json:"values"`
+	} `json:"exception"`
+	Request *sentryRequest    `json:"request,omitempty"`
+	Extra   map[string]string `json:"extra,omitempty"`
+}
+
+type sentryException struct {
+	Type       string `json:"type"`
+	Value      string `json:"value"`
+	Stacktrace struct {
+		Frames []sentryFrame `json:"frames"`
+	} `json:"stacktrace"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryRequest struct {
+	URL         string            `json:"url"`
+	Method      string            `json:"method"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// Report queues an event for the panic.
+func (s *Sentry) Report(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+	event := sentryEvent{
+		EventID:     newEventID(),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Level:       "fatal",
+		Platform:    "go",
+		Environment: s.Environment,
+		Release:     s.Release,
+		Tags:        s.Tags,
+	}
+	exception := sentryException{Type: fmt.Sprintf("%T", err), Value: fmt.Sprint(err)}
+	frames := camillo.ParseStack(stack)
+	// Sentry lists the frames outermost first
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+		exception.Stacktrace.Frames = append(exception.Stacktrace.Frames, sentryFrame{Function: f.Function, Filename: f.File, Lineno: f.Line, InApp: f.App})
+	}
+	event.Exception.Values = []sentryException{exception}
+	if dump := camillo.RequestDumpFromContext(ctx); dump != "" {
+		event.Extra = map[string]string{"request_dump": dump}
+	}
+	if r != nil {
+		u := *r.URL
+		u.RawQuery = ""
+		if u.Host == "" {
+			u.Host = r.Host
+			u.Scheme = "http"
+			if r.TLS != nil {
+				u.Scheme = "https"
+			}
+		}
+		event.Request = &sentryRequest{URL: u.String(), Method: r.Method, QueryString: r.URL.RawQuery, Headers: requestHeader(ctx, r)}
+		if id := camillo.RequestIDFromContext(ctx); id != "" {
+			event.Tags = mergeTags(event.Tags, "request_id", id)
+		}
+	}
+
+	if err := s.sender.enqueue(func() { s.send(event) }); err != nil {
+		s.Logger.Printf("dropped the Sentry event of a panic: %s", err)
+	}
+}
+
+// Close stops the reporter, waiting for the queued events to be sent. The panics
+// reported afterwards are dropped.
+func (s *Sentry) Close() error {
+	s.sender.Close()
+	return nil
+}
+
+func (s *Sentry) send(event sentryEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		s.Logger.Printf("failed to encode the Sentry event %s: %s", event.EventID, err)
+		return
+	}
+	var envelope bytes.Buffer
+	fmt.Fprintf(&envelope, "{\"event_id\":%q,\"dsn\":%q}\n{\"type\":\"event\",\"length\":%d}\n", event.EventID, s.dsn, len(payload))
+	envelope.Write(payload)
+	envelope.WriteByte('\n')
+
+	req, err := http.NewRequest("POST", s.endpoint, &envelope)
+	if err != nil {
+		s.Logger.Printf("failed to send the Sentry event %s: %s", event.EventID, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", "Sentry sentry_version=7, sentry_client=camillo/1.0, sentry_key="+s.key)
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		s.Logger.Printf("failed to send the Sentry event %s: %s", event.EventID, err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		s.Logger.Printf("failed to send the Sentry event %s: %s", event.EventID, res.Status)
+	}
+}
+
+func mergeTags(tags map[string]string, key, value string) map[string]string {
+	merged := map[string]string{key: value}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return merged
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
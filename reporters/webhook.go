@@ -0,0 +1,106 @@
+package reporters
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/fd/camillo"
+	"golang.org/x/net/context"
+)
+
+// WebhookReport is the JSON body posted by the Webhook reporter.
+type WebhookReport struct {
+	Time        time.Time         `json:"time"`
+	Panic       string            `json:"panic"`
+	Stack       string            `json:"stack"`
+	Method      string            `json:"method,omitempty"`
+	URL         string            `json:"url,omitempty"`
+	Header      map[string]string `json:"header,omitempty"`
+	RequestID   string            `json:"request_id,omitempty"`
+	RequestDump string            `json:"request_dump,omitempty"`
+}
+
+// Webhook is a camillo.Reporter posting every panic as a WebhookReport to a URL, for
+// error tracking systems and chat integrations that accept JSON webhooks.
+type Webhook struct {
+	// URL receives the reports
+	URL string
+	// Header is added to the requests, for example to authenticate them.
+	Header http.Header
+	// Client sends the reports
+	Client *http.Client
+	// Logger receives the errors sending reports
+	Logger camillo.LogSink
+
+	sender *sender
+}
+
+// NewWebhook returns a new Webhook reporter posting to url
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		URL:    url,
+		Header: http.Header{},
+		Client: defaultClient,
+		Logger: log.New(os.Stdout, "[camillo] ", 0),
+		sender: newSender(),
+	}
+}
+
+// Report queues a report of the panic.
+func (w *Webhook) Report(ctx context.Context, r *http.Request, err interface{}, stack []byte) {
+	report := WebhookReport{
+		Time:        time.Now(),
+		Panic:       fmt.Sprint(err),
+		Stack:       string(stack),
+		RequestID:   camillo.RequestIDFromContext(ctx),
+		RequestDump: camillo.RequestDumpFromContext(ctx),
+	}
+	if r != nil {
+		report.Method = r.Method
+		report.URL = r.URL.String()
+		report.Header = requestHeader(ctx, r)
+	}
+
+	if err := w.sender.enqueue(func() { w.send(report) }); err != nil {
+		w.Logger.Printf("dropped the webhook report of a panic: %s", err)
+	}
+}
+
+// Close stops the reporter, waiting for the queued reports to be sent. The panics
+// reported afterwards are dropped.
+func (w *Webhook) Close() error {
+	w.sender.Close()
+	return nil
+}
+
+func (w *Webhook) send(report WebhookReport) {
+	body, err := json.Marshal(report)
+	if err != nil {
+		w.Logger.Printf("failed to encode the webhook report: %s", err)
+		return
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		w.Logger.Printf("failed to send the webhook report: %s", err)
+		return
+	}
+	for k, v := range w.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := w.Client.Do(req)
+	if err != nil {
+		w.Logger.Printf("failed to send the webhook report: %s", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		w.Logger.Printf("failed to send the webhook report: %s", res.Status)
+	}
+}
@@ -0,0 +1,90 @@
+package camillo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ReproBundle holds what is needed to reproduce a request that caused a panic.
+type ReproBundle struct {
+	IncidentID    string      `json:"incident_id"`
+	RequestID     string      `json:"request_id,omitempty"`
+	Time          time.Time   `json:"time"`
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`
+	Proto         string      `json:"proto"`
+	Host          string      `json:"host"`
+	RemoteAddr    string      `json:"remote_addr"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body"`
+	BodyTruncated bool        `json:"body_truncated"`
+	Panic         string      `json:"panic"`
+	Stack         string      `json:"stack"`
+	Goroutines    string      `json:"goroutines"`
+}
+
+// ReproStore saves repro bundles, for example to a directory or an object store.
+type ReproStore interface {
+	SaveRepro(bundle *ReproBundle) error
+}
+
+// DirReproStore is a ReproStore that writes every bundle to a JSON file named after its
+// incident ID in a directory.
+type DirReproStore string
+
+// SaveRepro writes bundle to <dir>/<incident id>.json
+func (d DirReproStore) SaveRepro(bundle *ReproBundle) error {
+	b, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(string(d), bundle.IncidentID+".json"), b, 0600)
+}
+
+// DefaultReproRedactHeaders are the request headers that are redacted from repro bundles
+// by default.
+var DefaultReproRedactHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "X-Api-Key"}
+
+// capturedBody records the first limit bytes of a request body as it is read.
+type capturedBody struct {
+	io.ReadCloser
+	capture captureBuffer
+}
+
+func (b *capturedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.capture.Write(p[:n])
+	return n, err
+}
+
+// rest records the part of the body up to the limit that the handlers didn't read.
+func (b *capturedBody) rest() {
+	if !b.capture.truncated {
+		room := b.capture.limit - len(b.capture.buf)
+		io.Copy(&b.capture, io.LimitReader(b.ReadCloser, int64(room)+1))
+	}
+}
+
+func newIncidentID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// redactHeader returns a copy of h with the values of the headers in redact replaced.
+func redactHeader(h http.Header, redact []string) http.Header {
+	h = h.Clone()
+	for _, k := range redact {
+		k = http.CanonicalHeaderKey(k)
+		if _, ok := h[k]; ok {
+			h[k] = []string{"[REDACTED]"}
+		}
+	}
+	return h
+}
@@ -0,0 +1,33 @@
+package camillo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirReproStore(t *testing.T) {
+	dir := t.TempDir()
+	store := DirReproStore(dir)
+
+	err := store.SaveRepro(&ReproBundle{IncidentID: "abc", Method: "GET", Body: []byte("hello")})
+	expect(t, err, nil)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "abc.json"))
+	expect(t, err, nil)
+	var bundle ReproBundle
+	expect(t, json.Unmarshal(b, &bundle), nil)
+	expect(t, bundle.Method, "GET")
+	expect(t, string(bundle.Body), "hello")
+}
+
+func TestRedactHeader(t *testing.T) {
+	h := http.Header{"Cookie": {"session=1"}, "Accept": {"*/*"}}
+	redacted := redactHeader(h, DefaultReproRedactHeaders)
+
+	expect(t, redacted.Get("Cookie"), "[REDACTED]")
+	expect(t, redacted.Get("Accept"), "*/*")
+	expect(t, h.Get("Cookie"), "session=1")
+}
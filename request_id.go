@@ -0,0 +1,87 @@
+package camillo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the ID of the request set by RequestID, or "" when there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID is a middleware handler that assigns an ID to every request for correlating
+// the logs of the services it passes through. An ID sent by the client or an upstream
+// service in the header is kept, otherwise a new one is generated. The ID is stored in the
+// context and set on the response. Logger and Recovery include it in their lines, so
+// RequestID should come before them in the stack.
+type RequestID struct {
+	// Header is the request and response header holding the ID.
+	Header string
+	// Generate returns a new request ID
+	Generate func() string
+	// MaxLength is the maximum length of an incoming ID. Longer IDs and IDs with characters
+	// other than printable ASCII are replaced, so they can't be used to forge log lines.
+	MaxLength int
+}
+
+// NewRequestID returns a new instance of RequestID
+func NewRequestID() *RequestID {
+	return &RequestID{
+		Header:    "X-Request-Id",
+		Generate:  newRequestID,
+		MaxLength: maxRequestIDLength,
+	}
+}
+
+func (m *RequestID) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	id := r.Header.Get(m.Header)
+	if !m.valid(id) {
+		id = m.Generate()
+		r.Header.Set(m.Header, id)
+	}
+
+	rw.Header().Set(m.Header, id)
+	next(context.WithValue(ctx, requestIDKey{}, id), rw, r)
+}
+
+func (m *RequestID) valid(id string) bool {
+	return validRequestID(id, m.MaxLength)
+}
+
+// maxRequestIDLength is the maximum length of the IDs sent by the clients, by default.
+const maxRequestIDLength = 128
+
+// validRequestID returns whether id is printable ASCII of at most maxLength bytes, so it
+// can't forge log lines.
+func validRequestID(id string, maxLength int) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logPrefix returns the prefix of the log lines of a request with the given ID.
+func logPrefix(id string) string {
+	if id == "" {
+		return ""
+	}
+	return "[" + id + "] "
+}
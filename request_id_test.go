@@ -0,0 +1,72 @@
+package camillo
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func serveRequestID(t *testing.T, incoming string) (string, *httptest.ResponseRecorder) {
+	var id string
+	n := New(NewRequestID())
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		id = RequestIDFromContext(ctx)
+		expect(t, r.Header.Get("X-Request-Id"), id)
+	})
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	if incoming != "" {
+		req.Header.Set("X-Request-ID", incoming)
+	}
+	n.ServeHTTP(recorder, req)
+	return id, recorder
+}
+
+func TestRequestID(t *testing.T) {
+	id, recorder := serveRequestID(t, "")
+	expect(t, len(id), 32)
+	expect(t, recorder.Header().Get("X-Request-Id"), id)
+
+	id, recorder = serveRequestID(t, "upstream-123")
+	expect(t, id, "upstream-123")
+	expect(t, recorder.Header().Get("X-Request-Id"), "upstream-123")
+
+	for _, invalid := range []string{"forged\nline", "with space", strings.Repeat("a", 129)} {
+		id, _ = serveRequestID(t, invalid)
+		refute(t, id, invalid)
+		expect(t, len(id), 32)
+	}
+
+	expect(t, RequestIDFromContext(context.Background()), "")
+}
+
+func TestRequestIDLogCorrelation(t *testing.T) {
+	buff := bytes.NewBufferString("")
+
+	m := NewRequestID()
+	m.Generate = func() string { return "abc" }
+	l := NewLogger()
+	l.Logger = log.New(buff, "", 0)
+	rec := NewRecovery()
+	rec.Logger = l.Logger
+	rec.PrintStack = false
+
+	n := New(m, l, rec)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost:3000/foo", nil)
+	n.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+	expect(t, lines[0], "[abc] Started GET /foo")
+	expect(t, strings.HasPrefix(lines[1], "[abc] PANIC: boom"), true)
+	expect(t, strings.HasPrefix(lines[len(lines)-1], "[abc] Completed 500 Internal Server Error in "), true)
+}
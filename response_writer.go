@@ -3,8 +3,12 @@ package camillo
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
 // ResponseWriter is a wrapper around http.ResponseWriter that provides extra information about
@@ -15,20 +19,37 @@ type ResponseWriter interface {
 	http.Flusher
 	// Status returns the status code of the response or 0 if the response has not been written.
 	Status() int
-	// Written returns whether or not the ResponseWriter has been written.
+	// Written returns whether or not the ResponseWriter has been written. A hijacked connection counts
+	// as written.
 	Written() bool
+	// WrittenHeader returns a snapshot of the headers as they were written, after the Before functions
+	// ran, or nil if the response has not been written.
+	WrittenHeader() http.Header
 	// Size returns the size of the response body.
 	Size() int
+	// FirstByteTime returns the time the first byte of the response was written, or the zero time
+	// if the response has not been written.
+	FirstByteTime() time.Time
+	// SetWriteDeadline sets the write deadline of the underlying connection, overriding the server's
+	// WriteTimeout for this response. Streaming handlers use it to keep long downloads alive. A zero
+	// deadline means no deadline.
+	SetWriteDeadline(deadline time.Time) error
 	// Before allows for a function to be called before the ResponseWriter has been written to. This is
 	// useful for setting headers or any other operations that must happen before a response has been written.
+	// The functions are called once, in reverse order of registration, after the final status is known.
 	Before(func(ResponseWriter))
+	// Capture tees the response body written from now on into a buffer of at most limit bytes.
+	// This is useful for response logging, caching and ETag computation after the chain unwinds.
+	Capture(limit int)
+	// Captured returns the captured response body and whether it was truncated to the limit.
+	Captured() ([]byte, bool)
 }
 
 type beforeFunc func(ResponseWriter)
 
 // NewResponseWriter creates a ResponseWriter that wraps an http.ResponseWriter
 func NewResponseWriter(rw http.ResponseWriter) ResponseWriter {
-	return &responseWriter{rw, 0, 0, nil}
+	return &responseWriter{ResponseWriter: rw}
 }
 
 type responseWriter struct {
@@ -36,11 +57,52 @@ type responseWriter struct {
 	status      int
 	size        int
 	beforeFuncs []beforeFunc
+	capture     *captureBuffer
+	snapshot    http.Header
+	hijacked    bool
+	firstByte   time.Time
+	clock       Clock
+}
+
+var responseWriterPool = sync.Pool{
+	New: func() interface{} {
+		return &responseWriter{}
+	},
+}
+
+// acquireResponseWriter returns a pooled ResponseWriter that wraps rw and times the first byte
+// with clock. It must be released with releaseResponseWriter once the request has been served.
+func acquireResponseWriter(rw http.ResponseWriter, clock Clock) *responseWriter {
+	w := responseWriterPool.Get().(*responseWriter)
+	w.ResponseWriter = rw
+	w.clock = clock
+	return w
+}
+
+func releaseResponseWriter(w *responseWriter) {
+	for i := range w.beforeFuncs {
+		w.beforeFuncs[i] = nil
+	}
+	*w = responseWriter{beforeFuncs: w.beforeFuncs[:0]}
+	responseWriterPool.Put(w)
 }
 
 func (rw *responseWriter) WriteHeader(s int) {
+	if rw.Written() {
+		// net/http ignores superfluous WriteHeader calls, so do the same
+		return
+	}
+	if rw.firstByte.IsZero() {
+		rw.firstByte = clockNow(rw.clock)
+	}
+	if s >= 100 && s < 200 && s != http.StatusSwitchingProtocols {
+		// informational responses don't commit the final status
+		rw.ResponseWriter.WriteHeader(s)
+		return
+	}
 	rw.status = s
 	rw.callBefore()
+	rw.snapshot = rw.Header().Clone()
 	rw.ResponseWriter.WriteHeader(s)
 }
 
@@ -51,9 +113,34 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	}
 	size, err := rw.ResponseWriter.Write(b)
 	rw.size += size
+	if rw.capture != nil {
+		rw.capture.Write(b[:size])
+	}
 	return size, err
 }
 
+// ReadFrom lets io.Copy use the underlying ResponseWriter's io.ReaderFrom, when it
+// has one, so the sendfile path of net/http is preserved.
+func (rw *responseWriter) ReadFrom(src io.Reader) (int64, error) {
+	if !rw.Written() {
+		// The status will be StatusOK if WriteHeader has not been called yet
+		rw.WriteHeader(http.StatusOK)
+	}
+	var n int64
+	var err error
+	if rw.capture != nil {
+		// the captured copy can't be made on the sendfile path
+		src = io.TeeReader(src, rw.capture)
+	}
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(rw.ResponseWriter, src)
+	}
+	rw.size += int(n)
+	return n, err
+}
+
 func (rw *responseWriter) Status() int {
 	return rw.status
 }
@@ -62,20 +149,66 @@ func (rw *responseWriter) Size() int {
 	return rw.size
 }
 
+func (rw *responseWriter) FirstByteTime() time.Time {
+	return rw.firstByte
+}
+
+func (rw *responseWriter) SetWriteDeadline(deadline time.Time) error {
+	return http.NewResponseController(rw.ResponseWriter).SetWriteDeadline(deadline)
+}
+
 func (rw *responseWriter) Written() bool {
-	return rw.status != 0
+	return rw.status != 0 || rw.hijacked
+}
+
+func (rw *responseWriter) WrittenHeader() http.Header {
+	return rw.snapshot
 }
 
 func (rw *responseWriter) Before(before func(ResponseWriter)) {
 	rw.beforeFuncs = append(rw.beforeFuncs, before)
 }
 
+func (rw *responseWriter) Capture(limit int) {
+	if rw.capture == nil {
+		rw.capture = &captureBuffer{}
+	}
+	if limit > rw.capture.limit {
+		rw.capture.limit = limit
+	}
+}
+
+func (rw *responseWriter) Captured() ([]byte, bool) {
+	if rw.capture == nil {
+		return nil, false
+	}
+	return rw.capture.buf, rw.capture.truncated
+}
+
 func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	hijacker, ok := rw.ResponseWriter.(http.Hijacker)
 	if !ok {
 		return nil, nil, fmt.Errorf("the ResponseWriter doesn't support the Hijacker interface")
 	}
-	return hijacker.Hijack()
+	conn, brw, err := hijacker.Hijack()
+	if err == nil {
+		rw.hijacked = true
+	}
+	return conn, brw, err
+}
+
+// Unwrap returns the wrapped http.ResponseWriter, which lets http.ResponseController reach
+// the facilities of the underlying connection.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+func (rw *responseWriter) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := rw.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
 }
 
 func (rw *responseWriter) CloseNotify() <-chan bool {
@@ -91,6 +224,85 @@ func (rw *responseWriter) callBefore() {
 func (rw *responseWriter) Flush() {
 	flusher, ok := rw.ResponseWriter.(http.Flusher)
 	if ok {
+		if !rw.Written() {
+			// Flushing commits the headers, the status will be StatusOK
+			rw.WriteHeader(http.StatusOK)
+		}
 		flusher.Flush()
 	}
 }
+
+// Push initiates an HTTP/2 server push of target when rw supports it. Push is a no-op
+// on connections that don't support server push, such as HTTP/1.1 connections.
+func Push(rw http.ResponseWriter, target string, opts *http.PushOptions) error {
+	pusher, ok := rw.(http.Pusher)
+	if !ok {
+		return nil
+	}
+	err := pusher.Push(target, opts)
+	if err == http.ErrNotSupported {
+		return nil
+	}
+	return err
+}
+
+// ExtendWriteDeadline moves the write deadline of the response to d from now. It returns an
+// error wrapping http.ErrNotSupported when rw can't set deadlines.
+func ExtendWriteDeadline(rw http.ResponseWriter, d time.Duration) error {
+	return http.NewResponseController(rw).SetWriteDeadline(time.Now().Add(d))
+}
+
+// DeclareTrailer announces the trailer fields that will be sent after the response body
+// in the Trailer header. It has no effect once the response has been written.
+func DeclareTrailer(rw http.ResponseWriter, keys ...string) {
+	if res, ok := rw.(ResponseWriter); ok && res.Written() {
+		return
+	}
+	for _, key := range keys {
+		rw.Header().Add("Trailer", http.CanonicalHeaderKey(key))
+	}
+}
+
+// AddTrailer appends a value to the trailer field key. Unlike headers, trailers can be
+// added after the response body has been written, which makes them suitable for values
+// computed from the body, such as Server-Timing or checksums. Trailers are only sent on
+// connections that support them, such as chunked HTTP/1.1 and HTTP/2 responses.
+func AddTrailer(rw http.ResponseWriter, key, value string) {
+	rw.Header().Add(http.TrailerPrefix+http.CanonicalHeaderKey(key), value)
+}
+
+// isTrailer returns whether the header field key holds a trailer value rather than a
+// header value, given the fields declared in the Trailer header.
+func isTrailer(h http.Header, key string) bool {
+	if strings.HasPrefix(key, http.TrailerPrefix) {
+		return true
+	}
+	for _, v := range h["Trailer"] {
+		for _, declared := range strings.Split(v, ",") {
+			if http.CanonicalHeaderKey(strings.TrimSpace(declared)) == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// captureBuffer keeps up to limit bytes of everything written to it.
+type captureBuffer struct {
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+func (c *captureBuffer) Write(b []byte) (int, error) {
+	n := len(b)
+	if room := c.limit - len(c.buf); room < len(b) {
+		c.truncated = true
+		if room < 0 {
+			room = 0
+		}
+		b = b[:room]
+	}
+	c.buf = append(c.buf, b...)
+	return n, nil
+}
@@ -2,11 +2,16 @@ package camillo
 
 import (
 	"bufio"
+	"errors"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 type closeNotifyingRecorder struct {
@@ -46,6 +51,26 @@ func (h *hijackableResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return nil, nil, nil
 }
 
+type pushableResponse struct {
+	*httptest.ResponseRecorder
+	pushed []string
+}
+
+func (p *pushableResponse) Push(target string, opts *http.PushOptions) error {
+	p.pushed = append(p.pushed, target)
+	return nil
+}
+
+type readerFromResponse struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (r *readerFromResponse) ReadFrom(src io.Reader) (int64, error) {
+	r.readFrom = true
+	return io.Copy(r.ResponseRecorder, src)
+}
+
 func TestResponseWriterWritingString(t *testing.T) {
 	rec := httptest.NewRecorder()
 	rw := NewResponseWriter(rec)
@@ -105,6 +130,48 @@ func TestResponseWriterBefore(t *testing.T) {
 	expect(t, result, "barfoo")
 }
 
+func TestResponseWriterBeforeOnce(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+	calls := 0
+
+	rw.Before(func(rw ResponseWriter) {
+		calls++
+		expect(t, rw.Status(), http.StatusCreated)
+		rw.Header().Set("X-Status", "created")
+		// writing from a hook must not re-enter the hooks
+		rw.WriteHeader(http.StatusTeapot)
+	})
+
+	rw.WriteHeader(http.StatusCreated)
+	rw.WriteHeader(http.StatusNotFound)
+	rw.Write([]byte("Hello world"))
+
+	expect(t, calls, 1)
+	expect(t, rec.Code, http.StatusCreated)
+	expect(t, rw.Status(), http.StatusCreated)
+	expect(t, rec.Header().Get("X-Status"), "created")
+}
+
+func TestResponseWriterInformational(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+	calls := 0
+
+	rw.Before(func(ResponseWriter) {
+		calls++
+	})
+
+	rw.WriteHeader(http.StatusEarlyHints)
+	expect(t, rw.Written(), false)
+	expect(t, calls, 0)
+
+	rw.WriteHeader(http.StatusOK)
+	expect(t, rw.Written(), true)
+	expect(t, rw.Status(), http.StatusOK)
+	expect(t, calls, 1)
+}
+
 func TestResponseWriterHijack(t *testing.T) {
 	hijackable := newHijackableResponse()
 	rw := NewResponseWriter(hijackable)
@@ -115,6 +182,7 @@ func TestResponseWriterHijack(t *testing.T) {
 		t.Error(err)
 	}
 	expect(t, hijackable.Hijacked, true)
+	expect(t, rw.Written(), true)
 }
 
 func TestResponseWriteHijackNotOK(t *testing.T) {
@@ -125,6 +193,7 @@ func TestResponseWriteHijackNotOK(t *testing.T) {
 	_, _, err := hijacker.Hijack()
 
 	refute(t, err, nil)
+	expect(t, rw.Written(), false)
 }
 
 func TestResponseWriterCloseNotify(t *testing.T) {
@@ -148,3 +217,320 @@ func TestResponseWriterFlusher(t *testing.T) {
 	_, ok := rw.(http.Flusher)
 	expect(t, ok, true)
 }
+
+func TestResponseWriterFlushPassthrough(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+	result := ""
+
+	rw.Before(func(ResponseWriter) {
+		result += "foo"
+	})
+	rw.Flush()
+
+	expect(t, rec.Flushed, true)
+	expect(t, rw.Written(), true)
+	expect(t, rw.Status(), http.StatusOK)
+	expect(t, result, "foo")
+
+	rw.Write([]byte("data: hello\n\n"))
+	rw.Flush()
+
+	expect(t, rec.Body.String(), "data: hello\n\n")
+	expect(t, result, "foo")
+}
+
+func TestResponseWriterPush(t *testing.T) {
+	pushable := &pushableResponse{ResponseRecorder: httptest.NewRecorder()}
+	rw := NewResponseWriter(pushable)
+
+	pusher, ok := rw.(http.Pusher)
+	expect(t, ok, true)
+	if err := pusher.Push("/app.css", nil); err != nil {
+		t.Error(err)
+	}
+	if err := Push(rw, "/app.js", nil); err != nil {
+		t.Error(err)
+	}
+	expect(t, len(pushable.pushed), 2)
+	expect(t, pushable.pushed[1], "/app.js")
+}
+
+func TestResponseWriterPushNotSupported(t *testing.T) {
+	rw := NewResponseWriter(httptest.NewRecorder())
+
+	err := rw.(http.Pusher).Push("/app.css", nil)
+	expect(t, err, http.ErrNotSupported)
+	expect(t, Push(rw, "/app.css", nil), nil)
+	expect(t, Push(httptest.NewRecorder(), "/app.css", nil), nil)
+}
+
+func TestResponseWriterReadFrom(t *testing.T) {
+	rec := &readerFromResponse{ResponseRecorder: httptest.NewRecorder()}
+	rw := NewResponseWriter(rec)
+
+	n, err := io.Copy(rw, io.LimitReader(strings.NewReader("Hello world"), 1024))
+	if err != nil {
+		t.Error(err)
+	}
+
+	expect(t, n, int64(11))
+	expect(t, rec.readFrom, true)
+	expect(t, rec.Body.String(), "Hello world")
+	expect(t, rw.Status(), http.StatusOK)
+	expect(t, rw.Size(), 11)
+}
+
+func TestResponseWriterReadFromFallback(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+	rw.WriteHeader(http.StatusCreated)
+
+	_, ok := rw.(io.ReaderFrom)
+	expect(t, ok, true)
+	if _, err := io.Copy(rw, strings.NewReader("Hello world")); err != nil {
+		t.Error(err)
+	}
+
+	expect(t, rec.Code, http.StatusCreated)
+	expect(t, rec.Body.String(), "Hello world")
+	expect(t, rw.Size(), 11)
+}
+
+func TestResponseWriterSize(t *testing.T) {
+	rec := &readerFromResponse{ResponseRecorder: httptest.NewRecorder()}
+	rw := NewResponseWriter(rec)
+	expect(t, rw.Size(), 0)
+
+	rw.Header().Set("X-Header", "headers are not counted")
+	rw.Write([]byte("Hello "))
+	io.Copy(rw, io.LimitReader(strings.NewReader("world"), 1024))
+
+	expect(t, rw.Size(), 11)
+	expect(t, rec.Body.Len(), rw.Size())
+}
+
+func TestResponseWriterCapture(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+
+	body, truncated := rw.Captured()
+	expect(t, len(body), 0)
+	expect(t, truncated, false)
+
+	rw.Write([]byte("not captured "))
+	rw.Capture(11)
+	rw.Write([]byte("Hello "))
+	io.Copy(rw, io.LimitReader(strings.NewReader("world"), 1024))
+
+	body, truncated = rw.Captured()
+	expect(t, string(body), "Hello world")
+	expect(t, truncated, false)
+	expect(t, rec.Body.String(), "not captured Hello world")
+}
+
+func TestResponseWriterCaptureTruncated(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+
+	rw.Capture(5)
+	rw.Write([]byte("Hello world"))
+	rw.Write([]byte("foo"))
+
+	body, truncated := rw.Captured()
+	expect(t, string(body), "Hello")
+	expect(t, truncated, true)
+	expect(t, rw.Size(), 14)
+}
+
+func TestResponseWriterWrittenHeader(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+
+	rw.Header().Set("Content-Type", "text/plain")
+	rw.Before(func(rw ResponseWriter) {
+		rw.Header().Set("X-Before", "set")
+	})
+	expect(t, rw.WrittenHeader() == nil, true)
+
+	rw.Write([]byte("Hello world"))
+	rw.Header().Set("X-After", "set")
+
+	snapshot := rw.WrittenHeader()
+	expect(t, snapshot.Get("Content-Type"), "text/plain")
+	expect(t, snapshot.Get("X-Before"), "set")
+	expect(t, snapshot.Get("X-After"), "")
+}
+
+func TestResponseWriterPool(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := acquireResponseWriter(rec, nil)
+	rw.Before(func(ResponseWriter) {})
+	rw.Capture(10)
+	rw.Write([]byte("Hello world"))
+	releaseResponseWriter(rw)
+
+	expect(t, rw.ResponseWriter, nil)
+	expect(t, rw.Written(), false)
+	expect(t, rw.Size(), 0)
+	expect(t, len(rw.beforeFuncs), 0)
+	expect(t, rw.capture == nil, true)
+	expect(t, rw.WrittenHeader() == nil, true)
+}
+
+// benchmarkResponseWriter makes the benchmarked writers escape, like they do when serving.
+var benchmarkResponseWriter ResponseWriter
+
+func BenchmarkNewResponseWriter(b *testing.B) {
+	rec := httptest.NewRecorder()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rw := NewResponseWriter(rec)
+		rw.Before(func(ResponseWriter) {})
+		benchmarkResponseWriter = rw
+	}
+}
+
+func BenchmarkPooledResponseWriter(b *testing.B) {
+	rec := httptest.NewRecorder()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rw := acquireResponseWriter(rec, nil)
+		rw.Before(func(ResponseWriter) {})
+		benchmarkResponseWriter = rw
+		releaseResponseWriter(rw)
+	}
+}
+
+func TestResponseWriterFirstByteTime(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+	expect(t, rw.FirstByteTime().IsZero(), true)
+
+	before := time.Now()
+	rw.Write([]byte("Hello"))
+	first := rw.FirstByteTime()
+	expect(t, first.Before(before), false)
+
+	time.Sleep(time.Millisecond)
+	rw.Write([]byte(" world"))
+	expect(t, rw.FirstByteTime(), first)
+}
+
+func TestResponseWriterFirstByteClock(t *testing.T) {
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+	n := New()
+	n.Clock = clock
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		next(ctx, rw, r)
+		expect(t, rw.(ResponseWriter).FirstByteTime(), time.Date(2015, 6, 1, 12, 0, 1, 0, time.UTC))
+	})
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		clock.Advance(time.Second)
+		rw.Write([]byte("Hello"))
+		clock.Advance(time.Second)
+	})
+
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	n.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestResponseWriterUnwrap(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rw := NewResponseWriter(rec)
+
+	unwrapper, ok := rw.(interface{ Unwrap() http.ResponseWriter })
+	expect(t, ok, true)
+	expect(t, unwrapper.Unwrap(), http.ResponseWriter(rec))
+}
+
+func TestResponseWriterResponseController(t *testing.T) {
+	errs := make(chan error, 3)
+
+	n := New()
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(rw)
+		errs <- rc.SetReadDeadline(time.Now().Add(time.Minute))
+		errs <- rc.SetWriteDeadline(time.Now().Add(time.Minute))
+		rw.Write([]byte("Hello world"))
+		errs <- rc.Flush()
+	})
+	server := httptest.NewServer(n)
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestResponseWriterTrailers(t *testing.T) {
+	n := New()
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		next(ctx, rw, r)
+		AddTrailer(rw, "Server-Timing", "app;dur=1")
+	})
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		DeclareTrailer(rw, "x-checksum")
+		rw.Write([]byte("Hello world"))
+		rw.(http.Flusher).Flush()
+		rw.Header().Set("X-Checksum", "abc")
+		DeclareTrailer(rw, "X-Too-Late")
+	})
+	server := httptest.NewServer(n)
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	expect(t, string(body), "Hello world")
+	expect(t, res.Header.Get("Trailer"), "")
+	expect(t, res.Trailer.Get("X-Checksum"), "abc")
+	expect(t, res.Trailer.Get("Server-Timing"), "app;dur=1")
+	expect(t, res.Trailer.Get("X-Too-Late"), "")
+}
+
+func TestResponseWriterSetWriteDeadline(t *testing.T) {
+	errs := make(chan error, 1)
+
+	n := New()
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		errs <- ExtendWriteDeadline(rw, time.Second)
+		rw.Write([]byte("Hello "))
+		rw.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		rw.Write([]byte("world"))
+	})
+	server := httptest.NewUnstartedServer(n)
+	server.Config.WriteTimeout = 20 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	expect(t, <-errs, nil)
+	expect(t, err, nil)
+	expect(t, string(body), "Hello world")
+}
+
+func TestResponseWriterSetWriteDeadlineNotSupported(t *testing.T) {
+	rw := NewResponseWriter(httptest.NewRecorder())
+	expect(t, errors.Is(rw.SetWriteDeadline(time.Now()), http.ErrNotSupported), true)
+	expect(t, errors.Is(ExtendWriteDeadline(rw, time.Second), http.ErrNotSupported), true)
+}
@@ -0,0 +1,172 @@
+package camillo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/context"
+)
+
+// ErrBodyNotRewindable is returned by RewindBody for request bodies that were not buffered
+// by RewindableBody.
+var ErrBodyNotRewindable = errors.New("camillo: request body is not rewindable")
+
+// RewindableBody is a middleware handler that buffers request bodies so they can be read
+// more than once, for example by signature verification, request validation and the final
+// handler. Bodies are kept in memory up to MemoryLimit and spooled to a temporary file
+// beyond it. Every reader calls RewindBody before reading the body.
+type RewindableBody struct {
+	// MemoryLimit is the largest body that is kept in memory.
+	MemoryLimit int64
+	// MaxSize is the largest body that is accepted. Larger bodies are rejected with
+	// 413 Request Entity Too Large. Zero means no limit.
+	MaxSize int64
+	// TempDir is the directory of the temporary files. The default temporary directory
+	// is used when it is empty.
+	TempDir string
+}
+
+// NewRewindableBody returns a new instance of RewindableBody
+func NewRewindableBody() *RewindableBody {
+	return &RewindableBody{
+		MemoryLimit: 1024 * 1024,
+		MaxSize:     1024 * 1024 * 64,
+	}
+}
+
+func (rb *RewindableBody) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	if r.Body == nil || r.Body == http.NoBody {
+		next(ctx, rw, r)
+		return
+	}
+
+	body, err := rb.spool(r.Body)
+	r.Body.Close()
+	if body != nil {
+		defer body.remove()
+	}
+	if err == errBodyTooLarge {
+		http.Error(rw, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var readErr *bodyReadError
+	if errors.As(err, &readErr) {
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		LogError(ctx, fmt.Errorf("camillo: spooling the request body: %w", err))
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	r.Body = body
+	r.ContentLength = body.size
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(body.section()), nil
+	}
+	next(ctx, rw, r)
+}
+
+var errBodyTooLarge = errors.New("camillo: request body too large")
+
+// bodyReadError is an error reading the body sent by the client, rather than spooling it.
+type bodyReadError struct {
+	err error
+}
+
+func (e *bodyReadError) Error() string { return e.err.Error() }
+func (e *bodyReadError) Unwrap() error { return e.err }
+
+// bodyReader wraps the errors of r in bodyReadError.
+type bodyReader struct {
+	r io.Reader
+}
+
+func (b bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = &bodyReadError{err}
+	}
+	return n, err
+}
+
+func (rb *RewindableBody) spool(src io.Reader) (*rewindableBody, error) {
+	src = bodyReader{src}
+	if rb.MaxSize > 0 {
+		src = io.LimitReader(src, rb.MaxSize+1)
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(src, rb.MemoryLimit+1))
+	if err != nil {
+		return nil, err
+	}
+	if rb.MaxSize > 0 && n > rb.MaxSize {
+		return nil, errBodyTooLarge
+	}
+	if n <= rb.MemoryLimit {
+		return newRewindableBody(bytes.NewReader(buf.Bytes()), nil, n), nil
+	}
+
+	file, err := ioutil.TempFile(rb.TempDir, "camillo-body-")
+	if err != nil {
+		return nil, err
+	}
+	body := newRewindableBody(file, file, 0)
+	if body.size, err = io.Copy(file, io.MultiReader(&buf, src)); err != nil {
+		return body, err
+	}
+	if rb.MaxSize > 0 && body.size > rb.MaxSize {
+		return body, errBodyTooLarge
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	return body, err
+}
+
+// rewindableBody is a request body that can be read again after seeking to its start.
+type rewindableBody struct {
+	io.ReadSeeker
+	at   io.ReaderAt
+	file *os.File
+	size int64
+}
+
+func newRewindableBody(r interface {
+	io.ReadSeeker
+	io.ReaderAt
+}, file *os.File, size int64) *rewindableBody {
+	return &rewindableBody{ReadSeeker: r, at: r, file: file, size: size}
+}
+
+// Close does nothing, the body stays readable until the request has been served.
+func (b *rewindableBody) Close() error {
+	return nil
+}
+
+func (b *rewindableBody) section() io.Reader {
+	return io.NewSectionReader(b.at, 0, b.size)
+}
+
+func (b *rewindableBody) remove() {
+	if b.file != nil {
+		b.file.Close()
+		os.Remove(b.file.Name())
+	}
+}
+
+// RewindBody resets the body of r to its start so it can be read again. It returns
+// ErrBodyNotRewindable when the body was not buffered by RewindableBody.
+func RewindBody(r *http.Request) error {
+	body, ok := r.Body.(*rewindableBody)
+	if !ok {
+		return ErrBodyNotRewindable
+	}
+	_, err := body.Seek(0, io.SeekStart)
+	return err
+}
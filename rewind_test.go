@@ -0,0 +1,117 @@
+package camillo
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"golang.org/x/net/context"
+)
+
+func serveRewindable(t *testing.T, rb *RewindableBody, body string) (*httptest.ResponseRecorder, []string) {
+	var reads []string
+	read := func(r *http.Request) {
+		expect(t, RewindBody(r), nil)
+		b, err := ioutil.ReadAll(r.Body)
+		expect(t, err, nil)
+		reads = append(reads, string(b))
+	}
+
+	n := New(rb)
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		read(r)
+		r.Body.Close()
+		next(ctx, rw, r)
+	})
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		read(r)
+		expect(t, r.ContentLength, int64(len(body)))
+		getBody, err := r.GetBody()
+		expect(t, err, nil)
+		b, _ := ioutil.ReadAll(getBody)
+		reads = append(reads, string(b))
+	})
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "http://localhost:3000/", ioutil.NopCloser(strings.NewReader(body)))
+	n.ServeHTTP(recorder, req)
+	return recorder, reads
+}
+
+func TestRewindableBodyMemory(t *testing.T) {
+	recorder, reads := serveRewindable(t, NewRewindableBody(), "hello world")
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, len(reads), 3)
+	for _, read := range reads {
+		expect(t, read, "hello world")
+	}
+}
+
+func TestRewindableBodyTempFile(t *testing.T) {
+	rb := NewRewindableBody()
+	rb.MemoryLimit = 4
+	rb.TempDir = t.TempDir()
+
+	recorder, reads := serveRewindable(t, rb, "hello world")
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, len(reads), 3)
+	for _, read := range reads {
+		expect(t, read, "hello world")
+	}
+
+	files, _ := filepath.Glob(filepath.Join(rb.TempDir, "*"))
+	expect(t, len(files), 0)
+}
+
+func TestRewindableBodyTooLarge(t *testing.T) {
+	for _, memoryLimit := range []int64{4, 1024} {
+		rb := NewRewindableBody()
+		rb.MemoryLimit = memoryLimit
+		rb.MaxSize = 8
+		rb.TempDir = t.TempDir()
+
+		recorder, reads := serveRewindable(t, rb, "hello world")
+		expect(t, recorder.Code, http.StatusRequestEntityTooLarge)
+		expect(t, len(reads), 0)
+
+		files, _ := filepath.Glob(filepath.Join(rb.TempDir, "*"))
+		expect(t, len(files), 0)
+	}
+}
+
+func TestRewindableBodyErrors(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	l := NewLogger()
+	l.Logger = log.New(buff, "", 0)
+	l.Format = LoggerFormatJSON
+	rb := NewRewindableBody()
+	rb.MemoryLimit = 4
+	n := New(l, rb)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {})
+	serve := func(body io.Reader) int {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "http://localhost:3000/", ioutil.NopCloser(body))
+		n.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	// the client failing to send its body
+	expect(t, serve(io.MultiReader(strings.NewReader("hello"), iotest.ErrReader(io.ErrUnexpectedEOF))), http.StatusBadRequest)
+
+	// the server failing to spool it
+	rb.TempDir = filepath.Join(t.TempDir(), "missing")
+	expect(t, serve(strings.NewReader("hello world")), http.StatusInternalServerError)
+	expect(t, strings.Contains(buff.String(), "spooling the request body"), true)
+}
+
+func TestRewindBodyNotRewindable(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://localhost:3000/", strings.NewReader("hello"))
+	expect(t, RewindBody(req), ErrBodyNotRewindable)
+}
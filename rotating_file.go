@@ -0,0 +1,246 @@
+package camillo
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp of the name of a rotated log file.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is an io.WriteCloser appending to a log file that is rotated when it grows
+// beyond MaxSize. Rotated files are renamed with a timestamp, such as
+// access-2015-06-01T12-00-00.000.log, optionally compressed, and removed when they are
+// older than MaxAge or beyond the MaxBackups most recent ones. It is used as the output
+// of a log.Logger for the Logger and Recovery middleware:
+//
+//	l.Logger = log.New(camillo.NewRotatingFile("/var/log/app/access.log"), "", 0)
+//
+// Rotation happens while writing, wrap the sink in an AsyncLogSink to keep it off the
+// request path.
+type RotatingFile struct {
+	// Filename is the path of the log file.
+	Filename string
+	// MaxSize is the size in bytes from which the file is rotated. Zero never rotates.
+	MaxSize int64
+	// MaxAge is the age from which rotated files are removed. Zero keeps them.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files that are kept. Zero keeps all of them.
+	MaxBackups int
+	// Compress compresses rotated files with gzip.
+	Compress bool
+	// Clock timestamps the rotated files.
+	Clock Clock
+
+	mtx  sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile returns a new RotatingFile writing to filename, rotated every 100MB and
+// keeping 10 backups
+func NewRotatingFile(filename string) *RotatingFile {
+	return &RotatingFile{
+		Filename:   filename,
+		MaxSize:    1024 * 1024 * 100,
+		MaxBackups: 10,
+		Clock:      SystemClock,
+	}
+}
+
+// Write appends p to the file, rotating it first when p would grow it beyond MaxSize.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the file regardless of its size, for example on SIGHUP.
+func (f *RotatingFile) Rotate() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.rotate()
+}
+
+// Close closes the file. It is reopened by the next Write.
+func (f *RotatingFile) Close() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.Filename), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.Filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *RotatingFile) rotate() error {
+	if f.file != nil {
+		if err := f.file.Close(); err != nil {
+			return err
+		}
+		f.file = nil
+	}
+
+	backup := f.backupName(clockNow(f.Clock))
+	if err := os.Rename(f.Filename, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if f.Compress {
+		if err := compressFile(backup); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.removeBackups()
+}
+
+// backupName returns the name of the file rotated at t. The files rotated in the same
+// millisecond get a counter, so they don't replace each other.
+func (f *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(f.Filename)
+	base := strings.TrimSuffix(f.Filename, ext) + "-" + t.UTC().Format(backupTimeFormat)
+	name := base + ext
+	for i := 1; fileExists(name) || fileExists(name+".gz"); i++ {
+		name = base + "-" + strconv.Itoa(i) + ext
+	}
+	return name
+}
+
+func fileExists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+// removeBackups removes the rotated files beyond MaxBackups and older than MaxAge.
+func (f *RotatingFile) removeBackups() error {
+	if f.MaxBackups <= 0 && f.MaxAge <= 0 {
+		return nil
+	}
+
+	ext := filepath.Ext(f.Filename)
+	prefix := strings.TrimSuffix(filepath.Base(f.Filename), ext) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.Filename))
+	if err != nil {
+		return err
+	}
+
+	type backup struct {
+		name  string
+		time  time.Time
+		count int
+	}
+	var backups []backup
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext)
+		if entry.IsDir() || !strings.HasPrefix(stamp, prefix) {
+			continue
+		}
+		stamp = stamp[len(prefix):]
+		if len(stamp) < len(backupTimeFormat) {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, stamp[:len(backupTimeFormat)])
+		if err != nil {
+			continue
+		}
+		count := 0
+		if counter := stamp[len(backupTimeFormat):]; counter != "" {
+			if !strings.HasPrefix(counter, "-") {
+				continue
+			}
+			if count, err = strconv.Atoi(counter[1:]); err != nil {
+				continue
+			}
+		}
+		backups = append(backups, backup{name: name, time: t, count: count})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].time.Equal(backups[j].time) {
+			return backups[i].time.After(backups[j].time)
+		}
+		return backups[i].count > backups[j].count
+	})
+
+	cutoff := clockNow(f.Clock).Add(-f.MaxAge)
+	for i, b := range backups {
+		if (f.MaxBackups > 0 && i >= f.MaxBackups) || (f.MaxAge > 0 && b.time.Before(cutoff)) {
+			if err := os.Remove(filepath.Join(filepath.Dir(f.Filename), b.name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compressFile replaces name with a gzip compressed name.gz.
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
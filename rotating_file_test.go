@@ -0,0 +1,118 @@
+package camillo
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	f := NewRotatingFile(filepath.Join(dir, "access.log"))
+	f.Clock = clock
+	f.MaxSize = 10
+	f.MaxBackups = 2
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Minute)
+	}
+
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "access.log"))
+	expect(t, string(b), "fourth\n")
+	b, _ = ioutil.ReadFile(filepath.Join(dir, "access-2015-06-01T12-03-00.000.log"))
+	expect(t, string(b), "third\n")
+	expect(t, rotatedFiles(t, dir), "access-2015-06-01T12-02-00.000.log access-2015-06-01T12-03-00.000.log access.log")
+}
+
+func TestRotatingFileSameTime(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	f := NewRotatingFile(filepath.Join(dir, "access.log"))
+	f.Clock = clock
+	f.MaxSize = 1
+	f.MaxBackups = 2
+	defer f.Close()
+
+	// the rotations of the same millisecond keep every file
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect(t, rotatedFiles(t, dir), "access-2015-06-01T12-00-00.000-1.log access-2015-06-01T12-00-00.000-2.log access.log")
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "access-2015-06-01T12-00-00.000-2.log"))
+	expect(t, string(b), "third\n")
+}
+
+func TestRotatingFileMaxAgeCompress(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	f := NewRotatingFile(filepath.Join(dir, "app.log"))
+	f.Clock = clock
+	f.MaxAge = time.Hour
+	f.MaxBackups = 0
+	f.Compress = true
+	defer f.Close()
+
+	f.Write([]byte("old\n"))
+	expect(t, f.Rotate(), nil)
+	clock.Advance(2 * time.Hour)
+	f.Write([]byte("new\n"))
+	expect(t, f.Rotate(), nil)
+
+	expect(t, rotatedFiles(t, dir), "app-2015-06-01T14-00-00.000.log.gz app.log")
+
+	gz, err := os.Open(filepath.Join(dir, "app-2015-06-01T14-00-00.000.log.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gz.Close()
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(zr)
+	expect(t, string(b), "new\n")
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "app.log")
+	ioutil.WriteFile(name, []byte("existing\n"), 0644)
+
+	f := NewRotatingFile(name)
+	f.MaxSize = 32
+	f.Write([]byte("appended\n"))
+	f.Close()
+
+	f.Write([]byte("reopened\n"))
+	f.Close()
+	b, _ := ioutil.ReadFile(name)
+	expect(t, string(b), "existing\nappended\nreopened\n")
+}
+
+func rotatedFiles(t *testing.T, dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
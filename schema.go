@@ -0,0 +1,322 @@
+package camillo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"golang.org/x/net/context"
+)
+
+// SchemaValidator is a development middleware handler that validates JSON responses
+// against the schemas declared for their routes and logs the violations. It is meant
+// to catch contract drift before clients do; when it is disabled requests are passed
+// on without any extra work.
+type SchemaValidator struct {
+	// Enabled turns validation on. It defaults to true when CAMILLO_ENV is "development".
+	Enabled bool
+	// Logger is used to log schema violations
+	Logger LogSink
+	// MaxBodySize is the maximum size of the responses that are validated.
+	MaxBodySize int
+
+	routes []schemaRoute
+}
+
+type schemaRoute struct {
+	method  string
+	pattern string
+	schema  *Schema
+}
+
+// NewSchemaValidator returns a new instance of SchemaValidator
+func NewSchemaValidator() *SchemaValidator {
+	return &SchemaValidator{
+		Enabled:     os.Getenv("CAMILLO_ENV") == "development",
+		Logger:      log.New(os.Stdout, "[camillo] ", 0),
+		MaxBodySize: 1024 * 1024,
+	}
+}
+
+// Handle declares the schema of the 2xx responses for the requests matching method
+// and pattern. The pattern uses path.Match syntax and an empty method matches any method.
+func (v *SchemaValidator) Handle(method, pattern string, schema []byte) error {
+	if _, err := path.Match(pattern, "/"); err != nil {
+		return err
+	}
+	s, err := ParseSchema(schema)
+	if err != nil {
+		return err
+	}
+	v.routes = append(v.routes, schemaRoute{method, pattern, s})
+	return nil
+}
+
+func (v *SchemaValidator) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	if !v.Enabled {
+		next(ctx, rw, r)
+		return
+	}
+
+	schema := v.match(r)
+	res, ok := rw.(ResponseWriter)
+	if schema == nil || !ok {
+		next(ctx, rw, r)
+		return
+	}
+
+	res.Capture(v.MaxBodySize)
+	next(ctx, rw, r)
+
+	if res.Status() < 200 || res.Status() >= 300 {
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(res.Header().Get("Content-Type")); mediaType != "application/json" {
+		logf(v.Logger, LogLevelWarn, "schema violation: %s %s %d: expected application/json response, got %q", r.Method, r.URL.Path, res.Status(), mediaType)
+		return
+	}
+
+	body, truncated := res.Captured()
+	if truncated {
+		logf(v.Logger, LogLevelInfo, "schema validation skipped: %s %s %d: response is larger than %d bytes", r.Method, r.URL.Path, res.Status(), v.MaxBodySize)
+		return
+	}
+
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		logf(v.Logger, LogLevelWarn, "schema violation: %s %s %d: invalid JSON: %s", r.Method, r.URL.Path, res.Status(), err)
+		return
+	}
+
+	for _, violation := range schema.Validate(doc) {
+		logf(v.Logger, LogLevelWarn, "schema violation: %s %s %d: %s", r.Method, r.URL.Path, res.Status(), violation)
+	}
+}
+
+func (v *SchemaValidator) match(r *http.Request) *Schema {
+	for _, route := range v.routes {
+		if route.method != "" && route.method != r.Method {
+			continue
+		}
+		if ok, _ := path.Match(route.pattern, r.URL.Path); ok {
+			return route.schema
+		}
+	}
+	return nil
+}
+
+// Schema is a JSON Schema. The validation keywords type, enum, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength, minimum and
+// maximum are supported, which covers the schema objects used by OpenAPI.
+type Schema struct {
+	Type                 schemaTypes        `json:"type"`
+	Enum                 []interface{}      `json:"enum"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	Nullable             bool               `json:"nullable"`
+}
+
+// schemaTypes accepts both a single type name and a list of type names.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*t = schemaTypes{name}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return fmt.Errorf("schema type must be a string or a list of strings")
+	}
+	*t = names
+	return nil
+}
+
+// ParseSchema parses a JSON Schema document.
+func ParseSchema(b []byte) (*Schema, error) {
+	var s Schema
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Validate validates a decoded JSON document and returns the violations found. Numbers
+// in doc may be float64 or json.Number values.
+func (s *Schema) Validate(doc interface{}) []string {
+	var violations []string
+	s.validate("$", doc, &violations)
+	return violations
+}
+
+func (s *Schema) validate(at string, doc interface{}, violations *[]string) {
+	report := func(format string, args ...interface{}) {
+		*violations = append(*violations, at+": "+fmt.Sprintf(format, args...))
+	}
+
+	if doc == nil && s.Nullable {
+		return
+	}
+
+	kind := jsonType(doc)
+	if len(s.Type) > 0 && !s.allowsType(kind, doc) {
+		report("expected %s, got %s", joinTypes(s.Type), kind)
+		return
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, doc) {
+		report("value is not one of the allowed values")
+	}
+
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				report("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					report("unexpected property %q", name)
+				}
+				continue
+			}
+			prop.validate(at+"."+name, v[name], violations)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			report("expected at least %d items, got %d", *s.MinItems, len(v))
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			report("expected at most %d items, got %d", *s.MaxItems, len(v))
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(at+"["+strconv.Itoa(i)+"]", item, violations)
+			}
+		}
+	case string:
+		n := len([]rune(v))
+		if s.MinLength != nil && n < *s.MinLength {
+			report("expected at least %d characters, got %d", *s.MinLength, n)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			report("expected at most %d characters, got %d", *s.MaxLength, n)
+		}
+	case json.Number, float64:
+		f, _ := jsonFloat(v)
+		if s.Minimum != nil && f < *s.Minimum {
+			report("expected a minimum of %v, got %v", *s.Minimum, f)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			report("expected a maximum of %v, got %v", *s.Maximum, f)
+		}
+	}
+}
+
+func (s *Schema) allowsType(kind string, doc interface{}) bool {
+	for _, t := range s.Type {
+		if t == kind || (t == "number" && kind == "integer") {
+			return true
+		}
+		if t == "integer" && kind == "number" {
+			if f, ok := jsonFloat(doc); ok && f == float64(int64(f)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func jsonType(doc interface{}) string {
+	switch v := doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		return "number"
+	}
+	return fmt.Sprintf("%T", doc)
+}
+
+func jsonFloat(doc interface{}) (float64, bool) {
+	switch v := doc.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func inEnum(enum []interface{}, doc interface{}) bool {
+	for _, allowed := range enum {
+		a, aok := jsonFloat(allowed)
+		d, dok := jsonFloat(doc)
+		if aok && dok {
+			if a == d {
+				return true
+			}
+			continue
+		}
+		if reflect.DeepEqual(allowed, doc) {
+			return true
+		}
+	}
+	return false
+}
+
+func joinTypes(types []string) string {
+	if len(types) == 1 {
+		return types[0]
+	}
+	b := bytes.NewBufferString("one of")
+	for i, t := range types {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(" " + t)
+	}
+	return b.String()
+}
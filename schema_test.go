@@ -0,0 +1,148 @@
+package camillo
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const userSchema = `{
+	"type": "object",
+	"required": ["id", "name"],
+	"additionalProperties": false,
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"name": {"type": "string", "minLength": 1},
+		"role": {"enum": ["admin", "user"]},
+		"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}},
+		"manager": {"type": "object", "nullable": true}
+	}
+}`
+
+func newSchemaValidatorTest(t *testing.T, body string) (*bytes.Buffer, *httptest.ResponseRecorder) {
+	buff := bytes.NewBufferString("")
+	recorder := httptest.NewRecorder()
+
+	v := NewSchemaValidator()
+	v.Enabled = true
+	v.Logger = log.New(buff, "[camillo] ", 0)
+	if err := v.Handle("GET", "/users/*", []byte(userSchema)); err != nil {
+		t.Fatal(err)
+	}
+
+	n := New(v)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		rw.Write([]byte(body))
+	})
+
+	req, err := http.NewRequest("GET", "http://localhost:3000/users/1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	n.ServeHTTP(recorder, req)
+	return buff, recorder
+}
+
+func TestSchemaValidatorValid(t *testing.T) {
+	buff, recorder := newSchemaValidatorTest(t, `{"id": 1, "name": "bob", "role": "admin", "tags": ["a"], "manager": null}`)
+
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, buff.String(), "")
+}
+
+func TestSchemaValidatorViolations(t *testing.T) {
+	buff, recorder := newSchemaValidatorTest(t, `{"id": 0, "role": "root", "tags": ["a", 1, "c"], "extra": true}`)
+
+	// the response itself is never altered
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, strings.Contains(recorder.Body.String(), `"extra"`), true)
+
+	for _, violation := range []string{
+		`$: missing required property "name"`,
+		`$: unexpected property "extra"`,
+		`$.id: expected a minimum of 1, got 0`,
+		`$.role: value is not one of the allowed values`,
+		`$.tags: expected at most 2 items, got 3`,
+		`$.tags[1]: expected string, got integer`,
+	} {
+		if !strings.Contains(buff.String(), "schema violation: GET /users/1 200: "+violation) {
+			t.Errorf("Expected violation %q in %q", violation, buff.String())
+		}
+	}
+}
+
+func TestSchemaValidatorInvalidJSON(t *testing.T) {
+	buff, _ := newSchemaValidatorTest(t, `{"id": `)
+	expect(t, strings.Contains(buff.String(), "invalid JSON"), true)
+}
+
+func TestSchemaValidatorLogLevels(t *testing.T) {
+	var lines leveledRecorder
+	v := NewSchemaValidator()
+	v.Enabled = true
+	v.MaxBodySize = 16
+	v.Logger = &lines
+	v.Handle("GET", "/users/*", []byte(userSchema))
+
+	n := New(v)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/users/large" {
+			rw.Write([]byte(`{"id": 1, "name": "a very long name"}`))
+			return
+		}
+		rw.Write([]byte(`{"id": 0}`))
+	})
+	for _, path := range []string{"/users/1", "/users/large"} {
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expect(t, len(lines), 3)
+	expect(t, strings.HasPrefix(lines[0], "warn schema violation: GET /users/1 200"), true)
+	expect(t, strings.HasPrefix(lines[1], "warn schema violation: GET /users/1 200"), true)
+	expect(t, lines[2], "info schema validation skipped: GET /users/large 200: response is larger than 16 bytes")
+}
+
+func TestSchemaValidatorDisabled(t *testing.T) {
+	buff := bytes.NewBufferString("")
+	recorder := httptest.NewRecorder()
+
+	v := NewSchemaValidator()
+	v.Enabled = false
+	v.Logger = log.New(buff, "[camillo] ", 0)
+	v.Handle("", "/*", []byte(`{"type": "object"}`))
+
+	n := New(v)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(`[]`))
+	})
+	req, err := http.NewRequest("GET", "http://localhost:3000/users", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	n.ServeHTTP(recorder, req)
+
+	expect(t, buff.String(), "")
+}
+
+func TestSchemaValidate(t *testing.T) {
+	s, err := ParseSchema([]byte(`{"type": ["integer", "null"], "maximum": 10}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, len(s.Validate(nil)), 0)
+	expect(t, len(s.Validate(float64(3))), 0)
+	expect(t, len(s.Validate(json.Number("2.0"))), 0)
+	expect(t, len(s.Validate(json.Number("11"))), 1)
+	expect(t, s.Validate("3")[0], "$: expected one of integer, null, got string")
+
+	_, err = ParseSchema([]byte(`{"type": 1}`))
+	refute(t, err, nil)
+}
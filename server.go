@@ -0,0 +1,355 @@
+package camillo
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Run runs the camillo stack as an HTTP server on addr, which takes the same format as
+// http.ListenAndServe, until the process receives SIGINT or SIGTERM. It then stops
+// accepting connections and waits up to ShutdownTimeout for the requests in flight to
+// complete, as orchestrators such as Kubernetes expect. It returns nil once the server
+// shut down cleanly, and the error of the server otherwise.
+func (n *Camillo) Run(addr string) error {
+	return n.RunServer(n.NewServer(addr))
+}
+
+// RunContext runs the camillo stack as an HTTP server on addr like Run, but until ctx is
+// done instead of until SIGINT or SIGTERM, for the servers embedded in larger programs
+// such as the goroutines of an errgroup. It returns nil once the server shut down cleanly,
+// and the error of the server, such as the address being in use, otherwise. To also stop
+// on the signals of Run:
+//
+//	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+//	defer stop()
+//	n.RunContext(ctx, addr)
+func (n *Camillo) RunContext(ctx context.Context, addr string) error {
+	srv := n.NewServer(addr)
+	n.logf(LogLevelInfo, "listening on %s", addr)
+	return n.run(runOptions{ctx: ctx}, []serving{{srv, srv.ListenAndServe}})
+}
+
+// NewServer returns a new http.Server serving the camillo stack on addr, with options
+// guarding against slow and abusive clients instead of the unlimited defaults of
+// net/http: 10s to read the headers, 1MB of headers and 2m for idle connections. Its
+// ErrorLog writes to Logger. The bodies have no time limit, for the long downloads and
+// the streams, and should be limited per route. Run, RunTLS and RunAutoTLS use it, and
+// RunServer runs one with other options:
+//
+//	srv := n.NewServer(":3000")
+//	srv.ReadTimeout = 30 * time.Second
+//	srv.WriteTimeout = 30 * time.Second
+//	n.RunServer(srv)
+func (n *Camillo) NewServer(addr string) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           n,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		MaxHeaderBytes:    1 << 20,
+	}
+	if n.Logger != nil {
+		srv.ErrorLog = log.New(logSinkWriter{n.Logger, LogLevelError}, "", 0)
+	}
+	return srv
+}
+
+// RunServer runs srv like Run. The camillo stack is its Handler when it has none, and it
+// serves HTTPS when its TLSConfig has certificates.
+func (n *Camillo) RunServer(srv *http.Server) error {
+	if srv.Handler == nil {
+		srv.Handler = n
+	}
+	if c := srv.TLSConfig; c != nil && (len(c.Certificates) > 0 || c.GetCertificate != nil) {
+		n.logf(LogLevelInfo, "listening on %s (TLS)", srv.Addr)
+		return n.serve(serving{srv, func() error { return srv.ListenAndServeTLS("", "") }})
+	}
+	n.logf(LogLevelInfo, "listening on %s", srv.Addr)
+	return n.serve(serving{srv, srv.ListenAndServe})
+}
+
+// Serve serves the camillo stack on l like Run, for the listeners made elsewhere, such as
+// the ephemeral ports of tests or the listeners terminating TLS. l is closed on return.
+func (n *Camillo) Serve(l net.Listener) error {
+	srv := n.NewServer(l.Addr().String())
+	n.logf(LogLevelInfo, "listening on %s", srv.Addr)
+	return n.serve(serving{srv, func() error { return srv.Serve(l) }})
+}
+
+// RunUnix runs the camillo stack like Run on the unix domain socket at path, with the
+// permissions perm, for the servers behind a reverse proxy such as nginx on the same host.
+// A socket left at path by a previous run is replaced, and the socket is removed on return.
+func (n *Camillo) RunUnix(path string, perm os.FileMode) error {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		l.Close()
+		return err
+	}
+	return n.Serve(l)
+}
+
+// RunTLS runs the camillo stack like Run, as an HTTPS server with the certificate and
+// the matching private key of the PEM files. Built with the http3 tag, it also serves
+// HTTP/3 on the UDP port of addr, advertised to the HTTPS clients with Alt-Svc.
+func (n *Camillo) RunTLS(addr, certFile, keyFile string) error {
+	return n.serve(n.tlsServers(addr, certFile, keyFile)...)
+}
+
+// RunTLSRedirect runs the camillo stack like RunTLS on httpsAddr, such as ":443", and an
+// HTTP server on httpAddr, such as ":80", redirecting the requests to HTTPS with a 301, or
+// a 308 keeping the method of the requests other than GET and HEAD. The HTTP server
+// answers the HTTP-01 challenges of the AutoTLS of the stack, when there is one. Both
+// servers stop together.
+func (n *Camillo) RunTLSRedirect(httpAddr, httpsAddr, certFile, keyFile string) error {
+	redirect := n.NewServer(httpAddr)
+	redirect.Handler = redirectHTTPS(httpsAddr, n.autoTLS())
+	n.logf(LogLevelInfo, "listening on %s (redirecting to HTTPS)", httpAddr)
+	return n.serve(append(n.tlsServers(httpsAddr, certFile, keyFile), serving{redirect, redirect.ListenAndServe})...)
+}
+
+// tlsServers returns the HTTPS server of RunTLS, and its HTTP/3 server with the http3
+// build tag.
+func (n *Camillo) tlsServers(addr, certFile, keyFile string) []serving {
+	srv := n.NewServer(addr)
+	n.logf(LogLevelInfo, "listening on %s (TLS)", addr)
+	servers := []serving{{srv, func() error { return srv.ListenAndServeTLS(certFile, keyFile) }}}
+	if newHTTP3 != nil {
+		h3, advertise := newHTTP3(srv, certFile, keyFile)
+		srv.Handler = advertise
+		n.logf(LogLevelInfo, "listening on %s (HTTP/3)", addr)
+		servers = append(servers, h3)
+	}
+	return servers
+}
+
+// redirectHTTPS returns the handler redirecting the requests to the same URL over HTTPS on
+// the port of httpsAddr, except for the HTTP-01 challenges answered by a when not nil.
+func redirectHTTPS(httpsAddr string, a *AutoTLS) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if a != nil && acmeChallenge(r) {
+			a.manager().HTTPHandler(nil).ServeHTTP(rw, r)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		status := http.StatusMovedPermanently
+		if r.Method != "GET" && r.Method != "HEAD" {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(rw, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
+
+// newHTTP3 returns the HTTP/3 server serving the handler of srv next to it, and the handler
+// of srv advertising it. It is nil without the http3 build tag.
+var newHTTP3 func(srv *http.Server, certFile, keyFile string) (serving, http.Handler)
+
+// RunAutoTLS runs the camillo stack like Run, as an HTTPS server on :443 with certificates
+// obtained from Let's Encrypt for domains. The HTTP server on :80 answers the HTTP-01
+// challenges and redirects the other requests to HTTPS like RunTLSRedirect. The AutoTLS
+// of the stack is used when there is one, for its CacheDir and Email, and
+// NewAutoTLS(domains...) otherwise.
+func (n *Camillo) RunAutoTLS(domains ...string) error {
+	a := n.autoTLS()
+	if a == nil {
+		a = NewAutoTLS(domains...)
+	}
+	https := n.NewServer(":443")
+	https.TLSConfig = a.TLSConfig()
+	redirect := n.NewServer(":80")
+	redirect.Handler = redirectHTTPS(":443", a)
+	n.logf(LogLevelInfo, "listening on :443 (TLS) for %v and on :80", a.Domains)
+	return n.serve(
+		serving{https, func() error { return https.ListenAndServeTLS("", "") }},
+		serving{redirect, redirect.ListenAndServe},
+	)
+}
+
+// autoTLS returns the AutoTLS of the stack, or nil.
+func (n *Camillo) autoTLS() *AutoTLS {
+	for _, h := range n.Handlers() {
+		if a, ok := h.(*AutoTLS); ok {
+			return a
+		}
+	}
+	return nil
+}
+
+// server is a server run by serve: an http.Server, or the HTTP/3 server of RunTLS.
+type server interface {
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// serving is a server run by serve, with the function making it serve.
+type serving struct {
+	srv   server
+	serve func() error
+}
+
+// runOptions are the options of run.
+type runOptions struct {
+	// ctx stops the servers once done when not nil, instead of SIGINT and SIGTERM.
+	ctx context.Context
+	// upgrade starts the new process of an upgrade on upgradeSignal when not nil. The
+	// servers shut down once it succeeded.
+	upgrade func() error
+	// started is called once the servers were started, when not nil.
+	started func()
+}
+
+// serve runs the servers until one of them fails or the process is signaled to stop, and
+// then shuts them all down gracefully.
+func (n *Camillo) serve(servers ...serving) error {
+	return n.run(runOptions{}, servers)
+}
+
+// run runs the servers like serve, with opts.
+func (n *Camillo) run(opts runOptions, servers []serving) error {
+	var done <-chan struct{}
+	signals := make(chan os.Signal, 1)
+	if opts.ctx != nil {
+		done = opts.ctx.Done()
+	} else {
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(signals)
+	}
+	var upgrades chan os.Signal
+	if opts.upgrade != nil && upgradeSignal != nil {
+		upgrades = make(chan os.Signal, 1)
+		signal.Notify(upgrades, upgradeSignal)
+		defer signal.Stop(upgrades)
+	}
+
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		go func(s serving) { errs <- s.serve() }(s)
+	}
+	if opts.started != nil {
+		opts.started()
+	}
+
+	var err error
+	running := len(servers)
+	// the old process of an upgrade doesn't drain, the new one serves on the same sockets
+	drain := true
+	for stopped := false; !stopped; {
+		select {
+		case err = <-errs:
+			running--
+			stopped = true
+		case sig := <-signals:
+			n.logf(LogLevelInfo, "shutting down on %s", sig)
+			stopped = true
+		case <-done:
+			n.logf(LogLevelInfo, "shutting down: %s", opts.ctx.Err())
+			stopped = true
+		case sig := <-upgrades:
+			n.logf(LogLevelInfo, "upgrading on %s", sig)
+			if upgradeErr := opts.upgrade(); upgradeErr != nil {
+				n.logf(LogLevelError, "failed to upgrade: %s", upgradeErr)
+				continue
+			}
+			n.logf(LogLevelInfo, "upgraded, shutting down")
+			stopped, drain = true, false
+		}
+	}
+	if drain {
+		n.drain.set(true)
+		defer n.drain.set(false)
+		n.logf(LogLevelInfo, "draining, %d requests in flight", n.drain.Inflight())
+		if err == nil && n.ShutdownDelay > 0 {
+			n.logf(LogLevelInfo, "draining for %s", n.ShutdownDelay)
+			select {
+			case <-time.After(n.ShutdownDelay):
+			case <-signals:
+			}
+		}
+	}
+	if shutdownErr := n.shutdown(servers); shutdownErr != nil {
+		n.logf(LogLevelWarn, "closed the connections of %d requests in flight", n.drain.Inflight())
+		if err == nil {
+			err = shutdownErr
+		}
+	}
+	for ; running > 0; running-- {
+		if serveErr := <-errs; err == nil || errors.Is(err, http.ErrServerClosed) {
+			err = serveErr
+		}
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// shutdown shuts the servers down within ShutdownTimeout, and closes the connections still
+// open afterwards.
+func (n *Camillo) shutdown(servers []serving) error {
+	ctx := context.Background()
+	if n.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, n.ShutdownTimeout)
+		defer cancel()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(servers))
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, srv server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+				errs[i] = fmt.Errorf("camillo: shutting down: %w", err)
+			}
+		}(i, s.srv)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logSinkWriter writes the lines of a log.Logger to a LogSink at a level.
+type logSinkWriter struct {
+	sink  LogSink
+	level LogLevel
+}
+
+func (w logSinkWriter) Write(p []byte) (int, error) {
+	logf(w.sink, w.level, "%s", strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+func (n *Camillo) logf(level LogLevel, format string, v ...interface{}) {
+	if n.Logger != nil {
+		logf(n.Logger, level, format, v...)
+	}
+}
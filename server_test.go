@@ -0,0 +1,388 @@
+package camillo
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// awaitListening waits for a server to accept connections on addr.
+func awaitListening(t *testing.T, addr string) {
+	for i := 0; i < 100; i++ {
+		if c, err := net.Dial("tcp", addr); err == nil {
+			c.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected a server listening on %s", addr)
+}
+
+func TestRunGracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(rw, "done")
+	})
+
+	errs := make(chan error, 1)
+	go func() { errs <- n.Run("127.0.0.1:3017") }()
+	awaitListening(t, "127.0.0.1:3017")
+
+	responses := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://127.0.0.1:3017/")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		responses <- string(b)
+	}()
+
+	<-started
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	// the request in flight completes
+	expect(t, <-responses, "done")
+	expect(t, <-errs, nil)
+
+	_, err := net.Dial("tcp", "127.0.0.1:3017")
+	refute(t, err, nil)
+}
+
+func TestRunShutdownTimeout(t *testing.T) {
+	started := make(chan struct{})
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.ShutdownTimeout = 50 * time.Millisecond
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	})
+
+	errs := make(chan error, 1)
+	go func() { errs <- n.Run("127.0.0.1:3018") }()
+	awaitListening(t, "127.0.0.1:3018")
+	go http.Get("http://127.0.0.1:3018/")
+
+	<-started
+	syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	select {
+	case err := <-errs:
+		expect(t, err.Error(), "camillo: shutting down: context deadline exceeded")
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected Run to give up on the requests in flight")
+	}
+}
+
+func TestRunError(t *testing.T) {
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	refute(t, n.Run("127.0.0.1:-1"), nil)
+}
+
+func TestRunContext(t *testing.T) {
+	started := make(chan struct{})
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(rw, "done")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- n.RunContext(ctx, "127.0.0.1:3027") }()
+	awaitListening(t, "127.0.0.1:3027")
+
+	responses := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://127.0.0.1:3027/")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		responses <- string(b)
+	}()
+
+	<-started
+	cancel()
+	// the request in flight completes
+	expect(t, <-responses, "done")
+	expect(t, <-errs, nil)
+
+	_, err := net.Dial("tcp", "127.0.0.1:3027")
+	refute(t, err, nil)
+}
+
+func TestRunContextError(t *testing.T) {
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	expect(t, err, nil)
+	defer l.Close()
+
+	// the address is in use
+	err = n.RunContext(context.Background(), l.Addr().String())
+	refute(t, err, nil)
+	expect(t, strings.Contains(err.Error(), "address already in use"), true)
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1 and its key to PEM files,
+// and returns them with a pool trusting the certificate.
+func writeTestCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "camillo test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	cert, _ := x509.ParseCertificate(der)
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestRunTLS(t *testing.T) {
+	certFile, keyFile, pool := writeTestCert(t)
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, r.Proto)
+	})
+
+	errs := make(chan error, 1)
+	go func() { errs <- n.RunTLS("127.0.0.1:3019", certFile, keyFile) }()
+	awaitListening(t, "127.0.0.1:3019")
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	res, err := client.Get("https://127.0.0.1:3019/")
+	expect(t, err, nil)
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, string(b), "HTTP/1.1")
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	expect(t, <-errs, nil)
+}
+
+func TestRunTLSMissingCert(t *testing.T) {
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	err := n.RunTLS("127.0.0.1:3020", "missing.pem", "missing.key")
+	expect(t, errors.Is(err, fs.ErrNotExist), true)
+}
+
+func TestNewServer(t *testing.T) {
+	var logged []string
+	n := New()
+	n.Logger = LogSinkFunc(func(format string, v ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, v...))
+	})
+
+	srv := n.NewServer(":3000")
+	expect(t, srv.Addr, ":3000")
+	expect(t, srv.Handler, http.Handler(n))
+	expect(t, srv.ReadHeaderTimeout, 10*time.Second)
+	expect(t, srv.IdleTimeout, 2*time.Minute)
+	expect(t, srv.MaxHeaderBytes, 1<<20)
+
+	srv.ErrorLog.Printf("http: TLS handshake error")
+	expect(t, strings.Join(logged, "|"), "http: TLS handshake error")
+}
+
+func TestRunServer(t *testing.T) {
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, "ok")
+	})
+
+	srv := n.NewServer("127.0.0.1:3021")
+	srv.Handler = nil
+	srv.MaxHeaderBytes = 1024
+	errs := make(chan error, 1)
+	go func() { errs <- n.RunServer(srv) }()
+	awaitListening(t, "127.0.0.1:3021")
+
+	res, err := http.Get("http://127.0.0.1:3021/")
+	expect(t, err, nil)
+	res.Body.Close()
+	expect(t, res.StatusCode, http.StatusOK)
+
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:3021/", nil)
+	req.Header.Set("X-Large", strings.Repeat("x", 64*1024))
+	res, err = http.DefaultClient.Do(req)
+	expect(t, err, nil)
+	res.Body.Close()
+	expect(t, res.StatusCode, http.StatusRequestHeaderFieldsTooLarge)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	expect(t, <-errs, nil)
+}
+
+func TestServe(t *testing.T) {
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, "ok")
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	expect(t, err, nil)
+	errs := make(chan error, 1)
+	go func() { errs <- n.Serve(l) }()
+
+	res, err := http.Get("http://" + l.Addr().String() + "/")
+	expect(t, err, nil)
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, string(b), "ok")
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	expect(t, <-errs, nil)
+}
+
+func TestRunUnix(t *testing.T) {
+	// the paths of unix sockets are limited to about a hundred bytes
+	dir, err := os.MkdirTemp("", "camillo")
+	expect(t, err, nil)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.sock")
+	// a socket left by a crashed run
+	stale, err := net.Listen("unix", path)
+	expect(t, err, nil)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, "ok")
+	})
+	errs := make(chan error, 1)
+	go func() { errs <- n.RunUnix(path, 0660) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	var res *http.Response
+	for i := 0; i < 100; i++ {
+		if res, err = client.Get("http://unix/"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expect(t, err, nil)
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, string(b), "ok")
+
+	fi, err := os.Stat(path)
+	expect(t, err, nil)
+	expect(t, fi.Mode().Perm(), os.FileMode(0660))
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	expect(t, <-errs, nil)
+	_, err = os.Stat(path)
+	expect(t, errors.Is(err, fs.ErrNotExist), true)
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	a := NewAutoTLS("example.com")
+	a.CacheDir = ""
+	serve := func(h http.Handler, method, url string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		h.ServeHTTP(response, httptest.NewRequest(method, url, nil))
+		return response
+	}
+
+	response := serve(redirectHTTPS(":443", nil), "GET", "http://example.com:80/orders?id=1")
+	expect(t, response.Code, http.StatusMovedPermanently)
+	expect(t, response.Header().Get("Location"), "https://example.com/orders?id=1")
+
+	response = serve(redirectHTTPS("127.0.0.1:8443", a), "POST", "http://example.com/orders")
+	expect(t, response.Code, http.StatusPermanentRedirect)
+	expect(t, response.Header().Get("Location"), "https://example.com:8443/orders")
+
+	expect(t, serve(redirectHTTPS(":443", a), "GET", "http://example.com/.well-known/acme-challenge/token").Code, http.StatusNotFound)
+	expect(t, serve(redirectHTTPS(":443", nil), "GET", "http://example.com/.well-known/acme-challenge/token").Code, http.StatusMovedPermanently)
+}
+
+func TestRunTLSRedirect(t *testing.T) {
+	certFile, keyFile, pool := writeTestCert(t)
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, "secure")
+	})
+
+	errs := make(chan error, 1)
+	go func() { errs <- n.RunTLSRedirect("127.0.0.1:3024", "127.0.0.1:3025", certFile, keyFile) }()
+	awaitListening(t, "127.0.0.1:3024")
+	awaitListening(t, "127.0.0.1:3025")
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	res, err := client.Get("http://127.0.0.1:3024/page")
+	expect(t, err, nil)
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, res.Request.URL.String(), "https://127.0.0.1:3025/page")
+	expect(t, string(b), "secure")
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	expect(t, <-errs, nil)
+	_, err = net.Dial("tcp", "127.0.0.1:3024")
+	refute(t, err, nil)
+}
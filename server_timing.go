@@ -0,0 +1,147 @@
+package camillo
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type serverTimingKey struct{}
+
+// ServerTiming is a middleware handler that reports the durations recorded while serving a
+// request in the W3C Server-Timing response header, which browser devtools show as the
+// backend timing breakdown. Durations are recorded with StartTiming and TimeHandler, and a
+// "total" metric is added for the whole request. ServerTiming should come first in the
+// stack; the header only holds the durations recorded before the response was written.
+type ServerTiming struct {
+	// Clock is used to time requests
+	Clock Clock
+	// Trailer sends the metrics in a Server-Timing trailer instead of a header, so the
+	// durations recorded while the body is written are included. Few browsers read
+	// trailers, and they are only sent on connections that support them.
+	Trailer bool
+}
+
+// NewServerTiming returns a new instance of ServerTiming
+func NewServerTiming() *ServerTiming {
+	return &ServerTiming{Clock: SystemClock}
+}
+
+func (st *ServerTiming) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	res, ok := rw.(ResponseWriter)
+	if !ok {
+		next(ctx, rw, r)
+		return
+	}
+
+	timings := &serverTimings{clock: st.Clock, start: clockNow(st.Clock)}
+	ctx = context.WithValue(ctx, serverTimingKey{}, timings)
+
+	if st.Trailer {
+		DeclareTrailer(res, "Server-Timing")
+		next(ctx, rw, r)
+		AddTrailer(res, "Server-Timing", timings.header())
+		return
+	}
+
+	res.Before(func(res ResponseWriter) {
+		res.Header().Set("Server-Timing", timings.header())
+	})
+	next(ctx, rw, r)
+	if !res.Written() {
+		res.Header().Set("Server-Timing", timings.header())
+	}
+}
+
+// StartTiming starts timing the metric name of the request served with ctx, with an
+// optional description. The returned function stops the timer and records the duration;
+// durations of the same metric add up.
+//
+//	defer camillo.StartTiming(ctx, "db", "user lookup")()
+//
+// It does nothing when the request isn't served by a ServerTiming.
+func StartTiming(ctx context.Context, name, desc string) func() {
+	timings, ok := ctx.Value(serverTimingKey{}).(*serverTimings)
+	if !ok {
+		return func() {}
+	}
+	start := clockNow(timings.clock)
+	return func() {
+		timings.add(name, desc, clockNow(timings.clock).Sub(start))
+	}
+}
+
+// TimeHandler returns a Handler recording the time spent in h as the metric name, without
+// the time spent in the handlers after it.
+func TimeHandler(name string, h Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		timings, ok := ctx.Value(serverTimingKey{}).(*serverTimings)
+		if !ok {
+			h.ServeHTTP(ctx, rw, r, next)
+			return
+		}
+
+		start := clockNow(timings.clock)
+		h.ServeHTTP(ctx, rw, r, func(ctx context.Context, rw http.ResponseWriter, r *http.Request) {
+			timings.add(name, "", clockNow(timings.clock).Sub(start))
+			next(ctx, rw, r)
+			start = clockNow(timings.clock)
+		})
+		timings.add(name, "", clockNow(timings.clock).Sub(start))
+	})
+}
+
+type serverTimings struct {
+	clock Clock
+	start time.Time
+
+	mtx     sync.Mutex
+	metrics []serverTiming
+}
+
+type serverTiming struct {
+	name     string
+	desc     string
+	duration time.Duration
+}
+
+func (t *serverTimings) add(name, desc string, duration time.Duration) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for i := range t.metrics {
+		if t.metrics[i].name == name {
+			t.metrics[i].duration += duration
+			return
+		}
+	}
+	t.metrics = append(t.metrics, serverTiming{name: name, desc: desc, duration: duration})
+}
+
+// header formats the metrics as a Server-Timing header value, such as
+// `db;desc="user lookup";dur=12.5, total;dur=20`.
+func (t *serverTimings) header() string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	parts := make([]string, 0, len(t.metrics)+1)
+	for _, m := range t.metrics {
+		parts = append(parts, serverTimingMetric(m.name, m.desc, m.duration))
+	}
+	parts = append(parts, serverTimingMetric("total", "", clockNow(t.clock).Sub(t.start)))
+	return strings.Join(parts, ", ")
+}
+
+func serverTimingMetric(name, desc string, duration time.Duration) string {
+	metric := name
+	if desc != "" {
+		metric += ";desc=" + strconv.Quote(desc)
+	}
+	ms := strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', -1, 64)
+	return fmt.Sprintf("%s;dur=%s", metric, ms)
+}
@@ -0,0 +1,81 @@
+package camillo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestServerTiming(t *testing.T) {
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+	st := NewServerTiming()
+	st.Clock = clock
+
+	auth := HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		clock.Advance(2 * time.Millisecond)
+		next(ctx, rw, r)
+		clock.Advance(time.Millisecond)
+	})
+
+	n := New(st, TimeHandler("auth", auth))
+	n.Use(HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		stop := StartTiming(ctx, "db", "user lookup")
+		clock.Advance(10500 * time.Microsecond)
+		stop()
+		defer StartTiming(ctx, "db", "")()
+		clock.Advance(time.Millisecond)
+		rw.WriteHeader(http.StatusOK)
+	}))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	n.ServeHTTP(recorder, req)
+
+	// the header is written before the db timer stops and auth returns
+	expect(t, recorder.Header().Get("Server-Timing"), `auth;dur=2, db;desc="user lookup";dur=10.5, total;dur=13.5`)
+}
+
+func TestServerTimingNoWrite(t *testing.T) {
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+	st := NewServerTiming()
+	st.Clock = clock
+
+	n := New(st)
+	n.Use(HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		defer StartTiming(ctx, "render", "")()
+		clock.Advance(3 * time.Millisecond)
+	}))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	n.ServeHTTP(recorder, req)
+
+	expect(t, recorder.Header().Get("Server-Timing"), "render;dur=3, total;dur=3")
+}
+
+func TestServerTimingTrailer(t *testing.T) {
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+	st := NewServerTiming()
+	st.Clock = clock
+	st.Trailer = true
+
+	n := New(st)
+	n.Use(HandlerFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		defer StartTiming(ctx, "render", "")()
+		rw.Write([]byte("hello"))
+		clock.Advance(4 * time.Millisecond)
+	}))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	n.ServeHTTP(recorder, req)
+
+	expect(t, recorder.Result().Trailer.Get("Server-Timing"), "render;dur=4, total;dur=4")
+}
+
+func TestStartTimingWithoutServerTiming(t *testing.T) {
+	StartTiming(context.Background(), "db", "")()
+}
@@ -0,0 +1,138 @@
+package camillo
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"golang.org/x/net/context"
+)
+
+// ResponseSigner is a middleware handler that signs response bodies for high-integrity
+// endpoints such as firmware or policy downloads. It sets a Content-Digest header (RFC
+// 9530) and an HTTP Message Signature (RFC 9421) covering the digest and the status.
+//
+// The signature covers the body as written by the handlers after ResponseSigner, so it
+// should come before any middleware that transforms the body, such as compression, to
+// cover the bytes that are actually sent.
+type ResponseSigner struct {
+	// Signer is the private key used to sign responses. Ed25519 and RSA keys are supported.
+	Signer crypto.Signer
+	// KeyID identifies the key to the clients verifying the signature.
+	KeyID string
+	// Paths limits signing to the request paths matching one of these path.Match patterns.
+	// All responses are signed when it is empty.
+	Paths []string
+	// MaxSize is the maximum size of a response that is buffered for signing. Larger
+	// and streamed responses are sent unsigned.
+	MaxSize int
+	// Logger is used to log responses that could not be signed
+	Logger LogSink
+	// Clock is used for the created parameter of the signature
+	Clock Clock
+}
+
+// NewResponseSigner returns a new instance of ResponseSigner
+func NewResponseSigner(signer crypto.Signer, keyID string) *ResponseSigner {
+	return &ResponseSigner{
+		Signer:  signer,
+		KeyID:   keyID,
+		MaxSize: 1024 * 1024 * 64,
+		Logger:  log.New(os.Stdout, "[camillo] ", 0),
+		Clock:   SystemClock,
+	}
+}
+
+func (s *ResponseSigner) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	if !s.match(r.URL.Path) {
+		next(ctx, rw, r)
+		return
+	}
+
+	b := NewBufferedResponseWriter(rw)
+	b.MaxSize = s.MaxSize
+	next(ctx, b, r)
+
+	if b.Committed() || !b.Written() {
+		if b.Committed() {
+			logf(s.Logger, LogLevelWarn, "response to %s %s was sent unsigned: it was streamed or exceeded %d bytes", r.Method, r.URL.Path, s.MaxSize)
+		}
+		b.Commit()
+		return
+	}
+
+	if err := s.sign(b); err != nil {
+		logf(s.Logger, LogLevelError, "failed to sign response to %s %s: %s", r.Method, r.URL.Path, err)
+		b.Rollback()
+		http.Error(b, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+	b.Commit()
+}
+
+func (s *ResponseSigner) sign(b *BufferedResponseWriter) error {
+	sum := sha256.Sum256(b.Body())
+	digest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+
+	alg, err := signatureAlgorithm(s.Signer)
+	if err != nil {
+		return err
+	}
+	params := fmt.Sprintf(`("content-digest" "@status");created=%d;keyid=%s;alg="%s"`,
+		clockNow(s.Clock).Unix(), strconv.Quote(s.KeyID), alg)
+
+	sig, err := signMessage(s.Signer, []byte(SignatureBase(digest, b.Status(), params)))
+	if err != nil {
+		return err
+	}
+
+	b.Header().Set("Content-Digest", digest)
+	b.Header().Set("Signature-Input", "sig1="+params)
+	b.Header().Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	return nil
+}
+
+func (s *ResponseSigner) match(p string) bool {
+	if len(s.Paths) == 0 {
+		return true
+	}
+	for _, pattern := range s.Paths {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SignatureBase returns the RFC 9421 signature base signed by ResponseSigner for a
+// response with the given Content-Digest header, status and signature parameters.
+// Clients can use it to verify the Signature header.
+func SignatureBase(contentDigest string, status int, params string) string {
+	return fmt.Sprintf("\"content-digest\": %s\n\"@status\": %d\n\"@signature-params\": %s", contentDigest, status, params)
+}
+
+func signatureAlgorithm(signer crypto.Signer) (string, error) {
+	switch signer.Public().(type) {
+	case ed25519.PublicKey:
+		return "ed25519", nil
+	case *rsa.PublicKey:
+		return "rsa-v1_5-sha256", nil
+	}
+	return "", fmt.Errorf("unsupported signing key type %T", signer.Public())
+}
+
+func signMessage(signer crypto.Signer, message []byte) ([]byte, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, message, crypto.Hash(0))
+	}
+	sum := sha256.Sum256(message)
+	return signer.Sign(rand.Reader, sum[:], crypto.SHA256)
+}
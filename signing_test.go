@@ -0,0 +1,136 @@
+package camillo
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSignedResponse(t *testing.T, signer crypto.Signer, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+
+	s := NewResponseSigner(signer, "firmware-2015")
+	s.Clock = NewManualClock(time.Unix(1433160000, 0))
+	s.Logger = log.New(bytes.NewBufferString(""), "[camillo] ", 0)
+
+	n := New(s)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(body))
+	})
+
+	req, err := http.NewRequest("GET", "http://localhost:3000/firmware.bin", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	n.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func signedBase(t *testing.T, recorder *httptest.ResponseRecorder) []byte {
+	sum := sha256.Sum256(recorder.Body.Bytes())
+	digest := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	expect(t, recorder.Header().Get("Content-Digest"), digest)
+
+	params := strings.TrimPrefix(recorder.Header().Get("Signature-Input"), "sig1=")
+	return []byte(SignatureBase(digest, recorder.Code, params))
+}
+
+func signature(t *testing.T, recorder *httptest.ResponseRecorder) []byte {
+	v := recorder.Header().Get("Signature")
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(v, "sig1=:"), ":"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestResponseSignerEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := newSignedResponse(t, priv, "firmware image")
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, recorder.Body.String(), "firmware image")
+	expect(t, recorder.Header().Get("Signature-Input"), `sig1=("content-digest" "@status");created=1433160000;keyid="firmware-2015";alg="ed25519"`)
+	expect(t, ed25519.Verify(pub, signedBase(t, recorder), signature(t, recorder)), true)
+}
+
+func TestResponseSignerRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := newSignedResponse(t, key, "policy document")
+	sum := sha256.Sum256(signedBase(t, recorder))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], signature(t, recorder)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResponseSignerPaths(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	recorder := httptest.NewRecorder()
+
+	s := NewResponseSigner(priv, "firmware-2015")
+	s.Paths = []string{"/firmware/*"}
+	n := New(s)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("index"))
+	})
+
+	req, err := http.NewRequest("GET", "http://localhost:3000/index.html", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	n.ServeHTTP(recorder, req)
+	expect(t, recorder.Body.String(), "index")
+	expect(t, recorder.Header().Get("Signature"), "")
+}
+
+// failingSigner is a crypto.Signer whose signatures always fail.
+type failingSigner struct {
+	crypto.Signer
+}
+
+func (failingSigner) Sign(io.Reader, []byte, crypto.SignerOpts) ([]byte, error) {
+	return nil, errors.New("the key is unavailable")
+}
+
+func TestResponseSignerLogLevels(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	var lines leveledRecorder
+
+	s := NewResponseSigner(failingSigner{priv}, "firmware-2015")
+	s.Logger = &lines
+	n := New(s)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("firmware"))
+		if r.URL.Path == "/stream" {
+			rw.(http.Flusher).Flush()
+		}
+	})
+
+	for _, path := range []string{"/stream", "/firmware.bin"} {
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	expect(t, len(lines), 2)
+	expect(t, strings.HasPrefix(lines[0], "warn response to GET /stream was sent unsigned"), true)
+	expect(t, lines[1], "error failed to sign response to GET /firmware.bin: the key is unavailable")
+}
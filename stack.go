@@ -0,0 +1,156 @@
+package camillo
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// StackFrame is a function call of a stack trace.
+type StackFrame struct {
+	// Function is the qualified name of the function, such as "main.handler".
+	Function string
+	File     string
+	Line     int
+	// App is set for the functions outside of the standard library.
+	App bool
+}
+
+// ParseStack parses the frames of the first goroutine of a runtime.Stack trace, innermost
+// first. The first goroutine of a trace taken while recovering is the panicking one.
+func ParseStack(stack []byte) []StackFrame {
+	var frames []StackFrame
+	lines := strings.Split(string(stack), "\n")
+	for i := 0; i+1 < len(lines); i++ {
+		fn := lines[i]
+		if fn == "" {
+			if len(frames) > 0 {
+				break
+			}
+			continue
+		}
+		if strings.HasPrefix(fn, "goroutine ") || strings.HasPrefix(fn, "\t") || !strings.HasPrefix(lines[i+1], "\t") {
+			continue
+		}
+		i++
+		if strings.HasPrefix(fn, "created by ") {
+			fn = strings.TrimPrefix(fn, "created by ")
+			if j := strings.Index(fn, " in goroutine "); j > 0 {
+				fn = fn[:j]
+			}
+		} else if j := strings.LastIndex(fn, "("); j > 0 {
+			fn = fn[:j]
+		}
+
+		location := strings.TrimPrefix(lines[i], "\t")
+		if j := strings.LastIndex(location, " +0x"); j > 0 {
+			location = location[:j]
+		}
+		frame := StackFrame{Function: fn, File: location, App: !stdlibFunc(fn)}
+		if j := strings.LastIndex(location, ":"); j > 0 {
+			frame.File = location[:j]
+			frame.Line, _ = strconv.Atoi(location[j+1:])
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// panicFrames returns the frames from the one that panicked outwards, without the frames
+// of the recovering function and of the runtime and net/http packages.
+func panicFrames(frames []StackFrame) []StackFrame {
+	for i := len(frames) - 1; i >= 0; i-- {
+		if frames[i].Function == "panic" {
+			frames = frames[i+1:]
+			break
+		}
+	}
+	var trimmed []StackFrame
+	for _, frame := range frames {
+		if !noiseFunc(frame.Function) {
+			trimmed = append(trimmed, frame)
+		}
+	}
+	return trimmed
+}
+
+// FormatStack formats a runtime.Stack trace taken while recovering from a panic. Only the
+// frames of the panicking goroutine from the panic site outwards are kept, without the
+// runtime and net/http frames, and the first frame of the application is marked:
+//
+//	-> main.handler
+//	       /app/main.go:12
+//	   github.com/fd/camillo.(*Camillo).ServeHTTP
+//	       /src/camillo/camillo.go:99
+func FormatStack(stack []byte) []byte {
+	var buf bytes.Buffer
+	marked := false
+	for _, frame := range panicFrames(ParseStack(stack)) {
+		marker := "   "
+		if frame.App && !marked {
+			marker, marked = "-> ", true
+		}
+		fmt.Fprintf(&buf, "%s%s\n       %s:%d\n", marker, frame.Function, frame.File, frame.Line)
+	}
+	return buf.Bytes()
+}
+
+// panicSite returns the first frame of the application from the panic site outwards.
+func panicSite(stack []byte) (StackFrame, bool) {
+	for _, frame := range panicFrames(ParseStack(stack)) {
+		if frame.App {
+			return frame, true
+		}
+	}
+	return StackFrame{}, false
+}
+
+// sourceLine is a line of a source file.
+type sourceLine struct {
+	Number  int
+	Text    string
+	Current bool
+}
+
+// sourceLines returns the lines of file around line, or nil when the file can't be read.
+func sourceLines(file string, line, context int) []sourceLine {
+	b, err := os.ReadFile(file)
+	if err != nil || line <= 0 {
+		return nil
+	}
+	lines := strings.Split(string(b), "\n")
+	var snippet []sourceLine
+	for n := line - context; n <= line+context; n++ {
+		if n >= 1 && n <= len(lines) {
+			snippet = append(snippet, sourceLine{Number: n, Text: lines[n-1], Current: n == line})
+		}
+	}
+	return snippet
+}
+
+// stdlibFunc returns whether a function, such as "net/http.HandlerFunc.ServeHTTP", is in
+// the standard library, whose import paths have no dot in their first element.
+func stdlibFunc(fn string) bool {
+	if fn == "panic" {
+		return true
+	}
+	pkg := fn
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[:i]
+	} else if i := strings.Index(pkg, "."); i >= 0 {
+		pkg = pkg[:i]
+	}
+	if pkg == "main" {
+		return false
+	}
+	first := strings.SplitN(pkg, "/", 2)[0]
+	return !strings.Contains(first, ".")
+}
+
+// noiseFunc returns whether fn is a function of the runtime or net/http packages, which
+// frame every handler.
+func noiseFunc(fn string) bool {
+	return strings.HasPrefix(fn, "runtime.") || strings.HasPrefix(fn, "net/http.")
+}
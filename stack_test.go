@@ -0,0 +1,49 @@
+package camillo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testStack = "goroutine 1 [running]:\n" +
+	"github.com/fd/camillo.(*Recovery).ServeHTTP.func1()\n\t/src/camillo/recovery.go:110 +0x1e5\n" +
+	"panic({0x7a1c20?, 0x8c3a10?})\n\t/usr/local/go/src/runtime/panic.go:785 +0x132\n" +
+	"main.handler(0x1)\n\t/app/main.go:12 +0x1d\n" +
+	"net/http.HandlerFunc.ServeHTTP(...)\n\t/usr/local/go/src/net/http/server.go:2136\n" +
+	"github.com/fd/camillo.(*Camillo).ServeHTTP(0xc000010000)\n\t/src/camillo/camillo.go:99 +0x4b\n" +
+	"created by net/http.(*Server).Serve in goroutine 1\n\t/usr/local/go/src/net/http/server.go:3285 +0x4b4\n" +
+	"\n" +
+	"goroutine 2 [select]:\n" +
+	"main.worker()\n\t/app/worker.go:3 +0x1\n"
+
+func TestParseStack(t *testing.T) {
+	frames := ParseStack([]byte(testStack))
+
+	expect(t, len(frames), 6)
+	expect(t, frames[0], StackFrame{Function: "github.com/fd/camillo.(*Recovery).ServeHTTP.func1", File: "/src/camillo/recovery.go", Line: 110, App: true})
+	expect(t, frames[1], StackFrame{Function: "panic", File: "/usr/local/go/src/runtime/panic.go", Line: 785})
+	expect(t, frames[2], StackFrame{Function: "main.handler", File: "/app/main.go", Line: 12, App: true})
+	expect(t, frames[3], StackFrame{Function: "net/http.HandlerFunc.ServeHTTP", File: "/usr/local/go/src/net/http/server.go", Line: 2136})
+	expect(t, frames[5], StackFrame{Function: "net/http.(*Server).Serve", File: "/usr/local/go/src/net/http/server.go", Line: 3285})
+}
+
+func TestFormatStack(t *testing.T) {
+	expect(t, string(FormatStack([]byte(testStack))), ""+
+		"-> main.handler\n"+
+		"       /app/main.go:12\n"+
+		"   github.com/fd/camillo.(*Camillo).ServeHTTP\n"+
+		"       /src/camillo/camillo.go:99\n")
+}
+
+func TestSourceLines(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "main.go")
+	os.WriteFile(name, []byte("package main\n\nfunc main() {\n\tpanic(1)\n}\n"), 0644)
+
+	lines := sourceLines(name, 4, 1)
+	expect(t, len(lines), 3)
+	expect(t, lines[0], sourceLine{Number: 3, Text: "func main() {"})
+	expect(t, lines[1], sourceLine{Number: 4, Text: "\tpanic(1)", Current: true})
+	expect(t, len(sourceLines(name, 1, 2)), 3)
+	expect(t, len(sourceLines(filepath.Join(t.TempDir(), "missing.go"), 1, 2)), 0)
+}
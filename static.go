@@ -1,61 +1,223 @@
 package camillo
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
 	"net/http"
+	"os"
 	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/net/context"
 )
 
 // Static is a middleware handler that serves static files in the given directory/filesystem.
 type Static struct {
-	// Dir is the directory to serve static files from
+	// Dir is the directory to serve static files from, or an Overlay of several roots.
+	// ConfinedDir keeps symbolic links from escaping the directory.
 	Dir http.FileSystem
-	// Prefix is the optional prefix used to serve the static directory content
+	// Methods are the methods answered by Static, among GET, HEAD, OPTIONS and PROPFIND
+	// with WebDAV, all of them when empty. The requests with the other methods are passed to the next handler. HEAD
+	// requests get the headers of GET, Content-Length included, without the body, and
+	// OPTIONS requests for the files get a 204 with the Allow header. CORS preflights are
+	// passed to the next handler.
+	Methods []string
+	// WebDAV answers the PROPFIND requests of the WebDAV clients, so they can browse the
+	// files, the directories only listing their files as GET would with Listing. The
+	// WebDAV methods changing the files are passed to the next handler.
+	WebDAV bool
+	// Prefix is the optional prefix used to serve the static directory content, such as
+	// "/assets". Only the requests under it are looked up, with the prefix stripped, and
+	// the others are passed to the next handler without touching the filesystem.
 	Prefix string
+	// Root is the optional subdirectory of Dir the files are served from.
+	Root string
 	// IndexFile defines which file to serve as index if it exists.
 	IndexFile string
+	// IndexFiles are more index files tried in order when IndexFile doesn't exist, such
+	// as "index.htm".
+	IndexFiles []string
+	// Listing serves a generated listing of the directories without an index file, as
+	// HTML or as JSON for the clients accepting it. Dot files are not listed.
+	Listing bool
+	// NoListingFile is the name of a file disabling the listing of the directory it is in.
+	NoListingFile string
+	// Fallback is the file served instead of the missing files to the requests accepting
+	// HTML, such as "/index.html" for the single page applications routing on the client.
+	Fallback string
+	// ETag selects the entity tags sent with the files. Conditional requests are answered
+	// with a 304 when If-None-Match matches the entity tag or the file wasn't modified
+	// since If-Modified-Since.
+	ETag StaticETag
+	// Precompressed serves the precompressed variants of the files, such as app.js.br and
+	// app.js.gz next to app.js, to the clients accepting their content coding.
+	Precompressed bool
+	// MIMETypes maps extensions, such as ".wasm", to the content type of the files,
+	// overriding the built in and the system mappings.
+	MIMETypes map[string]string
+	// Charset is added to the textual content types without a charset, such as the ones
+	// of MIMETypes.
+	Charset string
+	// AllowDotFiles are the names starting with a dot that are served, such as
+	// ".well-known". The files and directories starting with another dot are never served.
+	AllowDotFiles []string
+	// ErrorHandler answers the requests for files that can't be served when set, instead
+	// of passing them to the next handler, with the status: 404 for the missing files,
+	// 403 for the files that may not be opened and the directories without an index, and
+	// 500 for the other errors.
+	ErrorHandler func(ctx context.Context, rw http.ResponseWriter, r *http.Request, status int, err error)
+	// MaxRangeRequests is the number of concurrent Range requests of a client, such as a
+	// download accelerator, beyond which they are answered with a 429. Zero means no limit.
+	MaxRangeRequests int
+	// BytesPerSecond limits the rate at which each response is sent. Zero means no limit.
+	BytesPerSecond int64
+	// ClientBytesPerSecond limits the rate at which the responses of a client are sent
+	// together, so a single client can't saturate the uplink. Zero means no limit.
+	ClientBytesPerSecond int64
+	// BandwidthBurst is the number of bytes sent at full speed before BytesPerSecond and
+	// ClientBytesPerSecond apply, refilled at their rate while idle.
+	BandwidthBurst int64
+	// ClientIP identifies the clients for MaxRangeRequests and ClientBytesPerSecond, by
+	// their remote address when nil.
+	ClientIP *ClientIP
+	// Development sends "Cache-Control: no-store" with every file and bypasses Cache, so
+	// the browsers always get the files as they are on disk. See Watch for reloading them.
+	Development bool
+	// Assets serves the fingerprinted names of its assets, with immutable caching, when set.
+	Assets *AssetManifest
+	// Metrics counts the files served when set.
+	Metrics StaticMetrics
+	// Cache keeps the small files in memory when set.
+	Cache *StaticCache
+	// CachePolicies set the Cache-Control header of the files, the first matching one wins.
+	CachePolicies []StaticCachePolicy
+
+	mtx    sync.Mutex
+	hashes map[string]staticHash
+	ranges map[string]int
+	// bandwidth are the buckets of the clients for ClientBytesPerSecond
+	bandwidth map[string]*bandwidthBucket
 }
 
 // NewStatic returns a new instance of Static
 func NewStatic(directory http.FileSystem) *Static {
 	return &Static{
-		Dir:       directory,
-		Prefix:    "",
-		IndexFile: "index.html",
+		Dir:           directory,
+		Prefix:        "",
+		IndexFile:     "index.html",
+		NoListingFile: ".nolisting",
+		ETag:          StaticETagWeak,
+		Charset:       "utf-8",
 	}
 }
 
+// NewStaticFS returns a new instance of Static serving the files of fsys, such as an
+// embed.FS, so single binaries can serve their assets:
+//
+//	//go:embed public
+//	var public embed.FS
+//
+//	s := camillo.NewStaticFS(public)
+//	s.Root = "public"
+func NewStaticFS(fsys fs.FS) *Static {
+	return NewStatic(http.FS(fsys))
+}
+
 func (s *Static) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
-	if r.Method != "GET" && r.Method != "HEAD" {
+	if s.Metrics == nil {
+		s.serve(ctx, rw, r, next)
+		return
+	}
+	start := time.Now()
+	res := &staticMetricsWriter{ResponseWriter: rw}
+	passed := false
+	cache := s.serve(ctx, res, r, func(ctx context.Context, _ http.ResponseWriter, r *http.Request) {
+		passed = true
+		next(ctx, rw, r)
+	})
+	if !passed {
+		s.Metrics.FileServed(res.status(), res.bytes, time.Since(start), cache)
+	}
+}
+
+// serve serves the file of r and returns whether it was served from Cache: "hit", "miss",
+// or "" when the Cache wasn't looked up.
+func (s *Static) serve(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) (cache string) {
+	if !s.allows(r.Method) || r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
 		next(ctx, rw, r)
 		return
 	}
 	file := r.URL.Path
 	// if we have a prefix, filter requests by stripping the prefix
-	if s.Prefix != "" {
-		if !strings.HasPrefix(file, s.Prefix) {
+	if prefix := strings.TrimSuffix(s.Prefix, "/"); prefix != "" {
+		if !strings.HasPrefix(file, prefix) {
 			next(ctx, rw, r)
 			return
 		}
-		file = file[len(s.Prefix):]
+		file = file[len(prefix):]
 		if file != "" && file[0] != '/' {
 			next(ctx, rw, r)
 			return
 		}
 	}
+	if unsafePath(r) {
+		http.Error(rw, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	if dotFile(file, s.AllowDotFiles) {
+		s.fail(ctx, rw, r, next, os.ErrNotExist)
+		return
+	}
+	fingerprinted := false
+	if s.Assets != nil {
+		if name, ok := s.Assets.Resolve(file); ok {
+			file, fingerprinted = "/"+name, true
+		}
+	}
+	if s.Cache != nil && !s.Development && (r.Method == "GET" || r.Method == "HEAD") {
+		if e, ok := s.Cache.get(r.URL.Path); ok {
+			for k, v := range e.header {
+				rw.Header().Set(k, v)
+			}
+			s.serveContent(rw, r, e.name, e.modTime, bytes.NewReader(e.body))
+			return "hit"
+		}
+		cache = "miss"
+	}
+	// clean the path first so it can't climb out of the root
+	file = path.Join("/", s.Root, path.Clean("/"+file))
 	f, err := s.Dir.Open(file)
+	fallback := false
+	if err != nil && s.Fallback != "" && acceptsHTML(r) {
+		file = path.Join("/", s.Root, path.Clean("/"+s.Fallback))
+		f, err = s.Dir.Open(file)
+		fallback = true
+	}
 	if err != nil {
-		// discard the error?
-		next(ctx, rw, r)
+		s.fail(ctx, rw, r, next, err)
 		return
 	}
 	defer f.Close()
 
 	fi, err := f.Stat()
 	if err != nil {
-		next(ctx, rw, r)
+		s.fail(ctx, rw, r, next, err)
+		return
+	}
+	if r.Method == "OPTIONS" {
+		rw.Header().Set("Allow", strings.Join(s.methods(), ", "))
+		if s.WebDAV {
+			rw.Header().Set("DAV", "1")
+		}
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method == "PROPFIND" {
+		s.servePropfind(rw, r, file, f, fi)
 		return
 	}
 
@@ -67,20 +229,152 @@ func (s *Static) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.
 			return
 		}
 
-		file = path.Join(file, s.IndexFile)
-		f, err = s.Dir.Open(file)
-		if err != nil {
-			next(ctx, rw, r)
+		index, indexInfo, ok := s.openIndex(file)
+		if !ok {
+			if s.listable(file) {
+				s.serveListing(rw, r, f, file == path.Join("/", s.Root))
+				return
+			}
+			s.fail(ctx, rw, r, next, os.ErrPermission)
 			return
 		}
-		defer f.Close()
+		defer index.Close()
+		file, f, fi = path.Join(file, indexInfo.Name()), index, indexInfo
+	}
+
+	name := file
+	vary := false
+	if s.Precompressed {
+		var v *staticVariant
+		v, vary = s.openPrecompressed(r, file)
+		if vary {
+			rw.Header().Add("Vary", "Accept-Encoding")
+		}
+		if v != nil {
+			defer v.file.Close()
+			rw.Header().Set("Content-Encoding", v.coding)
+			file, f, fi = v.name, v.file, v.info
+		}
+	}
 
-		fi, err = f.Stat()
+	if typ := s.contentType(name); typ != "" {
+		rw.Header().Set("Content-Type", typ)
+	}
+	if etag := s.etag(file, f, fi); etag != "" {
+		rw.Header().Set("ETag", etag)
+	}
+	if s.Development {
+		rw.Header().Set("Cache-Control", "no-store")
+	} else if fingerprinted {
+		rw.Header().Set("Cache-Control", immutableCacheControl)
+	} else if cc := s.cacheControl(name); cc != "" {
+		rw.Header().Set("Cache-Control", cc)
+	}
+	// the responses depending on the request headers aren't cached
+	if s.Cache != nil && !s.Development && !fallback && !vary && fi.Size() <= s.Cache.MaxFileSize {
+		s.cache(r.URL.Path, name, rw.Header(), f, fi)
+	}
+	s.serveContent(rw, r, name, fi.ModTime(), f)
+	return
+}
+
+// fail passes a request for a file that can't be served to the ErrorHandler, or to next.
+func (s *Static) fail(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc, err error) {
+	if s.ErrorHandler == nil {
+		next(ctx, rw, r)
+		return
+	}
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		status = http.StatusForbidden
+	}
+	s.ErrorHandler(ctx, rw, r, status, err)
+}
+
+// openIndex opens the first index file of dir that exists.
+func (s *Static) openIndex(dir string) (http.File, os.FileInfo, bool) {
+	for _, name := range append([]string{s.IndexFile}, s.IndexFiles...) {
+		if name == "" {
+			continue
+		}
+		f, err := s.Dir.Open(path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		fi, err := f.Stat()
 		if err != nil || fi.IsDir() {
-			next(ctx, rw, r)
-			return
+			f.Close()
+			continue
 		}
+		return f, fi, true
 	}
+	return nil, nil, false
+}
 
-	http.ServeContent(rw, r, file, fi.ModTime(), f)
+// listable returns whether the directory dir may be listed.
+func (s *Static) listable(dir string) bool {
+	return s.Listing && (s.NoListingFile == "" || !s.exists(path.Join(dir, s.NoListingFile)))
+}
+
+func (s *Static) exists(name string) bool {
+	f, err := s.Dir.Open(name)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
+
+// staticMethods are the methods answered by Static by default.
+var staticMethods = []string{"GET", "HEAD", "OPTIONS"}
+
+// methods returns the methods answered by s.
+func (s *Static) methods() []string {
+	if len(s.Methods) == 0 {
+		if s.WebDAV {
+			return append(staticMethods[:len(staticMethods):len(staticMethods)], "PROPFIND")
+		}
+		return staticMethods
+	}
+	var methods []string
+	for _, m := range s.Methods {
+		if m == "GET" || m == "HEAD" || m == "OPTIONS" || m == "PROPFIND" && s.WebDAV {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// allows returns whether s answers the requests with method.
+func (s *Static) allows(method string) bool {
+	for _, m := range s.methods() {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsHTML returns whether r explicitly accepts HTML, as browsers navigating do.
+func acceptsHTML(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/html") && negotiate(accept, "text/html") != ""
+}
+
+// cache adds the file f served for key to the cache, and rewinds it.
+func (s *Static) cache(key, name string, header http.Header, f http.File, fi os.FileInfo) {
+	body, err := io.ReadAll(f)
+	if _, seekErr := f.Seek(0, io.SeekStart); err != nil || seekErr != nil {
+		return
+	}
+	e := &staticCacheEntry{key: key, name: name, modTime: fi.ModTime(), body: body, header: map[string]string{}}
+	for _, k := range []string{"Content-Type", "ETag", "Cache-Control"} {
+		if v := header.Get(k); v != "" {
+			e.header[k] = v
+		}
+	}
+	s.Cache.put(e)
 }
@@ -0,0 +1,156 @@
+package camillo
+
+import (
+	"math"
+	"net/http"
+	"time"
+)
+
+// maxBandwidthClients is the number of clients whose bandwidth is tracked beyond which
+// the idle ones are forgotten.
+const maxBandwidthClients = 1024
+
+// bandwidthBucket is a token bucket of bytes, refilled at rate bytes per second up to
+// burst bytes.
+type bandwidthBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	active int
+}
+
+func newBandwidthBucket(rate, burst int64, now time.Time) *bandwidthBucket {
+	return &bandwidthBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// refill adds the tokens accumulated since the last call.
+func (b *bandwidthBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// reserve takes n bytes from the bucket and returns how long to wait before sending them.
+func (b *bandwidthBucket) reserve(n int, now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttle limits the rate at which the response is written to BytesPerSecond and, with
+// the other responses of the client, to ClientBytesPerSecond. The returned function
+// releases the client.
+func (s *Static) throttle(rw http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if s.BytesPerSecond <= 0 && s.ClientBytesPerSecond <= 0 {
+		return rw, func() {}
+	}
+	now := time.Now()
+	w := &throttledWriter{ResponseWriter: rw, s: s}
+	rates := []int64{s.BytesPerSecond, s.ClientBytesPerSecond}
+
+	if s.BytesPerSecond > 0 {
+		w.response = newBandwidthBucket(s.BytesPerSecond, s.BandwidthBurst, now)
+	}
+	release := func() {}
+	if s.ClientBytesPerSecond > 0 {
+		client := s.rangeClient(r)
+		w.client = s.acquireBandwidth(client, now)
+		release = func() { s.releaseBandwidth(client) }
+	}
+
+	// write at most a tenth of a second worth of bytes at a time to keep the rate smooth
+	for _, rate := range rates {
+		if chunk := int(rate / 10); rate > 0 && (w.chunk == 0 || chunk < w.chunk) {
+			w.chunk = chunk
+		}
+	}
+	if w.chunk < 1 {
+		w.chunk = 1
+	}
+	return w, release
+}
+
+func (s *Static) acquireBandwidth(client string, now time.Time) *bandwidthBucket {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	b, ok := s.bandwidth[client]
+	if !ok {
+		if s.bandwidth == nil {
+			s.bandwidth = make(map[string]*bandwidthBucket)
+		}
+		if len(s.bandwidth) >= maxBandwidthClients {
+			s.pruneBandwidth(now)
+		}
+		b = newBandwidthBucket(s.ClientBytesPerSecond, s.BandwidthBurst, now)
+		s.bandwidth[client] = b
+	}
+	b.active++
+	return b
+}
+
+func (s *Static) releaseBandwidth(client string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if b, ok := s.bandwidth[client]; ok {
+		b.active--
+	}
+}
+
+// pruneBandwidth forgets the clients without downloads whose bucket refilled, which are
+// in the state of a new client.
+func (s *Static) pruneBandwidth(now time.Time) {
+	for client, b := range s.bandwidth {
+		if b.refill(now); b.active == 0 && b.tokens >= b.burst {
+			delete(s.bandwidth, client)
+		}
+	}
+}
+
+// throttledWriter writes to the ResponseWriter at the rates of its buckets.
+type throttledWriter struct {
+	http.ResponseWriter
+	s        *Static
+	response *bandwidthBucket
+	client   *bandwidthBucket
+	chunk    int
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > w.chunk {
+			chunk = chunk[:w.chunk]
+		}
+		time.Sleep(w.wait(len(chunk)))
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// wait reserves n bytes in the buckets and returns how long to wait before writing them.
+func (w *throttledWriter) wait(n int) time.Duration {
+	now := time.Now()
+	var wait time.Duration
+	if w.response != nil {
+		wait = w.response.reserve(n, now)
+	}
+	if w.client != nil {
+		w.s.mtx.Lock()
+		if d := w.client.reserve(n, now); d > wait {
+			wait = d
+		}
+		w.s.mtx.Unlock()
+	}
+	return wait
+}
@@ -0,0 +1,62 @@
+package camillo
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestStaticBandwidth(t *testing.T) {
+	s := NewStaticFS(fstest.MapFS{"big.bin": {Data: make([]byte, 300)}})
+	s.BytesPerSecond = 1000
+	n := New(s)
+
+	start := time.Now()
+	response := httptest.NewRecorder()
+	n.ServeHTTP(response, httptest.NewRequest("GET", "http://localhost:3000/big.bin", nil))
+	expect(t, response.Body.Len(), 300)
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("Expected sending 300 bytes at 1000 B/s to take at least 250ms, took %s", elapsed)
+	}
+}
+
+func TestStaticClientBandwidth(t *testing.T) {
+	s := NewStaticFS(fstest.MapFS{"big.bin": {Data: make([]byte, 200)}})
+	s.ClientBytesPerSecond = 1000
+	s.BandwidthBurst = 200
+	n := New(s)
+
+	download := func(remoteAddr string) time.Duration {
+		start := time.Now()
+		response := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:3000/big.bin", nil)
+		req.RemoteAddr = remoteAddr
+		n.ServeHTTP(response, req)
+		expect(t, bytes.Equal(response.Body.Bytes(), make([]byte, 200)), true)
+		return time.Since(start)
+	}
+
+	// the burst is sent at full speed, then the client shares its rate
+	if elapsed := download("10.0.0.1:1234"); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected the burst to be sent at once, took %s", elapsed)
+	}
+	if elapsed := download("10.0.0.1:5678"); elapsed < 150*time.Millisecond {
+		t.Errorf("Expected sending 200 more bytes at 1000 B/s to take at least 150ms, took %s", elapsed)
+	}
+	if elapsed := download("10.0.0.2:1234"); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected the burst of another client to be sent at once, took %s", elapsed)
+	}
+}
+
+func TestBandwidthBucket(t *testing.T) {
+	now := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	b := newBandwidthBucket(100, 50, now)
+
+	expect(t, b.reserve(50, now), time.Duration(0))
+	expect(t, b.reserve(10, now), 100*time.Millisecond)
+	// the debt is paid before the bucket refills
+	expect(t, b.reserve(10, now.Add(100*time.Millisecond)), 100*time.Millisecond)
+	expect(t, b.reserve(50, now.Add(time.Hour)), time.Duration(0))
+}
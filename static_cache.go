@@ -0,0 +1,93 @@
+package camillo
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// StaticETag selects the entity tags Static sends with the files.
+type StaticETag int
+
+const (
+	// StaticETagNone sends no entity tags, leaving Last-Modified for conditional requests.
+	StaticETagNone StaticETag = iota
+	// StaticETagWeak sends weak entity tags derived from the size and the modification
+	// time of the files, which are free to compute.
+	StaticETagWeak
+	// StaticETagStrong sends strong entity tags derived from a hash of the contents of the
+	// files. The hashes are cached until the size or the modification time changes.
+	StaticETagStrong
+)
+
+// StaticCachePolicy is the Cache-Control header of the files whose name matches Pattern,
+// a path.Match pattern matched against the base name of the files, or against their path
+// when it contains a slash. For example fingerprinted files can be cached forever while
+// the HTML pages are revalidated:
+//
+//	s.CachePolicies = []camillo.StaticCachePolicy{
+//		{Pattern: "*.????????.*", CacheControl: "public, max-age=31536000, immutable"},
+//		{Pattern: "*.html", CacheControl: "no-cache"},
+//	}
+type StaticCachePolicy struct {
+	Pattern      string
+	CacheControl string
+}
+
+// staticHash is a cached content hash of a file.
+type staticHash struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// cacheControl returns the Cache-Control header of the first policy matching name.
+func (s *Static) cacheControl(name string) string {
+	for _, policy := range s.CachePolicies {
+		subject := path.Base(name)
+		if strings.Contains(policy.Pattern, "/") {
+			subject = name
+		}
+		if ok, _ := path.Match(policy.Pattern, subject); ok {
+			return policy.CacheControl
+		}
+	}
+	return ""
+}
+
+// etag returns the entity tag of the file name, or "" when there is none.
+func (s *Static) etag(name string, f http.File, fi os.FileInfo) string {
+	switch s.ETag {
+	case StaticETagWeak:
+		return fmt.Sprintf(`W/"%x-%x"`, fi.Size(), fi.ModTime().UnixNano())
+	case StaticETagStrong:
+		s.mtx.Lock()
+		cached, ok := s.hashes[name]
+		s.mtx.Unlock()
+		if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+			return cached.etag
+		}
+
+		h := sha256.New()
+		_, err := io.Copy(h, f)
+		if _, seekErr := f.Seek(0, io.SeekStart); err != nil || seekErr != nil {
+			return ""
+		}
+		etag := `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16]) + `"`
+
+		s.mtx.Lock()
+		if s.hashes == nil {
+			s.hashes = make(map[string]staticHash)
+		}
+		s.hashes[name] = staticHash{size: fi.Size(), modTime: fi.ModTime(), etag: etag}
+		s.mtx.Unlock()
+		return etag
+	}
+	return ""
+}
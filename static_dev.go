@@ -0,0 +1,83 @@
+package camillo
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Watch polls the files of s every interval until ctx is done, and calls onChange with the
+// name of every file that was added, modified or removed, such as "/css/app.css". It is
+// the hook for reloading the browsers in development, for example by pushing an event to
+// a live reload script:
+//
+//	go s.Watch(ctx, 500*time.Millisecond, func(name string) {
+//		reload.Broadcast(name)
+//	})
+func (s *Static) Watch(ctx context.Context, interval time.Duration, onChange func(name string)) {
+	root := path.Join("/", s.Root)
+	files := s.scan(root)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := s.scan(root)
+		var changed []string
+		for name, fi := range current {
+			if old, ok := files[name]; !ok || old.Size() != fi.Size() || !old.ModTime().Equal(fi.ModTime()) {
+				changed = append(changed, name)
+			}
+		}
+		for name := range files {
+			if _, ok := current[name]; !ok {
+				changed = append(changed, name)
+			}
+		}
+		sort.Strings(changed)
+		for _, name := range changed {
+			onChange(strings.TrimPrefix(name, strings.TrimSuffix(root, "/")))
+		}
+		files = current
+	}
+}
+
+// scan returns the files under dir by name, without the dot files.
+func (s *Static) scan(dir string) map[string]os.FileInfo {
+	files := make(map[string]os.FileInfo)
+	var walk func(name string, f http.File)
+	walk = func(name string, f http.File) {
+		infos, err := f.Readdir(-1)
+		if err != nil {
+			return
+		}
+		for _, fi := range infos {
+			if strings.HasPrefix(fi.Name(), ".") {
+				continue
+			}
+			child := path.Join(name, fi.Name())
+			if !fi.IsDir() {
+				files[child] = fi
+				continue
+			}
+			if sub, err := s.Dir.Open(child); err == nil {
+				walk(child, sub)
+				sub.Close()
+			}
+		}
+	}
+	if f, err := s.Dir.Open(dir); err == nil {
+		walk(dir, f)
+		f.Close()
+	}
+	return files
+}
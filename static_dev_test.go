@@ -0,0 +1,71 @@
+package camillo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestStaticDevelopment(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "app.css"), []byte("body {}"), 0644)
+
+	dir := &countingFS{FileSystem: http.Dir(root)}
+	s := NewStatic(dir)
+	s.Development = true
+	s.Cache = NewStaticCache(1024)
+	s.CachePolicies = []StaticCachePolicy{{Pattern: "*.css", CacheControl: "max-age=3600"}}
+
+	n := New(s)
+	serve := func() *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		n.ServeHTTP(response, httptest.NewRequest("GET", "http://localhost:3000/app.css", nil))
+		return response
+	}
+
+	expect(t, serve().Header().Get("Cache-Control"), "no-store")
+	opened := dir.opened
+	serve()
+	refute(t, dir.opened, opened)
+}
+
+func TestStaticWatch(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "css"), 0755)
+	os.WriteFile(filepath.Join(root, "css", "app.css"), []byte("body {}"), 0644)
+	os.WriteFile(filepath.Join(root, "app.js"), []byte("console.log(1)"), 0644)
+
+	s := NewStatic(http.Dir(root))
+	changes := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Watch(ctx, 10*time.Millisecond, func(name string) {
+		changes <- name
+	})
+
+	// let Watch take its first snapshot
+	time.Sleep(50 * time.Millisecond)
+	os.WriteFile(filepath.Join(root, "css", "app.css"), []byte("body { color: red }"), 0644)
+	expectChange(t, changes, "/css/app.css")
+
+	os.Remove(filepath.Join(root, "app.js"))
+	expectChange(t, changes, "/app.js")
+
+	os.WriteFile(filepath.Join(root, ".swp"), []byte("x"), 0644)
+	os.WriteFile(filepath.Join(root, "new.html"), []byte("new"), 0644)
+	expectChange(t, changes, "/new.html")
+}
+
+func expectChange(t *testing.T, changes chan string, name string) {
+	select {
+	case changed := <-changes:
+		expect(t, changed, name)
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected a change of %s", name)
+	}
+}
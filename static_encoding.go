@@ -0,0 +1,79 @@
+package camillo
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// staticEncodings are the content codings of the precompressed variants, by preference,
+// with the suffix of their files.
+var staticEncodings = []struct {
+	coding, suffix string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// staticVariant is an open precompressed variant of a file.
+type staticVariant struct {
+	name   string
+	coding string
+	file   http.File
+	info   os.FileInfo
+}
+
+// openPrecompressed opens the precompressed variant of name that the client accepts, or
+// returns nil. vary is set when name has variants, so caches key the response on
+// Accept-Encoding.
+func (s *Static) openPrecompressed(r *http.Request, name string) (v *staticVariant, vary bool) {
+	// the content type of the variants comes from the extension of name
+	if s.contentType(name) == "" {
+		return nil, false
+	}
+	accept := r.Header.Get("Accept-Encoding")
+	for _, enc := range staticEncodings {
+		variant, err := s.Dir.Open(name + enc.suffix)
+		if err != nil {
+			continue
+		}
+		vi, err := variant.Stat()
+		if err != nil || vi.IsDir() {
+			variant.Close()
+			continue
+		}
+		vary = true
+		if v == nil && acceptsEncoding(accept, enc.coding) {
+			v = &staticVariant{name: name + enc.suffix, coding: enc.coding, file: variant, info: vi}
+			continue
+		}
+		variant.Close()
+	}
+	return v, vary
+}
+
+// acceptsEncoding returns whether the Accept-Encoding header accepts the content coding.
+func acceptsEncoding(header, coding string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name != coding && name != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		if name == coding {
+			// an explicit coding overrides the wildcard
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
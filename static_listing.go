@@ -0,0 +1,76 @@
+package camillo
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var staticListing = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<ul>
+{{if not .Root}}<li><a href="../">../</a></li>
+{{end}}{{range .Entries}}<li><a href="{{.URL}}">{{.Name}}{{if .Dir}}/{{end}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// staticEntry is an entry of a generated directory listing.
+type staticEntry struct {
+	Name    string    `json:"name"`
+	URL     string    `json:"-"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Dir     bool      `json:"dir"`
+}
+
+// serveListing writes the listing of dir as HTML, or as JSON to the clients accepting it.
+// The listings of the root have no link to the parent directory.
+func (s *Static) serveListing(rw http.ResponseWriter, r *http.Request, dir http.File, root bool) {
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		http.Error(rw, "Error reading directory", http.StatusInternalServerError)
+		return
+	}
+	entries := []staticEntry{}
+	for _, fi := range infos {
+		name := fi.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		u := url.URL{Path: name}
+		if fi.IsDir() {
+			u.Path += "/"
+		}
+		entries = append(entries, staticEntry{Name: name, URL: u.String(), Size: fi.Size(), ModTime: fi.ModTime(), Dir: fi.IsDir()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	// render the listing first for the Content-Length of the HEAD requests
+	var body bytes.Buffer
+	if negotiate(r.Header.Get("Accept"), "text/html", "application/json") == "application/json" {
+		rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(&body).Encode(entries)
+	} else {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		staticListing.Execute(&body, struct {
+			Path    string
+			Root    bool
+			Entries []staticEntry
+		}{r.URL.Path, root, entries})
+	}
+	rw.Header().Set("Content-Length", strconv.Itoa(body.Len()))
+	if r.Method != "HEAD" {
+		rw.Write(body.Bytes())
+	}
+}
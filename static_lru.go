@@ -0,0 +1,94 @@
+package camillo
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// StaticCache is an in memory LRU cache of the small files served by a Static, with their
+// headers, so hot files like favicons and stylesheets are served without opening them.
+// The files are served from the cache until TTL elapses, so changes on disk are picked up
+// after at most TTL. A StaticCache must not be shared by several Static.
+type StaticCache struct {
+	// MaxFileSize is the size of the largest files that are cached
+	MaxFileSize int64
+	// MaxSize is the total size of the cached files
+	MaxSize int64
+	// TTL is how long a file is served from the cache
+	TTL time.Duration
+	// Clock is the source of time, the system clock when nil.
+	Clock Clock
+
+	mtx     sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// staticCacheEntry is a cached response of a Static.
+type staticCacheEntry struct {
+	key     string
+	name    string
+	modTime time.Time
+	header  map[string]string
+	body    []byte
+	expires time.Time
+}
+
+// NewStaticCache returns a new StaticCache holding up to maxSize bytes of files of at
+// most 64KB for a minute.
+func NewStaticCache(maxSize int64) *StaticCache {
+	return &StaticCache{
+		MaxFileSize: 1024 * 64,
+		MaxSize:     maxSize,
+		TTL:         time.Minute,
+	}
+}
+
+func (c *StaticCache) get(key string) (*staticCacheEntry, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*staticCacheEntry)
+	if !clockNow(c.Clock).Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+func (c *StaticCache) put(e *staticCacheEntry) {
+	size := int64(len(e.body))
+	if size > c.MaxFileSize || size > c.MaxSize {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.entries == nil {
+		c.lru = list.New()
+		c.entries = make(map[string]*list.Element)
+	}
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	e.expires = clockNow(c.Clock).Add(c.TTL)
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += size
+	for c.size > c.MaxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *StaticCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*staticCacheEntry)
+	delete(c.entries, e.key)
+	c.size -= int64(len(e.body))
+}
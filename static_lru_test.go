@@ -0,0 +1,62 @@
+package camillo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestStaticCache(t *testing.T) {
+	clock := NewManualClock(time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC))
+	dir := &countingFS{FileSystem: http.FS(fstest.MapFS{
+		"favicon.ico": {Data: []byte("icon")},
+		"style.css":   {Data: []byte("body {}")},
+		"big.js":      {Data: []byte("console.log('a big file')")},
+	})}
+	s := NewStatic(dir)
+	s.Cache = NewStaticCache(10)
+	s.Cache.MaxFileSize = 10
+	s.Cache.Clock = clock
+	s.CachePolicies = []StaticCachePolicy{{Pattern: "*.ico", CacheControl: "max-age=86400"}}
+
+	n := New(s)
+	serve := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	serve("/favicon.ico")
+	opened := dir.opened
+	response := serve("/favicon.ico")
+	expect(t, dir.opened, opened)
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Body.String(), "icon")
+	expect(t, response.Header().Get("Cache-Control"), "max-age=86400")
+	expect(t, response.Header().Get("Content-Type"), "image/vnd.microsoft.icon")
+	refute(t, response.Header().Get("ETag"), "")
+
+	// files larger than MaxFileSize aren't cached
+	serve("/big.js")
+	opened = dir.opened
+	serve("/big.js")
+	refute(t, dir.opened, opened)
+
+	// the least recently used files are evicted beyond MaxSize
+	serve("/style.css")
+	opened = dir.opened
+	serve("/favicon.ico")
+	refute(t, dir.opened, opened)
+	serve("/favicon.ico")
+	opened = dir.opened
+	serve("/favicon.ico")
+	expect(t, dir.opened, opened)
+
+	// the files expire after TTL
+	clock.Advance(time.Minute)
+	serve("/favicon.ico")
+	refute(t, dir.opened, opened)
+}
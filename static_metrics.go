@@ -0,0 +1,152 @@
+package camillo
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// StaticMetrics counts the requests answered by Static, for example in Prometheus or
+// expvar counters and histograms, so the assets traffic can be told apart from the
+// application traffic. StaticCounter is an in memory implementation.
+type StaticMetrics interface {
+	// FileServed is called for every request answered by Static, but not for the ones
+	// passed to the next handler, with the status and the number of body bytes of the
+	// response, the time it took and the Cache lookup: "hit", "miss", or "" when the Cache
+	// wasn't looked up.
+	FileServed(status int, bytes int64, duration time.Duration, cache string)
+}
+
+// StaticLatencyBuckets are the upper bounds of the latency histogram of StaticCounter.
+var StaticLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+}
+
+// StaticCounts is a snapshot of the counts of a StaticCounter.
+type StaticCounts struct {
+	// Hits are the files served, in full or in part.
+	Hits uint64 `json:"hits"`
+	// NotModified are the conditional requests answered with a 304.
+	NotModified uint64 `json:"not_modified"`
+	// Errors are the requests answered with a 4xx or 5xx status.
+	Errors      uint64 `json:"errors"`
+	Bytes       uint64 `json:"bytes"`
+	CacheHits   uint64 `json:"cache_hits"`
+	CacheMisses uint64 `json:"cache_misses"`
+	// CacheHitRatio is the ratio of the Cache lookups that were hits, 0 without lookups.
+	CacheHitRatio float64 `json:"cache_hit_ratio"`
+	// Latency is the cumulative histogram of the durations by upper bound, such as "10ms",
+	// and "+Inf" for all of them.
+	Latency map[string]uint64 `json:"latency"`
+}
+
+// StaticCounter is a StaticMetrics counting the requests in memory.
+type StaticCounter struct {
+	mtx         sync.Mutex
+	hits        uint64
+	notModified uint64
+	errors      uint64
+	bytes       uint64
+	cacheHits   uint64
+	cacheMisses uint64
+	// latency are the counts by bucket of StaticLatencyBuckets, and beyond the last one
+	latency []uint64
+}
+
+// NewStaticCounter returns a new instance of StaticCounter
+func NewStaticCounter() *StaticCounter {
+	return &StaticCounter{latency: make([]uint64, len(StaticLatencyBuckets)+1)}
+}
+
+// FileServed counts a request.
+func (c *StaticCounter) FileServed(status int, bytes int64, duration time.Duration, cache string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	switch {
+	case status == http.StatusNotModified:
+		c.notModified++
+	case status >= 400:
+		c.errors++
+	case status >= 200 && status < 300:
+		c.hits++
+	}
+	c.bytes += uint64(bytes)
+	switch cache {
+	case "hit":
+		c.cacheHits++
+	case "miss":
+		c.cacheMisses++
+	}
+	bucket := len(StaticLatencyBuckets)
+	for i, bound := range StaticLatencyBuckets {
+		if duration <= bound {
+			bucket = i
+			break
+		}
+	}
+	c.latency[bucket]++
+}
+
+// Counts returns a snapshot of the counts.
+func (c *StaticCounter) Counts() StaticCounts {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	counts := StaticCounts{
+		Hits:        c.hits,
+		NotModified: c.notModified,
+		Errors:      c.errors,
+		Bytes:       c.bytes,
+		CacheHits:   c.cacheHits,
+		CacheMisses: c.cacheMisses,
+		Latency:     make(map[string]uint64, len(c.latency)),
+	}
+	if lookups := c.cacheHits + c.cacheMisses; lookups > 0 {
+		counts.CacheHitRatio = float64(c.cacheHits) / float64(lookups)
+	}
+	var total uint64
+	for i, bound := range StaticLatencyBuckets {
+		total += c.latency[i]
+		counts.Latency[bound.String()] = total
+	}
+	counts.Latency["+Inf"] = total + c.latency[len(StaticLatencyBuckets)]
+	return counts
+}
+
+// staticMetricsWriter records the status and the size of the responses of Static.
+type staticMetricsWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (w *staticMetricsWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *staticMetricsWriter) Write(p []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *staticMetricsWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
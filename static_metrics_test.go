@@ -0,0 +1,65 @@
+package camillo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestStaticMetrics(t *testing.T) {
+	modTime := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := NewStaticFS(fstest.MapFS{"app.css": {Data: []byte("body {}"), ModTime: modTime}})
+	s.Cache = NewStaticCache(1024)
+	metrics := NewStaticCounter()
+	s.Metrics = metrics
+
+	n := New(s)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:3000"+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	expect(t, serve("/app.css", nil).Code, http.StatusOK)
+	etag := serve("/app.css", nil).Header().Get("ETag")
+	expect(t, serve("/app.css", http.Header{"If-None-Match": {etag}}).Code, http.StatusNotModified)
+	// the requests passed to the next handler are not counted
+	expect(t, serve("/missing.css", nil).Code, http.StatusTeapot)
+	expect(t, serve("/.env", nil).Code, http.StatusTeapot)
+
+	counts := metrics.Counts()
+	expect(t, counts.Hits, uint64(2))
+	expect(t, counts.NotModified, uint64(1))
+	expect(t, counts.Errors, uint64(0))
+	expect(t, counts.Bytes, uint64(14))
+	expect(t, counts.CacheHits, uint64(2))
+	expect(t, counts.CacheMisses, uint64(1))
+	expect(t, counts.CacheHitRatio, 2.0/3)
+	expect(t, counts.Latency["+Inf"], uint64(3))
+}
+
+func TestStaticCounterLatency(t *testing.T) {
+	c := NewStaticCounter()
+	c.FileServed(http.StatusOK, 10, 3*time.Millisecond, "")
+	c.FileServed(http.StatusOK, 10, 70*time.Millisecond, "")
+	c.FileServed(http.StatusNotFound, 0, 10*time.Second, "")
+
+	counts := c.Counts()
+	expect(t, counts.Hits, uint64(2))
+	expect(t, counts.Errors, uint64(1))
+	expect(t, counts.CacheHitRatio, 0.0)
+	expect(t, counts.Latency["1ms"], uint64(0))
+	expect(t, counts.Latency["5ms"], uint64(1))
+	expect(t, counts.Latency["100ms"], uint64(2))
+	expect(t, counts.Latency["5s"], uint64(2))
+	expect(t, counts.Latency["+Inf"], uint64(3))
+}
@@ -0,0 +1,55 @@
+package camillo
+
+import (
+	"mime"
+	"path"
+	"strings"
+)
+
+// staticMIMETypes are the content types of the extensions whose system mapping varies or
+// is missing, and breaks module scripts, wasm streaming or modern images.
+var staticMIMETypes = map[string]string{
+	".avif":  "image/avif",
+	".css":   "text/css",
+	".html":  "text/html",
+	".js":    "text/javascript",
+	".json":  "application/json",
+	".mjs":   "text/javascript",
+	".svg":   "image/svg+xml",
+	".wasm":  "application/wasm",
+	".webp":  "image/webp",
+	".woff2": "font/woff2",
+}
+
+// contentType returns the content type of the file name: the one of its extension in
+// MIMETypes, in the built in table, or in the system table, in that order. Charset is
+// added to the textual types without a charset. It is "" when the type is unknown.
+func (s *Static) contentType(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	typ, ok := s.MIMETypes[ext]
+	if !ok {
+		typ, ok = staticMIMETypes[ext]
+	}
+	if !ok {
+		typ = mime.TypeByExtension(ext)
+	}
+	if typ == "" || s.Charset == "" || strings.Contains(typ, "charset=") || !textualType(typ) {
+		return typ
+	}
+	return typ + "; charset=" + s.Charset
+}
+
+// textualType returns whether the content type typ is text that has a charset.
+func textualType(typ string) bool {
+	typ = strings.TrimSpace(strings.SplitN(typ, ";", 2)[0])
+	switch {
+	case strings.HasPrefix(typ, "text/"):
+		return true
+	case typ == "application/javascript", typ == "application/json", typ == "application/xml", typ == "image/svg+xml":
+		return true
+	}
+	return strings.HasSuffix(typ, "+json") || strings.HasSuffix(typ, "+xml")
+}
@@ -0,0 +1,44 @@
+package camillo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStaticMIMETypes(t *testing.T) {
+	s := NewStaticFS(fstest.MapFS{
+		"app.wasm":     {Data: []byte("\x00asm")},
+		"main.mjs":     {Data: []byte("export {}")},
+		"photo.AVIF":   {Data: []byte("avif")},
+		"notes.txt":    {Data: []byte("notes")},
+		"feed.atom":    {Data: []byte("<feed/>")},
+		"data.custom":  {Data: []byte("{}")},
+		"legacy.latin": {Data: []byte("caf\xe9")},
+	})
+	s.MIMETypes = map[string]string{
+		".custom": "application/vnd.example+json",
+		".atom":   "application/atom+xml",
+		".latin":  "text/plain; charset=iso-8859-1",
+	}
+
+	n := New(s)
+	contentType := func(path string) string {
+		response := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		n.ServeHTTP(response, req)
+		return response.Header().Get("Content-Type")
+	}
+
+	expect(t, contentType("/app.wasm"), "application/wasm")
+	expect(t, contentType("/main.mjs"), "text/javascript; charset=utf-8")
+	expect(t, contentType("/photo.AVIF"), "image/avif")
+	expect(t, contentType("/notes.txt"), "text/plain; charset=utf-8")
+	expect(t, contentType("/feed.atom"), "application/atom+xml; charset=utf-8")
+	expect(t, contentType("/data.custom"), "application/vnd.example+json; charset=utf-8")
+	expect(t, contentType("/legacy.latin"), "text/plain; charset=iso-8859-1")
+
+	s.Charset = ""
+	expect(t, contentType("/main.mjs"), "text/javascript")
+}
@@ -0,0 +1,83 @@
+package camillo
+
+import (
+	"net/http"
+	"os"
+	"sort"
+)
+
+// Overlay is an http.FileSystem searching its roots in order until a file is found, such
+// as theme overrides before the default files. It lets a single Static serve overlaid
+// roots:
+//
+//	camillo.NewStatic(camillo.Overlay{http.Dir("themes/dark"), http.Dir("public")})
+//
+// The directories list the files of every root, the first root having a name winning.
+type Overlay []http.FileSystem
+
+// Open opens the first file named name in the roots.
+func (o Overlay) Open(name string) (http.File, error) {
+	var firstErr error
+	for i, root := range o {
+		f, err := root.Open(name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if fi, err := f.Stat(); err == nil && fi.IsDir() {
+			return &overlayDir{File: f, overlay: o[i+1:], name: name}, nil
+		}
+		return f, nil
+	}
+	if firstErr == nil {
+		firstErr = os.ErrNotExist
+	}
+	return nil, firstErr
+}
+
+// overlayDir is a directory of an Overlay, listing the directories of the same name in
+// the later roots too.
+type overlayDir struct {
+	http.File
+	overlay Overlay
+	name    string
+	listed  bool
+}
+
+func (d *overlayDir) Readdir(count int) ([]os.FileInfo, error) {
+	if count > 0 {
+		// paging through a merged listing isn't supported, so only the first root is paged
+		return d.File.Readdir(count)
+	}
+	if d.listed {
+		return nil, nil
+	}
+	d.listed = true
+
+	infos, err := d.File.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(infos))
+	for _, fi := range infos {
+		seen[fi.Name()] = true
+	}
+	for _, root := range d.overlay {
+		f, err := root.Open(d.name)
+		if err != nil {
+			continue
+		}
+		more, _ := f.Readdir(-1)
+		f.Close()
+		for _, fi := range more {
+			if !seen[fi.Name()] {
+				seen[fi.Name()] = true
+				infos = append(infos, fi)
+			}
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
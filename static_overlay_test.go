@@ -0,0 +1,44 @@
+package camillo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOverlay(t *testing.T) {
+	theme := http.FS(fstest.MapFS{
+		"style.css":    {Data: []byte("dark")},
+		"img/logo.png": {Data: []byte("dark logo")},
+	})
+	defaults := http.FS(fstest.MapFS{
+		"style.css":       {Data: []byte("light")},
+		"app.js":          {Data: []byte("console.log(1)")},
+		"img/icon.png":    {Data: []byte("icon")},
+		"img/logo.png":    {Data: []byte("light logo")},
+		"docs/index.html": {Data: []byte("docs")},
+	})
+	s := NewStatic(Overlay{theme, defaults})
+	s.Listing = true
+
+	n := New(s)
+	n.UseHandler(http.NotFoundHandler())
+	serve := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	expect(t, serve("/style.css").Body.String(), "dark")
+	expect(t, serve("/app.js").Body.String(), "console.log(1)")
+	expect(t, serve("/img/logo.png").Body.String(), "dark logo")
+	expect(t, serve("/docs/").Body.String(), "docs")
+	expect(t, serve("/missing.txt").Code, http.StatusNotFound)
+
+	body := serve("/img/").Body.String()
+	expect(t, strings.Contains(body, "icon.png"), true)
+	expect(t, strings.Count(body, "logo.png</a>"), 1)
+}
@@ -0,0 +1,62 @@
+package camillo
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// serveContent serves content with http.ServeContent, which answers the Range requests
+// with 206 partial responses, multipart for several ranges. The Range requests beyond
+// MaxRangeRequests of the client are answered with a 429, and the content is sent at the
+// rates of the bandwidth limits.
+func (s *Static) serveContent(rw http.ResponseWriter, r *http.Request, name string, modTime time.Time, content io.ReadSeeker) {
+	if s.MaxRangeRequests > 0 && r.Header.Get("Range") != "" {
+		client := s.rangeClient(r)
+		if !s.acquireRange(client) {
+			rw.Header().Set("Retry-After", "1")
+			http.Error(rw, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		defer s.releaseRange(client)
+	}
+	rw, release := s.throttle(rw, r)
+	defer release()
+	http.ServeContent(rw, r, name, modTime, content)
+}
+
+// rangeClient identifies the client of r for MaxRangeRequests and ClientBytesPerSecond.
+func (s *Static) rangeClient(r *http.Request) string {
+	if s.ClientIP != nil {
+		return s.ClientIP.Resolve(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (s *Static) acquireRange(client string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.ranges[client] >= s.MaxRangeRequests {
+		return false
+	}
+	if s.ranges == nil {
+		s.ranges = make(map[string]int)
+	}
+	s.ranges[client]++
+	return true
+}
+
+func (s *Static) releaseRange(client string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.ranges[client]--; s.ranges[client] <= 0 {
+		delete(s.ranges, client)
+	}
+}
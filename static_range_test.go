@@ -0,0 +1,146 @@
+package camillo
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestStaticRanges(t *testing.T) {
+	modTime := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := NewStaticFS(fstest.MapFS{
+		"video.txt": {Data: []byte("0123456789abcdefghij"), ModTime: modTime},
+	})
+
+	var status, size int
+	n := New()
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		next(ctx, rw, r)
+		res := rw.(ResponseWriter)
+		status, size = res.Status(), res.Size()
+	})
+	n.Use(s)
+	serve := func(header http.Header) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:3000/video.txt", nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	response := serve(nil)
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Header().Get("Accept-Ranges"), "bytes")
+
+	response = serve(http.Header{"Range": {"bytes=10-"}})
+	expect(t, response.Code, http.StatusPartialContent)
+	expect(t, response.Header().Get("Content-Range"), "bytes 10-19/20")
+	expect(t, response.Body.String(), "abcdefghij")
+	expect(t, status, http.StatusPartialContent)
+	expect(t, size, 10)
+
+	response = serve(http.Header{"Range": {"bytes=0-1,5-6"}})
+	expect(t, response.Code, http.StatusPartialContent)
+	mediaType, params, err := mime.ParseMediaType(response.Header().Get("Content-Type"))
+	expect(t, err, nil)
+	expect(t, mediaType, "multipart/byteranges")
+	reader := multipart.NewReader(response.Body, params["boundary"])
+	var parts []string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		b, _ := io.ReadAll(part)
+		parts = append(parts, part.Header.Get("Content-Range")+" "+string(b))
+	}
+	expect(t, strings.Join(parts, ", "), "bytes 0-1/20 01, bytes 5-6/20 56")
+
+	// a resumed download of a file that changed gets the whole file
+	etag := serve(nil).Header().Get("ETag")
+	response = serve(http.Header{"Range": {"bytes=10-"}, "If-Range": {`"stale"`}})
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Body.Len(), 20)
+	response = serve(http.Header{"Range": {"bytes=10-"}, "If-Range": {"Sat, 02 Jan 2016 03:04:05 GMT"}})
+	expect(t, response.Code, http.StatusPartialContent)
+	refute(t, etag, "")
+
+	response = serve(http.Header{"Range": {"bytes=30-40"}})
+	expect(t, response.Code, http.StatusRequestedRangeNotSatisfiable)
+}
+
+// blockingFS serves files whose reads block until release is closed.
+type blockingFS struct {
+	http.FileSystem
+	reading chan struct{}
+	release chan struct{}
+}
+
+type blockingFile struct {
+	http.File
+	fs *blockingFS
+}
+
+func (fs *blockingFS) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &blockingFile{File: f, fs: fs}, nil
+}
+
+func (f *blockingFile) Read(p []byte) (int, error) {
+	select {
+	case f.fs.reading <- struct{}{}:
+	default:
+	}
+	<-f.fs.release
+	return f.File.Read(p)
+}
+
+func TestStaticMaxRangeRequests(t *testing.T) {
+	dir := &blockingFS{
+		FileSystem: http.FS(fstest.MapFS{"video.txt": {Data: []byte("0123456789")}}),
+		reading:    make(chan struct{}, 1),
+		release:    make(chan struct{}),
+	}
+	s := NewStatic(dir)
+	s.ETag = StaticETagNone
+	s.MaxRangeRequests = 1
+
+	n := New(s)
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:3000/video.txt", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Range", "bytes=2-")
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serve("192.0.2.1:1234")
+	}()
+	<-dir.reading
+
+	response := serve("192.0.2.1:5678")
+	expect(t, response.Code, http.StatusTooManyRequests)
+	expect(t, response.Header().Get("Retry-After"), "1")
+
+	close(dir.release)
+	expect(t, serve("192.0.2.2:1234").Code, http.StatusPartialContent)
+	expect(t, (<-done).Code, http.StatusPartialContent)
+	// the slot is released once the response is served
+	expect(t, serve("192.0.2.1:5678").Code, http.StatusPartialContent)
+}
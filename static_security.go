@@ -0,0 +1,78 @@
+package camillo
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// unsafePath returns whether the path of r tries to climb out of the served directory,
+// with ".." segments, backslashes or NUL bytes, encoded or not, or with encoded slashes.
+func unsafePath(r *http.Request) bool {
+	escaped := strings.ToLower(r.URL.EscapedPath())
+	for _, seq := range []string{"%2f", "%5c", "%00"} {
+		if strings.Contains(escaped, seq) {
+			return true
+		}
+	}
+	if strings.ContainsAny(r.URL.Path, "\\\x00") {
+		return true
+	}
+	for _, segment := range strings.Split(r.URL.Path, "/") {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// dotFile returns whether a segment of name starts with a dot and isn't in allowed.
+func dotFile(name string, allowed []string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if !strings.HasPrefix(segment, ".") {
+			continue
+		}
+		ok := false
+		for _, a := range allowed {
+			if segment == a {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfinedDir returns an http.FileSystem serving the files of the directory dir, like
+// http.Dir, that refuses to open the files whose path resolves outside of dir through
+// symbolic links.
+func ConfinedDir(dir string) http.FileSystem {
+	return confinedDir(dir)
+}
+
+type confinedDir string
+
+func (d confinedDir) Open(name string) (http.File, error) {
+	root, err := filepath.EvalSymlinks(string(d))
+	if err != nil {
+		return nil, err
+	}
+	f, err := http.Dir(d).Open(name)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(string(d), filepath.FromSlash(filepath.Clean("/"+name))))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		f.Close()
+		return nil, os.ErrPermission
+	}
+	return f, nil
+}
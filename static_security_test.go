@@ -0,0 +1,99 @@
+package camillo
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStaticTraversal(t *testing.T) {
+	s := NewStaticFS(fstest.MapFS{
+		"public/app.js": {Data: []byte("console.log(1)")},
+		"public/%2e%2e": {Data: []byte("literal")},
+		"secret.txt":    {Data: []byte("secret")},
+	})
+	s.Root = "public"
+
+	n := New(s)
+	n.UseHandler(http.NotFoundHandler())
+	serve := func(rawPath string) int {
+		response := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:3000"+rawPath, nil)
+		n.ServeHTTP(response, req)
+		return response.Code
+	}
+
+	expect(t, serve("/app.js"), http.StatusOK)
+	for _, p := range []string{
+		"/../secret.txt",
+		"/%2e%2e/secret.txt",
+		"/%2E%2E/secret.txt",
+		"/..%2fsecret.txt",
+		"/..%2Fsecret.txt",
+		"/..%5csecret.txt",
+		"/..\\secret.txt",
+		"/app.js%00.png",
+		"/a/%2e%2e/%2e%2e/secret.txt",
+	} {
+		if code := serve(p); code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected - Got %d", p, code)
+		}
+	}
+	// double encoding is a literal name, not a traversal
+	expect(t, serve("/%252e%252e"), http.StatusOK)
+	expect(t, serve("/%252e%252e/secret.txt"), http.StatusNotFound)
+}
+
+func TestStaticDotFiles(t *testing.T) {
+	s := NewStaticFS(fstest.MapFS{
+		".env":                     {Data: []byte("SECRET=1")},
+		".git/config":              {Data: []byte("[core]")},
+		".well-known/security.txt": {Data: []byte("Contact: me")},
+		"assets/.hidden/app.js":    {Data: []byte("hidden")},
+	})
+	s.AllowDotFiles = []string{".well-known"}
+
+	n := New(s)
+	n.UseHandler(http.NotFoundHandler())
+	serve := func(path string) int {
+		response := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:3000"+path, nil)
+		n.ServeHTTP(response, req)
+		return response.Code
+	}
+
+	expect(t, serve("/.env"), http.StatusNotFound)
+	expect(t, serve("/.git/config"), http.StatusNotFound)
+	expect(t, serve("/assets/.hidden/app.js"), http.StatusNotFound)
+	expect(t, serve("/.well-known/security.txt"), http.StatusOK)
+}
+
+func TestConfinedDir(t *testing.T) {
+	outside := t.TempDir()
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644)
+	os.WriteFile(filepath.Join(root, "app.js"), []byte("console.log(1)"), 0644)
+	os.Mkdir(filepath.Join(root, "lib"), 0755)
+	os.WriteFile(filepath.Join(root, "lib", "dep.js"), []byte("dep"), 0644)
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "secret.txt")); err != nil {
+		t.Skip("symbolic links are not supported:", err)
+	}
+	os.Symlink(filepath.Join(root, "lib", "dep.js"), filepath.Join(root, "dep.js"))
+
+	serve := func(dir http.FileSystem, path string) int {
+		n := New(NewStatic(dir))
+		n.UseHandler(http.NotFoundHandler())
+		response := httptest.NewRecorder()
+		n.ServeHTTP(response, httptest.NewRequest("GET", "http://localhost:3000"+path, nil))
+		return response.Code
+	}
+
+	expect(t, serve(http.Dir(root), "/secret.txt"), http.StatusOK)
+	expect(t, serve(ConfinedDir(root), "/secret.txt"), http.StatusNotFound)
+	expect(t, serve(ConfinedDir(root), "/app.js"), http.StatusOK)
+	expect(t, serve(ConfinedDir(root), "/dep.js"), http.StatusOK)
+	expect(t, serve(ConfinedDir(root), "/missing.js"), http.StatusNotFound)
+}
@@ -2,9 +2,18 @@ package camillo
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"golang.org/x/net/context"
 )
 
 func TestStatic(t *testing.T) {
@@ -62,6 +71,53 @@ func TestStaticAsPost(t *testing.T) {
 	expect(t, response.Code, http.StatusNotFound)
 }
 
+func TestStaticMethods(t *testing.T) {
+	s := NewStaticFS(fstest.MapFS{
+		"app.css":     {Data: []byte("body {}")},
+		"files/a.txt": {Data: []byte("a")},
+	})
+	s.Listing = true
+
+	n := New(s)
+	n.UseHandler(http.NotFoundHandler())
+	serve := func(method, path string, header http.Header) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req := httptest.NewRequest(method, "http://localhost:3000"+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	response := serve("HEAD", "/app.css", nil)
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Header().Get("Content-Length"), "7")
+	expect(t, response.Body.Len(), 0)
+
+	listing := serve("GET", "/files/", nil)
+	response = serve("HEAD", "/files/", nil)
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Header().Get("Content-Length"), fmt.Sprint(listing.Body.Len()))
+	expect(t, response.Body.Len(), 0)
+
+	response = serve("OPTIONS", "/app.css", nil)
+	expect(t, response.Code, http.StatusNoContent)
+	expect(t, response.Header().Get("Allow"), "GET, HEAD, OPTIONS")
+	expect(t, serve("OPTIONS", "/missing.css", nil).Code, http.StatusNotFound)
+	// CORS preflights are left to the CORS middleware
+	expect(t, serve("OPTIONS", "/app.css", http.Header{"Access-Control-Request-Method": {"GET"}}).Code, http.StatusNotFound)
+
+	s.Methods = []string{"GET"}
+	expect(t, serve("GET", "/app.css", nil).Code, http.StatusOK)
+	expect(t, serve("HEAD", "/app.css", nil).Code, http.StatusNotFound)
+	expect(t, serve("OPTIONS", "/app.css", nil).Code, http.StatusNotFound)
+
+	s.Methods = []string{"GET", "OPTIONS", "DELETE"}
+	expect(t, serve("OPTIONS", "/app.css", nil).Header().Get("Allow"), "GET, OPTIONS")
+	expect(t, serve("DELETE", "/app.css", nil).Code, http.StatusNotFound)
+}
+
 func TestStaticBadDir(t *testing.T) {
 	response := httptest.NewRecorder()
 
@@ -111,3 +167,319 @@ func TestStaticOptionsPrefix(t *testing.T) {
 	n.ServeHTTP(response, req)
 	expect(t, response.Code, http.StatusOK)
 }
+
+func TestStaticFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"public/app.js":     {Data: []byte("console.log(1)")},
+		"public/index.html": {Data: []byte("<h1>hello</h1>")},
+		"secret.txt":        {Data: []byte("secret")},
+	}
+	s := NewStaticFS(fsys)
+	s.Root = "public"
+
+	n := New(s)
+	n.UseHandler(http.NotFoundHandler())
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	response := serve("/app.js")
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Body.String(), "console.log(1)")
+
+	response = serve("/")
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Body.String(), "<h1>hello</h1>")
+
+	expect(t, serve("/secret.txt").Code, http.StatusNotFound)
+	expect(t, serve("/../secret.txt").Code, http.StatusBadRequest)
+}
+
+func TestStaticIndexFiles(t *testing.T) {
+	s := NewStaticFS(fstest.MapFS{
+		"docs/index.htm": {Data: []byte("docs")},
+	})
+	s.IndexFiles = []string{"default.html", "index.htm"}
+
+	response := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "http://localhost:3000/docs/", nil)
+	New(s).ServeHTTP(response, req)
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Body.String(), "docs")
+}
+
+func TestStaticListing(t *testing.T) {
+	s := NewStaticFS(fstest.MapFS{
+		"files/b.txt":          {Data: []byte("bb")},
+		"files/a <1>.txt":      {Data: []byte("a")},
+		"files/.hidden":        {Data: []byte("x")},
+		"files/sub/c.txt":      {Data: []byte("c")},
+		"private/.nolisting":   {},
+		"private/passwords.db": {Data: []byte("x")},
+	})
+
+	n := New(s)
+	n.UseHandler(http.NotFoundHandler())
+	serve := func(path, accept string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		req.Header.Set("Accept", accept)
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	// listings are opt-in
+	expect(t, serve("/files/", "").Code, http.StatusNotFound)
+
+	s.Listing = true
+	response := serve("/files/", "text/html")
+	body := response.Body.String()
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Header().Get("Content-Type"), "text/html; charset=utf-8")
+	expect(t, strings.Contains(body, `<li><a href="a%20%3C1%3E.txt">a &lt;1&gt;.txt</a></li>`), true)
+	expect(t, strings.Contains(body, `<li><a href="sub/">sub/</a></li>`), true)
+	expect(t, strings.Contains(body, `<a href="../">`), true)
+	expect(t, strings.Contains(body, ".hidden"), false)
+	expect(t, strings.Index(body, "a &lt;1&gt;.txt") < strings.Index(body, "b.txt"), true)
+
+	response = serve("/files/", "application/json")
+	expect(t, response.Header().Get("Content-Type"), "application/json; charset=utf-8")
+	var entries []struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+		Dir  bool   `json:"dir"`
+	}
+	expect(t, json.Unmarshal(response.Body.Bytes(), &entries), nil)
+	expect(t, len(entries), 3)
+	expect(t, entries[1].Name, "b.txt")
+	expect(t, entries[1].Size, int64(2))
+	expect(t, entries[2].Dir, true)
+
+	expect(t, serve("/private/", "").Code, http.StatusNotFound)
+}
+
+// countingFS counts the files opened from a FileSystem.
+type countingFS struct {
+	http.FileSystem
+	opened int
+}
+
+func (fs *countingFS) Open(name string) (http.File, error) {
+	fs.opened++
+	return fs.FileSystem.Open(name)
+}
+
+func TestStaticPrefixMount(t *testing.T) {
+	dir := &countingFS{FileSystem: http.FS(fstest.MapFS{
+		"app.js":     {Data: []byte("console.log(1)")},
+		"img/a.png":  {Data: []byte("png")},
+		"assets.txt": {Data: []byte("not under the prefix")},
+	})}
+	s := NewStatic(dir)
+	s.Prefix = "/assets/"
+	s.Listing = true
+
+	n := New(s)
+	n.UseHandler(http.NotFoundHandler())
+	serve := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	response := serve("/assets/app.js")
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Body.String(), "console.log(1)")
+
+	response = serve("/assets")
+	expect(t, response.Code, http.StatusFound)
+	expect(t, response.Header().Get("Location"), "/assets/")
+
+	response = serve("/assets/")
+	expect(t, response.Code, http.StatusOK)
+	expect(t, strings.Contains(response.Body.String(), `href="../"`), false)
+	expect(t, strings.Contains(serve("/assets/img/").Body.String(), `href="../"`), true)
+
+	opened := dir.opened
+	expect(t, serve("/app.js").Code, http.StatusNotFound)
+	expect(t, serve("/assets.txt").Code, http.StatusNotFound)
+	expect(t, dir.opened, opened)
+}
+
+func TestStaticConditional(t *testing.T) {
+	modTime := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := NewStaticFS(fstest.MapFS{
+		"app.3f9a2c1b.js": {Data: []byte("console.log(1)"), ModTime: modTime},
+		"index.html":      {Data: []byte("<h1>hello</h1>"), ModTime: modTime},
+	})
+	s.CachePolicies = []StaticCachePolicy{
+		{Pattern: "*.????????.*", CacheControl: "public, max-age=31536000, immutable"},
+		{Pattern: "*.html", CacheControl: "no-cache"},
+	}
+
+	n := New(s)
+	serve := func(path string, header http.Header) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	response := serve("/app.3f9a2c1b.js", nil)
+	etag := response.Header().Get("ETag")
+	expect(t, strings.HasPrefix(etag, `W/"e-`), true)
+	expect(t, response.Header().Get("Last-Modified"), "Sat, 02 Jan 2016 03:04:05 GMT")
+	expect(t, response.Header().Get("Cache-Control"), "public, max-age=31536000, immutable")
+
+	response = serve("/app.3f9a2c1b.js", http.Header{"If-None-Match": {etag}})
+	expect(t, response.Code, http.StatusNotModified)
+	expect(t, response.Body.Len(), 0)
+
+	response = serve("/app.3f9a2c1b.js", http.Header{"If-Modified-Since": {"Sat, 02 Jan 2016 03:04:05 GMT"}})
+	expect(t, response.Code, http.StatusNotModified)
+
+	response = serve("/", nil)
+	expect(t, response.Header().Get("Cache-Control"), "no-cache")
+
+	s.ETag = StaticETagStrong
+	response = serve("/index.html", nil)
+	etag = response.Header().Get("ETag")
+	expect(t, etag, `"`+base64.RawURLEncoding.EncodeToString(sha256Sum("<h1>hello</h1>")[:16])+`"`)
+	expect(t, response.Body.String(), "<h1>hello</h1>")
+	expect(t, serve("/index.html", http.Header{"If-None-Match": {etag}}).Code, http.StatusNotModified)
+
+	s.ETag = StaticETagNone
+	expect(t, serve("/index.html", nil).Header().Get("ETag"), "")
+}
+
+func sha256Sum(s string) []byte {
+	sum := sha256.Sum256([]byte(s))
+	return sum[:]
+}
+
+func TestStaticPrecompressed(t *testing.T) {
+	s := NewStaticFS(fstest.MapFS{
+		"app.js":      {Data: []byte("console.log(1)")},
+		"app.js.gz":   {Data: []byte("gzipped")},
+		"app.js.br":   {Data: []byte("brotli")},
+		"style.css":   {Data: []byte("body {}")},
+		"data.qqq":    {Data: []byte("raw")},
+		"data.qqq.gz": {Data: []byte("gzipped")},
+	})
+	s.Precompressed = true
+
+	n := New(s)
+	serve := func(path, accept string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	response := serve("/app.js", "gzip, deflate, br")
+	expect(t, response.Body.String(), "brotli")
+	expect(t, response.Header().Get("Content-Encoding"), "br")
+	expect(t, response.Header().Get("Content-Type"), "text/javascript; charset=utf-8")
+	expect(t, response.Header().Get("Vary"), "Accept-Encoding")
+	brETag := response.Header().Get("ETag")
+
+	response = serve("/app.js", "gzip")
+	expect(t, response.Body.String(), "gzipped")
+	expect(t, response.Header().Get("Content-Encoding"), "gzip")
+	refute(t, response.Header().Get("ETag"), brETag)
+
+	response = serve("/app.js", "*, br;q=0")
+	expect(t, response.Header().Get("Content-Encoding"), "gzip")
+
+	response = serve("/app.js", "")
+	expect(t, response.Body.String(), "console.log(1)")
+	expect(t, response.Header().Get("Content-Encoding"), "")
+	expect(t, response.Header().Get("Vary"), "Accept-Encoding")
+
+	response = serve("/style.css", "gzip")
+	expect(t, response.Body.String(), "body {}")
+	expect(t, response.Header().Get("Vary"), "")
+
+	// variants of files without a known content type aren't served
+	expect(t, serve("/data.qqq", "gzip").Body.String(), "raw")
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	expect(t, acceptsEncoding("gzip, br", "br"), true)
+	expect(t, acceptsEncoding("gzip;q=0.5", "gzip"), true)
+	expect(t, acceptsEncoding("gzip;q=0", "gzip"), false)
+	expect(t, acceptsEncoding("*", "br"), true)
+	expect(t, acceptsEncoding("*;q=0, gzip", "br"), false)
+	expect(t, acceptsEncoding("", "gzip"), false)
+}
+
+func TestStaticFallback(t *testing.T) {
+	s := NewStaticFS(fstest.MapFS{
+		"index.html": {Data: []byte("<div id=app></div>")},
+		"app.js":     {Data: []byte("console.log(1)")},
+	})
+	s.Fallback = "/index.html"
+
+	n := New(s)
+	n.UseHandler(http.NotFoundHandler())
+	serve := func(path, accept string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		req.Header.Set("Accept", accept)
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	response := serve("/orders/42", "text/html,application/xhtml+xml,*/*;q=0.8")
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Body.String(), "<div id=app></div>")
+	expect(t, response.Header().Get("Content-Type"), "text/html; charset=utf-8")
+
+	expect(t, serve("/app.js", "*/*").Body.String(), "console.log(1)")
+	expect(t, serve("/missing.js", "*/*").Code, http.StatusNotFound)
+	expect(t, serve("/api/orders", "application/json").Code, http.StatusNotFound)
+	expect(t, serve("/orders/42", "text/html;q=0").Code, http.StatusNotFound)
+}
+
+func TestStaticErrorHandler(t *testing.T) {
+	s := NewStaticFS(fstest.MapFS{
+		"app.js":      {Data: []byte("console.log(1)")},
+		"empty/a.txt": {Data: []byte("a")},
+		".env":        {Data: []byte("SECRET=1")},
+	})
+	var statuses []int
+	s.ErrorHandler = func(ctx context.Context, rw http.ResponseWriter, r *http.Request, status int, err error) {
+		statuses = append(statuses, status)
+		rw.WriteHeader(status)
+		fmt.Fprintf(rw, "asset error %d", status)
+	}
+
+	n := New(s)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("next"))
+	}))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "http://localhost:3000"+path, nil)
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	response := serve("GET", "/missing.js")
+	expect(t, response.Code, http.StatusNotFound)
+	expect(t, response.Body.String(), "asset error 404")
+	expect(t, serve("GET", "/.env").Code, http.StatusNotFound)
+	expect(t, serve("GET", "/empty/").Code, http.StatusForbidden)
+	expect(t, serve("GET", "/app.js").Body.String(), "console.log(1)")
+	expect(t, serve("POST", "/missing.js").Body.String(), "next")
+	expect(t, len(statuses), 3)
+}
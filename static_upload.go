@@ -0,0 +1,186 @@
+package camillo
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// staticUploadExt matches the extensions kept in the names of the uploads.
+var staticUploadExt = regexp.MustCompile(`^\.[a-z0-9]{1,10}$`)
+
+// StaticUpload is a middleware handler storing the files PUT under Prefix in Dir, named
+// after the SHA-256 of their content, for a Static serving Dir under the same Prefix to
+// publish them. It is a tiny asset store for internal tools:
+//
+//	n.Use(camillo.NewStaticUpload("/var/assets", "/assets", os.Getenv("UPLOAD_TOKEN")))
+//	s := camillo.NewStatic(http.Dir("/var/assets"))
+//	s.Prefix = "/assets"
+//	n.Use(s)
+//
+// The extension of the uploaded path, or else of the Content-Type, is kept: PUT
+// /assets/logo.png stores the file as <sha256>.png. The upload is answered with a 201,
+// or a 200 when the content was already stored, and a JSON body with its public URL:
+//
+//	{"url":"/assets/4f6c….png","sha256":"4f6c…","size":1042}
+type StaticUpload struct {
+	// Dir is the directory the files are stored in.
+	Dir string
+	// Prefix is the path under which the files are uploaded and served.
+	Prefix string
+	// URL is the public URL of Prefix returned to the clients, such as
+	// "https://cdn.example.com/assets", or Prefix when empty.
+	URL string
+	// Authorize returns whether r may upload. Every upload is refused when nil.
+	Authorize func(r *http.Request) bool
+	// MaxSize is the maximum size of a file. Zero means no limit.
+	MaxSize int64
+}
+
+// StaticUploaded is the JSON body of the answers to the uploads.
+type StaticUploaded struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// NewStaticUpload returns a new instance of StaticUpload storing the files in dir, and
+// authorizing the uploads with an "Authorization: Bearer <token>" header
+func NewStaticUpload(dir, prefix, token string) *StaticUpload {
+	return &StaticUpload{
+		Dir:       dir,
+		Prefix:    prefix,
+		Authorize: BearerToken(token),
+		MaxSize:   32 * 1024 * 1024,
+	}
+}
+
+// BearerToken returns an authorization function accepting the requests with an
+// "Authorization: Bearer <token>" header. It refuses every request when token is "".
+func BearerToken(token string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		auth := r.Header.Get("Authorization")
+		if token == "" || !strings.HasPrefix(auth, "Bearer ") {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+	}
+}
+
+func (u *StaticUpload) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	prefix := strings.TrimSuffix(u.Prefix, "/")
+	if r.Method != "PUT" || !strings.HasPrefix(r.URL.Path, prefix) {
+		next(ctx, rw, r)
+		return
+	}
+	rest := r.URL.Path[len(prefix):]
+	if rest != "" && rest[0] != '/' {
+		next(ctx, rw, r)
+		return
+	}
+
+	if u.Authorize == nil || !u.Authorize(r) {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	if u.MaxSize > 0 && r.ContentLength > u.MaxSize {
+		http.Error(rw, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	uploaded, created, err := u.store(rw, r, uploadExt(rest, r.Header.Get("Content-Type")))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(rw, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		LogError(ctx, err)
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	rw.Header().Set("Location", uploaded.URL)
+	rw.Header().Set("Content-Type", "application/json; charset=utf-8")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(uploaded)
+}
+
+// store writes the body of r to a temporary file of Dir, renamed after its hash once
+// complete, so the partial uploads are never served. It returns whether the file is new.
+func (u *StaticUpload) store(rw http.ResponseWriter, r *http.Request, ext string) (StaticUploaded, bool, error) {
+	body := io.Reader(r.Body)
+	if u.MaxSize > 0 {
+		body = http.MaxBytesReader(rw, r.Body, u.MaxSize)
+	}
+	// the temporary files are dot files, which Static doesn't serve
+	tmp, err := os.CreateTemp(u.Dir, ".upload-*")
+	if err != nil {
+		return StaticUploaded{}, false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), body)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return StaticUploaded{}, false, err
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	name := sum + ext
+	base := u.URL
+	if base == "" {
+		base = strings.TrimSuffix(u.Prefix, "/")
+	}
+	uploaded := StaticUploaded{URL: strings.TrimSuffix(base, "/") + "/" + name, SHA256: sum, Size: size}
+
+	dst := filepath.Join(u.Dir, name)
+	if _, err := os.Stat(dst); err == nil {
+		return uploaded, false, nil
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return StaticUploaded{}, false, err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return StaticUploaded{}, false, err
+	}
+	return uploaded, true, nil
+}
+
+// uploadExt returns the extension kept in the name of an upload to name with contentType.
+func uploadExt(name, contentType string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" && contentType != "" {
+		if typ, _, err := mime.ParseMediaType(contentType); err == nil {
+			if exts, _ := mime.ExtensionsByType(typ); len(exts) > 0 {
+				ext = exts[0]
+			}
+		}
+	}
+	if !staticUploadExt.MatchString(ext) {
+		return ""
+	}
+	return ext
+}
@@ -0,0 +1,75 @@
+package camillo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStaticUpload(t *testing.T) {
+	dir := t.TempDir()
+	u := NewStaticUpload(dir, "/assets", "secret")
+	u.MaxSize = 16
+	s := NewStatic(http.Dir(dir))
+	s.Prefix = "/assets"
+
+	n := New(u, s)
+	n.UseHandler(http.NotFoundHandler())
+	put := func(path, token, contentType, body string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "http://localhost:3000"+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("Content-Type", contentType)
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	expect(t, put("/assets/logo.png", "", "image/png", "png").Code, http.StatusUnauthorized)
+	expect(t, put("/assets/logo.png", "guess", "image/png", "png").Code, http.StatusUnauthorized)
+	expect(t, put("/assets/logo.png", "secret", "image/png", strings.Repeat("x", 17)).Code, http.StatusRequestEntityTooLarge)
+	expect(t, put("/other/logo.png", "secret", "image/png", "png").Code, http.StatusNotFound)
+
+	sum := sha256.Sum256([]byte("png"))
+	name := hex.EncodeToString(sum[:]) + ".png"
+	response := put("/assets/logo.png", "secret", "image/png", "png")
+	expect(t, response.Code, http.StatusCreated)
+	expect(t, response.Header().Get("Location"), "/assets/"+name)
+	var uploaded StaticUploaded
+	expect(t, json.Unmarshal(response.Body.Bytes(), &uploaded), nil)
+	expect(t, uploaded.URL, "/assets/"+name)
+	expect(t, uploaded.SHA256, hex.EncodeToString(sum[:]))
+	expect(t, uploaded.Size, int64(3))
+
+	// the same content is stored once
+	expect(t, put("/assets/other.png", "secret", "image/png", "png").Code, http.StatusOK)
+	u.URL = "https://cdn.example.com/assets/"
+	response = put("/assets", "secret", "image/png", "png")
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Header().Get("Location"), "https://cdn.example.com/assets/"+name)
+
+	entries, _ := os.ReadDir(dir)
+	expect(t, len(entries), 1)
+	b, _ := os.ReadFile(filepath.Join(dir, name))
+	expect(t, string(b), "png")
+
+	response = httptest.NewRecorder()
+	n.ServeHTTP(response, httptest.NewRequest("GET", "http://localhost:3000/assets/"+name, nil))
+	expect(t, response.Code, http.StatusOK)
+	expect(t, response.Body.String(), "png")
+}
+
+func TestUploadExt(t *testing.T) {
+	expect(t, uploadExt("/logo.PNG", ""), ".png")
+	expect(t, uploadExt("/", "image/png"), ".png")
+	expect(t, uploadExt("", "application/x-unknown"), "")
+	expect(t, uploadExt("/archive.tar.gz", ""), ".gz")
+	expect(t, uploadExt("/evil.p<h>p", ""), "")
+}
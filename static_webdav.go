@@ -0,0 +1,106 @@
+package camillo
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// davMultistatus is the body of the 207 answers to the PROPFIND requests.
+type davMultistatus struct {
+	XMLName   xml.Name      `xml:"D:multistatus"`
+	DAV       string        `xml:"xmlns:D,attr"`
+	Responses []davResponse `xml:"D:response"`
+}
+
+type davResponse struct {
+	Href     string      `xml:"D:href"`
+	Propstat davPropstat `xml:"D:propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"D:prop"`
+	Status string  `xml:"D:status"`
+}
+
+type davProp struct {
+	DisplayName   string          `xml:"D:displayname"`
+	ResourceType  davResourceType `xml:"D:resourcetype"`
+	ContentLength *int64          `xml:"D:getcontentlength,omitempty"`
+	ContentType   string          `xml:"D:getcontenttype,omitempty"`
+	LastModified  string          `xml:"D:getlastmodified"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"D:collection"`
+}
+
+// servePropfind answers a PROPFIND request for file with the properties of the file and,
+// with "Depth: 1", of the files of the directory when it may be listed. The properties of
+// the files are always all returned, whichever were requested, and "Depth: infinity", the
+// default, is refused as RFC 4918 allows.
+func (s *Static) servePropfind(rw http.ResponseWriter, r *http.Request, file string, f http.File, fi os.FileInfo) {
+	depth := r.Header.Get("Depth")
+	if depth != "0" && depth != "1" {
+		rw.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		rw.WriteHeader(http.StatusForbidden)
+		rw.Write([]byte(xml.Header + `<D:error xmlns:D="DAV:"><D:propfind-finite-depth/></D:error>`))
+		return
+	}
+
+	href := r.URL.Path
+	if fi.IsDir() && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
+	self := url.URL{Path: href}
+	status := davMultistatus{DAV: "DAV:", Responses: []davResponse{s.davResponse(self.EscapedPath(), fi)}}
+	// the directories GET doesn't list only answer for themselves
+	if fi.IsDir() && depth == "1" && s.listable(file) {
+		infos, err := f.Readdir(-1)
+		if err != nil {
+			http.Error(rw, "Error reading directory", http.StatusInternalServerError)
+			return
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+		for _, child := range infos {
+			if dotFile("/"+child.Name(), s.AllowDotFiles) {
+				continue
+			}
+			u := url.URL{Path: path.Join(href, child.Name())}
+			if child.IsDir() {
+				u.Path += "/"
+			}
+			status.Responses = append(status.Responses, s.davResponse(u.EscapedPath(), child))
+		}
+	}
+
+	body, err := xml.Marshal(status)
+	if err != nil {
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	rw.WriteHeader(http.StatusMultiStatus)
+	rw.Write([]byte(xml.Header))
+	rw.Write(body)
+}
+
+// davResponse returns the properties of the file at href.
+func (s *Static) davResponse(href string, fi os.FileInfo) davResponse {
+	prop := davProp{
+		DisplayName:  fi.Name(),
+		LastModified: fi.ModTime().UTC().Format(http.TimeFormat),
+	}
+	if fi.IsDir() {
+		prop.ResourceType.Collection = &struct{}{}
+	} else {
+		size := fi.Size()
+		prop.ContentLength = &size
+		prop.ContentType = s.contentType(fi.Name())
+	}
+	return davResponse{Href: href, Propstat: davPropstat{Prop: prop, Status: "HTTP/1.1 200 OK"}}
+}
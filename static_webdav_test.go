@@ -0,0 +1,110 @@
+package camillo
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestStaticWebDAV(t *testing.T) {
+	modTime := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := NewStaticFS(fstest.MapFS{
+		"releases/v1.0/app.tar.gz":   {Data: []byte("tarball"), ModTime: modTime},
+		"releases/notes 1.0.txt":     {Data: []byte("notes"), ModTime: modTime},
+		"releases/.upload-in-flight": {},
+		"private/.nolisting":         {},
+		"private/passwords.db":       {Data: []byte("x")},
+	})
+	s.Prefix = "/dav"
+
+	n := New(s)
+	n.UseHandler(http.NotFoundHandler())
+	propfind := func(path, depth string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		req := httptest.NewRequest("PROPFIND", "http://localhost:3000"+path, strings.NewReader(`<?xml version="1.0"?><propfind xmlns="DAV:"><allprop/></propfind>`))
+		if depth != "" {
+			req.Header.Set("Depth", depth)
+		}
+		n.ServeHTTP(response, req)
+		return response
+	}
+
+	// WebDAV is opt-in
+	expect(t, propfind("/dav/releases/", "1").Code, http.StatusNotFound)
+
+	s.WebDAV = true
+	s.Listing = true
+	response := propfind("/dav/releases", "1")
+	expect(t, response.Code, http.StatusMultiStatus)
+	expect(t, response.Header().Get("Content-Type"), "application/xml; charset=utf-8")
+
+	var status struct {
+		Responses []struct {
+			Href     string `xml:"href"`
+			Propstat struct {
+				Prop struct {
+					DisplayName   string `xml:"displayname"`
+					ContentLength string `xml:"getcontentlength"`
+					ContentType   string `xml:"getcontenttype"`
+					LastModified  string `xml:"getlastmodified"`
+					ResourceType  struct {
+						Collection *struct{} `xml:"collection"`
+					} `xml:"resourcetype"`
+				} `xml:"prop"`
+				Status string `xml:"status"`
+			} `xml:"propstat"`
+		} `xml:"response"`
+	}
+	expect(t, xml.Unmarshal(response.Body.Bytes(), &status), nil)
+	expect(t, len(status.Responses), 3)
+
+	dir := status.Responses[0]
+	expect(t, dir.Href, "/dav/releases/")
+	expect(t, dir.Propstat.Status, "HTTP/1.1 200 OK")
+	expect(t, dir.Propstat.Prop.ResourceType.Collection != nil, true)
+
+	notes := status.Responses[1]
+	expect(t, notes.Href, "/dav/releases/notes%201.0.txt")
+	expect(t, notes.Propstat.Prop.DisplayName, "notes 1.0.txt")
+	expect(t, notes.Propstat.Prop.ContentLength, "5")
+	expect(t, notes.Propstat.Prop.ContentType, "text/plain; charset=utf-8")
+	expect(t, notes.Propstat.Prop.LastModified, "Sat, 02 Jan 2016 03:04:05 GMT")
+	expect(t, notes.Propstat.Prop.ResourceType.Collection == nil, true)
+
+	expect(t, status.Responses[2].Href, "/dav/releases/v1.0/")
+
+	response = propfind("/dav/releases/v1.0/app.tar.gz", "0")
+	expect(t, response.Code, http.StatusMultiStatus)
+	expect(t, strings.Contains(response.Body.String(), "<D:href>/dav/releases/v1.0/app.tar.gz</D:href>"), true)
+	expect(t, strings.Count(response.Body.String(), "<D:response>"), 1)
+
+	// the directories that can't be listed only answer for themselves
+	response = propfind("/dav/private/", "1")
+	expect(t, response.Code, http.StatusMultiStatus)
+	expect(t, strings.Count(response.Body.String(), "<D:response>"), 1)
+	expect(t, strings.Contains(response.Body.String(), "passwords.db"), false)
+	s.Listing = false
+	response = propfind("/dav/releases/", "1")
+	expect(t, strings.Count(response.Body.String(), "<D:response>"), 1)
+	s.Listing = true
+
+	response = propfind("/dav/releases/", "")
+	expect(t, response.Code, http.StatusForbidden)
+	expect(t, strings.Contains(response.Body.String(), "propfind-finite-depth"), true)
+
+	expect(t, propfind("/dav/missing/", "1").Code, http.StatusNotFound)
+
+	response = httptest.NewRecorder()
+	n.ServeHTTP(response, httptest.NewRequest("OPTIONS", "http://localhost:3000/dav/releases/", nil))
+	expect(t, response.Header().Get("Allow"), "GET, HEAD, OPTIONS, PROPFIND")
+	expect(t, response.Header().Get("DAV"), "1")
+
+	// read-only
+	response = httptest.NewRecorder()
+	n.ServeHTTP(response, httptest.NewRequest("MKCOL", "http://localhost:3000/dav/releases/v2.0/", nil))
+	expect(t, response.Code, http.StatusNotFound)
+}
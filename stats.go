@@ -0,0 +1,223 @@
+package camillo
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Stats is a middleware handler that collects request statistics. The collected
+// statistics can be exposed as read-only JSON with the handler returned by Handler.
+type Stats struct {
+	// Windows are the rolling windows over which latency percentiles are reported.
+	Windows []time.Duration
+	// MaxSamples is the maximum number of latency samples that are retained.
+	MaxSamples int
+	// Clock is used to time requests and uptime. It should be set before the Stats is used.
+	Clock Clock
+
+	mtx      sync.Mutex
+	start    time.Time
+	total    uint64
+	active   int64
+	statuses map[string]uint64
+	samples  []latencySample
+	next     int
+}
+
+type latencySample struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// StatsData is a snapshot of the statistics collected by Stats.
+type StatsData struct {
+	Pid             int                      `json:"pid"`
+	Uptime          string                   `json:"uptime"`
+	UptimeSec       float64                  `json:"uptime_sec"`
+	TotalCount      uint64                   `json:"total_count"`
+	ActiveCount     int64                    `json:"active_count"`
+	StatusCodeCount map[string]uint64        `json:"status_code_count"`
+	Latency         map[string]LatencyWindow `json:"latency"`
+}
+
+// LatencyWindow holds the latency percentiles, in milliseconds, of the requests
+// completed within a rolling window.
+type LatencyWindow struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+}
+
+// NewStats returns a new instance of Stats
+func NewStats() *Stats {
+	return &Stats{
+		Windows:    []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute},
+		MaxSamples: 1024 * 8,
+		Clock:      SystemClock,
+	}
+}
+
+func (s *Stats) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+	start := clockNow(s.Clock)
+	s.begin()
+
+	defer func() {
+		status := http.StatusOK
+		if res, ok := rw.(ResponseWriter); ok && res.Status() != 0 {
+			status = res.Status()
+		}
+		// a panic is answered with a 500 by the Recovery before the Stats, if any
+		err := recover()
+		if err != nil {
+			status = http.StatusInternalServerError
+		}
+		s.end(status, clockNow(s.Clock).Sub(start))
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	next(ctx, rw, r)
+}
+
+func (s *Stats) begin() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.started(clockNow(s.Clock))
+	s.total++
+	s.active++
+}
+
+func (s *Stats) end(status int, duration time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.active--
+
+	if s.statuses == nil {
+		s.statuses = make(map[string]uint64)
+	}
+	s.statuses[statusClass(status)]++
+
+	if s.MaxSamples <= 0 {
+		return
+	}
+	sample := latencySample{clockNow(s.Clock), duration}
+	if len(s.samples) < s.MaxSamples {
+		s.samples = append(s.samples, sample)
+		return
+	}
+	s.samples[s.next] = sample
+	s.next = (s.next + 1) % len(s.samples)
+}
+
+// started starts the uptime at now, on the first use of the Stats, so it is measured with
+// the Clock set after NewStats. It must be called with the lock held.
+func (s *Stats) started(now time.Time) {
+	if s.start.IsZero() {
+		s.start = now
+	}
+}
+
+// Data returns a snapshot of the collected statistics.
+func (s *Stats) Data() *StatsData {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := clockNow(s.Clock)
+	s.started(now)
+	uptime := now.Sub(s.start)
+	data := &StatsData{
+		Pid:             os.Getpid(),
+		Uptime:          uptime.String(),
+		UptimeSec:       uptime.Seconds(),
+		TotalCount:      s.total,
+		ActiveCount:     s.active,
+		StatusCodeCount: make(map[string]uint64, len(s.statuses)),
+		Latency:         make(map[string]LatencyWindow, len(s.Windows)),
+	}
+	for class, count := range s.statuses {
+		data.StatusCodeCount[class] = count
+	}
+
+	for _, window := range s.Windows {
+		var durations []time.Duration
+		for _, sample := range s.samples {
+			if now.Sub(sample.at) <= window {
+				durations = append(durations, sample.duration)
+			}
+		}
+		data.Latency[window.String()] = latencyWindow(durations)
+	}
+
+	return data
+}
+
+// Handler returns a read-only http.Handler that writes the collected statistics as JSON.
+func (s *Stats) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			rw.Header().Set("Allow", "GET, HEAD")
+			http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		b, err := json.Marshal(s.Data())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-cache")
+		rw.Write(b)
+	})
+}
+
+func latencyWindow(durations []time.Duration) LatencyWindow {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return LatencyWindow{
+		Count: len(durations),
+		P50:   percentile(durations, 0.50),
+		P90:   percentile(durations, 0.90),
+		P99:   percentile(durations, 0.99),
+	}
+}
+
+// percentile returns the p-th percentile of the sorted durations in milliseconds.
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+func statusClass(status int) string {
+	switch {
+	case status >= 500:
+		return "5xx"
+	case status >= 400:
+		return "4xx"
+	case status >= 300:
+		return "3xx"
+	case status >= 200:
+		return "2xx"
+	default:
+		return "1xx"
+	}
+}
@@ -0,0 +1,133 @@
+package camillo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestStats(t *testing.T) {
+	s := NewStats()
+
+	n := New()
+	n.Use(s)
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		if r.URL.Path == "/missing" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Path == "/active" {
+			expect(t, s.Data().ActiveCount, int64(1))
+		}
+		rw.Write([]byte("ok"))
+	})
+
+	for _, path := range []string{"/", "/active", "/missing"} {
+		req, err := http.NewRequest("GET", "http://localhost:3000"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		n.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	data := s.Data()
+	expect(t, data.TotalCount, uint64(3))
+	expect(t, data.ActiveCount, int64(0))
+	expect(t, data.StatusCodeCount["2xx"], uint64(2))
+	expect(t, data.StatusCodeCount["4xx"], uint64(1))
+	expect(t, data.Latency[time.Minute.String()].Count, 3)
+}
+
+func TestStatsPanic(t *testing.T) {
+	s := NewStats()
+	rec := NewRecovery()
+	rec.Logger = log.New(ioutil.Discard, "", 0)
+	n := New(rec, s)
+	n.UseHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		panic("here is a panic!")
+	}))
+	req, _ := http.NewRequest("GET", "http://localhost:3000/", nil)
+	n.ServeHTTP(httptest.NewRecorder(), req)
+
+	data := s.Data()
+	expect(t, data.ActiveCount, int64(0))
+	expect(t, data.StatusCodeCount["2xx"], uint64(0))
+	expect(t, data.StatusCodeCount["5xx"], uint64(1))
+}
+
+func TestStatsMaxSamples(t *testing.T) {
+	s := NewStats()
+	s.MaxSamples = 2
+
+	for i := 0; i < 5; i++ {
+		s.begin()
+		s.end(http.StatusOK, time.Duration(i)*time.Millisecond)
+	}
+
+	data := s.Data()
+	expect(t, data.TotalCount, uint64(5))
+	expect(t, data.Latency[time.Minute.String()].Count, 2)
+	expect(t, data.Latency[time.Minute.String()].P99, float64(4))
+}
+
+func TestStatsHandler(t *testing.T) {
+	s := NewStats()
+	s.begin()
+	s.end(http.StatusInternalServerError, 10*time.Millisecond)
+
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "http://localhost:3000/stats", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	s.Handler().ServeHTTP(recorder, req)
+
+	expect(t, recorder.Code, http.StatusOK)
+	expect(t, recorder.Header().Get("Content-Type"), "application/json")
+
+	var data StatsData
+	if err := json.Unmarshal(recorder.Body.Bytes(), &data); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, data.TotalCount, uint64(1))
+	expect(t, data.StatusCodeCount["5xx"], uint64(1))
+	expect(t, data.Latency[time.Minute.String()].P50, float64(10))
+}
+
+func TestStatsHandlerReadOnly(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "http://localhost:3000/stats", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	NewStats().Handler().ServeHTTP(recorder, req)
+
+	expect(t, recorder.Code, http.StatusMethodNotAllowed)
+}
+
+func TestStatsClock(t *testing.T) {
+	clock := NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+	s := NewStats()
+	s.Clock = clock
+
+	n := New(s)
+	n.UseFunc(func(ctx context.Context, rw http.ResponseWriter, r *http.Request, next NextFunc) {
+		clock.Advance(20 * time.Millisecond)
+	})
+	n.ServeHTTP(httptest.NewRecorder(), (*http.Request)(nil))
+
+	clock.Advance(2 * time.Minute)
+	n.ServeHTTP(httptest.NewRecorder(), (*http.Request)(nil))
+
+	data := s.Data()
+	expect(t, data.UptimeSec, (2*time.Minute + 40*time.Millisecond).Seconds())
+	expect(t, data.Latency[time.Minute.String()].Count, 1)
+	expect(t, data.Latency[(5*time.Minute).String()].Count, 2)
+	expect(t, data.Latency[(5*time.Minute).String()].P50, float64(20))
+}
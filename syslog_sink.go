@@ -0,0 +1,184 @@
+package camillo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyslogFacility is the facility of the messages sent by a SyslogSink.
+type SyslogFacility int
+
+const (
+	SyslogKern   SyslogFacility = 0
+	SyslogUser   SyslogFacility = 1
+	SyslogDaemon SyslogFacility = 3
+	SyslogAuth   SyslogFacility = 4
+	SyslogLocal0 SyslogFacility = 16
+	SyslogLocal1 SyslogFacility = 17
+	SyslogLocal2 SyslogFacility = 18
+	SyslogLocal3 SyslogFacility = 19
+	SyslogLocal4 SyslogFacility = 20
+	SyslogLocal5 SyslogFacility = 21
+	SyslogLocal6 SyslogFacility = 22
+	SyslogLocal7 SyslogFacility = 23
+)
+
+// syslogSeverity returns the syslog severity of level, which journald uses as well.
+func syslogSeverity(level LogLevel) int {
+	switch level {
+	case LogLevelDebug:
+		return 7
+	case LogLevelInfo:
+		return 6
+	case LogLevelWarn:
+		return 4
+	}
+	return 3
+}
+
+// syslogSockets are the local syslog sockets tried when a SyslogSink has no network.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogSink is a LeveledLogSink sending RFC 5424 messages to a syslog daemon over UDP,
+// TCP or a unix socket. Levels are mapped to the debug, info, warning and err severities.
+// TCP messages are framed with octet counting (RFC 6587). The connection is dialed on the
+// first message and redialed once when sending fails.
+type SyslogSink struct {
+	// Network is "udp", "tcp", "unix" or "unixgram". The local syslog daemon is used when
+	// it is empty.
+	Network string
+	// Addr is the address of the syslog daemon, such as "logs.example.com:514".
+	Addr string
+	// Facility is the facility of the messages.
+	Facility SyslogFacility
+	// Tag is the APP-NAME of the messages.
+	Tag string
+	// Hostname is the HOSTNAME of the messages.
+	Hostname string
+	// Level is the level of the messages logged with Printf.
+	Level LogLevel
+	// Clock timestamps the messages.
+	Clock Clock
+
+	mtx  sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslogSink returns a new SyslogSink sending messages tagged with tag to the syslog
+// daemon at addr. An empty network and addr send them to the local syslog daemon.
+func NewSyslogSink(network, addr, tag string) *SyslogSink {
+	hostname, _ := os.Hostname()
+	return &SyslogSink{
+		Network:  network,
+		Addr:     addr,
+		Facility: SyslogUser,
+		Tag:      tag,
+		Hostname: hostname,
+		Level:    LogLevelInfo,
+		Clock:    SystemClock,
+	}
+}
+
+// Printf sends the message at Level.
+func (s *SyslogSink) Printf(format string, v ...interface{}) {
+	s.Logf(s.Level, format, v...)
+}
+
+// Logf sends the message at the severity of level. Messages that can't be sent are
+// written to stderr.
+func (s *SyslogSink) Logf(level LogLevel, format string, v ...interface{}) {
+	msg := strings.TrimSuffix(fmt.Sprintf(format, v...), "\n")
+	if err := s.send(s.format(level, msg)); err != nil {
+		fmt.Fprintf(os.Stderr, "camillo: syslog: %s: %s\n", err, msg)
+	}
+}
+
+// Close closes the connection to the syslog daemon.
+func (s *SyslogSink) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// format formats an RFC 5424 message:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (s *SyslogSink) format(level LogLevel, msg string) string {
+	return fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		int(s.Facility)*8+syslogSeverity(level),
+		clockNow(s.Clock).Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogField(s.Hostname, 255),
+		syslogField(s.Tag, 48),
+		os.Getpid(),
+		msg,
+	)
+}
+
+// syslogField returns s as a header field of at most max printable ASCII characters, or
+// "-" when it is empty.
+func syslogField(s string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+	if len(field) > max {
+		field = field[:max]
+	}
+	if field == "" {
+		return "-"
+	}
+	return field
+}
+
+func (s *SyslogSink) send(msg string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if strings.HasPrefix(s.Network, "tcp") {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if s.conn, err = s.dial(); err != nil {
+				return err
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err = s.conn.Write([]byte(msg)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *SyslogSink) dial() (net.Conn, error) {
+	if s.Network != "" {
+		return net.DialTimeout(s.Network, s.Addr, 5*time.Second)
+	}
+	for _, socket := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, socket); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, errors.New("no local syslog daemon")
+}
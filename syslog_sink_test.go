@@ -0,0 +1,67 @@
+package camillo
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink := NewSyslogSink("udp", conn.LocalAddr().String(), "my app")
+	sink.Hostname = "web1"
+	sink.Facility = SyslogLocal0
+	sink.Clock = NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+	defer sink.Close()
+
+	sink.Printf("Started %s %s\n", "GET", "/")
+	logf(sink, LogLevelError, "PANIC: %s", "boom")
+
+	buf := make([]byte, 1024)
+	n, _, _ := conn.ReadFrom(buf)
+	expect(t, string(buf[:n]), fmt.Sprintf("<134>1 2015-06-01T12:00:00.000000Z web1 myapp %d - - Started GET /", os.Getpid()))
+	n, _, _ = conn.ReadFrom(buf)
+	expect(t, string(buf[:n]), fmt.Sprintf("<131>1 2015-06-01T12:00:00.000000Z web1 myapp %d - - PANIC: boom", os.Getpid()))
+}
+
+func TestSyslogSinkTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	sink := NewSyslogSink("tcp", l.Addr().String(), "app")
+	sink.Hostname = ""
+	sink.Clock = NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+	defer sink.Close()
+
+	go sink.Logf(LogLevelWarn, "line one\nline two")
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := fmt.Sprintf("<12>1 2015-06-01T12:00:00.000000Z - app %d - - line one\nline two", os.Getpid())
+	framed := make([]byte, len(fmt.Sprint(len(msg)))+1+len(msg))
+	_, err = io.ReadFull(conn, framed)
+	expect(t, err, nil)
+	expect(t, string(framed), fmt.Sprintf("%d %s", len(msg), msg))
+}
+
+func TestSyslogField(t *testing.T) {
+	expect(t, syslogField("", 48), "-")
+	expect(t, syslogField("héllo wörld", 48), "hllowrld")
+	expect(t, syslogField(strings.Repeat("a", 50), 48), strings.Repeat("a", 48))
+}
@@ -0,0 +1,75 @@
+package camillo
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemdFirstFD is the first file descriptor passed by systemd, after stdin, stdout and
+// stderr.
+var systemdFirstFD = 3
+
+// SystemdListeners returns the listeners passed by systemd socket activation, in the order
+// of the ListenStream lines of the socket unit, or none when the process wasn't activated
+// by a socket. systemd then owns the sockets, so the connections queue up while the service
+// restarts instead of being refused. The LISTEN_* environment variables are unset, so the
+// child processes don't inherit the sockets.
+func SystemdListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(systemdFirstFD+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(systemdFirstFD+i), name)
+		// FileListener duplicates the descriptor, closed on exec unlike the inherited one
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("camillo: systemd socket %s: %w", name, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// RunSystemd runs the camillo stack like Run on the listeners of systemd socket activation,
+// or on addr when the process wasn't activated by a socket, so the same binary runs under
+// systemd and on its own.
+func (n *Camillo) RunSystemd(addr string) error {
+	listeners, err := SystemdListeners()
+	if err != nil {
+		return err
+	}
+	if len(listeners) == 0 {
+		return n.Run(addr)
+	}
+
+	servers := make([]serving, len(listeners))
+	for i, l := range listeners {
+		l := l
+		srv := n.NewServer(l.Addr().String())
+		n.logf(LogLevelInfo, "listening on %s (systemd)", srv.Addr)
+		servers[i] = serving{srv, func() error { return srv.Serve(l) }}
+	}
+	return n.serve(servers...)
+}
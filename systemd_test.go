@@ -0,0 +1,83 @@
+package camillo
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// activate passes the socket of l to the process like systemd socket activation.
+func activate(t *testing.T, l net.Listener) {
+	f, err := l.(*net.TCPListener).File()
+	expect(t, err, nil)
+	// the descriptor is owned by SystemdListeners, not by an os.File closing it
+	fd, err := syscall.Dup(int(f.Fd()))
+	expect(t, err, nil)
+	f.Close()
+	l.Close()
+
+	systemdFirstFD = fd
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "1")
+	os.Setenv("LISTEN_FDNAMES", "http")
+	t.Cleanup(func() { systemdFirstFD = 3 })
+}
+
+func TestSystemdListeners(t *testing.T) {
+	// not activated
+	os.Setenv("LISTEN_PID", "1")
+	os.Setenv("LISTEN_FDS", "1")
+	listeners, err := SystemdListeners()
+	expect(t, err, nil)
+	expect(t, len(listeners), 0)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	expect(t, err, nil)
+	addr := l.Addr().String()
+	activate(t, l)
+
+	listeners, err = SystemdListeners()
+	expect(t, err, nil)
+	expect(t, len(listeners), 1)
+	expect(t, listeners[0].Addr().String(), addr)
+	expect(t, os.Getenv("LISTEN_FDS"), "")
+	listeners[0].Close()
+}
+
+func TestRunSystemd(t *testing.T) {
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, "ok")
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	expect(t, err, nil)
+	addr := l.Addr().String()
+	activate(t, l)
+
+	errs := make(chan error, 1)
+	go func() { errs <- n.RunSystemd("127.0.0.1:3022") }()
+	awaitListening(t, addr)
+
+	res, err := http.Get("http://" + addr + "/")
+	expect(t, err, nil)
+	b, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, string(b), "ok")
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	expect(t, <-errs, nil)
+
+	// without activation, RunSystemd listens on addr
+	go func() { errs <- n.RunSystemd("127.0.0.1:3022") }()
+	awaitListening(t, "127.0.0.1:3022")
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	expect(t, <-errs, nil)
+}
@@ -0,0 +1,172 @@
+package camillo
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// The environment variables passing the listeners of an upgrade to the new process.
+const (
+	upgradeListenFDsEnv = "CAMILLO_LISTEN_FDS"
+	upgradeReadyFDEnv   = "CAMILLO_READY_FD"
+)
+
+// upgradeFirstFD is the first file descriptor passed to the new process of an upgrade,
+// after stdin, stdout and stderr.
+const upgradeFirstFD = 3
+
+// upgradeTimeout is how long the new process of an upgrade has to start serving.
+var upgradeTimeout = 30 * time.Second
+
+// upgradeCommand returns the command starting the new process of an upgrade: the binary
+// of the process, which may have been replaced since, with the same arguments.
+var upgradeCommand = func() (*exec.Cmd, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	return cmd, nil
+}
+
+// RunUpgradable runs the camillo stack like RunSystemd, and upgrades the binary without
+// dropping connections on SIGUSR2, for the deployments on bare VMs. The new binary is
+// started with the same arguments and inherits the listening sockets, so the connections
+// are never refused. Once it serves, the old process stops accepting connections and
+// waits up to ShutdownTimeout for its requests in flight like on SIGTERM, and returns nil.
+// The old process keeps serving when the new one fails to start within 30s.
+//
+//	go build -o app && kill -USR2 $(pidof app)
+//
+// A process manager must not take the exit of the old process for a crash: with systemd,
+// the service needs PIDFile, or Type=forking. Windows has no SIGUSR2, so RunUpgradable
+// runs like RunSystemd there.
+func (n *Camillo) RunUpgradable(addr string) error {
+	listeners, ready, err := upgradeListeners()
+	if err != nil {
+		return err
+	}
+	source := "upgrade"
+	if len(listeners) == 0 {
+		if listeners, err = SystemdListeners(); err != nil {
+			return err
+		}
+		source = "systemd"
+	}
+	if len(listeners) == 0 {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		listeners = []net.Listener{l}
+		source = "upgradable"
+	}
+
+	servers := make([]serving, len(listeners))
+	for i, l := range listeners {
+		l := l
+		srv := n.NewServer(l.Addr().String())
+		n.logf(LogLevelInfo, "listening on %s (%s)", srv.Addr, source)
+		servers[i] = serving{srv, func() error { return srv.Serve(l) }}
+	}
+	return n.run(runOptions{
+		upgrade: func() error { return n.upgrade(listeners) },
+		started: func() {
+			if ready != nil {
+				ready.Write([]byte{1})
+				ready.Close()
+			}
+		},
+	}, servers)
+}
+
+// upgradeListeners returns the listeners inherited from the old process of an upgrade, and
+// the pipe telling it the process serves, or none when the process wasn't started by an
+// upgrade. The environment variables are unset, so the child processes don't inherit them.
+func upgradeListeners() ([]net.Listener, *os.File, error) {
+	count, err := strconv.Atoi(os.Getenv(upgradeListenFDsEnv))
+	readyFD, readyErr := strconv.Atoi(os.Getenv(upgradeReadyFDEnv))
+	os.Unsetenv(upgradeListenFDsEnv)
+	os.Unsetenv(upgradeReadyFDEnv)
+	if err != nil || count <= 0 {
+		return nil, nil, nil
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		f := os.NewFile(uintptr(upgradeFirstFD+i), "upgrade")
+		// FileListener duplicates the descriptor, closed on exec unlike the inherited one
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, nil, fmt.Errorf("camillo: upgraded socket %d: %w", upgradeFirstFD+i, err)
+		}
+		listeners = append(listeners, l)
+	}
+	var ready *os.File
+	if readyErr == nil {
+		ready = os.NewFile(uintptr(readyFD), "ready")
+	}
+	return listeners, ready, nil
+}
+
+// upgrade starts the new process of an upgrade with the sockets of listeners, and waits
+// until it serves.
+func (n *Camillo) upgrade(listeners []net.Listener) error {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, l := range listeners {
+		f, err := listenerFile(l)
+		if err != nil {
+			return fmt.Errorf("camillo: upgrading: socket of %s: %w", l.Addr(), err)
+		}
+		files = append(files, f)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("camillo: upgrading: %w", err)
+	}
+	defer r.Close()
+	cmd, err := upgradeCommand()
+	if err != nil {
+		w.Close()
+		return fmt.Errorf("camillo: upgrading: %w", err)
+	}
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(cmd.Env,
+		upgradeListenFDsEnv+"="+strconv.Itoa(len(files)),
+		upgradeReadyFDEnv+"="+strconv.Itoa(upgradeFirstFD+len(files)),
+	)
+	err = cmd.Start()
+	// only the new process holds the write end, so the read fails if it exits
+	w.Close()
+	if err != nil {
+		return fmt.Errorf("camillo: upgrading: %w", err)
+	}
+	go cmd.Wait()
+
+	r.SetReadDeadline(time.Now().Add(upgradeTimeout))
+	if _, err := io.ReadFull(r, make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		return fmt.Errorf("camillo: upgrading: process %d didn't start serving: %w", cmd.Process.Pid, err)
+	}
+	n.logf(LogLevelInfo, "process %d serves", cmd.Process.Pid)
+	return nil
+}
@@ -0,0 +1,106 @@
+package camillo
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestUpgradeChild is the new process started by the upgrades of TestRunUpgradable, run
+// by the test binary itself. It serves until a request to /stop.
+func TestUpgradeChild(t *testing.T) {
+	if os.Getenv("CAMILLO_UPGRADE_CHILD") != "1" {
+		t.Skip("only run by TestRunUpgradable")
+	}
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stop" {
+			go syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+		}
+		io.WriteString(rw, "child")
+	})
+	expect(t, n.RunUpgradable("127.0.0.1:3026"), nil)
+}
+
+// upgradeTo makes the upgrades start the test binary running test.
+func upgradeTo(t *testing.T, test string) {
+	command := upgradeCommand
+	upgradeCommand = func() (*exec.Cmd, error) {
+		cmd := exec.Command(os.Args[0], "-test.run=^"+test+"$")
+		cmd.Env = append(os.Environ(), "CAMILLO_UPGRADE_CHILD=1")
+		return cmd, nil
+	}
+	t.Cleanup(func() { upgradeCommand = command })
+}
+
+func get(t *testing.T, url string) string {
+	// a new connection every time, so the kept alive ones don't hide the upgrades
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	res, err := client.Get(url)
+	expect(t, err, nil)
+	defer res.Body.Close()
+	b, _ := ioutil.ReadAll(res.Body)
+	return string(b)
+}
+
+func TestRunUpgradable(t *testing.T) {
+	upgradeTo(t, "TestUpgradeChild")
+
+	n := New()
+	n.Logger = log.New(ioutil.Discard, "", 0)
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, "parent")
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	expect(t, err, nil)
+	addr := l.Addr().String()
+	activate(t, l)
+
+	errs := make(chan error, 1)
+	go func() { errs <- n.RunUpgradable("127.0.0.1:3026") }()
+	awaitListening(t, addr)
+	expect(t, get(t, "http://"+addr+"/"), "parent")
+
+	// the old process returns once the new one serves on the same socket
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+	expect(t, <-errs, nil)
+	expect(t, get(t, "http://"+addr+"/"), "child")
+	expect(t, get(t, "http://"+addr+"/stop"), "child")
+}
+
+func TestRunUpgradableFailure(t *testing.T) {
+	// the new process exits without serving
+	upgradeTo(t, "TestUpgradeNone")
+
+	failures := make(chan string, 1)
+	n := New()
+	n.Logger = LogSinkFunc(func(format string, v ...interface{}) {
+		if msg := fmt.Sprintf(format, v...); strings.Contains(msg, "upgrading") {
+			failures <- msg
+		}
+	})
+	n.UseHandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		io.WriteString(rw, "parent")
+	})
+
+	errs := make(chan error, 1)
+	go func() { errs <- n.RunUpgradable("127.0.0.1:3026") }()
+	awaitListening(t, "127.0.0.1:3026")
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR2)
+	expect(t, strings.Contains(<-failures, "upgrading on"), true)
+	expect(t, strings.Contains(<-failures, "didn't start serving"), true)
+	expect(t, get(t, "http://127.0.0.1:3026/"), "parent")
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	expect(t, <-errs, nil)
+}
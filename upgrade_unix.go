@@ -0,0 +1,43 @@
+//go:build !windows
+
+package camillo
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// upgradeSignal is the signal upgrading the binary of RunUpgradable.
+var upgradeSignal os.Signal = syscall.SIGUSR2
+
+// listenerFile returns a duplicate of the socket of l to pass to the new process of an
+// upgrade. Unlike the files of TCPListener.File, starting the process with it doesn't put
+// the socket shared with l in blocking mode, which would hang the Accept of l.
+func listenerFile(l net.Listener) (*os.File, error) {
+	sc, ok := l.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("not a socket")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var fd int
+	var dupErr error
+	err = rc.Control(func(s uintptr) {
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if fd, dupErr = syscall.Dup(int(s)); dupErr == nil {
+			syscall.CloseOnExec(fd)
+		}
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "listener"), nil
+}
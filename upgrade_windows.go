@@ -0,0 +1,14 @@
+package camillo
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// upgradeSignal is the signal upgrading the binary of RunUpgradable, none on Windows.
+var upgradeSignal os.Signal
+
+func listenerFile(l net.Listener) (*os.File, error) {
+	return nil, errors.New("not supported on windows")
+}
@@ -0,0 +1,354 @@
+// Package resumable implements resumable uploads following the tus protocol
+// (https://tus.io/protocols/resumable-upload.html), version 1.0.0, including the
+// creation, expiration and termination extensions.
+//
+// The Handler is a Camillo middleware serving the upload endpoints under a prefix:
+//
+//	n := camillo.Classic()
+//	uploads := resumable.New(resumable.NewDirStore("/var/uploads"))
+//	uploads.Prefix = "/files"
+//	n.Use(uploads)
+package resumable
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fd/camillo"
+	"golang.org/x/net/context"
+)
+
+// Version is the version of the tus protocol implemented by the Handler.
+const Version = "1.0.0"
+
+// Handler is a middleware handler that serves resumable uploads under Prefix and
+// passes every other request on.
+type Handler struct {
+	// Prefix is the path under which uploads are created and served. An empty Prefix, or
+	// "/", serves them under /files, so the Handler doesn't claim every path.
+	Prefix string
+	// Store holds the uploads.
+	Store Store
+	// MaxSize is the maximum size of an upload. Zero means no limit.
+	MaxSize int64
+	// Expiration is how long an incomplete upload is kept after it was created. Zero
+	// means uploads don't expire.
+	Expiration time.Duration
+	// OnComplete is called when the last byte of an upload has been received.
+	OnComplete func(Info)
+	// Clock is used to expire uploads
+	Clock camillo.Clock
+
+	mtx  sync.Mutex
+	busy map[string]bool
+}
+
+// New returns a new Handler serving uploads under /files
+func New(store Store) *Handler {
+	return &Handler{
+		Prefix:     "/files",
+		Store:      store,
+		Expiration: 24 * time.Hour,
+		Clock:      camillo.SystemClock,
+	}
+}
+
+// prefix returns Prefix without its trailing slash, or /files when it is empty.
+func (h *Handler) prefix() string {
+	prefix := strings.TrimSuffix(h.Prefix, "/")
+	if prefix == "" {
+		return "/files"
+	}
+	return prefix
+}
+
+func (h *Handler) ServeHTTP(ctx context.Context, rw http.ResponseWriter, r *http.Request, next camillo.NextFunc) {
+	prefix := h.prefix()
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		next(ctx, rw, r)
+		return
+	}
+	rest := r.URL.Path[len(prefix):]
+	if rest != "" && rest[0] != '/' {
+		next(ctx, rw, r)
+		return
+	}
+	id := strings.TrimPrefix(rest, "/")
+
+	rw.Header().Set("Tus-Resumable", Version)
+
+	if r.Method == "OPTIONS" {
+		rw.Header().Set("Tus-Version", Version)
+		rw.Header().Set("Tus-Extension", "creation,expiration,termination")
+		if h.MaxSize > 0 {
+			rw.Header().Set("Tus-Max-Size", strconv.FormatInt(h.MaxSize, 10))
+		}
+		rw.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.Header.Get("Tus-Resumable") != Version {
+		rw.Header().Set("Tus-Version", Version)
+		http.Error(rw, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	switch {
+	case id == "" && r.Method == "POST":
+		h.create(rw, r)
+	case id == "":
+		rw.Header().Set("Allow", "OPTIONS, POST")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	case !validID(id):
+		http.NotFound(rw, r)
+	case r.Method == "HEAD":
+		h.head(rw, r, id)
+	case r.Method == "PATCH":
+		h.patch(rw, r, id)
+	case r.Method == "DELETE":
+		h.delete(rw, r, id)
+	default:
+		rw.Header().Set("Allow", "OPTIONS, HEAD, PATCH, DELETE")
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) create(rw http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(rw, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if h.MaxSize > 0 && length > h.MaxSize {
+		http.Error(rw, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(rw, "invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	info := Info{ID: id, Length: length, Metadata: metadata}
+	if h.Expiration > 0 {
+		info.Expires = h.now().Add(h.Expiration)
+	}
+	if err := h.Store.Create(info); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if length == 0 {
+		h.complete(info)
+	}
+
+	h.setExpires(rw, info)
+	rw.Header().Set("Location", h.prefix()+"/"+id)
+	rw.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) head(rw http.ResponseWriter, r *http.Request, id string) {
+	info, ok := h.info(rw, id)
+	if !ok {
+		return
+	}
+
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	rw.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	if len(info.Metadata) > 0 {
+		rw.Header().Set("Upload-Metadata", formatMetadata(info.Metadata))
+	}
+	h.setExpires(rw, info)
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) patch(rw http.ResponseWriter, r *http.Request, id string) {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/offset+octet-stream" {
+		http.Error(rw, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(rw, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+
+	if !h.lock(id) {
+		http.Error(rw, "upload is locked by another request", http.StatusConflict)
+		return
+	}
+	defer h.unlock(id)
+
+	info, ok := h.info(rw, id)
+	if !ok {
+		return
+	}
+	if offset != info.Offset {
+		http.Error(rw, "Upload-Offset does not match the current offset", http.StatusConflict)
+		return
+	}
+
+	n, err := h.Store.Append(id, offset, io.LimitReader(r.Body, info.Length-info.Offset))
+	info.Offset += n
+	if err != nil && n == 0 {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if info.Complete() {
+		h.complete(info)
+	}
+
+	// a partially received body is kept, the client resumes from the new offset
+	h.setExpires(rw, info)
+	rw.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) delete(rw http.ResponseWriter, r *http.Request, id string) {
+	if !h.lock(id) {
+		http.Error(rw, "upload is locked by another request", http.StatusConflict)
+		return
+	}
+	defer h.unlock(id)
+
+	if _, ok := h.info(rw, id); !ok {
+		return
+	}
+	if err := h.Store.Delete(id); err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// info loads an upload, writing an error response when it doesn't exist or has expired.
+func (h *Handler) info(rw http.ResponseWriter, id string) (Info, bool) {
+	info, err := h.Store.Info(id)
+	if err == ErrNotFound {
+		http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return info, false
+	}
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return info, false
+	}
+	if !info.Complete() && !info.Expires.IsZero() && !h.now().Before(info.Expires) {
+		h.Store.Delete(id)
+		http.Error(rw, http.StatusText(http.StatusGone), http.StatusGone)
+		return info, false
+	}
+	return info, true
+}
+
+func (h *Handler) complete(info Info) {
+	if h.OnComplete != nil {
+		h.OnComplete(info)
+	}
+}
+
+func (h *Handler) setExpires(rw http.ResponseWriter, info Info) {
+	if !info.Complete() && !info.Expires.IsZero() {
+		rw.Header().Set("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
+	}
+}
+
+func (h *Handler) lock(id string) bool {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.busy == nil {
+		h.busy = make(map[string]bool)
+	}
+	if h.busy[id] {
+		return false
+	}
+	h.busy[id] = true
+	return true
+}
+
+func (h *Handler) unlock(id string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	delete(h.busy, id)
+}
+
+func (h *Handler) now() time.Time {
+	if h.Clock == nil {
+		return time.Now()
+	}
+	return h.Clock.Now()
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// parseMetadata parses an Upload-Metadata header: comma separated pairs of a key and
+// an optional base64 encoded value.
+func parseMetadata(header string) (map[string]string, error) {
+	if strings.TrimSpace(header) == "" {
+		return nil, nil
+	}
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, strconv.ErrSyntax
+		}
+	}
+	return metadata, nil
+}
+
+func formatMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k
+		if metadata[k] != "" {
+			pairs[i] += " " + base64.StdEncoding.EncodeToString([]byte(metadata[k]))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,202 @@
+package resumable
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fd/camillo"
+)
+
+func expect(t *testing.T, a interface{}, b interface{}) {
+	if a != b {
+		t.Errorf("Expected %v (type %T) - Got %v (type %T)", b, b, a, a)
+	}
+}
+
+func do(t *testing.T, n http.Handler, method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req, err := http.NewRequest(method, "http://localhost:3000"+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Tus-Resumable", Version)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	recorder := httptest.NewRecorder()
+	n.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func testUpload(t *testing.T, store Store) {
+	var completed Info
+	h := New(store)
+	h.OnComplete = func(info Info) { completed = info }
+	n := camillo.New(h)
+	n.UseHandler(http.NotFoundHandler())
+
+	res := do(t, n, "POST", "/files", "", map[string]string{
+		"Upload-Length":   "11",
+		"Upload-Metadata": "filename aGVsbG8udHh0,private",
+	})
+	expect(t, res.Code, http.StatusCreated)
+	location := res.Header().Get("Location")
+	expect(t, strings.HasPrefix(location, "/files/"), true)
+	expect(t, res.Header().Get("Upload-Expires") != "", true)
+
+	res = do(t, n, "PATCH", location, "Hello ", map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	expect(t, res.Code, http.StatusNoContent)
+	expect(t, res.Header().Get("Upload-Offset"), "6")
+
+	res = do(t, n, "HEAD", location, "", nil)
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Header().Get("Upload-Offset"), "6")
+	expect(t, res.Header().Get("Upload-Length"), "11")
+	expect(t, res.Header().Get("Upload-Metadata"), "filename aGVsbG8udHh0,private")
+	expect(t, res.Header().Get("Cache-Control"), "no-store")
+
+	// resuming from a stale offset conflicts
+	res = do(t, n, "PATCH", location, "world", map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	})
+	expect(t, res.Code, http.StatusConflict)
+
+	// bytes beyond the upload length are ignored
+	res = do(t, n, "PATCH", location, "world and more", map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "6",
+	})
+	expect(t, res.Code, http.StatusNoContent)
+	expect(t, res.Header().Get("Upload-Offset"), "11")
+	expect(t, completed.ID, strings.TrimPrefix(location, "/files/"))
+	expect(t, completed.Metadata["filename"], "hello.txt")
+
+	res = do(t, n, "DELETE", location, "", nil)
+	expect(t, res.Code, http.StatusNoContent)
+	res = do(t, n, "HEAD", location, "", nil)
+	expect(t, res.Code, http.StatusNotFound)
+}
+
+func TestMemoryStoreUpload(t *testing.T) {
+	store := NewMemoryStore()
+	testUpload(t, store)
+}
+
+func TestDirStoreUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resumable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testUpload(t, NewDirStore(dir))
+}
+
+func TestDirStoreData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resumable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := NewDirStore(dir)
+	store.Create(Info{ID: "abc", Length: 11})
+	store.Append("abc", 0, strings.NewReader("Hello "))
+	store.Append("abc", 6, strings.NewReader("world"))
+
+	b, err := ioutil.ReadFile(store.Path("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, string(b), "Hello world")
+	info, _ := store.Info("abc")
+	expect(t, info.Offset, int64(11))
+}
+
+func TestOptions(t *testing.T) {
+	h := New(NewMemoryStore())
+	h.MaxSize = 1024
+	n := camillo.New(h)
+
+	req, _ := http.NewRequest("OPTIONS", "http://localhost:3000/files", nil)
+	res := httptest.NewRecorder()
+	n.ServeHTTP(res, req)
+
+	expect(t, res.Code, http.StatusNoContent)
+	expect(t, res.Header().Get("Tus-Version"), Version)
+	expect(t, res.Header().Get("Tus-Max-Size"), "1024")
+	expect(t, res.Header().Get("Tus-Extension"), "creation,expiration,termination")
+}
+
+func TestProtocolErrors(t *testing.T) {
+	h := New(NewMemoryStore())
+	h.MaxSize = 10
+	n := camillo.New(h)
+	n.UseHandler(http.NotFoundHandler())
+
+	req, _ := http.NewRequest("POST", "http://localhost:3000/files", nil)
+	res := httptest.NewRecorder()
+	n.ServeHTTP(res, req)
+	expect(t, res.Code, http.StatusPreconditionFailed)
+
+	res = do(t, n, "POST", "/files", "", nil)
+	expect(t, res.Code, http.StatusBadRequest)
+
+	res = do(t, n, "POST", "/files", "", map[string]string{"Upload-Length": "11"})
+	expect(t, res.Code, http.StatusRequestEntityTooLarge)
+
+	res = do(t, n, "POST", "/files", "", map[string]string{"Upload-Length": "5"})
+	location := res.Header().Get("Location")
+	res = do(t, n, "PATCH", location, "Hello", map[string]string{"Upload-Offset": "0"})
+	expect(t, res.Code, http.StatusUnsupportedMediaType)
+
+	res = do(t, n, "HEAD", "/files/../../etc/passwd", "", nil)
+	expect(t, res.Code, http.StatusNotFound)
+
+	// requests outside the prefix are passed on
+	res = do(t, n, "POST", "/filesystem", "", nil)
+	expect(t, res.Code, http.StatusNotFound)
+	expect(t, res.Header().Get("Tus-Resumable"), "")
+}
+
+func TestEmptyPrefix(t *testing.T) {
+	h := New(NewMemoryStore())
+	h.Prefix = "/"
+	n := camillo.New(h)
+	n.UseHandler(http.NotFoundHandler())
+
+	// the other paths of the site are passed on
+	res := do(t, n, "OPTIONS", "/about", "", nil)
+	expect(t, res.Code, http.StatusNotFound)
+	expect(t, res.Header().Get("Tus-Resumable"), "")
+
+	res = do(t, n, "POST", "/files", "", map[string]string{"Upload-Length": "5"})
+	expect(t, res.Code, http.StatusCreated)
+	expect(t, strings.HasPrefix(res.Header().Get("Location"), "/files/"), true)
+}
+
+func TestExpiration(t *testing.T) {
+	clock := camillo.NewManualClock(time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC))
+	h := New(NewMemoryStore())
+	h.Expiration = time.Hour
+	h.Clock = clock
+	n := camillo.New(h)
+
+	res := do(t, n, "POST", "/files", "", map[string]string{"Upload-Length": "5"})
+	location := res.Header().Get("Location")
+	expect(t, res.Header().Get("Upload-Expires"), "Mon, 01 Jun 2015 13:00:00 GMT")
+
+	clock.Advance(time.Hour)
+	res = do(t, n, "HEAD", location, "", nil)
+	expect(t, res.Code, http.StatusGone)
+	res = do(t, n, "HEAD", location, "", nil)
+	expect(t, res.Code, http.StatusNotFound)
+}
@@ -0,0 +1,210 @@
+package resumable
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Store for uploads that don't exist.
+var ErrNotFound = errors.New("resumable: upload not found")
+
+// Info describes an upload.
+type Info struct {
+	ID       string            `json:"id"`
+	Length   int64             `json:"length"`
+	Offset   int64             `json:"offset"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Expires  time.Time         `json:"expires,omitempty"`
+}
+
+// Complete returns whether or not all bytes of the upload have been received.
+func (info Info) Complete() bool {
+	return info.Offset >= info.Length
+}
+
+// Store is the storage backend of resumable uploads. Calls for the same upload are
+// never made concurrently by the Handler.
+type Store interface {
+	// Create stores a new, empty upload.
+	Create(info Info) error
+	// Info returns the current state of an upload.
+	Info(id string) (Info, error)
+	// Append writes the data of r at offset, which always equals the current offset of the
+	// upload, and returns the number of bytes written. The offset of the upload must be
+	// advanced by the bytes written even if an error is returned.
+	Append(id string, offset int64, r io.Reader) (int64, error)
+	// Delete removes an upload and its data.
+	Delete(id string) error
+}
+
+// MemoryStore is a Store that keeps uploads in memory.
+type MemoryStore struct {
+	mtx     sync.Mutex
+	uploads map[string]*memoryUpload
+}
+
+type memoryUpload struct {
+	info Info
+	data bytes.Buffer
+}
+
+// NewMemoryStore returns a new instance of MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{uploads: make(map[string]*memoryUpload)}
+}
+
+func (s *MemoryStore) Create(info Info) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.uploads[info.ID] = &memoryUpload{info: info}
+	return nil
+}
+
+func (s *MemoryStore) Info(id string) (Info, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	u, ok := s.uploads[id]
+	if !ok {
+		return Info{}, ErrNotFound
+	}
+	return u.info, nil
+}
+
+func (s *MemoryStore) Append(id string, offset int64, r io.Reader) (int64, error) {
+	s.mtx.Lock()
+	u, ok := s.uploads[id]
+	s.mtx.Unlock()
+	if !ok {
+		return 0, ErrNotFound
+	}
+
+	var chunk bytes.Buffer
+	n, err := io.Copy(&chunk, r)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	u.data.Write(chunk.Bytes())
+	u.info.Offset += n
+	return n, err
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.uploads[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.uploads, id)
+	return nil
+}
+
+// Data returns the data received for an upload.
+func (s *MemoryStore) Data(id string) ([]byte, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	u, ok := s.uploads[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), u.data.Bytes()...), nil
+}
+
+// DirStore is a Store that keeps uploads as files in a directory. The data of an upload
+// is stored in <id>.bin and its state in <id>.info.
+type DirStore struct {
+	Dir string
+}
+
+// NewDirStore returns a new instance of DirStore
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{Dir: dir}
+}
+
+// Path returns the path of the file holding the data of an upload.
+func (s *DirStore) Path(id string) string {
+	return filepath.Join(s.Dir, id+".bin")
+}
+
+func (s *DirStore) infoPath(id string) string {
+	return filepath.Join(s.Dir, id+".info")
+}
+
+func (s *DirStore) Create(info Info) error {
+	f, err := os.OpenFile(s.Path(info.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return s.writeInfo(info)
+}
+
+func (s *DirStore) Info(id string) (Info, error) {
+	b, err := ioutil.ReadFile(s.infoPath(id))
+	if os.IsNotExist(err) {
+		return Info{}, ErrNotFound
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	var info Info
+	err = json.Unmarshal(b, &info)
+	return info, err
+}
+
+func (s *DirStore) Append(id string, offset int64, r io.Reader) (int64, error) {
+	info, err := s.Info(id)
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(s.Path(id), os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+
+	info.Offset = offset + n
+	if werr := s.writeInfo(info); err == nil {
+		err = werr
+	}
+	return n, err
+}
+
+func (s *DirStore) Delete(id string) error {
+	err := os.Remove(s.infoPath(id))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(s.Path(id))
+}
+
+func (s *DirStore) writeInfo(info Info) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoPath(info.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(info.ID))
+}